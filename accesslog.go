@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The update endpoint answers 200 for just about everything, badauth and
+// nochg included, so the usual access log says nothing about who tried
+// what. This one has a line for every request with who it was from once
+// they're known, the hostnames it was for, and the answer each one got:
+//
+//	server:
+//	  access_log:
+//	    path: /var/log/route53Update/access.log
+//	    format: json
+//	    max_size_mb: 50
+//	    max_age: 24h
+//	    keep: 14
+//
+// Combined is the Apache format, for tools that already read that, with
+// the user and the answers tacked on the end. The file gets moved aside
+// with the time on the end once it's bigger than max_size_mb or older than
+// max_age, and only the newest keep of those are held on to.
+
+// AccessLogConfig is where the access log goes and when it gets rotated.
+type AccessLogConfig struct {
+	Path string `yaml:"path"`
+
+	// json, the default, or combined
+	Format string `yaml:"format"`
+
+	// Start a new file after this many megabytes or this long, either can
+	// be left out
+	MaxSizeMB int           `yaml:"max_size_mb"`
+	MaxAge    time.Duration `yaml:"max_age"`
+
+	// Rotated files to keep, all of them if it's 0
+	Keep int `yaml:"keep"`
+}
+
+func validAccessLogFormat(format string) bool {
+	return format == "" || format == "json" || format == "combined"
+}
+
+// AccessEntry is one request.
+type AccessEntry struct {
+	Time      time.Time `json:"time"`
+	Remote    string    `json:"remote"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	Bytes     int       `json:"bytes"`
+	Duration  float64   `json:"duration_ms"`
+	User      string    `json:"user,omitempty"`
+	Hostnames []string  `json:"hostnames,omitempty"`
+	Outcome   []string  `json:"outcome,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+
+	proto   string
+	referer string
+}
+
+// AccessLog writes the access log, rotating it as it goes.
+type AccessLog struct {
+	conf AccessLogConfig
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+}
+
+// NewAccessLog opens the access log from the config, nil if there isn't one.
+func NewAccessLog(conf AccessLogConfig) (*AccessLog, error) {
+	if conf.Path == "" {
+		return nil, nil
+	}
+	l := &AccessLog{conf: conf}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *AccessLog) open() error {
+	f, err := os.OpenFile(l.conf.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("Failed to open access log: %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("Failed to open access log: %v", err)
+	}
+	l.f, l.size, l.opened = f, info.Size(), time.Now()
+	return nil
+}
+
+// Move the file aside and start a new one. Call with the lock held.
+func (l *AccessLog) rotate() error {
+	l.f.Close()
+	rotated := l.conf.Path + "." + time.Now().UTC().Format("20060102-150405")
+	if err := os.Rename(l.conf.Path, rotated); err != nil {
+		log.Printf("Failed to rotate the access log: %v", err)
+	}
+	if l.conf.Keep > 0 {
+		old, _ := filepath.Glob(l.conf.Path + ".*")
+		slices.Sort(old)
+		for len(old) > l.conf.Keep {
+			os.Remove(old[0])
+			old = old[1:]
+		}
+	}
+	return l.open()
+}
+
+func (l *AccessLog) due(next int) bool {
+	if l.conf.MaxSizeMB > 0 && l.size > 0 && l.size+int64(next) > int64(l.conf.MaxSizeMB)*1024*1024 {
+		return true
+	}
+	return l.conf.MaxAge > 0 && time.Since(l.opened) > l.conf.MaxAge
+}
+
+// Write puts the entry in the log.
+func (l *AccessLog) Write(e *AccessEntry) {
+	var line []byte
+	if l.conf.Format == "combined" {
+		line = []byte(e.combined())
+	} else {
+		var err error
+		if line, err = json.Marshal(e); err != nil {
+			log.Printf("Failed to encode access log entry: %v", err)
+			return
+		}
+		line = append(line, '\n')
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.due(len(line)) {
+		if err := l.rotate(); err != nil {
+			log.Print(err)
+			return
+		}
+	}
+	n, err := l.f.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		log.Printf("Failed to write access log: %v", err)
+	}
+}
+
+// The Apache combined format, then the user and the answers quoted.
+func (e *AccessEntry) combined() string {
+	dash := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+	user := dash(e.User)
+	if strings.ContainsAny(user, " \"") {
+		user = fmt.Sprintf("%q", user)
+	}
+	return fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %d %q %q %q\n",
+		e.Remote, user, e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Method, e.Path, e.proto, e.Status, e.Bytes,
+		dash(e.referer), dash(e.UserAgent), strings.Join(e.Outcome, "; "))
+}
+
+// Wrap logs every request that goes through the handler.
+func (l *AccessLog) Wrap(h http.Handler) http.Handler {
+	if l == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := &AccessEntry{
+			Time:      time.Now(),
+			Remote:    r.RemoteAddr,
+			Method:    r.Method,
+			Path:      loggedPath(r),
+			UserAgent: r.UserAgent(),
+			proto:     r.Proto,
+			referer:   r.Referer(),
+		}
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			e.Remote = host
+		}
+		rec := &accessRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), accessKey{}, e)))
+
+		e.Status, e.Bytes = rec.status, rec.bytes
+		e.Duration = float64(time.Since(e.Time).Microseconds()) / 1000
+		if strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+			for _, answer := range strings.Split(strings.TrimSpace(rec.body.String()), "\n") {
+				if answer != "" {
+					e.Outcome = append(e.Outcome, answer)
+				}
+			}
+		}
+		l.Write(e)
+	})
+}
+
+// The path and query, less an approval link's signature, which would let
+// anyone reading the log use the link.
+func loggedPath(r *http.Request) string {
+	q := r.URL.Query()
+	if !q.Has("sig") {
+		return r.URL.RequestURI()
+	}
+	q.Del("sig")
+	if len(q) == 0 {
+		return r.URL.Path
+	}
+	return r.URL.Path + "?" + q.Encode()
+}
+
+type accessKey struct{}
+
+// noteAccess fills in who a request is from and the hostnames it's for,
+// once the handler knows.
+func noteAccess(r *http.Request, user string, hostnames ...string) {
+	e, ok := r.Context().Value(accessKey{}).(*AccessEntry)
+	if !ok {
+		return
+	}
+	if user != "" {
+		e.User = user
+	}
+	e.Hostnames = append(e.Hostnames, hostnames...)
+}
+
+// Keeps the status, the size, and enough of a plain text answer to log.
+type accessRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+	body   bytes.Buffer
+}
+
+func (rec *accessRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *accessRecorder) Write(b []byte) (int, error) {
+	if room := 4096 - rec.body.Len(); room > 0 {
+		rec.body.Write(b[:min(len(b), room)])
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/mikerowehl/route53Update/route53update"
+	"golang.org/x/crypto/acme"
+)
+
+// Putting the update endpoint on the internet means HTTPS, and usually a
+// reverse proxy just to get a certificate. But the server already has
+// credentials for the zone its hostname is in, which is all the DNS-01
+// challenge needs, so it can get its own:
+//
+//	server:
+//	  listen: ":443"
+//	  tls:
+//	    hostnames: [dyndns.example.com]
+//	    email: me@example.com
+//
+// The challenge TXT record goes in Route53 under _acme-challenge, and comes
+// out again once the name is validated. Nothing has to be reachable from
+// the CA for that, so it works behind a firewall too. The certificate gets
+// renewed once it's within renewBefore of running out, checked twice a day.
+
+// TLSConfig is the certificate the server gets for itself with ACME.
+type TLSConfig struct {
+	// The names on the certificate, the first one is the subject
+	Hostnames []string `yaml:"hostnames"`
+
+	// Contact address for the ACME account, optional
+	Email string `yaml:"email"`
+
+	// The ACME directory, Let's Encrypt if it's not set. Their staging
+	// directory is good for trying it out without running into limits.
+	Directory string `yaml:"directory"`
+
+	// Where the account key and the certificate are kept, a directory in
+	// the user's config directory if it's not set
+	Dir string `yaml:"dir"`
+}
+
+// Renew when there's this much time left on the certificate, which is what
+// Let's Encrypt suggests for their 90 day ones.
+const renewBefore = 30 * 24 * time.Hour
+
+// How long the challenge record gets to be on all of Route53's servers.
+const challengeSyncTimeout = 5 * time.Minute
+
+// CertManager gets the server's certificate and keeps it renewed.
+type CertManager struct {
+	conf   TLSConfig
+	client *route53.Client
+	zones  *ZoneCache
+	dir    string
+
+	mu   sync.Mutex
+	cert *tls.Certificate
+}
+
+// NewCertManager sets up ACME from the config, loading the certificate
+// from last time if there is one.
+func NewCertManager(conf TLSConfig, client *route53.Client, zones *ZoneCache) (*CertManager, error) {
+	dir := conf.Dir
+	if dir == "" {
+		config, err := os.UserConfigDir()
+		if err != nil {
+			return nil, fmt.Errorf("No tls dir set and no config directory for one: %v", err)
+		}
+		dir = filepath.Join(config, "route53update", "acme")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("Failed to make the tls dir: %v", err)
+	}
+	m := &CertManager{conf: conf, client: client, zones: zones, dir: dir}
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))
+	if err == nil && cert.Leaf != nil && m.covers(cert.Leaf) {
+		m.cert = &cert
+	}
+	return m, nil
+}
+
+// Whether the certificate is for all the hostnames, so changing them in
+// the config gets a new one.
+func (m *CertManager) covers(leaf *x509.Certificate) bool {
+	for _, name := range m.conf.Hostnames {
+		if strings.HasPrefix(name, "*.") {
+			name = "any" + name[1:]
+		}
+		if leaf.VerifyHostname(name) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// GetCertificate is for tls.Config.
+func (m *CertManager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cert == nil {
+		return nil, errors.New("No certificate yet")
+	}
+	return m.cert, nil
+}
+
+// Renew gets a new certificate if there isn't one or it's running out.
+func (m *CertManager) Renew() error {
+	m.mu.Lock()
+	cert := m.cert
+	m.mu.Unlock()
+	if cert != nil && time.Until(cert.Leaf.NotAfter) > renewBefore {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Minute)
+	defer cancel()
+	cert, err := m.obtain(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to get a certificate for %s: %v", strings.Join(m.conf.Hostnames, ", "), err)
+	}
+	m.mu.Lock()
+	m.cert = cert
+	m.mu.Unlock()
+	log.Printf("Got a certificate for %s good until %s", strings.Join(m.conf.Hostnames, ", "), cert.Leaf.NotAfter.Format(time.RFC1123))
+	return nil
+}
+
+// KeepRenewed checks the certificate every interval, for running in the
+// background. The old certificate is still good for weeks when renewing
+// starts, so failures are just logged and tried again next time.
+func (m *CertManager) KeepRenewed(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := m.Renew(); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// Go through an ACME order, answering each name's DNS-01 challenge in
+// Route53, and save the certificate that comes out of it.
+func (m *CertManager) obtain(ctx context.Context) (*tls.Certificate, error) {
+	accountKey, err := m.loadKey("account.key")
+	if err != nil {
+		return nil, err
+	}
+	ac := &acme.Client{Key: accountKey, DirectoryURL: m.conf.Directory, UserAgent: "route53Update/" + Version()}
+	if ac.DirectoryURL == "" {
+		ac.DirectoryURL = acme.LetsEncryptURL
+	}
+	account := &acme.Account{}
+	if m.conf.Email != "" {
+		account.Contact = []string{"mailto:" + m.conf.Email}
+	}
+	if _, err := ac.Register(ctx, account, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, fmt.Errorf("Failed to register with %s: %v", ac.DirectoryURL, err)
+	}
+
+	order, err := ac.AuthorizeOrder(ctx, acme.DomainIDs(m.conf.Hostnames...))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to start an order: %v", err)
+	}
+	for _, url := range order.AuthzURLs {
+		if err := m.authorize(ctx, ac, url); err != nil {
+			return nil, err
+		}
+	}
+	order, err = ac.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return nil, fmt.Errorf("Order never became ready: %v", err)
+	}
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: m.conf.Hostnames[0]},
+		DNSNames: m.conf.Hostnames,
+	}, certKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to make a certificate request: %v", err)
+	}
+	chain, _, err := ac.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get the certificate: %v", err)
+	}
+
+	var certPEM []byte
+	for _, der := range chain {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(certKey)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("Got a certificate that doesn't work: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(m.dir, "key.pem"), keyPEM, 0600); err != nil {
+		return nil, fmt.Errorf("Failed to save the certificate key: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(m.dir, "cert.pem"), certPEM, 0600); err != nil {
+		return nil, fmt.Errorf("Failed to save the certificate: %v", err)
+	}
+	return &cert, nil
+}
+
+// Answer the DNS-01 challenge for one name, unless the CA still remembers
+// it being validated.
+func (m *CertManager) authorize(ctx context.Context, ac *acme.Client, url string) error {
+	authz, err := ac.GetAuthorization(ctx, url)
+	if err != nil {
+		return fmt.Errorf("Failed to get authorization: %v", err)
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+	var chal *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == "dns-01" {
+			chal = c
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("%s can't be validated with DNS-01", authz.Identifier.Value)
+	}
+	value, err := ac.DNS01ChallengeRecord(chal.Token)
+	if err != nil {
+		return err
+	}
+
+	name := "_acme-challenge." + route53update.NormalizeHostname(authz.Identifier.Value)
+	cleanup, err := m.setChallenge(name, value)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if _, err := ac.Accept(ctx, chal); err != nil {
+		return fmt.Errorf("Failed to accept the challenge for %s: %v", authz.Identifier.Value, err)
+	}
+	if _, err := ac.WaitAuthorization(ctx, authz.URI); err != nil {
+		return fmt.Errorf("%s wasn't validated: %v", authz.Identifier.Value, err)
+	}
+	return nil
+}
+
+// Put the challenge TXT record up and wait for Route53 to have it
+// everywhere. Calling the func that comes back takes it down again. It's a
+// record we made and nobody needs back, so it doesn't go in the recycle
+// bin.
+func (m *CertManager) setChallenge(name string, value string) (func(), error) {
+	zone, err := m.zones.For(name, "")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to find the zone for %s: %v", name, err)
+	}
+	rec := &types.ResourceRecordSet{
+		Name:            aws.String(name),
+		Type:            types.RRTypeTxt,
+		TTL:             aws.Int64(60),
+		ResourceRecords: []types.ResourceRecord{{Value: aws.String(QuoteTXT(value))}},
+	}
+	change := func(action types.ChangeAction) (*route53.ChangeResourceRecordSetsOutput, error) {
+		return route53update.ChangeRecordSets(m.client, &route53.ChangeResourceRecordSetsInput{
+			ChangeBatch: &types.ChangeBatch{
+				Changes: []types.Change{{Action: action, ResourceRecordSet: rec}},
+				Comment: aws.String("route53Update ACME challenge"),
+			},
+			HostedZoneId: zone.Id,
+		})
+	}
+	res, err := change(types.ChangeActionUpsert)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to set the challenge record %s: %v", name, err)
+	}
+	cleanup := func() {
+		if _, err := change(types.ChangeActionDelete); err != nil {
+			log.Printf("Failed to remove the challenge record %s: %v", name, err)
+		}
+	}
+	if _, err := route53update.WaitForChange(m.client, *res.ChangeInfo.Id, challengeSyncTimeout); err != nil {
+		cleanup()
+		return nil, fmt.Errorf("Challenge record %s never synced: %v", name, err)
+	}
+	return cleanup, nil
+}
+
+// An ECDSA key from the tls dir, made and saved the first time.
+func (m *CertManager) loadKey(file string) (crypto.Signer, error) {
+	path := filepath.Join(m.dir, file)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%s isn't a PEM key", path)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return nil, fmt.Errorf("Failed to save %s: %v", path, err)
+	}
+	return key, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Running every minute out of cron means a ListResourceRecordSets call
+// every minute, nearly always to find out the record is what it was a
+// minute ago. With cache_max_age set, the address each record was last
+// seen to have in Route53, whether we put it there or found it there, goes
+// in the state file. When the address we have now is the same, and it was
+// seen recently enough, there's nothing Route53 could tell us and the read
+// gets skipped. Once it's older than cache_max_age the record gets read
+// again, which is what catches anyone changing it behind our back.
+
+// PushedAddress is the address a record was last seen to have.
+type PushedAddress struct {
+	IP   string    `json:"ip"`
+	Time time.Time `json:"time"`
+}
+
+func pushedKey(name string, record string) string {
+	if record == "" {
+		record = "A"
+	}
+	return name + " " + record
+}
+
+// The address the record had recently enough to trust without asking.
+func (u *Updater) cachedAddress(name string, record string) (PushedAddress, bool) {
+	if u.CacheMaxAge <= 0 || u.State == "" {
+		return PushedAddress{}, false
+	}
+	st, _, err := LoadRuntimeState(u.State)
+	if err != nil {
+		log.Printf("Can't check the address cache, asking Route53: %v", err)
+		return PushedAddress{}, false
+	}
+	p, ok := st.Pushed[pushedKey(name, record)]
+	if !ok || time.Since(p.Time) > u.CacheMaxAge {
+		return PushedAddress{}, false
+	}
+	return p, true
+}
+
+// Remember the address the record has now.
+func (u *Updater) rememberAddress(name string, record string, ip string) {
+	if u.CacheMaxAge <= 0 || u.State == "" || u.DryRun {
+		return
+	}
+	err := UpdateRuntimeState(u.State, func(st *RuntimeState) error {
+		if st.Pushed == nil {
+			st.Pushed = map[string]PushedAddress{}
+		}
+		st.Pushed[pushedKey(name, record)] = PushedAddress{IP: ip, Time: time.Now().UTC()}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to cache the address for %s: %v", name, err)
+	}
+}
+
+// Skip the Route53 read if the address is the one we already know it has.
+func (u *Updater) cacheHit(name string, record string, ip string) bool {
+	p, ok := u.cachedAddress(name, record)
+	if !ok || p.IP != ip {
+		return false
+	}
+	u.newMismatch(name+" "+record, "")
+	u.Reporter.Report(Event{Type: EventNoChange, Domain: name, Record: record, Source: u.Source, OldIp: p.IP, NewIp: ip})
+	fmt.Printf("%s was %s as of %s ago, not asking route53, done\n", pushedKey(name, record), ip, time.Since(p.Time).Round(time.Second))
+	return true
+}
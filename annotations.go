@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// When several people share a zone it's not always obvious why a record is
+// being kept up to date or who to ask about it. Annotations are free form
+// labels on a domain for that:
+//
+//	domains:
+//	  vpn.example.com:
+//	    annotations:
+//	      owner: alice
+//	      purpose: office VPN
+//	      ticket: OPS-123
+//
+// They show up in list and status, in notifications, and as the comment on
+// the changes we make, so they're there in CloudTrail and the change history
+// too. Nothing else looks at them.
+
+// The annotations as one line, sorted so they come out the same every time.
+func formatAnnotations(annotations map[string]string) string {
+	keys := make([]string, 0, len(annotations))
+	for k := range annotations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		parts = append(parts, k+"="+annotations[k])
+	}
+	return strings.Join(parts, " ")
+}
+
+// Route53 cuts change comments off at 256 characters.
+const maxChangeComment = 256
+
+// The comment for a batch of changes to these domains, nil if none of them
+// have annotations.
+func (u *Updater) changeComment(names ...string) *string {
+	var parts []string
+	seen := map[string]bool{}
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		if a := u.Domains[name].Annotations; len(a) > 0 {
+			parts = append(parts, fmt.Sprintf("%s: %s", name, formatAnnotations(a)))
+		}
+	}
+	if len(parts) == 0 {
+		return nil
+	}
+	comment := strings.Join(parts, "; ")
+	if len(comment) > maxChangeComment {
+		comment = comment[:maxChangeComment-3] + "..."
+	}
+	return aws.String(comment)
+}
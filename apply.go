@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/mikerowehl/route53Update/route53update"
+)
+
+// apply takes record changes from other systems, a JSON object a line:
+//
+//	{"name":"vm1.example.com","type":"A","value":"203.0.113.7"}
+//	{"name":"vm1.example.com","type":"TXT","value":"owner=ci","ttl":60}
+//
+// and makes them the way updates are made, with the same policies, pauses,
+// maintenance windows, and locks, and with the changes for each zone going
+// out together in as few batches as Route53 allows. The input can be a
+// stream that never ends, whatever has come in gets applied once there's a
+// full batch or the input goes quiet for a moment. Each request gets a
+// JSON line back saying what happened to it.
+
+// ApplyRequest is one record to set.
+type ApplyRequest struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	TTL   int64  `json:"ttl,omitempty"`
+}
+
+// ApplyResult says what happened to a request: changed, unchanged, held,
+// rejected, or failed.
+type ApplyResult struct {
+	ApplyRequest
+	Result   string `json:"result"`
+	OldValue string `json:"old_value,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	ChangeId string `json:"change_id,omitempty"`
+}
+
+// The record types apply can set.
+var applyTypes = []types.RRType{types.RRTypeA, types.RRTypeAaaa, types.RRTypeCname, types.RRTypeTxt}
+
+// Past this many names in one zone it's fewer calls to list the whole zone,
+// 300 records a page, than to look the names up one at a time.
+const applyListThreshold = 10
+
+// Check a request over and tidy it up. The error is why it can't be done.
+func (r *ApplyRequest) validate() error {
+	r.Name = route53update.NormalizeHostname(r.Name)
+	r.Type = strings.ToUpper(r.Type)
+	if !strings.Contains(r.Name, ".") {
+		return fmt.Errorf("%q isn't a full domain name", r.Name)
+	}
+	if !slices.Contains(applyTypes, types.RRType(r.Type)) {
+		return fmt.Errorf("Can't set %s records, only A, AAAA, CNAME, and TXT", r.Type)
+	}
+	if r.TTL < 0 || r.TTL > maxTTL {
+		return fmt.Errorf("TTL must be between 1 and %d, not %d", maxTTL, r.TTL)
+	}
+	switch types.RRType(r.Type) {
+	case types.RRTypeA, types.RRTypeAaaa:
+		addr, err := netip.ParseAddr(r.Value)
+		if err != nil || addr.Is4() != (r.Type == "A") {
+			return fmt.Errorf("%q isn't an %s address", r.Value, map[bool]string{true: "IPv4", false: "IPv6"}[r.Type == "A"])
+		}
+		r.Value = addr.String()
+	case types.RRTypeCname:
+		if r.Value == "" {
+			return errors.New("A CNAME needs a target")
+		}
+		r.Value = route53update.NormalizeHostname(r.Value) + "."
+	}
+	return nil
+}
+
+// The value the way Route53 has it.
+func (r ApplyRequest) recordValue() string {
+	if r.Type == string(types.RRTypeTxt) {
+		return QuoteTXT(r.Value)
+	}
+	return r.Value
+}
+
+// Apply reads requests from in until it ends, applying them in batches of
+// up to batchSize, or whatever's there once the input has been quiet for
+// the flush interval. The results go to out.
+func (u *Updater) Apply(in io.Reader, out io.Writer, batchSize int, flushAfter time.Duration) error {
+	lines := make(chan string)
+	readErr := make(chan error, 1)
+	go func() {
+		scanner := bufio.NewScanner(in)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		readErr <- scanner.Err()
+		close(lines)
+	}()
+
+	enc := json.NewEncoder(out)
+	var pending []ApplyRequest
+	flush := func() {
+		for _, res := range u.applyBatch(pending) {
+			enc.Encode(res)
+		}
+		pending = nil
+	}
+	timer := time.NewTimer(flushAfter)
+	defer timer.Stop()
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				flush()
+				return <-readErr
+			}
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+			var req ApplyRequest
+			if err := json.Unmarshal([]byte(line), &req); err != nil {
+				enc.Encode(ApplyResult{Result: "failed", Reason: fmt.Sprintf("Can't parse %q: %v", line, err)})
+				continue
+			}
+			pending = append(pending, req)
+			if len(pending) >= batchSize {
+				flush()
+			}
+			timer.Reset(flushAfter)
+		case <-timer.C:
+			if len(pending) > 0 {
+				flush()
+			}
+			timer.Reset(flushAfter)
+		}
+	}
+}
+
+// One pending request on its way into a change.
+type applyItem struct {
+	res    *ApplyResult
+	zoneId string
+	unlock func()
+}
+
+// Apply a batch of requests, the results in the same order.
+func (u *Updater) applyBatch(reqs []ApplyRequest) []ApplyResult {
+	results := make([]ApplyResult, len(reqs))
+
+	// The last request for a record wins, the earlier ones would just be
+	// overwritten in the same batch
+	last := map[string]int{}
+	for i := range reqs {
+		results[i].ApplyRequest = reqs[i]
+		if err := results[i].validate(); err != nil {
+			results[i].Result, results[i].Reason = "rejected", err.Error()
+			continue
+		}
+		key := results[i].Name + " " + results[i].Type
+		if j, ok := last[key]; ok {
+			results[j].Result, results[j].Reason = "unchanged", "replaced by a later request for the same record"
+		}
+		last[key] = i
+	}
+
+	byZone := map[string][]*applyItem{}
+	var zones []string
+	locked := map[string]func(){}
+	defer func() {
+		for _, unlock := range locked {
+			unlock()
+		}
+	}()
+	for i := range results {
+		res := &results[i]
+		if res.Result != "" {
+			continue
+		}
+		if held := u.heldBecause(res.Name); held != "" {
+			res.Result, res.Reason = "held", held
+			continue
+		}
+		if res.Type == "A" || res.Type == "AAAA" {
+			if err := u.Policies.Check(res.Name, res.Value); err != nil {
+				res.Result, res.Reason = "rejected", err.Error()
+				continue
+			}
+		}
+		if _, ok := locked[res.Name]; !ok {
+			unlock, err := u.lock(res.Name)
+			if err != nil {
+				res.Result, res.Reason = "failed", err.Error()
+				continue
+			}
+			locked[res.Name] = unlock
+		}
+		zone, err := u.zone(res.Name)
+		if err != nil {
+			res.Result, res.Reason = "failed", fmt.Sprintf("Failed to find zone: %v", err)
+			continue
+		}
+		if _, ok := byZone[*zone.Id]; !ok {
+			zones = append(zones, *zone.Id)
+		}
+		byZone[*zone.Id] = append(byZone[*zone.Id], &applyItem{res: res, zoneId: *zone.Id})
+	}
+
+	var pacer ChangePacer
+	for _, zoneId := range zones {
+		u.applyZone(zoneId, byZone[zoneId], &pacer)
+	}
+	return results
+}
+
+// Make the changes for one zone, skipping records that are already right.
+func (u *Updater) applyZone(zoneId string, items []*applyItem, pacer *ChangePacer) {
+	current, err := u.currentRecords(zoneId, items)
+	if err != nil {
+		for _, item := range items {
+			item.res.Result, item.res.Reason = "failed", err.Error()
+		}
+		return
+	}
+
+	var changes []types.Change
+	var changing []*applyItem
+	for _, item := range items {
+		res := item.res
+		rec := current[res.Name+" "+res.Type]
+		ttl := res.TTL
+		if ttl == 0 {
+			ttl = u.ttl(res.Name).For(true)
+		}
+		if rec != nil {
+			var values []string
+			for _, rr := range rec.ResourceRecords {
+				values = append(values, aws.ToString(rr.Value))
+			}
+			res.OldValue = strings.Join(values, " ")
+			if len(values) == 1 && values[0] == res.recordValue() && aws.ToInt64(rec.TTL) == ttl {
+				res.Result = "unchanged"
+				continue
+			}
+		}
+		change := types.Change{
+			Action: types.ChangeActionUpsert,
+			ResourceRecordSet: &types.ResourceRecordSet{
+				Name:            aws.String(res.Name + "."),
+				Type:            types.RRType(res.Type),
+				TTL:             aws.Int64(ttl),
+				ResourceRecords: []types.ResourceRecord{{Value: aws.String(res.recordValue())}},
+			},
+		}
+		if u.DryRun {
+			printPlannedChange(log.Writer(), rec, change)
+			res.Result, res.Reason = "held", "dry run"
+			continue
+		}
+		changes = append(changes, change)
+		changing = append(changing, item)
+	}
+
+	start := 0
+	for _, batch := range splitChanges(changes, importBatchSize) {
+		pacer.Wait(batch)
+		items := changing[start : start+len(batch)]
+		start += len(batch)
+		var names []string
+		for _, item := range items {
+			names = append(names, item.res.Name)
+		}
+		out, err := route53update.ChangeRecordSets(u.Client, &route53.ChangeResourceRecordSetsInput{
+			ChangeBatch:  &types.ChangeBatch{Changes: batch, Comment: u.changeComment(names...)},
+			HostedZoneId: aws.String(zoneId),
+		})
+		for _, item := range items {
+			res := item.res
+			record := ""
+			if res.Type != "A" {
+				record = res.Type
+			}
+			if err != nil {
+				res.Result, res.Reason = "failed", err.Error()
+				u.Reporter.Report(Event{Type: EventFailure, Domain: res.Name, Record: record, Source: u.Source, Error: err.Error()})
+				continue
+			}
+			res.Result, res.ChangeId = "changed", *out.ChangeInfo.Id
+			u.Reporter.Report(Event{Type: EventChange, Domain: res.Name, Record: record, Source: u.Source, OldIp: res.OldValue, NewIp: res.Value, TTL: aws.ToInt64(batch[0].ResourceRecordSet.TTL)})
+		}
+		if err == nil {
+			log.Printf("Changed %d records in %s. Change: %s", len(batch), strings.TrimPrefix(zoneId, "/hostedzone/"), *out.ChangeInfo.Id)
+		}
+	}
+}
+
+// What's in the zone now for the requested records, by name and type.
+func (u *Updater) currentRecords(zoneId string, items []*applyItem) (map[string]*types.ResourceRecordSet, error) {
+	current := map[string]*types.ResourceRecordSet{}
+	add := func(rec types.ResourceRecordSet) {
+		if rec.SetIdentifier == nil {
+			current[route53update.NormalizeHostname(aws.ToString(rec.Name))+" "+string(rec.Type)] = &rec
+		}
+	}
+	if len(items) > applyListThreshold {
+		paginator := route53.NewListResourceRecordSetsPaginator(u.Client, &route53.ListResourceRecordSetsInput{HostedZoneId: aws.String(zoneId)})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(context.TODO())
+			if err != nil {
+				return nil, fmt.Errorf("Failed to list records: %v", err)
+			}
+			for _, rec := range page.ResourceRecordSets {
+				add(rec)
+			}
+		}
+		return current, nil
+	}
+	for _, item := range items {
+		rec, err := route53update.GetRecord(u.Client, zoneId, item.res.Name+".", types.RRType(item.res.Type))
+		if errors.Is(err, route53update.ErrRecordNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to look up %s: %v", item.res.Name, err)
+		}
+		add(*rec)
+	}
+	return current, nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long an approval link keeps working by default. Long enough to get to
+// it after a night's sleep, short enough that an old email can't flip a
+// record back to some address from last month.
+const approvalLifetime = 24 * time.Hour
+
+// Approvals signs and checks the links in notify only alerts. The watcher
+// puts approve and reject links in the alert, and the server does the update
+// or records the rejection when someone follows one. Nothing about the
+// change is stored in between, the link carries the record and the address,
+// and an HMAC with the shared secret keeps anyone from making up their own.
+// A change nobody acts on just expires with its links.
+//
+// The one thing that does get stored is which changes have been approved or
+// rejected, so each change can only be dealt with once. The approve and
+// reject links for a change share an id, and once either one is used
+// neither works again. That keeps an old link from being replayed to put
+// back an address from hours ago, and a rejection from being undone by
+// someone clicking approve after it. The used ids go in the state file if
+// there is one, and are only kept in memory if there isn't.
+type Approvals struct {
+	url      string
+	secret   []byte
+	lifetime time.Duration
+
+	// State file to keep used ids in, set by the server
+	State string
+
+	mu   sync.Mutex
+	used map[string]UsedApproval
+}
+
+// UsedApproval is a change that's been approved or rejected, kept until
+// its links would have expired anyway.
+type UsedApproval struct {
+	Action  string    `json:"action"`
+	Expires time.Time `json:"expires"`
+}
+
+var errApprovalUsed = errors.New("This change has already been")
+
+// NewApprovals returns nil if approvals aren't set up.
+func NewApprovals(conf ApprovalConfig) *Approvals {
+	if conf.URL == "" || conf.Secret == "" {
+		return nil
+	}
+	lifetime := conf.Expires
+	if lifetime <= 0 {
+		lifetime = approvalLifetime
+	}
+	return &Approvals{
+		url:      strings.TrimRight(conf.URL, "/") + "/approve",
+		secret:   []byte(conf.Secret),
+		lifetime: lifetime,
+		used:     map[string]UsedApproval{},
+	}
+}
+
+// Link makes an approval link for setting the record to ip.
+func (a *Approvals) Link(domain string, record string, ip string, now time.Time) string {
+	return a.link("", domain, record, ip, now)
+}
+
+// RejectLink makes the link for turning the change down.
+func (a *Approvals) RejectLink(domain string, record string, ip string, now time.Time) string {
+	return a.link("reject", domain, record, ip, now)
+}
+
+func (a *Approvals) link(action string, domain string, record string, ip string, now time.Time) string {
+	q := url.Values{}
+	if action != "" {
+		q.Set("action", action)
+	}
+	q.Set("domain", domain)
+	if record != "" {
+		q.Set("record", record)
+	}
+	q.Set("ip", ip)
+	q.Set("expires", strconv.FormatInt(now.Add(a.lifetime).Unix(), 10))
+	q.Set("sig", a.sign(q))
+	return a.url + "?" + q.Encode()
+}
+
+// Verify checks the signature and expiry on the query from an approval link,
+// and hands back what it's for. Action is reject for reject links and empty
+// for approvals.
+func (a *Approvals) Verify(q url.Values, now time.Time) (domain string, record string, ip string, action string, err error) {
+	sig, err := hex.DecodeString(q.Get("sig"))
+	if err != nil || !hmac.Equal(sig, a.mac(q)) {
+		return "", "", "", "", fmt.Errorf("Bad approval signature")
+	}
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil || now.After(time.Unix(expires, 0)) {
+		return "", "", "", "", fmt.Errorf("Approval link has expired")
+	}
+	return q.Get("domain"), q.Get("record"), q.Get("ip"), q.Get("action"), nil
+}
+
+// The id shared by the approve and reject links for a change. They're made
+// at the same time, so the expiry is the same in both.
+func approvalID(q url.Values) string {
+	h := sha256.New()
+	for _, key := range []string{"domain", "record", "ip", "expires"} {
+		fmt.Fprintf(h, "%s=%s\n", key, q.Get(key))
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// Used says what was done with the change a link is for, if anything.
+func (a *Approvals) Used(q url.Values) (string, bool) {
+	id := approvalID(q)
+	if a.State == "" {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		u, ok := a.used[id]
+		return u.Action, ok
+	}
+	st, _, err := LoadRuntimeState(a.State)
+	if err != nil {
+		return "", false
+	}
+	u, ok := st.UsedApprovals[id]
+	return u.Action, ok
+}
+
+// Use marks the change a link is for as approved or rejected, failing if
+// it already was. It's checked and marked in one go, so two clicks at once
+// can't both get through.
+func (a *Approvals) Use(q url.Values, action string) error {
+	if action == "" {
+		action = "approved"
+	} else {
+		action += "ed"
+	}
+	id := approvalID(q)
+	expires, _ := strconv.ParseInt(q.Get("expires"), 10, 64)
+	mark := func(used map[string]UsedApproval) error {
+		for key, u := range used {
+			if time.Now().After(u.Expires) {
+				delete(used, key)
+			}
+		}
+		if u, ok := used[id]; ok {
+			return fmt.Errorf("%w %s", errApprovalUsed, u.Action)
+		}
+		used[id] = UsedApproval{Action: action, Expires: time.Unix(expires, 0).UTC()}
+		return nil
+	}
+	if a.State == "" {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		return mark(a.used)
+	}
+	return UpdateRuntimeState(a.State, func(st *RuntimeState) error {
+		if st.UsedApprovals == nil {
+			st.UsedApprovals = map[string]UsedApproval{}
+		}
+		return mark(st.UsedApprovals)
+	})
+}
+
+// Unuse takes back Use, for an approval that didn't go through and can be
+// tried again.
+func (a *Approvals) Unuse(q url.Values) {
+	id := approvalID(q)
+	if a.State == "" {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		delete(a.used, id)
+		return
+	}
+	UpdateRuntimeState(a.State, func(st *RuntimeState) error {
+		delete(st.UsedApprovals, id)
+		return nil
+	})
+}
+
+// Lifetime is how long links stay good, and so how long a rejection sticks.
+func (a *Approvals) Lifetime() time.Duration {
+	return a.lifetime
+}
+
+func (a *Approvals) sign(q url.Values) string {
+	return hex.EncodeToString(a.mac(q))
+}
+
+// The signature covers everything in the link except itself. The action
+// only goes in when there is one, so approval links from before reject links
+// existed still check out.
+func (a *Approvals) mac(q url.Values) []byte {
+	m := hmac.New(sha256.New, a.secret)
+	if action := q.Get("action"); action != "" {
+		fmt.Fprintf(m, "action=%s\n", action)
+	}
+	for _, key := range []string{"domain", "record", "ip", "expires"} {
+		fmt.Fprintf(m, "%s=%s\n", key, q.Get(key))
+	}
+	return m.Sum(nil)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The query from a link the way the server gets it.
+func linkQuery(t *testing.T, link string) url.Values {
+	t.Helper()
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("Bad link %s: %v", link, err)
+	}
+	return u.Query()
+}
+
+func TestApprovalVerify(t *testing.T) {
+	a := NewApprovals(ApprovalConfig{URL: "https://dns.example.com/", Secret: "secret"})
+	now := time.Now()
+	link := a.Link("home.example.com", "AAAA", "2001:db8::1", now)
+	if !strings.HasPrefix(link, "https://dns.example.com/approve?") {
+		t.Errorf("Link %s doesn't go to the approve endpoint", link)
+	}
+
+	domain, record, ip, action, err := a.Verify(linkQuery(t, link), now)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if domain != "home.example.com" || record != "AAAA" || ip != "2001:db8::1" || action != "" {
+		t.Errorf("Verify gave %s %s %s %q", domain, record, ip, action)
+	}
+	if _, _, _, action, err := a.Verify(linkQuery(t, a.RejectLink("home.example.com", "", "203.0.113.1", now)), now); err != nil || action != "reject" {
+		t.Errorf("Reject link gave action %q, %v", action, err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(q url.Values)
+		at     time.Time
+		want   string
+	}{
+		{"other address", func(q url.Values) { q.Set("ip", "198.51.100.1") }, now, "Bad approval signature"},
+		{"other domain", func(q url.Values) { q.Set("domain", "www.example.com") }, now, "Bad approval signature"},
+		{"other record", func(q url.Values) { q.Del("record") }, now, "Bad approval signature"},
+		{"turned into a reject", func(q url.Values) { q.Set("action", "reject") }, now, "Bad approval signature"},
+		{"longer expiry", func(q url.Values) { q.Set("expires", "99999999999") }, now, "Bad approval signature"},
+		{"no signature", func(q url.Values) { q.Del("sig") }, now, "Bad approval signature"},
+		{"expired", func(q url.Values) {}, now.Add(approvalLifetime + time.Second), "expired"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := linkQuery(t, link)
+			tt.change(q)
+			if _, _, _, _, err := a.Verify(q, tt.at); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Got %v, want an error with %q", err, tt.want)
+			}
+		})
+	}
+
+	other := NewApprovals(ApprovalConfig{URL: "https://dns.example.com", Secret: "other"})
+	if _, _, _, _, err := other.Verify(linkQuery(t, link), now); err == nil {
+		t.Errorf("Link checked out with another secret")
+	}
+}
+
+func TestApprovalUse(t *testing.T) {
+	for _, state := range []string{"", "state.json"} {
+		name := "memory"
+		if state != "" {
+			name = "state file"
+		}
+		t.Run(name, func(t *testing.T) {
+			a := NewApprovals(ApprovalConfig{URL: "https://dns.example.com", Secret: "secret"})
+			if state != "" {
+				a.State = filepath.Join(t.TempDir(), state)
+			}
+			now := time.Now()
+			approve := linkQuery(t, a.Link("home.example.com", "", "203.0.113.1", now))
+			reject := linkQuery(t, a.RejectLink("home.example.com", "", "203.0.113.1", now))
+
+			if _, ok := a.Used(approve); ok {
+				t.Fatalf("New link is already used")
+			}
+			if err := a.Use(approve, ""); err != nil {
+				t.Fatalf("Use: %v", err)
+			}
+			if action, ok := a.Used(approve); !ok || action != "approved" {
+				t.Errorf("Used gave %q %v, want approved", action, ok)
+			}
+			if err := a.Use(approve, ""); !errors.Is(err, errApprovalUsed) {
+				t.Errorf("Second use of the link gave %v, want it already used", err)
+			}
+
+			// An approval that didn't go through can be tried again
+			a.Unuse(approve)
+			if _, ok := a.Used(approve); ok {
+				t.Fatalf("Still used after Unuse")
+			}
+
+			// Rejecting shares the id, so the approve link stops working
+			if err := a.Use(reject, "reject"); err != nil {
+				t.Fatalf("Use reject: %v", err)
+			}
+			if action, ok := a.Used(approve); !ok || action != "rejected" {
+				t.Errorf("Approve link after reject is %q %v, want rejected", action, ok)
+			}
+			if err := a.Use(approve, ""); err == nil || !strings.Contains(err.Error(), "already been rejected") {
+				t.Errorf("Approving after a reject gave %v, want already rejected", err)
+			}
+
+			// Another change has its own id
+			other := linkQuery(t, a.Link("home.example.com", "", "203.0.113.2", now))
+			if err := a.Use(other, ""); err != nil {
+				t.Errorf("Use of another change: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// This tool sits on a box with credentials that can rewrite DNS, so if the
+// box gets broken into the history is the first thing worth covering up.
+// The audit log makes that hard to do quietly. Every decision and every AWS
+// call gets a line, and each line carries the hash of the one before it, so
+// changing or taking out a line breaks the chain from there on:
+//
+//	audit:
+//	  path: /var/log/route53Update/audit.log
+//	  key: /etc/route53Update/audit.key
+//
+// With a key each line's hash is signed too, so the chain can't just be
+// worked out again after an edit without the key. Keep the key readable
+// only by the user this runs as. Cutting lines off the end leaves a chain
+// that still checks out, so for that the last hash that audit verify prints
+// needs to be kept somewhere else now and then.
+
+// AuditConfig is where the audit log goes, and the key to sign it with.
+type AuditConfig struct {
+	Path string `yaml:"path"`
+
+	// An Ed25519 private key from audit keygen, optional
+	Key string `yaml:"key"`
+}
+
+// AuditEntry is one thing that happened, either a decision or an AWS call.
+type AuditEntry struct {
+	Seq      int64      `json:"seq"`
+	Time     time.Time  `json:"time"`
+	Decision *Event     `json:"decision,omitempty"`
+	Call     *AuditCall `json:"call,omitempty"`
+}
+
+// AuditCall is an AWS call, with what was asked for when it's a change to
+// records.
+type AuditCall struct {
+	APICall
+	Input json.RawMessage `json:"input,omitempty"`
+}
+
+// What goes on each line. The entry stays as the exact bytes that were
+// hashed, so checking doesn't depend on encoding it the same way twice.
+type auditLine struct {
+	Prev  string          `json:"prev"`
+	Entry json.RawMessage `json:"entry"`
+	Hash  string          `json:"hash"`
+	Sig   string          `json:"sig,omitempty"`
+}
+
+func auditHash(prev string, entry []byte) string {
+	h := sha256.New()
+	h.Write([]byte(prev))
+	h.Write([]byte{'\n'})
+	h.Write(entry)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// AuditLog appends to the audit log file.
+type AuditLog struct {
+	path  string
+	key   ed25519.PrivateKey
+	muted bool
+
+	mu sync.Mutex
+}
+
+// The audit log from the config, if there is one. Everything that makes AWS
+// clients or reports events needs it, so like the run report it lives here.
+var auditLog *AuditLog
+
+// NewAuditLog sets up the audit log from the config, nil if there isn't one.
+func NewAuditLog(conf AuditConfig) (*AuditLog, error) {
+	if conf.Path == "" {
+		return nil, nil
+	}
+	a := &AuditLog{path: conf.Path}
+	if conf.Key != "" {
+		key, err := readAuditKey(conf.Key)
+		if err != nil {
+			return nil, err
+		}
+		a.key = key
+	}
+	return a, nil
+}
+
+// Mute stops anything going in the log, for dry runs.
+func (a *AuditLog) Mute() {
+	if a != nil {
+		a.muted = true
+	}
+}
+
+// Decision logs an event.
+func (a *AuditLog) Decision(e Event) {
+	if a == nil {
+		return
+	}
+	a.append(AuditEntry{Time: e.Time, Decision: &e})
+}
+
+// Add a middleware to an AWS client's stack that logs each call. Only
+// changes to records get their input logged, the rest would just be noise
+// or state that's already in the history.
+func (a *AuditLog) trackCalls(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("AuditLog",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			call := AuditCall{APICall: APICall{
+				Service:   awsmiddleware.GetServiceID(ctx),
+				Operation: awsmiddleware.GetOperationName(ctx),
+				Started:   time.Now(),
+			}}
+			if input, ok := in.Parameters.(*route53.ChangeResourceRecordSetsInput); ok {
+				call.Input, _ = json.Marshal(input)
+			}
+			out, md, err := next.HandleInitialize(ctx, in)
+			call.DurationSeconds = time.Since(call.Started).Seconds()
+			if err != nil {
+				call.Error = err.Error()
+			}
+			a.append(AuditEntry{Time: call.Started, Call: &call})
+			return out, md, err
+		}), middleware.Before)
+}
+
+// Losing an audit line is bad but not worth stopping an update over, so
+// failures are logged and that's it. A gap shows up as a broken chain.
+func (a *AuditLog) append(entry AuditEntry) {
+	if a.muted {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	f, err := os.OpenFile(a.path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		log.Printf("Failed to open audit log: %v", err)
+		return
+	}
+	defer f.Close()
+
+	// Another copy could have added to it since we last did, so the chain
+	// picks up from whatever's at the end of the file now
+	last, err := lastAuditLine(f)
+	if err != nil {
+		log.Printf("Failed to read audit log: %v", err)
+		return
+	}
+	entry.Seq = 1
+	if last != nil {
+		var prev AuditEntry
+		json.Unmarshal(last.Entry, &prev)
+		entry.Seq = prev.Seq + 1
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Failed to encode audit entry: %v", err)
+		return
+	}
+	line := auditLine{Entry: data}
+	if last != nil {
+		line.Prev = last.Hash
+	}
+	line.Hash = auditHash(line.Prev, data)
+	if a.key != nil {
+		line.Sig = base64.StdEncoding.EncodeToString(ed25519.Sign(a.key, []byte(line.Hash)))
+	}
+	out, err := json.Marshal(line)
+	if err != nil {
+		log.Printf("Failed to encode audit entry: %v", err)
+		return
+	}
+	if _, err := f.Write(append(out, '\n')); err != nil {
+		log.Printf("Failed to write audit log: %v", err)
+	}
+}
+
+// How far back from the end to look for the last line. Entries are small,
+// a line bigger than this means something other than us wrote it.
+const auditTailSize = 64 * 1024
+
+func lastAuditLine(f *os.File) (*auditLine, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() == 0 {
+		return nil, nil
+	}
+	start := max(info.Size()-auditTailSize, 0)
+	tail := make([]byte, info.Size()-start)
+	if _, err := f.ReadAt(tail, start); err != nil && err != io.EOF {
+		return nil, err
+	}
+	tail = bytes.TrimRight(tail, "\n")
+	if i := bytes.LastIndexByte(tail, '\n'); i >= 0 {
+		tail = tail[i+1:]
+	} else if start > 0 {
+		return nil, errors.New("Last line is too long to be an audit entry")
+	}
+	var line auditLine
+	if err := json.Unmarshal(tail, &line); err != nil {
+		return nil, fmt.Errorf("Last line isn't an audit entry: %v", err)
+	}
+	return &line, nil
+}
+
+// AuditSummary is what checking a log found.
+type AuditSummary struct {
+	Entries  int
+	Signed   bool // every entry had a signature that checked out
+	LastHash string
+}
+
+// VerifyAuditLog checks the chain from the first line to the last, and the
+// signatures if there's a public key to check them with. The error says
+// which line is the first one that's wrong.
+func VerifyAuditLog(r io.Reader, pub ed25519.PublicKey) (AuditSummary, error) {
+	var sum AuditSummary
+	sum.Signed = pub != nil
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), auditTailSize)
+	var seq int64
+	for n := 1; scanner.Scan(); n++ {
+		var line auditLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return sum, fmt.Errorf("Line %d isn't an audit entry: %v", n, err)
+		}
+		if line.Prev != sum.LastHash {
+			return sum, fmt.Errorf("Line %d doesn't follow the line before it, something was changed or taken out", n)
+		}
+		if auditHash(line.Prev, line.Entry) != line.Hash {
+			return sum, fmt.Errorf("Line %d doesn't match its hash, it was changed", n)
+		}
+		if pub != nil {
+			sig, err := base64.StdEncoding.DecodeString(line.Sig)
+			if err != nil || !ed25519.Verify(pub, []byte(line.Hash), sig) {
+				return sum, fmt.Errorf("Line %d isn't signed by the key", n)
+			}
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal(line.Entry, &entry); err != nil {
+			return sum, fmt.Errorf("Line %d has a bad entry: %v", n, err)
+		}
+		if entry.Seq != seq+1 {
+			return sum, fmt.Errorf("Line %d is entry %d, expected %d", n, entry.Seq, seq+1)
+		}
+		seq = entry.Seq
+		sum.Entries++
+		sum.LastHash = line.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		return sum, fmt.Errorf("Failed to read audit log: %v", err)
+	}
+	return sum, nil
+}
+
+// NewAuditKey makes a signing key, returning it PEM encoded along with the
+// public key to check signatures with.
+func NewAuditKey() ([]byte, string, error) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return nil, "", err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, "", err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), base64.StdEncoding.EncodeToString(pub), nil
+}
+
+func readAuditKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read audit key: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s isn't a PEM encoded key", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse audit key: %v", err)
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s isn't an Ed25519 key", path)
+	}
+	return priv, nil
+}
+
+// ParseAuditPublicKey reads a public key the way audit keygen prints it.
+func ParseAuditPublicKey(s string) (ed25519.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(b) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%q isn't an Ed25519 public key", s)
+	}
+	return ed25519.PublicKey(b), nil
+}
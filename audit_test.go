@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Write a few entries to an audit log and return its lines.
+func writeAuditLog(t *testing.T, key string) [][]byte {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	a, err := NewAuditLog(AuditConfig{Path: path, Key: key})
+	if err != nil {
+		t.Fatalf("NewAuditLog: %v", err)
+	}
+	now := time.Now()
+	for _, domain := range []string{"one.example.com", "two.example.com", "three.example.com", "four.example.com"} {
+		a.Decision(Event{Type: EventChange, Time: now, Domain: domain, OldIp: "203.0.113.1", NewIp: "203.0.113.2"})
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.SplitAfter(bytes.TrimRight(data, "\n"), []byte("\n"))
+}
+
+func joinLines(lines ...[]byte) []byte {
+	var out []byte
+	for _, line := range lines {
+		out = append(out, bytes.TrimRight(line, "\n")...)
+		out = append(out, '\n')
+	}
+	return out
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	lines := writeAuditLog(t, "")
+	if len(lines) != 4 {
+		t.Fatalf("Got %d lines, want 4", len(lines))
+	}
+	sum, err := VerifyAuditLog(bytes.NewReader(joinLines(lines...)), nil)
+	if err != nil {
+		t.Fatalf("VerifyAuditLog: %v", err)
+	}
+	if sum.Entries != 4 || sum.Signed {
+		t.Errorf("Got %d entries signed %v, want 4 unsigned", sum.Entries, sum.Signed)
+	}
+
+	edited := bytes.Replace(lines[1], []byte("203.0.113.2"), []byte("198.51.100.9"), 1)
+	tests := []struct {
+		name string
+		log  []byte
+		want string
+	}{
+		{"edited", joinLines(lines[0], edited, lines[2], lines[3]), "Line 2 doesn't match its hash"},
+		{"deleted", joinLines(lines[0], lines[2], lines[3]), "Line 2 doesn't follow"},
+		{"deleted first", joinLines(lines[1], lines[2], lines[3]), "Line 1 doesn't follow"},
+		{"reordered", joinLines(lines[0], lines[2], lines[1], lines[3]), "Line 2 doesn't follow"},
+		{"not an entry", joinLines(lines[0], []byte("hello")), "Line 2 isn't an audit entry"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := VerifyAuditLog(bytes.NewReader(tt.log), nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Got %v, want an error with %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifySignedAuditLog(t *testing.T) {
+	priv, pub, err := NewAuditKey()
+	if err != nil {
+		t.Fatalf("NewAuditKey: %v", err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "audit.key")
+	if err := os.WriteFile(keyPath, priv, 0600); err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParseAuditPublicKey(pub)
+	if err != nil {
+		t.Fatalf("ParseAuditPublicKey: %v", err)
+	}
+
+	lines := writeAuditLog(t, keyPath)
+	sum, err := VerifyAuditLog(bytes.NewReader(joinLines(lines...)), key)
+	if err != nil {
+		t.Fatalf("VerifyAuditLog: %v", err)
+	}
+	if sum.Entries != 4 || !sum.Signed {
+		t.Errorf("Got %d entries signed %v, want 4 signed", sum.Entries, sum.Signed)
+	}
+
+	// The chain checks out without the key, but not against some other key
+	_, other, _ := NewAuditKey()
+	otherKey, _ := ParseAuditPublicKey(other)
+	if _, err := VerifyAuditLog(bytes.NewReader(joinLines(lines...)), otherKey); err == nil || !strings.Contains(err.Error(), "Line 1 isn't signed by the key") {
+		t.Errorf("Got %v checking against another key, want line 1 not signed", err)
+	}
+	unsigned := writeAuditLog(t, "")
+	if _, err := VerifyAuditLog(bytes.NewReader(joinLines(unsigned...)), key); err == nil || !strings.Contains(err.Error(), "isn't signed") {
+		t.Errorf("Got %v for an unsigned log, want it not signed", err)
+	}
+}
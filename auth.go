@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/mikerowehl/route53Update/route53update"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// CredentialStore holds the users allowed to hit the update endpoint. Routers
+// only really know how to do basic auth with a username and password, so
+// that's what this checks, and then each user gets an allowlist of hostnames
+// it's allowed to change.
+type CredentialStore struct {
+	users map[string]*credential
+	// Hash to compare against when the username doesn't exist, so a bad
+	// username takes as long to reject as a bad password.
+	dummy []byte
+}
+
+type credential struct {
+	hash    []byte
+	records map[string]RecordConfig
+}
+
+// NewCredentialStore builds the store from the users in the config. Every
+// password has to already be a bcrypt hash, we never want plain text
+// passwords sitting in the config file.
+func NewCredentialStore(users []UserConfig) (*CredentialStore, error) {
+	dummy, err := bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to set up credential store: %v", err)
+	}
+
+	store := &CredentialStore{
+		users: map[string]*credential{},
+		dummy: dummy,
+	}
+	for _, user := range users {
+		if user.Username == "" {
+			return nil, fmt.Errorf("User entry is missing a username")
+		}
+		if _, exists := store.users[user.Username]; exists {
+			return nil, fmt.Errorf("User %s is listed more than once", user.Username)
+		}
+		if _, err := bcrypt.Cost([]byte(user.Password)); err != nil {
+			return nil, fmt.Errorf("Password for user %s is not a bcrypt hash: %v", user.Username, err)
+		}
+
+		cred := &credential{
+			hash:    []byte(user.Password),
+			records: map[string]RecordConfig{},
+		}
+		for _, rec := range user.Records {
+			cred.records[route53update.NormalizeHostname(rec.Name)] = rec
+		}
+		store.users[user.Username] = cred
+	}
+	return store, nil
+}
+
+// Authenticate checks the username and password, and if they're good returns
+// the records that user is allowed to update keyed by hostname.
+func (s *CredentialStore) Authenticate(username string, password string) (map[string]RecordConfig, bool) {
+	cred, ok := s.users[username]
+	if !ok {
+		bcrypt.CompareHashAndPassword(s.dummy, []byte(password))
+		return nil, false
+	}
+	if err := bcrypt.CompareHashAndPassword(cred.hash, []byte(password)); err != nil {
+		return nil, false
+	}
+	return cred.records, true
+}
+
+// HashPassword makes a bcrypt hash suitable for the password field in the
+// config file.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
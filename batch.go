@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/mikerowehl/route53Update/route53update"
+)
+
+// Updating the apex, www, and the AAAA recs of both used to be a
+// ChangeResourceRecordSets call for each one. Now while a run goes through
+// its domains the changes get queued up, and then each zone's changes go
+// out in one batch. Route53 makes a batch all or nothing, so the records in
+// a zone never end up half updated, and it's a lot fewer calls against the
+// API limits. Whatever happens after a change, reporting it, waiting for
+// it to go in sync, and the post change hooks, waits until its batch is in.
+// A batch goes out after every domain has been checked, which can be a
+// while after any one of them was, so each record gets looked at again
+// right before its batch is sent, and one that someone else changed in the
+// meantime gets left out of it.
+//
+// Follow, uplink, and failover domains already make all their changes in
+// one call, so they go out right away like they always have.
+
+type pendingChange struct {
+	name    string
+	zoneId  string
+	changes []types.Change
+	check   func() error
+	done    func(changeId string, err error) error
+}
+
+type changeBatch struct {
+	mu      sync.Mutex
+	pending []pendingChange
+}
+
+// Make a change to the domain's records, or queue it up if there's a batch
+// going. check says if the change still makes sense, and only gets run for
+// a queued change right before its batch goes out, since otherwise the
+// caller just checked. done gets the change id once it's been made, and
+// what it returns is the result of the change.
+func (u *Updater) submitChange(name string, zoneId string, changes []types.Change, check func() error, done func(changeId string, err error) error) error {
+	if u.batch == nil {
+		out, err := route53update.ChangeRecordSets(u.Client, &route53.ChangeResourceRecordSetsInput{
+			ChangeBatch:  &types.ChangeBatch{Changes: changes, Comment: u.changeComment(name)},
+			HostedZoneId: aws.String(zoneId),
+		})
+		if err != nil {
+			return done("", err)
+		}
+		return done(*out.ChangeInfo.Id, nil)
+	}
+	u.batch.mu.Lock()
+	defer u.batch.mu.Unlock()
+	u.batch.pending = append(u.batch.pending, pendingChange{name: name, zoneId: zoneId, changes: changes, check: check, done: done})
+	fmt.Printf("Queued %d change(s) for %s\n", len(changes), name)
+	return nil
+}
+
+// Send each zone's queued changes in one call, in the order the zones came
+// up. The errors are by domain name. Once the context is done nothing more
+// goes out, the changes still queued fail instead so they get reported.
+func (u *Updater) flushBatch(ctx context.Context, batch *changeBatch) map[string]error {
+	var zones []string
+	byZone := map[string][]pendingChange{}
+	for _, p := range batch.pending {
+		if _, ok := byZone[p.zoneId]; !ok {
+			zones = append(zones, p.zoneId)
+		}
+		byZone[p.zoneId] = append(byZone[p.zoneId], p)
+	}
+
+	errs := map[string]error{}
+	fail := func(name string, err error) {
+		if err != nil {
+			errs[name] = errors.Join(errs[name], err)
+		}
+	}
+	for _, zoneId := range zones {
+		if err := ctx.Err(); err != nil {
+			for _, p := range byZone[zoneId] {
+				fail(p.name, p.done("", fmt.Errorf("Out of time before the change went out: %w", err)))
+			}
+			continue
+		}
+		var pending []pendingChange
+		var changes []types.Change
+		var names []string
+		for _, p := range byZone[zoneId] {
+			if p.check != nil {
+				if err := p.check(); err != nil {
+					fail(p.name, p.done("", err))
+					continue
+				}
+			}
+			pending = append(pending, p)
+			changes = append(changes, p.changes...)
+			names = append(names, p.name)
+		}
+		if len(pending) == 0 {
+			continue
+		}
+		var changeId string
+		out, err := route53update.ChangeRecordSets(u.Client, &route53.ChangeResourceRecordSetsInput{
+			ChangeBatch:  &types.ChangeBatch{Changes: changes, Comment: u.changeComment(names...)},
+			HostedZoneId: aws.String(zoneId),
+		})
+		if err != nil {
+			log.Printf("Failed to change %d records in %s: %v", len(changes), strings.TrimPrefix(zoneId, "/hostedzone/"), err)
+		} else {
+			changeId = *out.ChangeInfo.Id
+			if len(pending) > 1 {
+				fmt.Printf("Changed %d records in one batch. Change: %s\n", len(changes), changeId)
+			}
+		}
+		for _, p := range pending {
+			fail(p.name, p.done(changeId, err))
+		}
+	}
+	return errs
+}
+
+// UpdateAll checks each of the domains the way Update does, but the changes
+// for domains in the same zone are made together. The errors go with the
+// names in order, nil for the ones that worked. When the context is done
+// the domains not checked yet are left alone, and fail with its error.
+func (u *Updater) UpdateAll(ctx context.Context, names []string) []error {
+	errs := make([]error, len(names))
+	index := map[string]int{}
+
+	batch := &changeBatch{}
+	u.batch = batch
+	defer func() { u.batch = nil }()
+
+	// Each domain's lock is held until its changes are in
+	for i, name := range names {
+		if err := ctx.Err(); err != nil {
+			errs[i] = fmt.Errorf("Out of time before checking it: %w", err)
+			continue
+		}
+		if len(names) > 1 {
+			fmt.Printf("Checking %s\n", name)
+		}
+		unlock, err := u.lock(name)
+		if errors.Is(err, errLocked) {
+			// Someone else is checking it right now, which is just as good
+			fmt.Printf("Skipping %s, %v\n", name, err)
+			continue
+		}
+		if err != nil {
+			u.Reporter.Failure(name, u.Source, err)
+			errs[i] = err
+			continue
+		}
+		defer unlock()
+		index[name] = i
+		errs[i] = u.update(name)
+	}
+
+	u.batch = nil
+	for name, err := range u.flushBatch(ctx, batch) {
+		i := index[name]
+		errs[i] = errors.Join(errs[i], err)
+	}
+	return errs
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/mikerowehl/route53Update/route53update"
+)
+
+// How long to wait for Route53 to finish pushing the canary change out.
+const canarySyncTimeout = 5 * time.Minute
+
+// Canary tries a new address out on a throwaway record before the real one
+// gets it. The canary record gets updated, Route53 has to report the change
+// in sync, the zone's own nameservers have to hand back the new address, and
+// if there's a port to check the address has to answer on it. Any of those
+// failing means the real record stays where it is.
+func Canary(client *route53.Client, zoneId string, canary string, rtype types.RRType, ip string, port int) error {
+	fqdn := route53update.NormalizeHostname(canary) + "."
+	change, err := route53update.UpdateRecIp(client, zoneId, fqdn, rtype, ip)
+	if err != nil {
+		return fmt.Errorf("Failed to update canary %s: %v", canary, err)
+	}
+
+	if _, err := route53update.WaitForChange(client, *change.ChangeInfo.Id, canarySyncTimeout); err != nil {
+		return fmt.Errorf("Canary change never went in sync: %v", err)
+	}
+
+	if err := checkAuthoritative(client, zoneId, fqdn, rtype, ip); err != nil {
+		return err
+	}
+
+	if port != 0 {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(route53update.ReachableHost(ip), strconv.Itoa(port)), 10*time.Second)
+		if err != nil {
+			return fmt.Errorf("New address isn't reachable: %v", err)
+		}
+		conn.Close()
+	}
+	return nil
+}
+
+// Ask each of the zone's nameservers directly, no caches in the way, and
+// make sure they all have the new address.
+func checkAuthoritative(client *route53.Client, zoneId string, fqdn string, rtype types.RRType, ip string) error {
+	res, err := client.GetHostedZone(context.TODO(), &route53.GetHostedZoneInput{Id: &zoneId})
+	if err != nil {
+		return fmt.Errorf("Failed to get nameservers for zone: %v", err)
+	}
+	if res.DelegationSet == nil || len(res.DelegationSet.NameServers) == 0 {
+		return fmt.Errorf("Zone doesn't have any nameservers to check")
+	}
+
+	network := "ip4"
+	if rtype == types.RRTypeAaaa {
+		network = "ip6"
+	}
+	for _, ns := range res.DelegationSet.NameServers {
+		resolver := &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, proto string, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, proto, net.JoinHostPort(ns, "53"))
+			},
+		}
+		ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+		ips, err := resolver.LookupIP(ctx, network, fqdn)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("Canary lookup on %s failed: %v", ns, err)
+		}
+		found := false
+		for _, got := range ips {
+			if got.Equal(net.ParseIP(ip)) {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("Nameserver %s doesn't have the new address for the canary yet", ns)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mikerowehl/route53Update/route53update"
+)
+
+// AddressReport is what the sanity checks found out about an address we're
+// about to publish.
+type AddressReport struct {
+	Ptr      string   `json:"ptr,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// AddressChecker runs the configured sanity checks on new addresses. None of
+// them block an update, the point is to make it obvious in the output and the
+// notifications when an address looks off, like the lookup service handing
+// back something that isn't our ISP at all.
+type AddressChecker struct {
+	rdns       bool
+	rdnsExpect *regexp.Regexp
+	dnsbl      []string
+}
+
+// NewAddressChecker sets up the checks from the config. A nil config gives a
+// checker that doesn't check anything.
+func NewAddressChecker(conf *Config) (*AddressChecker, error) {
+	c := &AddressChecker{}
+	if conf == nil {
+		return c, nil
+	}
+	c.rdns = conf.Checks.RDNS.Enabled
+	for _, zone := range conf.Checks.DNSBL {
+		c.dnsbl = append(c.dnsbl, route53update.NormalizeHostname(zone))
+	}
+	if conf.Checks.RDNS.Expect != "" {
+		re, err := regexp.Compile(conf.Checks.RDNS.Expect)
+		if err != nil {
+			return nil, fmt.Errorf("Bad rdns expect pattern: %v", err)
+		}
+		c.rdns = true
+		c.rdnsExpect = re
+	}
+	return c, nil
+}
+
+// Check runs the enabled checks against the address.
+func (c *AddressChecker) Check(ip string) AddressReport {
+	var report AddressReport
+	if c.rdns {
+		c.checkRDNS(ip, &report)
+	}
+	for _, zone := range c.dnsbl {
+		c.checkDNSBL(ip, zone, &report)
+	}
+	for _, w := range report.Warnings {
+		log.Printf("Warning: %s", w)
+	}
+	return report
+}
+
+func (c *AddressChecker) checkRDNS(ip string, report *AddressReport) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	names, err := net.DefaultResolver.LookupAddr(ctx, ip)
+	if err != nil || len(names) == 0 {
+		if c.rdnsExpect != nil {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("No reverse DNS for %s, expected a name matching %s", ip, c.rdnsExpect))
+		}
+		return
+	}
+
+	report.Ptr = strings.TrimSuffix(names[0], ".")
+	if c.rdnsExpect == nil {
+		return
+	}
+	for _, name := range names {
+		if c.rdnsExpect.MatchString(name) {
+			return
+		}
+	}
+	report.Warnings = append(report.Warnings, fmt.Sprintf("Reverse DNS for %s is %s, which doesn't match %s", ip, report.Ptr, c.rdnsExpect))
+}
+
+// Look the address up in a DNS blocklist. Listed addresses resolve to
+// something in 127.0.0.0/8, and unlisted ones don't resolve at all. Spamhaus
+// answers 127.255.255.x when it refuses to answer (like queries through big
+// public resolvers), which isn't a listing so we just mention it.
+func (c *AddressChecker) checkDNSBL(ip string, zone string, report *AddressReport) {
+	query, ok := dnsblQuery(ip, zone)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupHost(ctx, query)
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+			return
+		}
+		log.Printf("Unable to check %s against %s: %v", ip, zone, err)
+		return
+	}
+
+	for _, addr := range addrs {
+		if strings.HasPrefix(addr, "127.255.255.") {
+			log.Printf("Blocklist %s refused to answer for %s (%s)", zone, ip, addr)
+			return
+		}
+	}
+
+	warning := fmt.Sprintf("%s is listed in %s (%s)", ip, zone, strings.Join(addrs, ", "))
+	if txt, err := net.DefaultResolver.LookupTXT(ctx, query); err == nil && len(txt) > 0 {
+		warning += ": " + strings.Join(txt, " ")
+	}
+	report.Warnings = append(report.Warnings, warning)
+}
+
+// Build the blocklist query name, the address reversed under the list zone.
+// IPv4 goes by octets and IPv6 by nibbles, same as reverse DNS.
+func dnsblQuery(ip string, zone string) (string, bool) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", false
+	}
+
+	var labels []string
+	if v4 := parsed.To4(); v4 != nil {
+		for i := len(v4) - 1; i >= 0; i-- {
+			labels = append(labels, fmt.Sprintf("%d", v4[i]))
+		}
+	} else {
+		for i := len(parsed) - 1; i >= 0; i-- {
+			labels = append(labels, fmt.Sprintf("%x", parsed[i]&0xf), fmt.Sprintf("%x", parsed[i]>>4))
+		}
+	}
+	return strings.Join(labels, ".") + "." + zone + ".", true
+}
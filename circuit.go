@@ -0,0 +1,46 @@
+package main
+
+import (
+	"log"
+
+	"github.com/mikerowehl/route53Update/route53update"
+)
+
+// BreakerConfig sets the limits for the circuit breaker in front of every
+// Route53 change, see route53update.CircuitBreaker. A run is one command,
+// one round of checks in watch mode, or an hour of the server. Both are off
+// unless set.
+//
+//	breaker:
+//	  max_failures: 3
+//	  max_changes: 20
+type BreakerConfig struct {
+	MaxFailures int `yaml:"max_failures"`
+	MaxChanges  int `yaml:"max_changes"`
+}
+
+// The breaker from the config, nil if there isn't one. Watch mode resets it
+// every round.
+var changeBreaker *route53update.CircuitBreaker
+
+// Set up the breaker, with a trip going out to the notifiers like a
+// failure would.
+func setupBreaker(conf *Config) {
+	if conf == nil || (conf.Breaker.MaxFailures <= 0 && conf.Breaker.MaxChanges <= 0) {
+		return
+	}
+	changeBreaker = &route53update.CircuitBreaker{
+		MaxFailures: conf.Breaker.MaxFailures,
+		MaxChanges:  conf.Breaker.MaxChanges,
+		OnTrip: func(reason string) {
+			log.Printf("Circuit breaker tripped, not making any more changes: %s", reason)
+			reporter, err := NewReporter(conf)
+			if err != nil {
+				log.Printf("Unable to report the circuit breaker tripping: %v", err)
+				return
+			}
+			reporter.Report(Event{Type: EventBreaker, Error: reason})
+		},
+	}
+	route53update.SetCircuitBreaker(changeBreaker)
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// The client registry can keep everything in a SQLite database instead of
+// a JSON state file:
+//
+//	server:
+//	  registry:
+//	    db: /var/lib/route53update/clients.db
+//
+// Along with when each client last checked in, the database has the clients
+// themselves, so a device added with clients add doesn't need a config
+// change and a restart, and one revoked with clients revoke is locked out
+// on its very next request. Clients listed in the config get copied in when
+// the server starts. Every change a client makes gets recorded too, for
+// clients list -history.
+
+const clientDBSchema = `
+CREATE TABLE IF NOT EXISTS clients (
+	name TEXT PRIMARY KEY,
+	token_sha256 TEXT NOT NULL UNIQUE,
+	hostname TEXT NOT NULL,
+	zone TEXT NOT NULL,
+	created INTEGER NOT NULL,
+	revoked INTEGER,
+	last_seen INTEGER,
+	expired INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS client_hosts (
+	client TEXT NOT NULL,
+	hostname TEXT NOT NULL,
+	ip TEXT NOT NULL,
+	last_seen INTEGER NOT NULL,
+	PRIMARY KEY (client, hostname)
+);
+CREATE TABLE IF NOT EXISTS client_updates (
+	client TEXT NOT NULL,
+	hostname TEXT NOT NULL,
+	old_ip TEXT NOT NULL,
+	new_ip TEXT NOT NULL,
+	time INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS client_updates_by_client ON client_updates (client, time);
+`
+
+type clientDB struct {
+	db *sql.DB
+}
+
+func openClientDB(file string) (*clientDB, error) {
+	db, err := sql.Open("sqlite3", file+"?_busy_timeout=5000")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open %s: %v", file, err)
+	}
+	if _, err := db.Exec(clientDBSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Failed to set up %s: %v", file, err)
+	}
+	return &clientDB{db: db}, nil
+}
+
+// ClientRecord is a client as the database has it.
+type ClientRecord struct {
+	ClientConfig
+	Created  time.Time
+	Revoked  time.Time // zero if it hasn't been
+	LastSeen time.Time // zero if it never checked in
+	Expired  bool
+
+	// The address each hostname was last set to
+	Hostnames map[string]string
+}
+
+// ClientUpdate is one change a client made.
+type ClientUpdate struct {
+	Client   string
+	Hostname string
+	OldIp    string
+	NewIp    string
+	Time     time.Time
+}
+
+func unixTime(t sql.NullInt64) time.Time {
+	if !t.Valid {
+		return time.Time{}
+	}
+	return time.Unix(t.Int64, 0)
+}
+
+// Add a new client, which can't reuse a name or a token.
+func (d *clientDB) add(c ClientConfig) error {
+	_, err := d.db.Exec(`INSERT INTO clients (name, token_sha256, hostname, zone, created) VALUES (?, ?, ?, ?, ?)`,
+		c.Name, c.TokenHash, c.Hostname, c.Zone, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("Failed to add client %s: %v", c.Name, err)
+	}
+	return nil
+}
+
+// Copy in a client from the config, over what the database has for it. A
+// client that's been revoked stays revoked, taking it out of the config
+// doesn't bring it back.
+func (d *clientDB) sync(c ClientConfig) error {
+	_, err := d.db.Exec(`INSERT INTO clients (name, token_sha256, hostname, zone, created) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET token_sha256 = excluded.token_sha256, hostname = excluded.hostname, zone = excluded.zone`,
+		c.Name, c.TokenHash, c.Hostname, c.Zone, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("Failed to save client %s: %v", c.Name, err)
+	}
+	return nil
+}
+
+// The client a token hash belongs to, if it hasn't been revoked.
+func (d *clientDB) lookup(hash string) (ClientConfig, bool, error) {
+	var c ClientConfig
+	err := d.db.QueryRow(`SELECT name, token_sha256, hostname, zone FROM clients WHERE token_sha256 = ? AND revoked IS NULL`, hash).
+		Scan(&c.Name, &c.TokenHash, &c.Hostname, &c.Zone)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ClientConfig{}, false, nil
+	}
+	if err != nil {
+		return ClientConfig{}, false, err
+	}
+	return c, true, nil
+}
+
+func (d *clientDB) client(name string) (ClientConfig, bool, error) {
+	var c ClientConfig
+	err := d.db.QueryRow(`SELECT name, token_sha256, hostname, zone FROM clients WHERE name = ?`, name).
+		Scan(&c.Name, &c.TokenHash, &c.Hostname, &c.Zone)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ClientConfig{}, false, nil
+	}
+	if err != nil {
+		return ClientConfig{}, false, err
+	}
+	return c, true, nil
+}
+
+// Record a check-in. Says whether the client had been marked expired.
+func (d *clientDB) seen(name string, hostname string, ip string, now time.Time) (bool, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	var expired bool
+	if err := tx.QueryRow(`SELECT expired FROM clients WHERE name = ?`, name).Scan(&expired); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(`UPDATE clients SET last_seen = ?, expired = 0 WHERE name = ?`, now.Unix(), name); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(`INSERT INTO client_hosts (client, hostname, ip, last_seen) VALUES (?, ?, ?, ?)
+		ON CONFLICT (client, hostname) DO UPDATE SET ip = excluded.ip, last_seen = excluded.last_seen`,
+		name, hostname, ip, now.Unix()); err != nil {
+		return false, err
+	}
+	return expired, tx.Commit()
+}
+
+func (d *clientDB) updated(u ClientUpdate) error {
+	_, err := d.db.Exec(`INSERT INTO client_updates (client, hostname, old_ip, new_ip, time) VALUES (?, ?, ?, ?, ?)`,
+		u.Client, u.Hostname, u.OldIp, u.NewIp, u.Time.Unix())
+	return err
+}
+
+// The clients that went quiet before the cutoff and haven't been handled
+// yet, marking them handled. Revoked clients don't count.
+func (d *clientDB) expired(cutoff time.Time) (map[string]ClientState, error) {
+	rows, err := d.db.Query(`SELECT name, last_seen FROM clients
+		WHERE expired = 0 AND revoked IS NULL AND last_seen IS NOT NULL AND last_seen < ?`, cutoff.Unix())
+	if err != nil {
+		return nil, err
+	}
+	expired := map[string]ClientState{}
+	for rows.Next() {
+		var name string
+		var lastSeen sql.NullInt64
+		if err := rows.Scan(&name, &lastSeen); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		expired[name] = ClientState{LastSeen: unixTime(lastSeen), Expired: true}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for name, st := range expired {
+		if st.Hostnames, err = d.hostnames(name); err != nil {
+			return nil, err
+		}
+		expired[name] = st
+		if _, err := d.db.Exec(`UPDATE clients SET expired = 1 WHERE name = ?`, name); err != nil {
+			return nil, err
+		}
+	}
+	return expired, nil
+}
+
+func (d *clientDB) hostnames(name string) (map[string]string, error) {
+	rows, err := d.db.Query(`SELECT hostname, ip FROM client_hosts WHERE client = ?`, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hostnames := map[string]string{}
+	for rows.Next() {
+		var hostname, ip string
+		if err := rows.Scan(&hostname, &ip); err != nil {
+			return nil, err
+		}
+		hostnames[hostname] = ip
+	}
+	return hostnames, rows.Err()
+}
+
+// Revoke a client's token. Its records stay put, the way they do when a
+// client expires with expire_action alert.
+func (d *clientDB) revoke(name string) error {
+	res, err := d.db.Exec(`UPDATE clients SET revoked = ? WHERE name = ? AND revoked IS NULL`, time.Now().Unix(), name)
+	if err != nil {
+		return fmt.Errorf("Failed to revoke %s: %v", name, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("No client %s, or it's already revoked", name)
+	}
+	return nil
+}
+
+// Every client, by name.
+func (d *clientDB) list() ([]ClientRecord, error) {
+	rows, err := d.db.Query(`SELECT name, token_sha256, hostname, zone, created, revoked, last_seen, expired FROM clients ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	var clients []ClientRecord
+	for rows.Next() {
+		var c ClientRecord
+		var created int64
+		var revoked, lastSeen sql.NullInt64
+		if err := rows.Scan(&c.Name, &c.TokenHash, &c.Hostname, &c.Zone, &created, &revoked, &lastSeen, &c.Expired); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		c.Created = time.Unix(created, 0)
+		c.Revoked = unixTime(revoked)
+		c.LastSeen = unixTime(lastSeen)
+		clients = append(clients, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range clients {
+		if clients[i].Hostnames, err = d.hostnames(clients[i].Name); err != nil {
+			return nil, err
+		}
+	}
+	return clients, nil
+}
+
+// The last few changes a client made, newest first.
+func (d *clientDB) history(name string, limit int) ([]ClientUpdate, error) {
+	rows, err := d.db.Query(`SELECT client, hostname, old_ip, new_ip, time FROM client_updates
+		WHERE client = ? ORDER BY time DESC LIMIT ?`, name, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var updates []ClientUpdate
+	for rows.Next() {
+		var u ClientUpdate
+		var at int64
+		if err := rows.Scan(&u.Client, &u.Hostname, &u.OldIp, &u.NewIp, &at); err != nil {
+			return nil, err
+		}
+		u.Time = time.Unix(at, 0)
+		updates = append(updates, u)
+	}
+	return updates, rows.Err()
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/mikerowehl/route53Update/route53update"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const cloudDNSAPI = "https://dns.googleapis.com/dns/v1"
+
+// CloudDNSProvider keeps records up to date in Google Cloud DNS. Credentials
+// are a service account key file if the config names one, otherwise the
+// usual application default credentials, so it works the same from a laptop
+// with gcloud auth set up as from a VM with a service account attached.
+//
+// Changes go in as a Cloud DNS change with the old record as a deletion and
+// the new one as an addition. Cloud DNS only applies it if the deletion
+// matches what's there exactly, so it's the conditional update Route53
+// doesn't have.
+type CloudDNSProvider struct {
+	project string
+	client  *http.Client
+
+	mu    sync.Mutex
+	zones map[string]string
+}
+
+func NewCloudDNSProvider(conf GoogleConfig) (*CloudDNSProvider, error) {
+	ctx := context.TODO()
+	scope := "https://www.googleapis.com/auth/ndev.clouddns.readwrite"
+
+	var creds *google.Credentials
+	var err error
+	if conf.CredentialsFile != "" {
+		data, readErr := os.ReadFile(conf.CredentialsFile)
+		if readErr != nil {
+			return nil, fmt.Errorf("Failed to read Google credentials: %v", readErr)
+		}
+		creds, err = google.CredentialsFromJSON(ctx, data, scope)
+	} else {
+		creds, err = google.FindDefaultCredentials(ctx, scope)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load Google credentials: %v", err)
+	}
+
+	project := conf.Project
+	if project == "" {
+		project = creds.ProjectID
+	}
+	if project == "" {
+		return nil, fmt.Errorf("No Google Cloud project set and the credentials don't have one")
+	}
+	return &CloudDNSProvider{
+		project: project,
+		client:  oauth2.NewClient(ctx, creds.TokenSource),
+		zones:   map[string]string{},
+	}, nil
+}
+
+type cloudDNSRecordSet struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	TTL     int64    `json:"ttl"`
+	Rrdatas []string `json:"rrdatas"`
+}
+
+func (c *CloudDNSProvider) GetRecord(domain string, rtype types.RRType) (string, error) {
+	rec, err := c.findRecord(domain, rtype)
+	if err != nil {
+		return "", err
+	}
+	return rec.Rrdatas[0], nil
+}
+
+func (c *CloudDNSProvider) SetRecord(domain string, rtype types.RRType, ip string, ttl int64) error {
+	zone, err := c.zoneFor(domain)
+	if err != nil {
+		return err
+	}
+	var change struct {
+		Additions []cloudDNSRecordSet `json:"additions"`
+		Deletions []cloudDNSRecordSet `json:"deletions,omitempty"`
+	}
+	change.Additions = []cloudDNSRecordSet{{Name: domain + ".", Type: string(rtype), TTL: ttl, Rrdatas: []string{ip}}}
+
+	existing, err := c.findRecord(domain, rtype)
+	switch {
+	case err == nil:
+		change.Deletions = []cloudDNSRecordSet{*existing}
+	case err != route53update.ErrRecordNotFound:
+		return err
+	}
+	return c.call("POST", "/managedZones/"+zone+"/changes", change, nil)
+}
+
+func (c *CloudDNSProvider) findRecord(domain string, rtype types.RRType) (*cloudDNSRecordSet, error) {
+	zone, err := c.zoneFor(domain)
+	if err != nil {
+		return nil, err
+	}
+	var res struct {
+		Rrsets []cloudDNSRecordSet `json:"rrsets"`
+	}
+	q := url.Values{"name": {domain + "."}, "type": {string(rtype)}}
+	if err := c.call("GET", "/managedZones/"+zone+"/rrsets?"+q.Encode(), nil, &res); err != nil {
+		return nil, err
+	}
+	if len(res.Rrsets) == 0 || len(res.Rrsets[0].Rrdatas) == 0 {
+		return nil, route53update.ErrRecordNotFound
+	}
+	return &res.Rrsets[0], nil
+}
+
+// Find the managed zone for a name, trying the name and then each parent.
+// Cloud DNS zones are looked up by DNS name but addressed by their own name.
+func (c *CloudDNSProvider) zoneFor(domain string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if zone, ok := c.zones[domain]; ok {
+		return zone, nil
+	}
+	for name := domain; strings.Contains(name, "."); name = name[strings.Index(name, ".")+1:] {
+		var res struct {
+			ManagedZones []struct {
+				Name       string `json:"name"`
+				Visibility string `json:"visibility"`
+			} `json:"managedZones"`
+		}
+		if err := c.call("GET", "/managedZones?dnsName="+url.QueryEscape(name+"."), nil, &res); err != nil {
+			return "", err
+		}
+		for _, z := range res.ManagedZones {
+			// Private zones with the same name only matter inside a VPC
+			if z.Visibility != "private" {
+				c.zones[domain] = z.Name
+				return z.Name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("Can't find a Cloud DNS zone for %s in %s", domain, c.project)
+}
+
+func (c *CloudDNSProvider) call(method string, path string, body any, result any) error {
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, cloudDNSAPI+"/projects/"+url.PathEscape(c.project)+path, &reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Cloud DNS request failed: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		var apiErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.NewDecoder(res.Body).Decode(&apiErr)
+		return fmt.Errorf("Cloud DNS returned %s: %s", res.Status, apiErr.Error.Message)
+	}
+	if result != nil {
+		return json.NewDecoder(res.Body).Decode(result)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/mikerowehl/route53Update/route53update"
+)
+
+const cloudflareAPI = "https://api.cloudflare.com/client/v4"
+
+// CloudflareProvider keeps records up to date in Cloudflare, using an API
+// token with Zone:Read and DNS:Edit on the zones involved. Domains can say
+// whether their records go through Cloudflare's proxy with proxied:, and
+// without it a record keeps whatever it was set to in the dashboard.
+type CloudflareProvider struct {
+	token   string
+	client  *http.Client
+	proxied map[string]*bool
+
+	mu    sync.Mutex
+	zones map[string]string
+}
+
+func NewCloudflareProvider(token string, domains map[string]DomainConfig) *CloudflareProvider {
+	proxied := map[string]*bool{}
+	for name, d := range domains {
+		proxied[name] = d.Proxied
+	}
+	return &CloudflareProvider{
+		token:   token,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		proxied: proxied,
+		zones:   map[string]string{},
+	}
+}
+
+type cloudflareRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int64  `json:"ttl"`
+	Proxied *bool  `json:"proxied,omitempty"`
+}
+
+func (c *CloudflareProvider) GetRecord(domain string, rtype types.RRType) (string, error) {
+	rec, err := c.findRecord(domain, rtype)
+	if err != nil {
+		return "", err
+	}
+	return rec.Content, nil
+}
+
+func (c *CloudflareProvider) SetRecord(domain string, rtype types.RRType, ip string, ttl int64) error {
+	zone, err := c.zoneFor(domain)
+	if err != nil {
+		return err
+	}
+	rec := cloudflareRecord{Type: string(rtype), Name: domain, Content: ip, TTL: ttl, Proxied: c.proxied[domain]}
+
+	existing, err := c.findRecord(domain, rtype)
+	switch {
+	case err == route53update.ErrRecordNotFound:
+		return c.call("POST", "/zones/"+zone+"/dns_records", rec, nil)
+	case err != nil:
+		return err
+	}
+	if rec.Proxied == nil {
+		rec.Proxied = existing.Proxied
+	}
+	return c.call("PUT", "/zones/"+zone+"/dns_records/"+existing.ID, rec, nil)
+}
+
+func (c *CloudflareProvider) findRecord(domain string, rtype types.RRType) (*cloudflareRecord, error) {
+	zone, err := c.zoneFor(domain)
+	if err != nil {
+		return nil, err
+	}
+	var recs []cloudflareRecord
+	q := url.Values{"type": {string(rtype)}, "name": {domain}}
+	if err := c.call("GET", "/zones/"+zone+"/dns_records?"+q.Encode(), nil, &recs); err != nil {
+		return nil, err
+	}
+	if len(recs) == 0 {
+		return nil, route53update.ErrRecordNotFound
+	}
+	return &recs[0], nil
+}
+
+// Find the zone id for a name, trying the name and then each parent the
+// same way FindHostedZone does for Route53.
+func (c *CloudflareProvider) zoneFor(domain string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if id, ok := c.zones[domain]; ok {
+		return id, nil
+	}
+	for name := domain; strings.Contains(name, "."); name = name[strings.Index(name, ".")+1:] {
+		var zones []struct {
+			ID string `json:"id"`
+		}
+		if err := c.call("GET", "/zones?name="+url.QueryEscape(name), nil, &zones); err != nil {
+			return "", err
+		}
+		if len(zones) > 0 {
+			c.zones[domain] = zones[0].ID
+			return zones[0].ID, nil
+		}
+	}
+	return "", fmt.Errorf("Can't find a Cloudflare zone for %s", domain)
+}
+
+// Make an API call and unpack the result from Cloudflare's envelope.
+func (c *CloudflareProvider) call(method string, path string, body any, result any) error {
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, cloudflareAPI+path, &reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Cloudflare request failed: %v", err)
+	}
+	defer res.Body.Close()
+
+	var envelope struct {
+		Success bool `json:"success"`
+		Errors  []struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&envelope); err != nil {
+		return fmt.Errorf("Cloudflare returned %s and something that isn't JSON", res.Status)
+	}
+	if !envelope.Success {
+		var msgs []string
+		for _, e := range envelope.Errors {
+			msgs = append(msgs, fmt.Sprintf("%d %s", e.Code, e.Message))
+		}
+		return fmt.Errorf("Cloudflare returned %s: %s", res.Status, strings.Join(msgs, ", "))
+	}
+	if result != nil {
+		return json.Unmarshal(envelope.Result, result)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudtrail"
+	"github.com/aws/aws-sdk-go-v2/service/cloudtrail/types"
+	"github.com/mikerowehl/route53Update/route53update"
+)
+
+// Attribution is who CloudTrail says made a change.
+type Attribution struct {
+	Principal string    `json:"principal,omitempty"`
+	SourceIp  string    `json:"source_ip,omitempty"`
+	EventTime time.Time `json:"event_time,omitempty"`
+	EventId   string    `json:"event_id,omitempty"`
+}
+
+// CloudTrailAttributor digs through CloudTrail for the change that touched a
+// record, so a drift alert can say who did it instead of just that it
+// happened. Route53 is a global service and its events all land in the
+// partition's global region (us-east-1 for regular AWS), no matter what
+// region the rest of the config uses.
+type CloudTrailAttributor struct {
+	client *cloudtrail.Client
+}
+
+func NewCloudTrailAttributor(cfg aws.Config, partition Partition) *CloudTrailAttributor {
+	client := cloudtrail.NewFromConfig(cfg, func(o *cloudtrail.Options) {
+		o.Region = partition.GlobalRegion
+	})
+	return &CloudTrailAttributor{client: client}
+}
+
+// The bits of a CloudTrail record we care about.
+type changeRecord struct {
+	UserIdentity struct {
+		Arn string `json:"arn"`
+	} `json:"userIdentity"`
+	SourceIPAddress   string `json:"sourceIPAddress"`
+	RequestParameters struct {
+		ChangeBatch struct {
+			Changes []struct {
+				ResourceRecordSet struct {
+					Name string `json:"name"`
+				} `json:"resourceRecordSet"`
+			} `json:"changes"`
+		} `json:"changeBatch"`
+	} `json:"requestParameters"`
+}
+
+// Attribute finds the most recent ChangeResourceRecordSets call since the
+// given time that touched the domain. Returns nil if there isn't one, which
+// is pretty common since CloudTrail can take a while to deliver events.
+func (c *CloudTrailAttributor) Attribute(domain string, since time.Time) (*Attribution, error) {
+	req := &cloudtrail.LookupEventsInput{
+		LookupAttributes: []types.LookupAttribute{
+			{
+				AttributeKey:   types.LookupAttributeKeyEventName,
+				AttributeValue: aws.String("ChangeResourceRecordSets"),
+			},
+		},
+		StartTime: aws.Time(since),
+		EndTime:   aws.Time(time.Now()),
+	}
+
+	// Events come back newest first, so the first match is the one we want
+	paginator := cloudtrail.NewLookupEventsPaginator(c.client, req)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, fmt.Errorf("CloudTrail lookup failed: %v", err)
+		}
+		for _, ev := range page.Events {
+			var rec changeRecord
+			if err := json.Unmarshal([]byte(aws.ToString(ev.CloudTrailEvent)), &rec); err != nil {
+				continue
+			}
+			for _, change := range rec.RequestParameters.ChangeBatch.Changes {
+				if route53update.NormalizeHostname(change.ResourceRecordSet.Name) != route53update.NormalizeHostname(domain) {
+					continue
+				}
+				return &Attribution{
+					Principal: rec.UserIdentity.Arn,
+					SourceIp:  rec.SourceIPAddress,
+					EventTime: aws.ToTime(ev.EventTime),
+					EventId:   aws.ToString(ev.EventId),
+				}, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (a *Attribution) String() string {
+	var parts []string
+	if a.Principal != "" {
+		parts = append(parts, "by "+a.Principal)
+	}
+	if a.SourceIp != "" {
+		parts = append(parts, "from "+a.SourceIp)
+	}
+	if !a.EventTime.IsZero() {
+		parts = append(parts, "at "+a.EventTime.Format(time.RFC3339))
+	}
+	return strings.Join(parts, " ")
+}
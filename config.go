@@ -0,0 +1,801 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mikerowehl/route53Update/route53update"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is everything that can be set from the YAML config file. The plain
+// command line mode doesn't need a config at all, this is mostly for the
+// longer running modes that have more knobs.
+type Config struct {
+	Server      ServerConfig      `yaml:"server"`
+	History     string            `yaml:"history"`
+	State       string            `yaml:"state"`
+	Locks       string            `yaml:"locks"`
+	RecycleBin  string            `yaml:"recycle_bin"`
+	Notify      NotifyConfig      `yaml:"notify"`
+	GeoIP       GeoIPConfig       `yaml:"geoip"`
+	Checks      ChecksConfig      `yaml:"checks"`
+	Policy      PolicyConfig      `yaml:"policy"`
+	Digest      DigestConfig      `yaml:"digest"`
+	Metrics     MetricsConfig     `yaml:"metrics"`
+	EventBridge EventBridgeConfig `yaml:"eventbridge"`
+	Drift       DriftConfig       `yaml:"drift"`
+	AWS         AWSConfig         `yaml:"aws"`
+	Cloudflare  CloudflareConfig  `yaml:"cloudflare"`
+	Google      GoogleConfig      `yaml:"google"`
+	Propagation PropagationConfig `yaml:"propagation"`
+
+	// Manage the AAAA rec for the domain alongside the A rec
+	IPv6 bool `yaml:"ipv6"`
+
+	// Which records to manage, A, AAAA, both, or auto to do the AAAA rec
+	// whenever there's a public IPv6 address. Empty goes by ipv6 above.
+	Records string `yaml:"records"`
+
+	// For a single record setup, publish just one record, the A rec for 4
+	// or the AAAA rec for 6, falling back to the other family when the
+	// connection doesn't have the preferred one
+	PreferFamily int `yaml:"prefer_family"`
+
+	// Where to find the public addresses: ipify, aws-checkip, interface,
+	// stun, dns, dns-cloudflare, router, which is router:<gateway> for a
+	// router that isn't the default gateway, or one of the ip_endpoints.
+	// Empty is ipify. More than one separated by commas get tried in
+	// order, or with ip_consensus over one, all get asked and that many
+	// have to agree.
+	IPSource    string `yaml:"ip_source"`
+	IPConsensus int    `yaml:"ip_consensus"`
+
+	// What's my IP services of your own, by the name ip_source uses
+	IPEndpoints map[string]IPEndpointConfig `yaml:"ip_endpoints"`
+
+	// The interface the interface source reads, any of them if it's not
+	// set. On its own it means the interface source.
+	IPInterface string `yaml:"ip_interface"`
+
+	// The servers the stun source asks, host or host:port, tried in order.
+	// On their own they mean the stun source.
+	STUNServers []string `yaml:"stun_servers"`
+
+	// Check and report but never change anything in Route53, for trying
+	// things out before handing over write access
+	MonitorOnly bool `yaml:"monitor_only"`
+
+	// Trust the address last seen in a record for this long instead of
+	// reading it again, see addrcache.go. Needs state.
+	CacheMaxAge time.Duration `yaml:"cache_max_age"`
+
+	// Remember which hosted zone each domain is in for this long, so runs
+	// don't have to look it up again, see zones.go. Needs state.
+	ZoneCacheTTL time.Duration `yaml:"zone_cache_ttl"`
+
+	// Settings for particular domains, keyed by domain name
+	Domains map[string]DomainConfig `yaml:"domains"`
+
+	Approval ApprovalConfig `yaml:"approval"`
+
+	Hooks HooksConfig `yaml:"hooks"`
+
+	Maintenance MaintenanceConfig `yaml:"maintenance"`
+
+	TTL TTLConfig `yaml:"ttl"`
+
+	Audit AuditConfig `yaml:"audit"`
+
+	Breaker BreakerConfig `yaml:"breaker"`
+
+	// Share watch between copies with one updating at a time, see
+	// leader.go
+	Leader LeaderConfig `yaml:"leader"`
+
+	Helper HelperConfig `yaml:"helper"`
+
+	// Keep a TXT record with when and by what each record was last
+	// updated at _route53update.<name>
+	Metadata bool `yaml:"metadata"`
+
+	// Refuse to load a config with keys we don't know about, instead of
+	// warning about them and going on, see schema.go
+	Strict bool `yaml:"strict"`
+
+	// Keys for decrypting encrypted values, see secrets.go
+	Secrets SecretsConfig `yaml:"secrets"`
+}
+
+// TTLConfig sets the TTL on the records we manage. Normal defaults to 300.
+// Setting AfterChange turns on a lower TTL right after the address changes,
+// so if it changes again soon resolvers catch up quickly, and then once the
+// address has stayed put for Stable (default 24h) the TTL goes back to
+// normal to save resolvers the lookups. Knowing when the last change was
+// takes the history file.
+type TTLConfig struct {
+	Normal      int64         `yaml:"normal"`
+	AfterChange int64         `yaml:"after_change"`
+	Stable      time.Duration `yaml:"stable"`
+}
+
+// For returns the TTL a record should have, given whether it just changed.
+func (t TTLConfig) For(justChanged bool) int64 {
+	if justChanged && t.AfterChange > 0 {
+		return t.AfterChange
+	}
+	if t.Normal > 0 {
+		return t.Normal
+	}
+	return route53update.DefaultTTL
+}
+
+// MaintenanceConfig limits automatic updates to certain times. A mismatch
+// outside all the windows is reported and left for the first check inside
+// one. No windows means updates can happen any time.
+type MaintenanceConfig struct {
+	Windows []WindowConfig `yaml:"windows"`
+}
+
+// WindowConfig is a time range on some days, in local time. Days are like
+// mon, tue, and default to every day. From and To look like 02:00, and
+// leaving both out means all day, so "never on weekends" is just a window
+// with the days mon through fri.
+type WindowConfig struct {
+	Days []string `yaml:"days"`
+	From string   `yaml:"from"`
+	To   string   `yaml:"to"`
+}
+
+// DomainConfig is per domain settings. Update is auto (the default) to fix a
+// mismatch right away, or notify to just send an alert, with an approval
+// link if approvals are set up.
+//
+// Canary is a record in the same zone, like canary.example.com, that gets
+// the new address first. The real record only gets updated once the canary
+// change is live on the zone's nameservers, and if CanaryPort is set, once
+// the new address answers on that TCP port.
+//
+// Interval is how often watch mode checks the domain, when it should be
+// different from the -interval everything else uses.
+//
+// Uplinks publishes several addresses for the domain as weighted records
+// instead of the one ipify sees, see uplinks.go. With UplinkMode failover
+// it publishes just the first one that's up instead, see failover.go.
+//
+// Provider is where the domain's records live, route53 unless it says
+// otherwise. Proxied turns Cloudflare's proxy on or off for its records.
+//
+// Follow copies the addresses of another name into the domain's records
+// instead of using ours, see follow.go.
+//
+// HealthCheck holds updates while something on this box isn't working, so
+// coming back online in the middle of an outage doesn't send traffic to a
+// broken host. It's tcp://host:port or an http(s) URL that should return a
+// 2xx, like http://localhost:8080/healthz, and a mismatch waits until the
+// check passes.
+type DomainConfig struct {
+	Update      string         `yaml:"update"`
+	Canary      string         `yaml:"canary"`
+	CanaryPort  int            `yaml:"canary_port"`
+	Interval    time.Duration  `yaml:"interval"`
+	Uplinks     []UplinkConfig `yaml:"uplinks"`
+	UplinkMode  string         `yaml:"uplink_mode"`
+	Failover    FailoverConfig `yaml:"failover"`
+	Provider    string         `yaml:"provider"`
+	Proxied     *bool          `yaml:"proxied"`
+	Hooks       HooksConfig    `yaml:"hooks"`
+	Follow      string         `yaml:"follow"`
+	Policy      PolicyConfig   `yaml:"policy"`
+	HealthCheck string         `yaml:"health_check"`
+
+	// Free form labels like owner or ticket, see annotations.go
+	Annotations map[string]string `yaml:"annotations"`
+
+	// The normal TTL for this domain's records, which records to manage
+	// (A, AAAA, both, or auto), and the hosted zone they're in. Without a
+	// zone it's the closest one the domain is part of, so this is only
+	// needed to pick example.com over a www.example.com zone.
+	TTL  int64  `yaml:"ttl"`
+	Type string `yaml:"type"`
+	Zone string `yaml:"zone"`
+
+	// The hosted zone's id, which skips looking the zone up at all. For IAM
+	// policies that only cover the one zone, where ListHostedZonesByName
+	// isn't allowed.
+	ZoneId string `yaml:"zone_id"`
+
+	// Use the private zone with the name instead of the public one, for
+	// split horizon setups updated from inside the VPC. With a vpc_id it's
+	// the private zone associated with that VPC, for when there's more
+	// than one.
+	PrivateZone bool   `yaml:"private_zone"`
+	VPCId       string `yaml:"vpc_id"`
+
+	// Split horizon, for a machine behind NAT that goes by the same name
+	// inside and out. The public zone gets the public address like always,
+	// and the private zone with the same name gets the LAN address, the
+	// first private one on LANInterface, or on any interface if that's not
+	// set. vpc_id picks the private zone if there's more than one.
+	SplitHorizon bool   `yaml:"split_horizon"`
+	LANInterface string `yaml:"lan_interface"`
+}
+
+// HooksConfig is commands to run around a change to a record, see hooks.go.
+type HooksConfig struct {
+	PreChange    []HookConfig `yaml:"pre_change"`
+	PostChange   []HookConfig `yaml:"post_change"`
+	ChangeFailed []HookConfig `yaml:"change_failed"`
+}
+
+func (h HooksConfig) validate(where string) error {
+	for _, hook := range slices.Concat(h.PreChange, h.PostChange, h.ChangeFailed) {
+		if strings.TrimSpace(hook.Command) == "" {
+			return fmt.Errorf("Hooks for %s need a command", where)
+		}
+	}
+	return nil
+}
+
+// HookConfig is one hook command, which gets a minute if Timeout isn't set.
+type HookConfig struct {
+	Command string        `yaml:"command"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// UplinkConfig is one of a site's internet connections. The address is
+// either a fixed IP or looked up through Interface. Check is tcp://host:port
+// or an http(s) URL, with {ip} standing in for the uplink's address, and
+// Weight is its share of clients while healthy, 1 if not set.
+type UplinkConfig struct {
+	Name      string `yaml:"name"`
+	IP        string `yaml:"ip"`
+	Interface string `yaml:"interface"`
+	Check     string `yaml:"check"`
+	Weight    int64  `yaml:"weight"`
+}
+
+// ApprovalConfig lets notify only alerts carry links to approve or reject the
+// update. URL is where the server is reachable, like https://dyn.example.com,
+// and Secret signs the links, so the watcher and server need the same one.
+// Expires is how long the links work, 24h if not set.
+type ApprovalConfig struct {
+	URL     string        `yaml:"url"`
+	Secret  string        `yaml:"secret"`
+	Expires time.Duration `yaml:"expires"`
+}
+
+// AWSConfig is for setups that aren't plain old commercial AWS. Partition is
+// aws, aws-us-gov, or aws-cn, and normally comes from the region, but setting
+// it makes sure everything (CloudTrail, query logs, ARNs) stays in the right
+// partition even if the region isn't set. FIPS switches every AWS call over
+// to the FIPS validated endpoints.
+type AWSConfig struct {
+	Partition string `yaml:"partition"`
+	FIPS      bool   `yaml:"fips"`
+
+	// Profile from the AWS shared config and credentials files to use
+	// instead of the default one
+	Profile string `yaml:"profile"`
+
+	// Use the dual stack endpoints that have IPv6 addresses. Turns on by
+	// itself on hosts that can't reach IPv4 at all.
+	DualStack bool `yaml:"dualstack"`
+}
+
+// DriftConfig controls what happens when a record turns out to have been
+// changed by something other than us. With CloudTrail on, the drift alert
+// includes who made the change and from where, which needs
+// cloudtrail:LookupEvents permission.
+type DriftConfig struct {
+	CloudTrail bool `yaml:"cloudtrail"`
+}
+
+// CloudflareConfig is the API token for domains with provider: cloudflare.
+// The token can be left out of the file and come from CLOUDFLARE_API_TOKEN.
+type CloudflareConfig struct {
+	APIToken string `yaml:"api_token"`
+}
+
+// GoogleConfig is for domains with provider: google, in Cloud DNS. Without a
+// credentials file it uses application default credentials, and without a
+// project it uses the one the credentials belong to. Enabled is needed when
+// neither is set, since there's nothing else saying Cloud DNS is in use.
+type GoogleConfig struct {
+	Enabled         bool   `yaml:"enabled"`
+	Project         string `yaml:"project"`
+	CredentialsFile string `yaml:"credentials_file"`
+}
+
+// PropagationConfig is the resolvers the propagation command checks, as IP
+// addresses, or system for the ones in /etc/resolv.conf.
+type PropagationConfig struct {
+	Resolvers []string `yaml:"resolvers"`
+}
+
+// EventBridgeConfig publishes an event to a bus after each successful record
+// change. Bus is the name or ARN of the event bus, source and detail type
+// default to route53update and "DNS Record Changed" for writing rules.
+type EventBridgeConfig struct {
+	Bus        string `yaml:"bus"`
+	Source     string `yaml:"source"`
+	DetailType string `yaml:"detail_type"`
+}
+
+// MetricsConfig is where run metrics get sent.
+type MetricsConfig struct {
+	Pushgateway PushgatewayConfig `yaml:"pushgateway"`
+}
+
+// PushgatewayConfig points at a Prometheus Pushgateway, like
+// http://pushgateway:9091. Job defaults to route53update and instance to the
+// hostname.
+type PushgatewayConfig struct {
+	URL      string `yaml:"url"`
+	Job      string `yaml:"job"`
+	Instance string `yaml:"instance"`
+}
+
+// DigestConfig turns on a summary notification in watch mode. Period is
+// daily or weekly (weekly goes out on Mondays), and At is the local time of
+// day to send it, defaulting to 08:00. Needs the history file to work from.
+type DigestConfig struct {
+	Period string `yaml:"period"`
+	At     string `yaml:"at"`
+}
+
+// ChecksConfig turns on extra sanity checks of a new address before it gets
+// published. They only ever warn, they don't stop the update. DNSBL is a list
+// of blocklist zones to look the address up in, like zen.spamhaus.org.
+type ChecksConfig struct {
+	RDNS  RDNSCheckConfig `yaml:"rdns"`
+	DNSBL []string        `yaml:"dnsbl"`
+}
+
+// PolicyConfig limits what a record can be changed to, see policy.go. An
+// address outside the CIDRs, a private one with NoPrivate set, or one that
+// doesn't geolocate to one of the Countries gets an alert instead of an
+// update. Countries are two letter codes like US and need a GeoIP database.
+type PolicyConfig struct {
+	CIDRs     []string `yaml:"cidrs"`
+	NoPrivate bool     `yaml:"no_private"`
+	Countries []string `yaml:"countries"`
+}
+
+// RDNSCheckConfig looks up the PTR for a new address. If Expect is set it's a
+// regexp the PTR name should match, like '\.comcast\.net\.$' for a home
+// connection, and we warn when it doesn't.
+type RDNSCheckConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Expect  string `yaml:"expect"`
+}
+
+// NotifyConfig lists where notifications about changes and failures go.
+type NotifyConfig struct {
+	Webhooks []WebhookConfig `yaml:"webhooks"`
+	Email    []EmailConfig   `yaml:"email"`
+	// Heartbeat style monitors that hear about every check, not just the
+	// interesting ones
+	UptimeKuma []UptimeKumaConfig `yaml:"uptime_kuma"`
+}
+
+// UptimeKumaConfig is the push URL from an Uptime Kuma push monitor, like
+// https://kuma.example.com/api/push/abc123. Any query string on it is
+// replaced with the status of each check.
+type UptimeKumaConfig struct {
+	URL string `yaml:"url"`
+}
+
+type WebhookConfig struct {
+	URL   string         `yaml:"url"`
+	Quiet []WindowConfig `yaml:"quiet"`
+
+	// Only these kinds of events and these domains, see NotifyFilter
+	Events  []string `yaml:"events"`
+	Domains []string `yaml:"domains"`
+}
+
+// EmailConfig sends notifications through an SMTP server. Server is
+// host:port, and the username and password can be left out if the server
+// doesn't need them.
+type EmailConfig struct {
+	Server   string   `yaml:"server"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+
+	Quiet []WindowConfig `yaml:"quiet"`
+
+	// Only these kinds of events and these domains, see NotifyFilter
+	Events  []string `yaml:"events"`
+	Domains []string `yaml:"domains"`
+}
+
+// IPEndpointConfig is a service of your own that answers with the address
+// the request came from:
+//
+//	ip_endpoints:
+//	  home:
+//	    url4: https://ip.example.com/v4
+//	    url6: https://ip.example.com/v6
+//	    json_path: data.ip
+//	    headers:
+//	      X-Api-Key: !kms AQICAHh...
+//
+// The answer is the bare address unless json_path or regex say how to find
+// it in there, see route53update.HTTPSourceConfig.
+type IPEndpointConfig struct {
+	URL4     string            `yaml:"url4"`
+	URL6     string            `yaml:"url6"`
+	JSONPath string            `yaml:"json_path"`
+	Regex    string            `yaml:"regex"`
+	Headers  map[string]string `yaml:"headers"`
+	Username string            `yaml:"username"`
+	Password string            `yaml:"password"`
+}
+
+// The ip_endpoints as IP sources. The names can't be ones the built in
+// sources already have.
+func (c *Config) httpSources() ([]route53update.IPSource, error) {
+	if c == nil {
+		return nil, nil
+	}
+	var sources []route53update.IPSource
+	for name, e := range c.IPEndpoints {
+		if slices.Contains(route53update.IPSourceNames(), name) {
+			return nil, fmt.Errorf("ip_endpoints can't have one called %s, that's a built in IP source", name)
+		}
+		s, err := route53update.NewHTTPSource(route53update.HTTPSourceConfig{
+			Name:     name,
+			URL4:     e.URL4,
+			URL6:     e.URL6,
+			JSONPath: e.JSONPath,
+			Regex:    e.Regex,
+			Headers:  e.Headers,
+			Username: e.Username,
+			Password: e.Password,
+		})
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, s)
+	}
+	return sources, nil
+}
+
+// GeoIPConfig points at MaxMind format databases used to add location and
+// network owner info to events. Leave them out to use the GeoLite2 databases
+// from the usual install locations if they're there.
+type GeoIPConfig struct {
+	City string `yaml:"city"`
+	ASN  string `yaml:"asn"`
+}
+
+// ServerConfig controls the DynDNS2 compatible update endpoint.
+type ServerConfig struct {
+	Listen   string         `yaml:"listen"`
+	Users    []UserConfig   `yaml:"users"`
+	Clients  []ClientConfig `yaml:"clients"`
+	Registry RegistryConfig `yaml:"registry"`
+
+	// A line for every request, see accesslog.go
+	AccessLog AccessLogConfig `yaml:"access_log"`
+
+	// Serve HTTPS with a certificate from ACME, see acme.go
+	TLS TLSConfig `yaml:"tls"`
+}
+
+// UserConfig is one set of credentials for the update endpoint. The password
+// is stored as a bcrypt hash (use the hash-password command to make one), and
+// the user can only touch the records listed for it.
+type UserConfig struct {
+	Username string         `yaml:"username"`
+	Password string         `yaml:"password"`
+	Records  []RecordConfig `yaml:"records"`
+}
+
+// ClientConfig is a device that checks in with a token instead of a
+// username and password. The device owns every hostname matching its pattern
+// (like "*.lab.example.com"), and records it creates get a TXT marker so we
+// know who they belong to. Only the sha256 of the token goes in the config,
+// the new-token command makes a token and its hash.
+type ClientConfig struct {
+	Name      string `yaml:"name"`
+	TokenHash string `yaml:"token_sha256"`
+	Hostname  string `yaml:"hostname"`
+	Zone      string `yaml:"zone"`
+}
+
+// RegistryConfig controls where client check-ins are remembered and what
+// happens to a client that stops checking in. ExpireAction is "alert" to just
+// log about it, or "remove" to also delete the records it owns. DB is a
+// SQLite database to keep the clients themselves in as well, in place of
+// the State file, see clientdb.go.
+type RegistryConfig struct {
+	State        string        `yaml:"state"`
+	DB           string        `yaml:"db"`
+	ExpireAfter  time.Duration `yaml:"expire_after"`
+	ExpireAction string        `yaml:"expire_action"`
+}
+
+// RecordConfig maps a hostname onto a record in Route53. If the zone isn't
+// given the record goes in the closest hosted zone the hostname is part of.
+type RecordConfig struct {
+	Name string `yaml:"name"`
+	Zone string `yaml:"zone"`
+}
+
+// ZoneNames lists the hosted zones the users and clients update records in,
+// so they can all be looked up when the server starts.
+func (s ServerConfig) ZoneNames() []string {
+	var names []string
+	for _, u := range s.Users {
+		for _, r := range u.Records {
+			names = append(names, r.ZoneName())
+		}
+	}
+	for _, c := range s.Clients {
+		names = append(names, c.Zone)
+	}
+	return names
+}
+
+// ZoneName returns the name to find the record's hosted zone by, the zone
+// itself if it was given.
+func (r RecordConfig) ZoneName() string {
+	if r.Zone != "" {
+		return route53update.NormalizeHostname(r.Zone)
+	}
+	return route53update.NormalizeHostname(r.Name)
+}
+
+// LoadConfig reads and parses the config file at path. If profile isn't
+// empty, that profile from the profiles section gets laid over the rest of
+// the file first.
+//
+// Profiles let one file cover a few different setups, like home and office.
+// Each one is a chunk of config, and any top level section it has replaces
+// the same section from the main part of the file completely, so a profile
+// with its own domains gets only its own domains. Overrides from -set go on
+// after that, see Overrides.
+//
+//	notify: ...
+//	profiles:
+//	  home:
+//	    domains: ...
+//	  office:
+//	    aws: {profile: office}
+//	    domains: ...
+func LoadConfig(path string, profile string, overrides []string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read config: %v", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("Failed to parse config %s: %v", path, err)
+	}
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		root = doc.Content[0]
+	}
+	if profile != "" {
+		if err := applyProfile(root, profile); err != nil {
+			return nil, err
+		}
+	}
+	for _, o := range overrides {
+		if err := applyOverride(root, o); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := decryptSecrets(root); err != nil {
+		return nil, fmt.Errorf("Failed to decrypt config %s: %v", path, err)
+	}
+
+	cfg := &Config{}
+	if err := root.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("Failed to parse config %s: %v", path, err)
+	}
+	if unknown := unknownConfigKeys(root); len(unknown) > 0 {
+		if cfg.Strict {
+			return nil, fmt.Errorf("Config %s has keys that aren't settings:\n  %s", path, strings.Join(unknown, "\n  "))
+		}
+		for _, u := range unknown {
+			log.Printf("Warning: %s %s, ignoring it", path, u)
+		}
+	}
+	if _, err := ParseTimeWindows(cfg.Maintenance.Windows); err != nil {
+		return nil, fmt.Errorf("Bad maintenance window: %v", err)
+	}
+	if err := expandDomainTemplates(cfg.Domains); err != nil {
+		return nil, err
+	}
+	if err := cfg.Hooks.validate("the top level"); err != nil {
+		return nil, err
+	}
+	if _, err := newPolicy(cfg.Policy); err != nil {
+		return nil, err
+	}
+	for name, d := range cfg.Domains {
+		if err := d.Hooks.validate(name); err != nil {
+			return nil, err
+		}
+		if _, err := newPolicy(d.Policy); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		if !validRecords(d.Type) {
+			return nil, fmt.Errorf("Type for %s must be A, AAAA, both, or auto, not %q", name, d.Type)
+		}
+		if d.TTL < 0 || d.TTL > maxTTL {
+			return nil, fmt.Errorf("TTL for %s must be between 1 and %d", name, maxTTL)
+		}
+		if d.Zone != "" && d.ZoneId != "" {
+			return nil, fmt.Errorf("%s has both a zone and a zone_id, it only needs one", name)
+		}
+		if d.ZoneId != "" && (d.PrivateZone || d.VPCId != "") {
+			return nil, fmt.Errorf("%s has a zone_id, which already says which zone, so private_zone and vpc_id don't do anything", name)
+		}
+		if d.SplitHorizon && (d.ZoneId != "" || d.PrivateZone) {
+			return nil, fmt.Errorf("%s is split horizon, which uses both the public and private zone, so it can't have a zone_id or private_zone", name)
+		}
+		if d.SplitHorizon && (len(d.Uplinks) > 0 || d.Follow != "" || (d.Provider != "" && d.Provider != "route53")) {
+			return nil, fmt.Errorf("%s is split horizon, which only works for plain Route53 domains", name)
+		}
+		if d.Zone != "" && name != route53update.NormalizeHostname(d.Zone) && !strings.HasSuffix(name, "."+route53update.NormalizeHostname(d.Zone)) {
+			return nil, fmt.Errorf("%s isn't in zone %s", name, d.Zone)
+		}
+		if d.Follow != "" && (len(d.Uplinks) > 0 || (d.Provider != "" && d.Provider != "route53") || d.Canary != "") {
+			return nil, fmt.Errorf("%s follows %s, which doesn't work with uplinks, other providers, or a canary", name, d.Follow)
+		}
+	}
+	if !validRecords(cfg.Records) {
+		return nil, fmt.Errorf("Records must be A, AAAA, both, or auto, not %q", cfg.Records)
+	}
+	if cfg.PreferFamily != 0 && cfg.PreferFamily != 4 && cfg.PreferFamily != 6 {
+		return nil, fmt.Errorf("prefer_family must be 4 or 6, not %d", cfg.PreferFamily)
+	}
+	if cfg.PreferFamily != 0 && cfg.Records != "" {
+		return nil, fmt.Errorf("Set either records or prefer_family, not both")
+	}
+	if cfg.Breaker.MaxFailures < 0 || cfg.Breaker.MaxChanges < 0 {
+		return nil, fmt.Errorf("breaker limits can't be negative")
+	}
+	if cfg.CacheMaxAge < 0 {
+		return nil, fmt.Errorf("cache_max_age can't be negative")
+	}
+	if cfg.CacheMaxAge > 0 && cfg.State == "" {
+		return nil, fmt.Errorf("cache_max_age needs a state file to keep the addresses in")
+	}
+	if !validAccessLogFormat(cfg.Server.AccessLog.Format) {
+		return nil, fmt.Errorf("The access log format must be json or combined, not %q", cfg.Server.AccessLog.Format)
+	}
+	if len(cfg.Server.TLS.Hostnames) == 0 && (cfg.Server.TLS.Email != "" || cfg.Server.TLS.Directory != "" || cfg.Server.TLS.Dir != "") {
+		return nil, fmt.Errorf("server tls needs the hostnames to get a certificate for")
+	}
+	if cfg.Leader.TTL < 0 || (cfg.Leader.TTL > 0 && cfg.Leader.TTL < 3*time.Second) {
+		return nil, fmt.Errorf("leader ttl has to be at least 3s")
+	}
+	if cfg.ZoneCacheTTL < 0 {
+		return nil, fmt.Errorf("zone_cache_ttl can't be negative")
+	}
+	if cfg.ZoneCacheTTL > 0 && cfg.State == "" {
+		return nil, fmt.Errorf("zone_cache_ttl needs a state file to keep the zones in")
+	}
+	if cfg.Audit.Key != "" && cfg.Audit.Path == "" {
+		return nil, fmt.Errorf("The audit key needs an audit path to sign")
+	}
+	if cfg.IPConsensus != 0 && cfg.IPSource == "" {
+		return nil, fmt.Errorf("ip_consensus needs a list of sources in ip_source")
+	}
+	custom, err := cfg.httpSources()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ipSourceFrom(cfg.IPSource, cfg.IPConsensus, cfg.IPInterface, cfg.STUNServers, custom); err != nil {
+		return nil, err
+	}
+	if cfg.TTL.Normal < 0 || cfg.TTL.Normal > maxTTL || cfg.TTL.AfterChange < 0 || cfg.TTL.AfterChange > maxTTL {
+		return nil, fmt.Errorf("TTLs must be between 1 and %d", maxTTL)
+	}
+	if cfg.TTL.AfterChange > 0 && cfg.History == "" {
+		return nil, fmt.Errorf("The after change TTL needs a history file to know when the last change was")
+	}
+	if cfg.TTL.Stable == 0 {
+		cfg.TTL.Stable = 24 * time.Hour
+	}
+	for _, w := range cfg.Notify.Webhooks {
+		if _, err := ParseTimeWindows(w.Quiet); err != nil {
+			return nil, fmt.Errorf("Bad quiet hours for webhook %s: %v", w.URL, err)
+		}
+		if _, err := NewNotifyFilter(w.Events, w.Domains); err != nil {
+			return nil, fmt.Errorf("Bad filter for webhook %s: %v", w.URL, err)
+		}
+	}
+	for _, e := range cfg.Notify.Email {
+		if _, err := ParseTimeWindows(e.Quiet); err != nil {
+			return nil, fmt.Errorf("Bad quiet hours for email to %s: %v", strings.Join(e.To, ","), err)
+		}
+		if _, err := NewNotifyFilter(e.Events, e.Domains); err != nil {
+			return nil, fmt.Errorf("Bad filter for email to %s: %v", strings.Join(e.To, ","), err)
+		}
+	}
+	if cfg.Cloudflare.APIToken == "" {
+		cfg.Cloudflare.APIToken = os.Getenv("CLOUDFLARE_API_TOKEN")
+	}
+	for name, d := range cfg.Domains {
+		if d.Update != "" && d.Update != "auto" && d.Update != "notify" {
+			return nil, fmt.Errorf("Update for %s must be auto or notify, not %q", name, d.Update)
+		}
+		if (d.Provider != "" && d.Provider != "route53") && (len(d.Uplinks) > 0 || d.Canary != "") {
+			return nil, fmt.Errorf("%s uses provider %s, uplinks and canaries only work with route53", name, d.Provider)
+		}
+		if d.HealthCheck != "" {
+			if u, err := url.Parse(d.HealthCheck); err != nil || (u.Scheme != "tcp" && u.Scheme != "http" && u.Scheme != "https") {
+				return nil, fmt.Errorf("Health check for %s must be tcp://host:port or an http(s) URL, not %q", name, d.HealthCheck)
+			}
+		}
+		if d.UplinkMode != "" && d.UplinkMode != "weighted" && d.UplinkMode != "failover" {
+			return nil, fmt.Errorf("Uplink mode for %s must be weighted or failover, not %q", name, d.UplinkMode)
+		}
+		if d.Failover.FailAfter < 0 || d.Failover.RecoverAfter < 0 {
+			return nil, fmt.Errorf("Failover counts for %s can't be negative", name)
+		}
+		seen := map[string]bool{}
+		for _, up := range d.Uplinks {
+			if up.Name == "" || seen[up.Name] {
+				return nil, fmt.Errorf("Uplinks for %s each need a different name", name)
+			}
+			seen[up.Name] = true
+			if up.IP == "" && up.Interface == "" {
+				return nil, fmt.Errorf("Uplink %s for %s needs an ip or an interface", up.Name, name)
+			}
+			if up.Weight < 0 || up.Weight > 255 {
+				return nil, fmt.Errorf("Uplink %s for %s has weight %d, it must be 0 to 255", up.Name, name, up.Weight)
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// Lay a profile's sections over the top level of the config.
+func applyProfile(root *yaml.Node, profile string) error {
+	profiles := mappingValue(root, "profiles")
+	if profiles == nil {
+		return fmt.Errorf("Config doesn't have any profiles")
+	}
+	p := mappingValue(profiles, profile)
+	if p == nil || p.Kind != yaml.MappingNode {
+		return fmt.Errorf("Config doesn't have a profile named %s", profile)
+	}
+	for i := 0; i+1 < len(p.Content); i += 2 {
+		key, value := p.Content[i], p.Content[i+1]
+		if existing := mappingValue(root, key.Value); existing != nil {
+			*existing = *value
+		} else {
+			root.Content = append(root.Content, key, value)
+		}
+	}
+	return nil
+}
+
+// Find the value for a key in a YAML mapping, nil if it isn't there.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
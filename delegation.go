@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/mikerowehl/route53Update/route53update"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// A hosted zone only works if the registrar, through the parent zone, hands
+// out the same nameservers Route53 gave the zone. Recreate a zone, or move
+// a domain between accounts, and the new zone gets a new delegation set
+// while the registrar keeps sending everyone to the old one. Nothing
+// errors, updates all go through, and the records just never show up
+// anywhere. So this compares the two and asks each of the zone's
+// nameservers for the zone.
+
+// DelegationCheck is how a zone's delegation stands.
+type DelegationCheck struct {
+	Zone string
+
+	// The nameservers the parent zone says to use, and the ones Route53
+	// gave the hosted zone
+	Registrar []string
+	Route53   []string
+
+	// How each of the zone's nameservers answered, "" if it's fine
+	Servers map[string]string
+}
+
+// CheckDelegation looks up a zone's nameservers both ways and asks each of
+// Route53's for the zone's SOA.
+func CheckDelegation(client *route53.Client, zoneName string) (*DelegationCheck, error) {
+	zoneName = route53update.NormalizeHostname(zoneName)
+	zone, err := route53update.GetHostedZone(client, zoneName+".")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to find zone: %v", err)
+	}
+	res, err := client.GetHostedZone(context.TODO(), &route53.GetHostedZoneInput{Id: zone.Id})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get the zone's delegation set: %v", err)
+	}
+	if res.DelegationSet == nil {
+		return nil, fmt.Errorf("%s has no delegation set, private zones aren't delegated", zoneName)
+	}
+
+	check := &DelegationCheck{Zone: zoneName, Servers: map[string]string{}}
+	for _, ns := range res.DelegationSet.NameServers {
+		check.Route53 = append(check.Route53, normalizeNS(ns))
+	}
+	slices.Sort(check.Route53)
+
+	check.Registrar, err = parentDelegation(zoneName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ns := range check.Route53 {
+		check.Servers[ns] = askForSOA(ns, zoneName)
+	}
+	return check, nil
+}
+
+// The NS records for the zone in its parent, asked of the parent's own
+// servers so no resolver's cache gets in the way. The parent answers with
+// a referral, so the records are in the authority section.
+func parentDelegation(zoneName string) ([]string, error) {
+	_, parent, ok := strings.Cut(zoneName, ".")
+	if !ok {
+		return nil, fmt.Errorf("%s has no parent zone to check", zoneName)
+	}
+	servers, err := AuthoritativeServers(parent)
+	if err != nil {
+		return nil, err
+	}
+	var errs []string
+	for _, server := range servers {
+		res, err := exchangeQuery(server, zoneName, dnsmessage.TypeNS)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", server, err))
+			continue
+		}
+		if res.RCode != dnsmessage.RCodeSuccess {
+			errs = append(errs, fmt.Sprintf("%s: %s", server, rcodeName(res.RCode)))
+			continue
+		}
+		var nss []string
+		for _, rr := range append(res.Answers, res.Authorities...) {
+			body, ok := rr.Body.(*dnsmessage.NSResource)
+			if ok && route53update.NormalizeHostname(rr.Header.Name.String()) == zoneName {
+				nss = append(nss, normalizeNS(body.NS.String()))
+			}
+		}
+		slices.Sort(nss)
+		return slices.Compact(nss), nil
+	}
+	return nil, fmt.Errorf("None of the %s servers answered for %s: %s", parent, zoneName, strings.Join(errs, ", "))
+}
+
+// Ask a nameserver for the zone's SOA, and say what's wrong with the answer
+// if anything is.
+func askForSOA(server string, zoneName string) string {
+	res, err := exchangeQuery(server, zoneName, dnsmessage.TypeSOA)
+	switch {
+	case err != nil:
+		return err.Error()
+	case res.RCode != dnsmessage.RCodeSuccess:
+		return "answered " + rcodeName(res.RCode)
+	case !res.Authoritative:
+		return "isn't authoritative for the zone"
+	}
+	for _, rr := range res.Answers {
+		if _, ok := rr.Body.(*dnsmessage.SOAResource); ok {
+			return ""
+		}
+	}
+	return "has no SOA for the zone"
+}
+
+func normalizeNS(ns string) string {
+	return strings.ToLower(strings.TrimSuffix(ns, "."))
+}
+
+// Problems lists everything wrong with the delegation, nothing if it's all
+// good.
+func (c *DelegationCheck) Problems() []string {
+	var problems []string
+	for _, ns := range c.Registrar {
+		if !slices.Contains(c.Route53, ns) {
+			problems = append(problems, fmt.Sprintf("the registrar delegates to %s, which isn't one of the zone's nameservers", ns))
+		}
+	}
+	for _, ns := range c.Route53 {
+		if !slices.Contains(c.Registrar, ns) {
+			problems = append(problems, fmt.Sprintf("the registrar doesn't delegate to %s", ns))
+		}
+		if msg := c.Servers[ns]; msg != "" {
+			problems = append(problems, fmt.Sprintf("%s %s", ns, msg))
+		}
+	}
+	if len(c.Registrar) == 0 {
+		problems = append(problems, "the parent zone has no NS records for it")
+	}
+	return problems
+}
+
+// Print shows each nameserver, whether the registrar and Route53 have it,
+// and how it answered.
+func (c *DelegationCheck) Print(w io.Writer) {
+	all := slices.Concat(c.Route53, c.Registrar)
+	slices.Sort(all)
+	all = slices.Compact(all)
+	yesNo := map[bool]string{true: "yes", false: "no"}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAMESERVER\tREGISTRAR\tROUTE53\tANSWERS")
+	for _, ns := range all {
+		answers := "-"
+		if msg, ok := c.Servers[ns]; ok {
+			answers = "ok"
+			if msg != "" {
+				answers = msg
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", ns, yesNo[slices.Contains(c.Registrar, ns)], yesNo[slices.Contains(c.Route53, ns)], answers)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Digest sends a summary of what's been happening on a schedule, so there's
+// a low noise way to know the setup is still alive and well even when nothing
+// has changed.
+type Digest struct {
+	conf     DigestConfig
+	history  string
+	reporter *Reporter
+	at       time.Duration
+}
+
+// NewDigest checks the digest config over. Returns nil if digests aren't
+// turned on.
+func NewDigest(conf *Config, reporter *Reporter) (*Digest, error) {
+	if conf == nil || conf.Digest.Period == "" {
+		return nil, nil
+	}
+	if conf.Digest.Period != "daily" && conf.Digest.Period != "weekly" {
+		return nil, fmt.Errorf("Digest period must be daily or weekly, not %q", conf.Digest.Period)
+	}
+	if conf.History == "" {
+		return nil, fmt.Errorf("Digests need a history file to summarize")
+	}
+
+	at := conf.Digest.At
+	if at == "" {
+		at = "08:00"
+	}
+	t, err := time.Parse("15:04", at)
+	if err != nil {
+		return nil, fmt.Errorf("Digest time %q should look like 08:00", at)
+	}
+
+	return &Digest{
+		conf:     conf.Digest,
+		history:  conf.History,
+		reporter: reporter,
+		at:       time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute,
+	}, nil
+}
+
+func (d *Digest) length() time.Duration {
+	if d.conf.Period == "weekly" {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// Next works out when the next digest after now should go out.
+func (d *Digest) Next(now time.Time) time.Time {
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	next := day.Add(d.at)
+	if d.conf.Period == "weekly" {
+		for next.Weekday() != time.Monday {
+			next = next.AddDate(0, 0, 1)
+		}
+	}
+	for !next.After(now) {
+		if d.conf.Period == "weekly" {
+			next = next.AddDate(0, 0, 7)
+		} else {
+			next = next.AddDate(0, 0, 1)
+		}
+	}
+	return next
+}
+
+// Send summarizes the history for the period ending now and sends it out.
+func (d *Digest) Send(now time.Time) error {
+	since := now.Add(-d.length())
+	events, err := ReadHistory(d.history, since)
+	if err != nil {
+		return err
+	}
+	d.reporter.Notify(Event{
+		Time:    now,
+		Type:    EventDigest,
+		Summary: Summarize(events, d.conf.Period, since, now),
+	})
+	return nil
+}
+
+// Summarize turns a set of history events into the text of a digest.
+func Summarize(events []Event, period string, since time.Time, now time.Time) string {
+	checks := 0
+	changes := 0
+	var failures []Event
+	changed := map[string][]string{}
+	for _, e := range events {
+		switch e.Type {
+		case EventNoChange:
+			checks++
+		case EventChange:
+			checks++
+			changes++
+			changed[e.Domain] = append(changed[e.Domain], e.NewIp)
+		case EventFailure:
+			checks++
+			failures = append(failures, e)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "route53Update %s digest, %s to %s\n", period, since.Format("2006-01-02 15:04"), now.Format("2006-01-02 15:04"))
+	fmt.Fprintf(&b, "Checks: %d, changes: %d, failures: %d\n", checks, changes, len(failures))
+
+	domains := make([]string, 0, len(changed))
+	for domain := range changed {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	for _, domain := range domains {
+		fmt.Fprintf(&b, "  %s now %s (changed %d times)\n", domain, changed[domain][len(changed[domain])-1], len(changed[domain]))
+	}
+
+	// The last few failures are plenty to go look at what's wrong
+	if len(failures) > 5 {
+		failures = failures[len(failures)-5:]
+	}
+	for _, e := range failures {
+		fmt.Fprintf(&b, "  %s %s: %s\n", e.Time.Format("01-02 15:04"), e.Domain, e.Error)
+	}
+	if checks == 0 {
+		b.WriteString("No checks ran in this period, is the updater still running?\n")
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
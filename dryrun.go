@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/mikerowehl/route53Update/route53update"
+)
+
+// A dry run does every lookup a real run does and shows the changes it
+// would make as a diff, without making them. It's meant for auditing what
+// would happen from somewhere that only has read access, so nothing gets
+// written anywhere, not the history, not notifications, and not locks.
+
+// StartDryRun turns on dry run mode for the updater.
+func (u *Updater) StartDryRun() {
+	u.DryRun = true
+	u.Reporter.Mute()
+	auditLog.Mute()
+}
+
+// Show the change a real run would make to a record, along with the
+// metadata record if that's turned on.
+func (u *Updater) planChange(zoneId string, name string, current *types.ResourceRecordSet, rtype types.RRType, ip string) {
+	domain := name + "."
+	ttl := u.ttl(name).For(true)
+	printPlannedChange(os.Stdout, current, types.Change{
+		Action: types.ChangeActionUpsert,
+		ResourceRecordSet: &types.ResourceRecordSet{
+			Name:            aws.String(domain),
+			Type:            rtype,
+			TTL:             aws.Int64(ttl),
+			ResourceRecords: []types.ResourceRecord{{Value: aws.String(ip)}},
+		},
+	})
+	if !u.Metadata {
+		return
+	}
+	meta := MetadataChange(domain, time.Now(), u.ttl(name).For(false))
+	currentMeta, err := route53update.GetRecord(u.Client, zoneId, *meta.ResourceRecordSet.Name, types.RRTypeTxt)
+	if err != nil && !errors.Is(err, route53update.ErrRecordNotFound) {
+		fmt.Printf("Unable to read %s: %v\n", *meta.ResourceRecordSet.Name, err)
+	}
+	printPlannedChange(os.Stdout, currentMeta, meta)
+}
+
+// printPlannedChange writes what a change would do to a record, the current
+// record on the - line and what it would be on the + line.
+func printPlannedChange(w io.Writer, current *types.ResourceRecordSet, change types.Change) {
+	rec := change.ResourceRecordSet
+	name := aws.ToString(rec.Name) + " " + string(rec.Type)
+	if rec.SetIdentifier != nil {
+		name += " " + *rec.SetIdentifier
+	}
+	fmt.Fprintf(w, "Dry run, would %s %s\n", change.Action, name)
+	fmt.Fprintf(w, "- %s\n", describeRecordSet(current))
+	fmt.Fprintf(w, "+ %s\n", describeRecordSet(rec))
+}
+
+func describeRecordSet(rec *types.ResourceRecordSet) string {
+	if rec == nil {
+		return "(no record)"
+	}
+	var parts []string
+	if rec.TTL != nil {
+		parts = append(parts, fmt.Sprintf("ttl=%d", *rec.TTL))
+	}
+	if rec.Weight != nil {
+		parts = append(parts, fmt.Sprintf("weight=%d", *rec.Weight))
+	}
+	var values []string
+	for _, r := range rec.ResourceRecords {
+		values = append(values, aws.ToString(r.Value))
+	}
+	return strings.Join(append(parts, strings.Join(values, " ")), " ")
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/mikerowehl/route53Update/route53update"
+)
+
+// Ephemeral hosts register their own name when they boot and remove it when
+// they shut down cleanly. The TXT record next to the address carries both
+// the ownership marker, so one machine can't take over another's name, and
+// an expiry marker. Registering again before the lease runs out is the
+// heartbeat, and a machine that dies without deregistering just lets its
+// lease lapse, at which point the reaper cleans it up like any other
+// expired temporary record.
+
+// RegisterHost creates or refreshes the record for an ephemeral host.
+func RegisterHost(client *route53.Client, zone string, domain string, ip string, owner string, lease time.Duration) error {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return fmt.Errorf("%s isn't an IP address", ip)
+	}
+	rtype := types.RRTypeAaaa
+	if parsed.To4() != nil {
+		rtype = types.RRTypeA
+	}
+
+	_, _, txt, err := GetOwnedRecords(client, zone, domain)
+	if err != nil {
+		return fmt.Errorf("Failed to check existing records: %v", err)
+	}
+	if err := checkOwner(client, zone, domain, txt, owner); err != nil {
+		return err
+	}
+
+	expires := expiresMarkerPrefix + time.Now().Add(lease).UTC().Format(time.RFC3339)
+	ttl := int64(60)
+	changes := []types.Change{
+		{
+			Action: types.ChangeActionUpsert,
+			ResourceRecordSet: &types.ResourceRecordSet{
+				Name:            aws.String(domain),
+				Type:            rtype,
+				ResourceRecords: []types.ResourceRecord{{Value: aws.String(ip)}},
+				TTL:             aws.Int64(ttl),
+			},
+		},
+		{
+			Action: types.ChangeActionUpsert,
+			ResourceRecordSet: &types.ResourceRecordSet{
+				Name:            aws.String(domain),
+				Type:            types.RRTypeTxt,
+				ResourceRecords: WithMarkers(txt, ownerMarker(owner), expires),
+				TTL:             aws.Int64(ttl),
+			},
+		},
+	}
+	_, err = route53update.ChangeRecordSets(client, &route53.ChangeResourceRecordSetsInput{
+		ChangeBatch:  &types.ChangeBatch{Changes: changes},
+		HostedZoneId: aws.String(zone),
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to register: %v", err)
+	}
+	return nil
+}
+
+// DeregisterHost removes an ephemeral host's records, as long as they're
+// still its records.
+func DeregisterHost(client *route53.Client, zone string, domain string, owner string) error {
+	_, _, txt, err := GetOwnedRecords(client, zone, domain)
+	if err != nil {
+		return fmt.Errorf("Failed to check existing records: %v", err)
+	}
+	if txt == nil {
+		return nil
+	}
+	if RecordOwner(txt) != owner {
+		return fmt.Errorf("%s isn't registered to %s", domain, owner)
+	}
+
+	recs := []*types.ResourceRecordSet{txt}
+	for _, rtype := range []types.RRType{types.RRTypeA, types.RRTypeAaaa} {
+		rec, err := route53update.GetRecord(client, zone, domain, rtype)
+		if err == nil {
+			recs = append(recs, rec)
+		}
+	}
+	if _, err := DeleteRecords(client, zone, "deregistered", recs...); err != nil {
+		return fmt.Errorf("Failed to deregister: %v", err)
+	}
+	return nil
+}
+
+// A name can be registered if nothing's there, or if it's already ours. An
+// address record without any marker was made by someone else, leave it be.
+// Other TXT values are fine, they get kept next to our markers.
+func checkOwner(client *route53.Client, zone string, domain string, txt *types.ResourceRecordSet, owner string) error {
+	if current := RecordOwner(txt); current != "" {
+		if current != owner {
+			return fmt.Errorf("%s is registered to %s", domain, current)
+		}
+		return nil
+	}
+	for _, rtype := range []types.RRType{types.RRTypeA, types.RRTypeAaaa} {
+		if _, err := route53update.GetRecord(client, zone, domain, rtype); err == nil {
+			return fmt.Errorf("%s already has a %s record that isn't managed by us", domain, rtype)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+)
+
+// EventBridgePublisher puts an event on an EventBridge bus after every record
+// change, so other automation (security group rules for the home address,
+// WAF allowlists, that kind of thing) can key off DNS updates.
+type EventBridgePublisher struct {
+	client     *eventbridge.Client
+	bus        string
+	source     string
+	detailType string
+}
+
+// NewEventBridgePublisher returns nil if no bus is configured.
+func NewEventBridgePublisher(c *Config) (*EventBridgePublisher, error) {
+	conf := c.EventBridge
+	if conf.Bus == "" {
+		return nil, nil
+	}
+	cfg, _, err := LoadAWSConfig(c)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &EventBridgePublisher{
+		client:     eventbridge.NewFromConfig(cfg),
+		bus:        conf.Bus,
+		source:     conf.Source,
+		detailType: conf.DetailType,
+	}
+	if p.source == "" {
+		p.source = "route53update"
+	}
+	if p.detailType == "" {
+		p.detailType = "DNS Record Changed"
+	}
+	return p, nil
+}
+
+// Publish sends the event as the detail of an EventBridge event.
+func (p *EventBridgePublisher) Publish(e Event) error {
+	detail, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	res, err := p.client.PutEvents(context.TODO(), &eventbridge.PutEventsInput{
+		Entries: []types.PutEventsRequestEntry{
+			{
+				EventBusName: aws.String(p.bus),
+				Source:       aws.String(p.source),
+				DetailType:   aws.String(p.detailType),
+				Detail:       aws.String(string(detail)),
+				Time:         aws.Time(e.Time),
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("EventBridge put failed: %v", err)
+	}
+	// PutEvents can succeed as a call and still fail the entry
+	if res.FailedEntryCount > 0 && len(res.Entries) > 0 {
+		return fmt.Errorf("EventBridge rejected event: %s %s", aws.ToString(res.Entries[0].ErrorCode), aws.ToString(res.Entries[0].ErrorMessage))
+	}
+	return nil
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// The kinds of things that happen that are worth remembering or telling
+// someone about.
+const (
+	EventChange        = "change"
+	EventNoChange      = "nochg"
+	EventFailure       = "failure"
+	EventClientExpired = "expired"
+	EventDigest        = "digest"
+	EventDrift         = "drift"
+	EventMismatch      = "mismatch"
+	EventRejected      = "rejected"
+	EventDelegation    = "delegation"
+	EventBreaker       = "breaker"
+)
+
+// Event is one thing that happened to a record. Every event goes into the
+// history file, and the interesting ones get sent out as notifications too.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	Domain  string    `json:"domain"`
+	Record  string    `json:"record,omitempty"` // AAAA, empty for the A rec
+	Source  string    `json:"source,omitempty"`
+	OldIp   string    `json:"old_ip,omitempty"`
+	NewIp   string    `json:"new_ip,omitempty"`
+	OldGeo  *GeoInfo  `json:"old_geo,omitempty"`
+	NewGeo  *GeoInfo  `json:"new_geo,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Summary string    `json:"summary,omitempty"`
+
+	// Who made an outside change to the record, for drift events
+	ChangedBy *Attribution `json:"changed_by,omitempty"`
+
+	// Links to approve or reject a mismatch for a notify only domain
+	ApproveURL string `json:"approve_url,omitempty"`
+	RejectURL  string `json:"reject_url,omitempty"`
+
+	// The TTL a change went out with, and how long Route53 took to report
+	// it in sync, when the update waited to find out
+	TTL         int64   `json:"ttl,omitempty"`
+	Propagation float64 `json:"propagation_seconds,omitempty"`
+
+	// The domain's annotations from the config
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	AddressReport
+}
+
+// Message is the human readable version of the event used in notifications.
+func (e Event) Message() string {
+	msg := e.summary()
+	if e.Ptr != "" {
+		msg += fmt.Sprintf(" [rDNS %s]", e.Ptr)
+	}
+	for _, w := range e.Warnings {
+		msg += "\nWarning: " + w
+	}
+	if e.ApproveURL != "" {
+		msg += "\nApprove: " + e.ApproveURL
+	}
+	if e.RejectURL != "" {
+		msg += "\nReject: " + e.RejectURL
+	}
+	if len(e.Annotations) > 0 {
+		msg += "\n" + formatAnnotations(e.Annotations)
+	}
+	return msg
+}
+
+func (e Event) summary() string {
+	name := e.Domain
+	if e.Record != "" {
+		name += " " + e.Record
+	}
+	switch e.Type {
+	case EventChange:
+		return fmt.Sprintf("%s changed from %s to %s", name, describeIp(e.OldIp, e.OldGeo), describeIp(e.NewIp, e.NewGeo))
+	case EventNoChange:
+		return fmt.Sprintf("%s already points at %s", name, describeIp(e.NewIp, e.NewGeo))
+	case EventClientExpired:
+		return fmt.Sprintf("%s: %s", name, e.Error)
+	case EventDigest:
+		return e.Summary
+	case EventMismatch:
+		msg := fmt.Sprintf("%s points at %s but the current address is %s, not updated", name, describeIp(e.OldIp, e.OldGeo), describeIp(e.NewIp, e.NewGeo))
+		if e.Summary != "" {
+			msg += " (" + e.Summary + ")"
+		}
+		return msg
+	case EventRejected:
+		return fmt.Sprintf("%s change to %s was rejected", name, describeIp(e.NewIp, e.NewGeo))
+	case EventBreaker:
+		return "Stopped making Route53 changes: " + e.Error
+	case EventDelegation:
+		return fmt.Sprintf("%s isn't delegated right: %s", name, e.Error)
+	case EventDrift:
+		msg := fmt.Sprintf("%s was changed outside route53Update from %s to %s", name, describeIp(e.OldIp, e.OldGeo), describeIp(e.NewIp, e.NewGeo))
+		if e.ChangedBy != nil {
+			msg += " " + e.ChangedBy.String()
+		}
+		return msg
+	default:
+		return fmt.Sprintf("%s update failed: %s", name, e.Error)
+	}
+}
+
+func describeIp(ip string, geo *GeoInfo) string {
+	if ip == "" {
+		ip = "nothing"
+	}
+	if geo == nil || geo.String() == "" {
+		return ip
+	}
+	return fmt.Sprintf("%s (%s)", ip, geo)
+}
+
+// Reporter takes events from wherever they happen, fills in the extra info
+// we can figure out locally, and sends them on to the history file and
+// notifiers.
+type Reporter struct {
+	history    string
+	notifiers  []Notifier
+	heartbeats []Notifier
+	metrics    *Pushgateway
+	bus        *EventBridgePublisher
+	geo        *GeoLookup
+	watchers   []func(Event)
+
+	// Annotations for each domain, which go along with its events
+	annotations map[string]map[string]string
+
+	// Muted for dry runs, see Mute
+	muted bool
+
+	mu sync.Mutex
+}
+
+// NewReporter sets up reporting from the config. A nil config just gives a
+// reporter that doesn't do anything, which keeps the callers simple.
+func NewReporter(conf *Config) (*Reporter, error) {
+	r := &Reporter{}
+	if conf == nil {
+		return r, nil
+	}
+	r.history = conf.History
+	r.notifiers = NewNotifiers(conf.Notify)
+	r.heartbeats = NewHeartbeats(conf.Notify)
+	r.metrics = NewPushgateway(conf.Metrics.Pushgateway)
+	r.geo = NewGeoLookup(conf.GeoIP)
+	for name, d := range conf.Domains {
+		if len(d.Annotations) > 0 {
+			if r.annotations == nil {
+				r.annotations = map[string]map[string]string{}
+			}
+			r.annotations[name] = d.Annotations
+		}
+	}
+
+	bus, err := NewEventBridgePublisher(conf)
+	if err != nil {
+		return nil, err
+	}
+	r.bus = bus
+	return r, nil
+}
+
+// Report records an event and notifies about it if it's worth a notification.
+func (r *Reporter) Report(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if r.geo != nil {
+		e.OldGeo = r.geo.Lookup(e.OldIp)
+		e.NewGeo = r.geo.Lookup(e.NewIp)
+	}
+	if e.Annotations == nil {
+		e.Annotations = r.annotations[e.Domain]
+	}
+
+	if currentRun != nil {
+		currentRun.addEvent(e)
+	}
+	for _, w := range r.watchers {
+		w(e)
+	}
+	if r.muted {
+		return
+	}
+	r.appendHistory(e)
+	auditLog.Decision(e)
+
+	if e.Type == EventChange && r.bus != nil {
+		if err := r.bus.Publish(e); err != nil {
+			log.Printf("Failed to publish change event: %v", err)
+		}
+	}
+
+	// Heartbeat monitors want to hear about every check, so they can tell
+	// the difference between nothing changing and nothing running
+	if e.Type == EventChange || e.Type == EventNoChange || e.Type == EventFailure || e.Type == EventMismatch {
+		if r.metrics != nil {
+			r.metrics.Record(e)
+		}
+		for _, h := range r.heartbeats {
+			if err := h.Notify(e); err != nil {
+				log.Printf("Failed to send heartbeat: %v", err)
+			}
+		}
+	}
+
+	// Nobody wants a notification every time nothing happened
+	if e.Type == EventNoChange {
+		return
+	}
+	r.Notify(e)
+}
+
+// Mute stops events from going into the history or out to anyone, they
+// only go to the run report and watchers. The history still gets read.
+func (r *Reporter) Mute() {
+	r.muted = true
+}
+
+// Watch has every event reported from now on passed to fn as well, for
+// things like the tui that show what's going on. Set up watchers before
+// anything starts reporting.
+func (r *Reporter) Watch(fn func(Event)) {
+	r.watchers = append(r.watchers, fn)
+}
+
+// Notify sends an event to the notifiers without putting it in the history,
+// for things like digests that are about the history rather than part of it.
+func (r *Reporter) Notify(e Event) {
+	if r.muted {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	for _, n := range r.notifiers {
+		if err := n.Notify(e); err != nil {
+			log.Printf("Failed to send notification: %v", err)
+		}
+	}
+}
+
+// PushMetrics sends the metrics for the run so far, if there's anywhere to
+// send them. Call it at the end of each run.
+func (r *Reporter) PushMetrics() {
+	if r.metrics == nil || r.muted {
+		return
+	}
+	if err := r.metrics.Push(); err != nil {
+		log.Printf("Failed to push metrics: %v", err)
+	}
+}
+
+// LastKnown finds the most recent event in the history that tells us what
+// the record for a domain held. Record is the type, empty for the A rec.
+func (r *Reporter) LastKnown(domain string, record string) (Event, bool) {
+	return r.lastEvent(domain, record, EventChange, EventNoChange, EventDrift)
+}
+
+// Rejected says if someone turned down changing the record to ip since the
+// given time.
+func (r *Reporter) Rejected(domain string, record string, ip string, since time.Time) bool {
+	e, ok := r.lastEvent(domain, record, EventRejected, EventChange)
+	return ok && e.Type == EventRejected && e.NewIp == ip && e.Time.After(since)
+}
+
+// LastChange finds the last time we changed the record.
+func (r *Reporter) LastChange(domain string, record string) (Event, bool) {
+	return r.lastEvent(domain, record, EventChange)
+}
+
+func (r *Reporter) lastEvent(domain string, record string, types ...string) (Event, bool) {
+	if r.history == "" {
+		return Event{}, false
+	}
+	events, err := ReadHistory(r.history, time.Time{})
+	if err != nil {
+		return Event{}, false
+	}
+	for i := len(events) - 1; i >= 0; i-- {
+		e := events[i]
+		if e.Domain != domain || e.Record != record {
+			continue
+		}
+		if slices.Contains(types, e.Type) {
+			return e, true
+		}
+	}
+	return Event{}, false
+}
+
+// Failure is a shortcut for reporting an error for a domain.
+func (r *Reporter) Failure(domain string, source string, err error) {
+	r.Report(Event{
+		Type:   EventFailure,
+		Domain: domain,
+		Source: source,
+		Error:  err.Error(),
+	})
+}
+
+// History is just one JSON encoded event per line, easy to append to and easy
+// to pick apart with other tools.
+func (r *Reporter) appendHistory(e Event) {
+	if r.history == "" {
+		return
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("Failed to encode history entry: %v", err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := appendState(r.history, append(data, '\n')); err != nil {
+		log.Printf("Failed to write history entry: %v", err)
+	}
+}
+
+// ReadHistory loads the events from the history file that happened at or
+// after since. Lines that don't parse are skipped, a half written line at the
+// end of the file after a crash shouldn't make the whole history unreadable.
+func ReadHistory(path string, since time.Time) ([]Event, error) {
+	data, _, err := readState(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open history: %v", err)
+	}
+
+	var events []Event
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if e.Time.Before(since) {
+			continue
+		}
+		events = append(events, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to read history: %v", err)
+	}
+	return events, nil
+}
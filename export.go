@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The history file is fine for the tool itself but not much fun to poke at
+// by hand. Export turns it into CSV for a spreadsheet or a plain JSON array
+// for anything else, with the columns flattened out so nobody has to dig
+// through nested geo and report objects.
+
+var exportColumns = []string{
+	"time", "type", "domain", "record", "source", "old_ip", "new_ip",
+	"ttl", "propagation_seconds", "error", "summary",
+}
+
+type exportRow struct {
+	Time        time.Time `json:"time"`
+	Type        string    `json:"type"`
+	Domain      string    `json:"domain"`
+	Record      string    `json:"record"`
+	Source      string    `json:"source,omitempty"`
+	OldIp       string    `json:"old_ip,omitempty"`
+	NewIp       string    `json:"new_ip,omitempty"`
+	TTL         int64     `json:"ttl,omitempty"`
+	Propagation float64   `json:"propagation_seconds,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Summary     string    `json:"summary,omitempty"`
+}
+
+func exportRowFor(e Event) exportRow {
+	record := e.Record
+	if record == "" {
+		record = "A"
+	}
+	return exportRow{
+		Time: e.Time, Type: e.Type, Domain: e.Domain, Record: record, Source: e.Source,
+		OldIp: e.OldIp, NewIp: e.NewIp, TTL: e.TTL, Propagation: e.Propagation,
+		Error: e.Error, Summary: e.Summary,
+	}
+}
+
+// ExportHistory writes events as csv or json.
+func ExportHistory(w io.Writer, events []Event, format string) error {
+	switch format {
+	case "json":
+		rows := []exportRow{}
+		for _, e := range events {
+			rows = append(rows, exportRowFor(e))
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(exportColumns)
+		for _, e := range events {
+			r := exportRowFor(e)
+			ttl, propagation := "", ""
+			if r.TTL > 0 {
+				ttl = strconv.FormatInt(r.TTL, 10)
+			}
+			if r.Propagation > 0 {
+				propagation = strconv.FormatFloat(r.Propagation, 'f', 1, 64)
+			}
+			cw.Write([]string{
+				r.Time.UTC().Format(time.RFC3339), r.Type, r.Domain, r.Record, r.Source,
+				r.OldIp, r.NewIp, ttl, propagation, r.Error, r.Summary,
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("Unknown export format %s, use csv or json", format)
+}
+
+// ParseSince takes how far back to go, like 90d, 12h, or 2006-01-02.
+func ParseSince(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return time.Time{}, fmt.Errorf("%q isn't a number of days", s)
+		}
+		return now.AddDate(0, 0, -n), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q should look like 90d, 12h, or 2006-01-02", s)
+	}
+	return now.Add(-d), nil
+}
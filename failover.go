@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/mikerowehl/route53Update/route53update"
+)
+
+// Weighted records spread clients over every uplink that works, but plenty
+// of dual WAN sites have a main line and a backup that's slow or metered,
+// and only want the backup used when the main line is down. In failover
+// mode the domain gets a plain A record with the address of one uplink, the
+// first one in the list that's up:
+//
+//	domains:
+//	  home.example.com:
+//	    uplink_mode: failover
+//	    failover:
+//	      fail_after: 2
+//	      recover_after: 5
+//	    uplinks:
+//	      - name: fiber
+//	        interface: eth0
+//	        check: tcp://{ip}:443
+//	      - name: lte
+//	        interface: wwan0
+//
+// Flapping lines would have the record bouncing back and forth, so it only
+// moves off an uplink after fail_after checks in a row fail, and only moves
+// back to one higher up the list after recover_after checks in a row pass.
+// Moving down the list to a backup happens as soon as the backup is up.
+// Failing over and back both get notified like any other change.
+
+// FailoverConfig is how many checks in a row it takes to move the record.
+type FailoverConfig struct {
+	FailAfter    int `yaml:"fail_after"`
+	RecoverAfter int `yaml:"recover_after"`
+}
+
+const (
+	defaultFailAfter    = 2
+	defaultRecoverAfter = 3
+)
+
+func (f FailoverConfig) failAfter() int {
+	if f.FailAfter > 0 {
+		return f.FailAfter
+	}
+	return defaultFailAfter
+}
+
+func (f FailoverConfig) recoverAfter() int {
+	if f.RecoverAfter > 0 {
+		return f.RecoverAfter
+	}
+	return defaultRecoverAfter
+}
+
+// Pick the uplink the record should point at, given the one it points at
+// now and each uplink's streak: how many checks in a row it's passed, or
+// failed if negative. -1 if there's nothing to pick.
+func chooseUplink(states []uplinkState, active int, streaks map[string]int, conf FailoverConfig) int {
+	for i, st := range states {
+		streak := streaks[st.name]
+		switch {
+		case st.ip == "":
+			continue
+		case i == active:
+			if streak > -conf.failAfter() {
+				return i
+			}
+		case active >= 0 && i < active:
+			if streak >= conf.recoverAfter() {
+				return i
+			}
+		case st.healthy:
+			return i
+		}
+	}
+	// Nothing better, so stay put even if it's down
+	return active
+}
+
+// Add this check's results to each uplink's streak. The streaks live in the
+// state file if there is one, so they carry over between cron runs, and just
+// in memory otherwise.
+func (u *Updater) updateStreaks(name string, states []uplinkState) map[string]int {
+	bump := func(streaks map[string]int) map[string]int {
+		next := map[string]int{}
+		for _, st := range states {
+			key := name + " " + st.name
+			if st.healthy {
+				next[key] = max(streaks[key], 0) + 1
+			} else {
+				next[key] = min(streaks[key], 0) - 1
+			}
+		}
+		return next
+	}
+
+	var updated map[string]int
+	if u.State != "" && !u.DryRun {
+		err := UpdateRuntimeState(u.State, func(st *RuntimeState) error {
+			updated = bump(st.UplinkStreaks)
+			if st.UplinkStreaks == nil {
+				st.UplinkStreaks = map[string]int{}
+			}
+			for k, v := range updated {
+				st.UplinkStreaks[k] = v
+			}
+			return nil
+		})
+		if err == nil {
+			return byUplink(name, updated)
+		}
+		log.Printf("Failed to save uplink streaks: %v", err)
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	updated = bump(u.streaks)
+	if u.streaks == nil {
+		u.streaks = map[string]int{}
+	}
+	for k, v := range updated {
+		u.streaks[k] = v
+	}
+	return byUplink(name, updated)
+}
+
+// Streaks are kept by domain and uplink, this is just the domain's.
+func byUplink(name string, streaks map[string]int) map[string]int {
+	out := map[string]int{}
+	for k, v := range streaks {
+		out[k[len(name)+1:]] = v
+	}
+	return out
+}
+
+// Point the domain's A record at the uplink that should have it.
+func (u *Updater) updateFailover(name string, states []uplinkState) error {
+	domain := name + "."
+	conf := u.Domains[name].Failover
+	fail := func(err error) {
+		u.Reporter.Report(Event{Type: EventFailure, Domain: name, Source: u.Source, Error: err.Error()})
+	}
+
+	zone, err := u.zone(name)
+	if err != nil {
+		fail(err)
+		return fmt.Errorf("Failed to find zone: %w", err)
+	}
+	rec, err := route53update.GetRecord(u.Client, *zone.Id, domain, types.RRTypeA)
+	if err != nil && !errors.Is(err, route53update.ErrRecordNotFound) {
+		fail(err)
+		return fmt.Errorf("Error trying to check configured ip: %w", err)
+	}
+	var configuredIp string
+	if rec != nil {
+		if configuredIp, err = route53update.RecordIp(rec); err != nil {
+			fail(err)
+			return fmt.Errorf("Error trying to check configured ip: %w", err)
+		}
+	}
+	active := -1
+	for i, st := range states {
+		if st.ip != "" && st.ip == configuredIp {
+			active = i
+		}
+	}
+
+	streaks := u.updateStreaks(name, states)
+	chosen := chooseUplink(states, active, streaks, conf)
+	if chosen < 0 {
+		err := fmt.Errorf("No uplink is up")
+		fail(err)
+		return err
+	}
+	to := states[chosen]
+	if chosen == active {
+		u.newMismatch(name, "")
+		u.Reporter.Report(Event{Type: EventNoChange, Domain: name, Source: u.Source, OldIp: configuredIp, NewIp: to.ip, Summary: "on uplink " + to.name})
+		if !to.healthy {
+			fmt.Printf("Staying on uplink %s, it's down but nothing else is up\n", to.name)
+		} else {
+			fmt.Printf("Staying on uplink %s, done\n", to.name)
+		}
+		return nil
+	}
+
+	summary := "failover to " + to.name
+	if active >= 0 {
+		summary = fmt.Sprintf("failover from %s to %s", states[active].name, to.name)
+		if chosen < active {
+			summary = fmt.Sprintf("failback from %s to %s", states[active].name, to.name)
+		}
+	}
+	fmt.Printf("Moving %s: %s\n", name, summary)
+
+	if u.DryRun {
+		printPlannedChange(os.Stdout, rec, types.Change{
+			Action: types.ChangeActionUpsert,
+			ResourceRecordSet: &types.ResourceRecordSet{
+				Name:            aws.String(domain),
+				Type:            types.RRTypeA,
+				TTL:             aws.Int64(u.ttl(name).For(true)),
+				ResourceRecords: []types.ResourceRecord{{Value: aws.String(to.ip)}},
+			},
+		})
+		return nil
+	}
+	if held := u.heldBecause(name); held != "" {
+		fmt.Printf("Not moving: %s\n", held)
+		if u.newMismatch(name, to.ip) {
+			u.Reporter.Report(Event{Type: EventMismatch, Domain: name, Source: u.Source, OldIp: configuredIp, NewIp: to.ip, Summary: summary + ", " + held})
+		}
+		return nil
+	}
+
+	change, err := route53update.ChangeRecordSets(u.Client, &route53.ChangeResourceRecordSetsInput{
+		ChangeBatch: &types.ChangeBatch{
+			Changes: []types.Change{route53update.AddressChange(domain, types.RRTypeA, to.ip, u.ttl(name).For(true))},
+			Comment: u.changeComment(name),
+		},
+		HostedZoneId: zone.Id,
+	})
+	if err != nil {
+		fail(err)
+		return fmt.Errorf("Error trying to update A record: %w", err)
+	}
+	u.Reporter.Report(Event{Type: EventChange, Domain: name, Source: u.Source, OldIp: configuredIp, NewIp: to.ip, TTL: u.ttl(name).For(true), Summary: summary})
+	fmt.Printf("Updated A. Change: %s\n", *change.ChangeInfo.Id)
+	return u.waitForSync(*change.ChangeInfo.Id)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/mikerowehl/route53Update/route53update"
+)
+
+// The apex of a zone can't be a CNAME, and Route53 aliases only point at
+// things inside AWS. So for an apex that should go wherever some other
+// dynamic name goes, like a home router's own dyndns name from the ISP, a
+// domain can follow that name instead of using our own address. Each check
+// looks the target up and copies its addresses into the domain's records,
+// which is the same thing CNAME flattening does:
+//
+//	domains:
+//	  example.com:
+//	    follow: myrouter.isp-dyndns.net
+//
+// Both the A and AAAA recs get copied unless the domain's type says just
+// one, and a family the target doesn't have gets removed, so the records
+// always match the target.
+
+// How long to give the lookup of the target.
+const followTimeout = 10 * time.Second
+
+// Look up the addresses of the target for one family, sorted so they
+// compare the same whatever order the resolver gave them in.
+func resolveFollow(target string, rtype types.RRType) ([]string, error) {
+	network := "ip4"
+	if rtype == types.RRTypeAaaa {
+		network = "ip6"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), followTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, network, target)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	for _, a := range addrs {
+		values = append(values, a.Unmap().String())
+	}
+	slices.Sort(values)
+	return slices.Compact(values), nil
+}
+
+// Look up every family at once. A target with no addresses at all is much
+// more likely a typo or an outage than a real move, so that's an error
+// rather than a reason to delete the records.
+func resolveFollowAll(target string, rtypes []types.RRType) (map[types.RRType][]string, error) {
+	wants := map[types.RRType][]string{}
+	found := false
+	for _, rtype := range rtypes {
+		want, err := resolveFollow(target, rtype)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to look up %s for %s: %v", rtype, target, err)
+		}
+		wants[rtype] = want
+		found = found || len(want) > 0
+	}
+	if !found {
+		return nil, fmt.Errorf("%s doesn't have any addresses, leaving the records alone", target)
+	}
+	return wants, nil
+}
+
+// The records a followed domain copies.
+func followTypes(d DomainConfig) []types.RRType {
+	switch d.Type {
+	case "A":
+		return []types.RRType{types.RRTypeA}
+	case "AAAA":
+		return []types.RRType{types.RRTypeAaaa}
+	}
+	return []types.RRType{types.RRTypeA, types.RRTypeAaaa}
+}
+
+func recordValues(rec *types.ResourceRecordSet) []string {
+	if rec == nil {
+		return nil
+	}
+	var values []string
+	for _, r := range rec.ResourceRecords {
+		values = append(values, aws.ToString(r.Value))
+	}
+	slices.Sort(values)
+	return values
+}
+
+// Bring the domain's records in line with the target's addresses, all the
+// changes in one batch.
+func (u *Updater) updateFollow(name string, target string) error {
+	domain := name + "."
+	fail := func(record string, err error) {
+		u.Reporter.Report(Event{Type: EventFailure, Domain: name, Record: record, Source: u.Source, Error: err.Error()})
+	}
+
+	zone, err := u.zone(name)
+	if err != nil {
+		fail("", err)
+		return fmt.Errorf("Failed to find zone: %w", err)
+	}
+
+	wants, err := resolveFollowAll(target, followTypes(u.Domains[name]))
+	if err != nil {
+		fail("", err)
+		return err
+	}
+
+	var changes []types.Change
+	var current []*types.ResourceRecordSet
+	var events []Event
+	for _, rtype := range followTypes(u.Domains[name]) {
+		record := ""
+		if rtype != types.RRTypeA {
+			record = string(rtype)
+		}
+		want := wants[rtype]
+		for _, ip := range want {
+			if err := u.Policies.Check(name, ip); err != nil {
+				u.reportPolicy(name, record, "", ip, err, AddressReport{})
+				return nil
+			}
+		}
+		rec, err := route53update.GetRecord(u.Client, *zone.Id, domain, rtype)
+		if err != nil && !errors.Is(err, route53update.ErrRecordNotFound) {
+			fail(record, err)
+			return fmt.Errorf("Error trying to check configured %s ip: %w", rtype, err)
+		}
+		have := recordValues(rec)
+		fmt.Printf("%s for %s is %s, %s has %s\n", rtype, target, joinOrNothing(want), name, joinOrNothing(have))
+
+		// A TTL that's different from the config is worth fixing too, as
+		// long as there's a record to fix it on
+		ttl := u.ttl(name).For(false)
+		ttlOk := len(want) == 0 || rec == nil || rec.TTL == nil || *rec.TTL == ttl
+
+		event := Event{Domain: name, Record: record, Source: u.Source, OldIp: strings.Join(have, " "), NewIp: strings.Join(want, " "), Summary: "following " + target}
+		if slices.Equal(want, have) && ttlOk {
+			event.Type = EventNoChange
+			u.newMismatch(name+" "+record, "")
+			u.Reporter.Report(event)
+			continue
+		}
+		event.Type = EventChange
+		events = append(events, event)
+		current = append(current, rec)
+		if len(want) == 0 {
+			// The target dropped this family, so we do too
+			changes = append(changes, types.Change{Action: types.ChangeActionDelete, ResourceRecordSet: rec})
+			continue
+		}
+		var values []types.ResourceRecord
+		for _, ip := range want {
+			values = append(values, types.ResourceRecord{Value: aws.String(ip)})
+		}
+		changes = append(changes, types.Change{
+			Action: types.ChangeActionUpsert,
+			ResourceRecordSet: &types.ResourceRecordSet{
+				Name:            aws.String(domain),
+				Type:            rtype,
+				TTL:             aws.Int64(u.ttl(name).For(false)),
+				ResourceRecords: values,
+			},
+		})
+	}
+	if len(changes) == 0 {
+		fmt.Printf("Already following %s, done\n", target)
+		return nil
+	}
+
+	if u.DryRun {
+		for i, c := range changes {
+			printPlannedChange(os.Stdout, current[i], c)
+		}
+		return nil
+	}
+	if held := u.heldBecause(name); held != "" {
+		for _, e := range events {
+			fmt.Printf("%s %s should be %s, not updating: %s\n", name, e.Record, joinOrNothing(strings.Fields(e.NewIp)), held)
+			if u.newMismatch(name+" "+e.Record, e.NewIp) {
+				e.Type = EventMismatch
+				e.Summary = held
+				u.Reporter.Report(e)
+			}
+		}
+		return nil
+	}
+
+	for _, c := range changes {
+		if c.Action != types.ChangeActionDelete {
+			continue
+		}
+		if err := RecycleRecords(*zone.Id, "following "+target, c.ResourceRecordSet); err != nil {
+			fail("", err)
+			return err
+		}
+	}
+	change, err := route53update.ChangeRecordSets(u.Client, &route53.ChangeResourceRecordSetsInput{
+		ChangeBatch:  &types.ChangeBatch{Changes: changes, Comment: u.changeComment(name)},
+		HostedZoneId: zone.Id,
+	})
+	if err != nil {
+		fail("", err)
+		return fmt.Errorf("Error trying to update records to follow %s: %w", target, err)
+	}
+	for _, e := range events {
+		u.Reporter.Report(e)
+	}
+	fmt.Printf("Updated %d records to follow %s. Change: %s\n", len(changes), target, *change.ChangeInfo.Id)
+	if err := u.waitForSync(*change.ChangeInfo.Id); err != nil {
+		fail("", err)
+		return err
+	}
+	return nil
+}
+
+func joinOrNothing(values []string) string {
+	if len(values) == 0 {
+		return "nothing"
+	}
+	return strings.Join(values, " ")
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Places geoipupdate and the distro GeoIP packages usually put databases. If
+// the config doesn't say where the databases are we look in these.
+var geoipDirs = []string{
+	"/usr/share/GeoIP",
+	"/var/lib/GeoIP",
+	"/usr/local/share/GeoIP",
+}
+
+// GeoInfo is what we can tell about an address from the local databases.
+type GeoInfo struct {
+	Country string `json:"country,omitempty"`
+	City    string `json:"city,omitempty"`
+	ASN     uint   `json:"asn,omitempty"`
+	Org     string `json:"org,omitempty"`
+}
+
+func (g *GeoInfo) String() string {
+	var parts []string
+	if g.ASN != 0 {
+		parts = append(parts, strings.TrimSpace(fmt.Sprintf("AS%d %s", g.ASN, g.Org)))
+	}
+	if g.City != "" {
+		parts = append(parts, g.City)
+	}
+	if g.Country != "" {
+		parts = append(parts, g.Country)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// GeoLookup looks addresses up in MaxMind format databases. Either database
+// can be missing, we just fill in what we can.
+type GeoLookup struct {
+	city *maxminddb.Reader
+	asn  *maxminddb.Reader
+}
+
+// NewGeoLookup opens the databases from the config, or the usual GeoLite2
+// ones if they're installed. Returns nil if there's nothing to use.
+func NewGeoLookup(conf GeoIPConfig) *GeoLookup {
+	cityPath := conf.City
+	if cityPath == "" {
+		cityPath = findGeoDB("GeoLite2-City.mmdb", "GeoLite2-Country.mmdb")
+	}
+	asnPath := conf.ASN
+	if asnPath == "" {
+		asnPath = findGeoDB("GeoLite2-ASN.mmdb")
+	}
+
+	g := &GeoLookup{}
+	if cityPath != "" {
+		r, err := maxminddb.Open(cityPath)
+		if err != nil {
+			log.Printf("Unable to open GeoIP database %s: %v", cityPath, err)
+		} else {
+			g.city = r
+		}
+	}
+	if asnPath != "" {
+		r, err := maxminddb.Open(asnPath)
+		if err != nil {
+			log.Printf("Unable to open GeoIP database %s: %v", asnPath, err)
+		} else {
+			g.asn = r
+		}
+	}
+	if g.city == nil && g.asn == nil {
+		return nil
+	}
+	return g
+}
+
+func findGeoDB(names ...string) string {
+	for _, dir := range geoipDirs {
+		for _, name := range names {
+			p := filepath.Join(dir, name)
+			if _, err := os.Stat(p); err == nil {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+// Lookup returns what the databases know about the address, or nil if the
+// address is empty or they don't know anything.
+func (g *GeoLookup) Lookup(ip string) *GeoInfo {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return nil
+	}
+
+	info := &GeoInfo{}
+	if g.city != nil {
+		var rec struct {
+			Country struct {
+				ISOCode string `maxminddb:"iso_code"`
+			} `maxminddb:"country"`
+			City struct {
+				Names map[string]string `maxminddb:"names"`
+			} `maxminddb:"city"`
+		}
+		if err := g.city.Lookup(parsed, &rec); err == nil {
+			info.Country = rec.Country.ISOCode
+			info.City = rec.City.Names["en"]
+		}
+	}
+	if g.asn != nil {
+		var rec struct {
+			Number uint   `maxminddb:"autonomous_system_number"`
+			Org    string `maxminddb:"autonomous_system_organization"`
+		}
+		if err := g.asn.Lookup(parsed, &rec); err == nil {
+			info.ASN = rec.Number
+			info.Org = rec.Org
+		}
+	}
+	if *info == (GeoInfo{}) {
+		return nil
+	}
+	return info
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/mikerowehl/route53Update/route53update"
+)
+
+// Whatever talks to the internet, the update server and the address
+// discovery, is what's most likely to get broken into, and it's also what's
+// been holding credentials that can rewrite any record in the account. The
+// helper splits those apart. It runs as its own user with the AWS
+// credentials and listens on a local socket, and all it'll do is read or
+// upsert the address records of the domains set up to go through it. The
+// rest runs as a user with no AWS access at all:
+//
+//	helper:
+//	  socket: /run/route53Update/helper.sock
+//	domains:
+//	  home.example.com:
+//	    provider: helper
+//
+// Both sides can use the same config. route53Update helper -config <file>
+// runs the privileged side, and everything else sees the helper as one
+// more DNS provider, including the update server for users' hostnames that
+// are listed under domains. The helper checks the policies itself, so a
+// compromised client can't get around them either. Registered clients
+// still need the server to have AWS access, their records come with
+// ownership records the helper doesn't deal in.
+
+// HelperConfig is the socket the helper listens on.
+type HelperConfig struct {
+	Socket string `yaml:"socket"`
+}
+
+// The helper protocol is a line of JSON each way per request.
+type helperRequest struct {
+	Op     string `json:"op"` // get or upsert
+	Domain string `json:"domain"`
+	Type   string `json:"type"`
+	Ip     string `json:"ip,omitempty"`
+	TTL    int64  `json:"ttl,omitempty"`
+}
+
+type helperResponse struct {
+	Ip       string `json:"ip,omitempty"`
+	ChangeId string `json:"change_id,omitempty"`
+	NotFound bool   `json:"not_found,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Helper answers requests from the unprivileged side.
+type Helper struct {
+	updater *Updater
+	allowed map[string]bool
+}
+
+// NewHelper sets up the helper for the domains in the config that use it.
+func NewHelper(conf *Config) (*Helper, error) {
+	h := &Helper{allowed: map[string]bool{}}
+	for name, d := range conf.Domains {
+		if d.Provider == "helper" {
+			h.allowed[name] = true
+		}
+	}
+	if len(h.allowed) == 0 {
+		return nil, fmt.Errorf("No domains use the helper, give them provider: helper")
+	}
+	// The helper does the Route53 side for these domains, so it shouldn't
+	// turn around and ask itself
+	h.updater = newUpdater(conf, "helper")
+	delete(h.updater.Providers, "helper")
+	return h, nil
+}
+
+// Listen takes requests on the socket until something goes wrong. The
+// socket is left for the group to use, so the unprivileged user gets in by
+// being in the helper's group.
+func (h *Helper) Listen(path string) error {
+	os.Remove(path)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("Failed to listen on %s: %v", path, err)
+	}
+	defer l.Close()
+	if err := os.Chmod(path, 0660); err != nil {
+		return fmt.Errorf("Failed to set permissions on %s: %v", path, err)
+	}
+	log.Printf("Helper listening on %s for %d domains", path, len(h.allowed))
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go h.serve(conn)
+	}
+}
+
+func (h *Helper) serve(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	enc := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var req helperRequest
+		var res helperResponse
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			res.Error = fmt.Sprintf("Bad request: %v", err)
+		} else {
+			res = h.handle(req)
+		}
+		if err := enc.Encode(res); err != nil {
+			return
+		}
+	}
+}
+
+func (h *Helper) handle(req helperRequest) helperResponse {
+	name := route53update.NormalizeHostname(req.Domain)
+	if !h.allowed[name] {
+		log.Printf("Refused %s for %s, it doesn't use the helper", req.Op, name)
+		return helperResponse{Error: fmt.Sprintf("%s doesn't use the helper", name)}
+	}
+	rtype := types.RRType(req.Type)
+	if rtype != types.RRTypeA && rtype != types.RRTypeAaaa {
+		return helperResponse{Error: fmt.Sprintf("Only A and AAAA records, not %q", req.Type)}
+	}
+	zone, err := h.updater.zone(name)
+	if err != nil {
+		return helperResponse{Error: fmt.Sprintf("Failed to find zone: %v", err)}
+	}
+
+	switch req.Op {
+	case "get":
+		ip, err := route53update.GetRecIp(h.updater.Client, *zone.Id, name+".", rtype)
+		if errors.Is(err, route53update.ErrRecordNotFound) {
+			return helperResponse{NotFound: true}
+		}
+		if err != nil {
+			return helperResponse{Error: err.Error()}
+		}
+		return helperResponse{Ip: ip}
+	case "upsert":
+		parsed := net.ParseIP(req.Ip)
+		if parsed == nil || (parsed.To4() != nil) != (rtype == types.RRTypeA) {
+			return helperResponse{Error: fmt.Sprintf("%q isn't a usable %s address", req.Ip, rtype)}
+		}
+		if req.TTL < 0 || req.TTL > maxTTL {
+			return helperResponse{Error: fmt.Sprintf("TTL %d is out of range", req.TTL)}
+		}
+		if err := h.updater.Policies.Check(name, req.Ip); err != nil {
+			log.Printf("Refused to set %s %s to %s, blocked by policy: %v", name, rtype, req.Ip, err)
+			return helperResponse{Error: fmt.Sprintf("Blocked by policy: %v", err)}
+		}
+		ttl := req.TTL
+		if ttl == 0 {
+			ttl = route53update.DefaultTTL
+		}
+		change, err := route53update.UpdateRecIpTTL(h.updater.Client, *zone.Id, name+".", rtype, req.Ip, ttl)
+		if err != nil {
+			return helperResponse{Error: err.Error()}
+		}
+		log.Printf("Set %s %s to %s. Change: %s", name, rtype, req.Ip, *change.ChangeInfo.Id)
+		return helperResponse{ChangeId: *change.ChangeInfo.Id}
+	}
+	return helperResponse{Error: fmt.Sprintf("Unknown op %q", req.Op)}
+}
+
+// HelperProvider is the unprivileged side, a DNSProvider that asks the
+// helper to do everything.
+type HelperProvider struct {
+	socket string
+}
+
+func NewHelperProvider(socket string) *HelperProvider {
+	return &HelperProvider{socket: socket}
+}
+
+// Each request gets its own connection, they're few and far between and
+// this way a helper restart never leaves us holding a dead one.
+func (p *HelperProvider) call(req helperRequest) (helperResponse, error) {
+	conn, err := net.DialTimeout("unix", p.socket, 5*time.Second)
+	if err != nil {
+		return helperResponse{}, fmt.Errorf("Can't reach the helper: %v", err)
+	}
+	defer conn.Close()
+	// Upserts go all the way to Route53 and back
+	conn.SetDeadline(time.Now().Add(time.Minute))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return helperResponse{}, fmt.Errorf("Failed to send to the helper: %v", err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return helperResponse{}, fmt.Errorf("No answer from the helper: %v", err)
+	}
+	var res helperResponse
+	if err := json.Unmarshal([]byte(strings.TrimSpace(line)), &res); err != nil {
+		return helperResponse{}, fmt.Errorf("Bad answer from the helper: %v", err)
+	}
+	if res.Error != "" {
+		return res, fmt.Errorf("Helper: %s", res.Error)
+	}
+	return res, nil
+}
+
+func (p *HelperProvider) GetRecord(domain string, rtype types.RRType) (string, error) {
+	res, err := p.call(helperRequest{Op: "get", Domain: domain, Type: string(rtype)})
+	if err != nil {
+		return "", err
+	}
+	if res.NotFound {
+		return "", route53update.ErrRecordNotFound
+	}
+	return res.Ip, nil
+}
+
+func (p *HelperProvider) SetRecord(domain string, rtype types.RRType, ip string, ttl int64) error {
+	_, err := p.call(helperRequest{Op: "upsert", Domain: domain, Type: string(rtype), Ip: ip, TTL: ttl})
+	return err
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Some records have other things hanging off the address, like a firewall
+// rule letting the new address in or a VPN peer pointed at it. Hooks let
+// those change along with the record. Pre change hooks run right before the
+// record changes and any of them failing, exiting nonzero or running past
+// its timeout, stops the change. Post change hooks run once Route53 says the
+// change is in sync, so they never get ahead of DNS.
+//
+//	hooks:
+//	  pre_change:
+//	    - command: /usr/local/bin/firewall-allow "$ROUTE53UPDATE_NEW_IP"
+//	      timeout: 30s
+//	  post_change:
+//	    - command: /usr/local/bin/firewall-revoke "$ROUTE53UPDATE_OLD_IP"
+//	  change_failed:
+//	    - command: /usr/local/bin/firewall-revoke "$ROUTE53UPDATE_NEW_IP"
+//
+// None of this is a transaction. Whatever a pre change hook did stays done
+// if the record doesn't change after all, so change failed hooks are there
+// to undo it. They run when the record was left alone after the pre change
+// hooks started, a pre change hook failing or Route53 turning the change
+// down, with the reason in ROUTE53UPDATE_ERROR. That can be before some of
+// the pre change hooks got to run, so undoing has to be fine with nothing
+// to undo. A post change hook failing leaves the new address in place.
+//
+// Hooks can go at the top level of the config, or on a domain for ones that
+// only matter to it, and the top level ones run first. Commands run with
+// sh -c and get the details of the change in ROUTE53UPDATE_ variables.
+
+// How long a hook gets if it doesn't say, and how long to wait for a change
+// to go in sync before giving up on the post change hooks.
+const (
+	defaultHookTimeout = time.Minute
+	hookSyncTimeout    = 5 * time.Minute
+)
+
+// Keep this much of a hook's output for the run report.
+const hookOutputLimit = 64 * 1024
+
+// HookChange is the change a hook is running for.
+type HookChange struct {
+	Domain   string
+	Record   string // A or AAAA
+	OldIp    string
+	NewIp    string
+	ChangeId string // only set for post change hooks
+	Error    string // only set for change failed hooks
+}
+
+func (c HookChange) env() []string {
+	return append(os.Environ(),
+		"ROUTE53UPDATE_DOMAIN="+c.Domain,
+		"ROUTE53UPDATE_RECORD="+c.Record,
+		"ROUTE53UPDATE_OLD_IP="+c.OldIp,
+		"ROUTE53UPDATE_NEW_IP="+c.NewIp,
+		"ROUTE53UPDATE_CHANGE_ID="+c.ChangeId,
+		"ROUTE53UPDATE_ERROR="+c.Error,
+	)
+}
+
+// HookRun is one hook that ran, for the run report.
+type HookRun struct {
+	Stage           string    `json:"stage"`
+	Domain          string    `json:"domain"`
+	Record          string    `json:"record"`
+	Command         string    `json:"command"`
+	Started         time.Time `json:"started"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	ExitCode        int       `json:"exit_code"`
+	Output          string    `json:"output,omitempty"`
+	Error           string    `json:"error,omitempty"`
+}
+
+// The hooks for a domain's stage, top level ones first.
+func (u *Updater) hooksFor(name string, stage string) []HookConfig {
+	domain := u.Domains[name].Hooks
+	switch stage {
+	case "pre_change":
+		return append(append([]HookConfig{}, u.Hooks.PreChange...), domain.PreChange...)
+	case "change_failed":
+		return append(append([]HookConfig{}, u.Hooks.ChangeFailed...), domain.ChangeFailed...)
+	}
+	return append(append([]HookConfig{}, u.Hooks.PostChange...), domain.PostChange...)
+}
+
+// Give the pre change hooks a chance to undo what they did, since the record
+// didn't change after all. The change failing is the error that matters, so
+// these failing just gets logged.
+func (u *Updater) changeFailed(name string, change HookChange, err error) {
+	change.Error = err.Error()
+	if err := runHooks("change_failed", u.hooksFor(name, "change_failed"), change); err != nil {
+		log.Print(err)
+	}
+}
+
+// Run the hooks for a stage in order, stopping at the first one that fails.
+func runHooks(stage string, hooks []HookConfig, change HookChange) error {
+	for _, h := range hooks {
+		if err := runHook(stage, h, change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runHook(stage string, h HookConfig, change HookChange) error {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", h.Command)
+	cmd.Env = change.env()
+	hookProcessGroup(cmd)
+	// Don't hang around on something the hook left running in the background
+	cmd.WaitDelay = 5 * time.Second
+
+	run := HookRun{Stage: stage, Domain: change.Domain, Record: change.Record, Command: h.Command, Started: time.Now()}
+	out, err := cmd.CombinedOutput()
+	run.DurationSeconds = time.Since(run.Started).Seconds()
+	run.Output = string(out[:min(len(out), hookOutputLimit)])
+	run.ExitCode = cmd.ProcessState.ExitCode()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			err = fmt.Errorf("exited with %d", exitErr.ExitCode())
+		}
+		run.Error = err.Error()
+	}
+	if currentRun != nil {
+		currentRun.addHook(run)
+	}
+
+	fmt.Printf("%s hook %q: %s\n", stage, h.Command, hookResult(run))
+	if err != nil {
+		return fmt.Errorf("%s hook %q %v", stage, h.Command, err)
+	}
+	return nil
+}
+
+func hookResult(run HookRun) string {
+	if run.Error != "" {
+		return run.Error
+	}
+	return "ok"
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os/exec"
+
+// Only unix has process groups to clean up, see hooks_unix.go.
+func hookProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/mikerowehl/route53Update/route53update"
+)
+
+// For a fleet of devices all built from the same image, every one needs its
+// own name but they should all be able to share one config. Names can be
+// templates that get filled in from the machine they're running on:
+//
+//	domains:
+//	  "{{ .Hostname }}.fleet.example.com": {}
+//	  "{{ .MachineID | short }}.devices.example.com": {}
+//
+// Templates work for the domains in the config and for names given on the
+// command line. Hostname is the short hostname, FQDN is the full one,
+// MachineID comes from /etc/machine-id, and env pulls in an environment
+// variable, like {{ env "SITE" }}. The short function takes the first 8
+// characters and label turns anything into something that's allowed in a
+// DNS name.
+
+// The values are looked up when a template uses them, so a config that only
+// uses the hostname works on machines without a machine id.
+type machineIdentity struct{}
+
+func (machineIdentity) Hostname() (string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	short, _, _ := strings.Cut(host, ".")
+	return short, nil
+}
+
+func (machineIdentity) FQDN() (string, error) {
+	return os.Hostname()
+}
+
+var machineIdFiles = []string{"/etc/machine-id", "/var/lib/dbus/machine-id"}
+
+func (machineIdentity) MachineID() (string, error) {
+	for _, path := range machineIdFiles {
+		data, err := os.ReadFile(path)
+		if err == nil && strings.TrimSpace(string(data)) != "" {
+			return strings.TrimSpace(string(data)), nil
+		}
+	}
+	return "", fmt.Errorf("No machine id found in %s", strings.Join(machineIdFiles, " or "))
+}
+
+var identityFuncs = template.FuncMap{
+	"short": func(s string) string {
+		return s[:min(len(s), 8)]
+	},
+	"label": dnsLabel,
+	"lower": strings.ToLower,
+	"env":   os.Getenv,
+}
+
+// Turn anything into a valid DNS label, lowercase letters, digits, and
+// hyphens, not starting or ending with a hyphen and at most 63 long.
+func dnsLabel(s string) string {
+	var b strings.Builder
+	for _, ch := range strings.ToLower(s) {
+		switch {
+		case ch >= 'a' && ch <= 'z', ch >= '0' && ch <= '9':
+			b.WriteRune(ch)
+		default:
+			b.WriteByte('-')
+		}
+	}
+	label := strings.Trim(b.String(), "-")
+	if len(label) > 63 {
+		label = strings.TrimRight(label[:63], "-")
+	}
+	return label
+}
+
+// ExpandHostname fills in a name template with this machine's details.
+// Names without any template in them come back as they are.
+func ExpandHostname(name string) (string, error) {
+	if !strings.Contains(name, "{{") {
+		return name, nil
+	}
+	tmpl, err := template.New("name").Funcs(identityFuncs).Parse(name)
+	if err != nil {
+		return "", fmt.Errorf("Bad name template %q: %v", name, err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, machineIdentity{}); err != nil {
+		return "", fmt.Errorf("Failed to fill in name template %q: %v", name, err)
+	}
+	expanded := route53update.NormalizeHostname(b.String())
+	for _, label := range strings.Split(expanded, ".") {
+		if label == "" || dnsLabel(label) != label {
+			return "", fmt.Errorf("Name template %q gave %q, which isn't a valid hostname", name, expanded)
+		}
+	}
+	return expanded, nil
+}
+
+// Expand every domain name in the config that's a template.
+func expandDomainTemplates(domains map[string]DomainConfig) error {
+	for name, d := range domains {
+		expanded, err := ExpandHostname(name)
+		if err != nil {
+			return err
+		}
+		if expanded == name {
+			continue
+		}
+		if _, ok := domains[expanded]; ok {
+			return fmt.Errorf("Name template %q gave %s, which is already in the config", name, expanded)
+		}
+		delete(domains, name)
+		domains[expanded] = d
+	}
+	return nil
+}
+
+// The same for a name from the command line, where a bad one is fatal.
+func mustExpandHostname(name string) string {
+	expanded, err := ExpandHostname(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return expanded
+}
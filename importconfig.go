@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mikerowehl/route53Update/route53update"
+	"gopkg.in/yaml.v3"
+)
+
+// A lot of people come to this from years of running ddclient or inadyn, and
+// their hostnames are already written down in its config. Import pulls out what
+// carries over, the names, how often they're checked, and whether IPv6 is
+// in use, and writes it as config for this tool. Provider logins don't
+// carry over since Route53 goes by AWS credentials, and names that live
+// under a provider's own domain, like something.duckdns.org, can't move to
+// a hosted zone, so those get flagged instead of imported.
+
+// ImportedConfig is what an import found, ready to be written out as YAML.
+type ImportedConfig struct {
+	From     string
+	Domains  []ImportedDomain
+	IPv6     bool
+	Warnings []string
+}
+
+// ImportedDomain is one name to manage, with the interval it was checked at
+// if the old config had one.
+type ImportedDomain struct {
+	Name     string
+	Interval time.Duration
+}
+
+// Domains that belong to a dynamic DNS provider, so names under them can't
+// be moved to Route53.
+var providerDomains = []string{
+	"duckdns.org", "no-ip.com", "no-ip.org", "no-ip.biz", "ddns.net",
+	"hopto.org", "zapto.org", "sytes.net", "dyndns.org", "dynu.net",
+	"afraid.org", "changeip.com", "dnsalias.com", "homeip.net",
+	"freeddns.org", "dynv6.net",
+}
+
+func providerDomain(name string) string {
+	for _, d := range providerDomains {
+		if name == d || strings.HasSuffix(name, "."+d) {
+			return d
+		}
+	}
+	return ""
+}
+
+// ddclient protocols and inadyn providers for services whose names are
+// under their own domain, where the config often just has the bare name,
+// like "myhost" for myhost.duckdns.org.
+var providerProtocols = map[string]string{
+	"duckdns":  "duckdns.org",
+	"noip":     "no-ip.com",
+	"no-ip":    "no-ip.com",
+	"freedns":  "afraid.org",
+	"dynu":     "dynu.net",
+	"changeip": "changeip.com",
+	"dynv6":    "dynv6.net",
+}
+
+// The provider domain a protocol or provider name is for, if it's one of
+// them. inadyn names them like default@duckdns.org.
+func protocolDomain(protocol string) string {
+	protocol = strings.ToLower(protocol)
+	if _, after, ok := strings.Cut(protocol, "@"); ok {
+		protocol = after
+	}
+	if d, ok := providerProtocols[protocol]; ok {
+		return d
+	}
+	return providerDomain(protocol)
+}
+
+func (c *ImportedConfig) warn(format string, args ...any) {
+	c.Warnings = append(c.Warnings, fmt.Sprintf(format, args...))
+}
+
+// Add a name, unless it's a duplicate or stuck at a provider.
+func (c *ImportedConfig) add(name string, protocol string, interval time.Duration) {
+	name = route53update.NormalizeHostname(name)
+	if name == "" {
+		return
+	}
+	if d := providerDomain(name); d != "" {
+		c.warn("Skipped %s, it's under %s (%s) and can't move to a hosted zone", name, d, protocol)
+		return
+	}
+	if !strings.Contains(name, ".") {
+		if d := protocolDomain(protocol); d != "" {
+			c.warn("Skipped %s, it's a name under %s (%s) and can't move to a hosted zone", name, d, protocol)
+		} else {
+			c.warn("Skipped %s, it isn't a full domain name (%s)", name, protocol)
+		}
+		return
+	}
+	for _, existing := range c.Domains {
+		if existing.Name == name {
+			return
+		}
+	}
+	c.Domains = append(c.Domains, ImportedDomain{Name: name, Interval: interval})
+}
+
+// ImportDdclient reads a ddclient.conf. Lines are settings (key=value,
+// separated by commas or spaces) followed by hostnames. A line with just
+// settings changes the defaults for everything after it, settings on a line
+// with hostnames only apply to those hosts.
+func ImportDdclient(r io.Reader) (*ImportedConfig, error) {
+	c := &ImportedConfig{From: "ddclient"}
+	globals := map[string]string{}
+	sawLogin := false
+
+	lines, err := ddclientLines(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		settings := map[string]string{}
+		var hosts []string
+		for _, tok := range ddclientTokens(line) {
+			if key, value, ok := strings.Cut(tok, "="); ok {
+				settings[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+			} else {
+				hosts = append(hosts, tok)
+			}
+		}
+		if len(hosts) == 0 {
+			for k, v := range settings {
+				globals[k] = v
+			}
+			continue
+		}
+
+		merged := map[string]string{}
+		for k, v := range globals {
+			merged[k] = v
+		}
+		for k, v := range settings {
+			merged[k] = v
+		}
+		if merged["login"] != "" || merged["password"] != "" {
+			sawLogin = true
+		}
+		if v := merged["usev6"]; v != "" && v != "no" && v != "disabled" {
+			c.IPv6 = true
+		}
+		interval, err := ddclientInterval(merged["daemon"])
+		if err != nil {
+			c.warn("Ignored daemon=%s, %v", merged["daemon"], err)
+		}
+		protocol := merged["protocol"]
+		if protocol == "" {
+			protocol = "dyndns2"
+		}
+		for _, h := range hosts {
+			c.add(h, protocol, interval)
+		}
+	}
+
+	if sawLogin {
+		c.warn("Provider logins weren't imported, Route53 uses your AWS credentials")
+	}
+	return c, nil
+}
+
+// Strip comments and join lines continued with a backslash.
+func ddclientLines(r io.Reader) ([]string, error) {
+	var lines []string
+	var pending string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if strings.HasSuffix(line, `\`) {
+			pending += strings.TrimSuffix(line, `\`) + " "
+			continue
+		}
+		line = strings.TrimSpace(pending + line)
+		pending = ""
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to read config: %v", err)
+	}
+	if pending != "" {
+		lines = append(lines, strings.TrimSpace(pending))
+	}
+	return lines, nil
+}
+
+// Cut off a # comment, unless the # is inside a quoted value.
+func stripComment(line string) string {
+	var quote rune
+	for i, ch := range line {
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"':
+			quote = ch
+		case ch == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// Split a line on commas and spaces, keeping quoted values together.
+func ddclientTokens(line string) []string {
+	var tokens []string
+	var cur strings.Builder
+	var quote rune
+	for _, ch := range line {
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(ch)
+			}
+		case ch == '\'' || ch == '"':
+			quote = ch
+		case ch == ',' || ch == ' ' || ch == '\t':
+			if cur.Len() > 0 {
+				tokens = append(tokens, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteRune(ch)
+		}
+	}
+	if cur.Len() > 0 {
+		tokens = append(tokens, cur.String())
+	}
+
+	// "key = value" with spaces comes out as three tokens, put them back
+	var joined []string
+	for i := 0; i < len(tokens); i++ {
+		if tokens[i] == "=" && len(joined) > 0 && i+1 < len(tokens) {
+			joined[len(joined)-1] += "=" + tokens[i+1]
+			i++
+			continue
+		}
+		if strings.HasSuffix(tokens[i], "=") && i+1 < len(tokens) {
+			joined = append(joined, tokens[i]+tokens[i+1])
+			i++
+			continue
+		}
+		joined = append(joined, tokens[i])
+	}
+	return joined
+}
+
+// ddclient's daemon setting is seconds, or a number with s, m, h, or d.
+func ddclientInterval(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	unit := time.Second
+	switch s[len(s)-1] {
+	case 's':
+		s = s[:len(s)-1]
+	case 'm':
+		unit, s = time.Minute, s[:len(s)-1]
+	case 'h':
+		unit, s = time.Hour, s[:len(s)-1]
+	case 'd':
+		unit, s = 24*time.Hour, s[:len(s)-1]
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("not a number of seconds")
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// YAML renders the import as a config file, with the warnings as comments
+// at the top so they don't get lost.
+func (c *ImportedConfig) YAML() ([]byte, error) {
+	head := []string{"Imported from " + c.From}
+	for _, w := range c.Warnings {
+		head = append(head, "WARNING: "+w)
+	}
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	if c.IPv6 {
+		root.Content = append(root.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: "ipv6"},
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "true"})
+	}
+	domains := &yaml.Node{Kind: yaml.MappingNode}
+	for _, d := range c.Domains {
+		entry := &yaml.Node{Kind: yaml.MappingNode}
+		if d.Interval > 0 {
+			entry.Content = append(entry.Content,
+				&yaml.Node{Kind: yaml.ScalarNode, Value: "interval"},
+				&yaml.Node{Kind: yaml.ScalarNode, Value: d.Interval.String()})
+		} else {
+			entry.Style = yaml.FlowStyle
+		}
+		domains.Content = append(domains.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: d.Name}, entry)
+	}
+	root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "domains"}, domains)
+	root.Content[0].HeadComment = strings.Join(head, "\n")
+	return yaml.Marshal(root)
+}
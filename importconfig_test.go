@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// The domains an import found, as "name interval".
+func importedDomains(c *ImportedConfig) []string {
+	var got []string
+	for _, d := range c.Domains {
+		got = append(got, fmt.Sprintf("%s %s", d.Name, d.Interval))
+	}
+	return got
+}
+
+// Every warning wanted is in one of the warnings given, and there aren't
+// any others.
+func checkWarnings(t *testing.T, got []string, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("Got warnings %q, want ones with %q", got, want)
+		return
+	}
+	for _, w := range want {
+		if !slices.ContainsFunc(got, func(g string) bool { return strings.Contains(g, w) }) {
+			t.Errorf("No warning with %q in %q", w, got)
+		}
+	}
+}
+
+func TestImportDdclient(t *testing.T) {
+	tests := []struct {
+		name     string
+		conf     string
+		domains  []string
+		ipv6     bool
+		warnings []string
+	}{
+		{
+			name:     "dyndns2 with a login",
+			conf:     "protocol=dyndns2\nserver=members.dyndns.org\nlogin=someone\npassword='secret'\nhome.example.com\n",
+			domains:  []string{"home.example.com 0s"},
+			warnings: []string{"Provider logins weren't imported"},
+		},
+		{
+			name:    "daemon global and per host",
+			conf:    "daemon=300\nprotocol=dyndns2, daemon=10m a.example.com\nb.example.com\n",
+			domains: []string{"a.example.com 10m0s", "b.example.com 5m0s"},
+		},
+		{
+			name:    "daemon units",
+			conf:    "daemon=2h a.example.com\ndaemon=1d b.example.com\ndaemon=30s c.example.com\n",
+			domains: []string{"a.example.com 2h0m0s", "b.example.com 24h0m0s", "c.example.com 30s"},
+		},
+		{
+			name:     "bad daemon",
+			conf:     "daemon=often home.example.com\n",
+			domains:  []string{"home.example.com 0s"},
+			warnings: []string{"Ignored daemon=often"},
+		},
+		{
+			name:    "usev6",
+			conf:    "usev6=ifv6, ifv6=eth0 home.example.com\n",
+			domains: []string{"home.example.com 0s"},
+			ipv6:    true,
+		},
+		{
+			name:    "usev6 off",
+			conf:    "usev6=no home.example.com\n",
+			domains: []string{"home.example.com 0s"},
+		},
+		{
+			name:    "several hosts and continued lines",
+			conf:    "protocol=dyndns2 \\\n  home.example.com, \\\n  nas.example.com\n",
+			domains: []string{"home.example.com 0s", "nas.example.com 0s"},
+		},
+		{
+			name:     "comments and spaced settings",
+			conf:     "# ddclient.conf\ndaemon = 600\npassword = 'has#hash' # not a comment inside quotes\nhome.example.com # the house\n",
+			domains:  []string{"home.example.com 10m0s"},
+			warnings: []string{"Provider logins weren't imported"},
+		},
+		{
+			name:    "duplicates",
+			conf:    "home.example.com\nHOME.example.com.\n",
+			domains: []string{"home.example.com 0s"},
+		},
+		{
+			name:     "duckdns bare name",
+			conf:     "protocol=duckdns, password=token myhost\n",
+			warnings: []string{"Skipped myhost, it's a name under duckdns.org (duckdns)", "Provider logins"},
+		},
+		{
+			name:     "noip name",
+			conf:     "protocol=noip home.example.com, myhost.ddns.net\n",
+			domains:  []string{"home.example.com 0s"},
+			warnings: []string{"Skipped myhost.ddns.net, it's under ddns.net (noip)"},
+		},
+		{
+			name:     "freedns bare name",
+			conf:     "protocol=freedns myhost\n",
+			warnings: []string{"under afraid.org (freedns)"},
+		},
+		{
+			name:     "not a full name",
+			conf:     "protocol=dyndns2 myhost\n",
+			warnings: []string{"Skipped myhost, it isn't a full domain name (dyndns2)"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ImportDdclient(strings.NewReader(tt.conf))
+			if err != nil {
+				t.Fatalf("ImportDdclient: %v", err)
+			}
+			if got := importedDomains(c); !slices.Equal(got, tt.domains) {
+				t.Errorf("Got domains %q, want %q", got, tt.domains)
+			}
+			if c.IPv6 != tt.ipv6 {
+				t.Errorf("IPv6 is %v, want %v", c.IPv6, tt.ipv6)
+			}
+			checkWarnings(t, c.Warnings, tt.warnings)
+		})
+	}
+}
+
+func TestImportedConfigYAML(t *testing.T) {
+	c, err := ImportDdclient(strings.NewReader("usev6=ifv6 daemon=300 home.example.com\nprotocol=duckdns myhost\n"))
+	if err != nil {
+		t.Fatalf("ImportDdclient: %v", err)
+	}
+	out, err := c.YAML()
+	if err != nil {
+		t.Fatalf("YAML: %v", err)
+	}
+	for _, want := range []string{"# Imported from ddclient", "# WARNING: Skipped myhost", "ipv6: true", "home.example.com:", "interval: 5m0s"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("No %q in\n%s", want, out)
+		}
+	}
+}
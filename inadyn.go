@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// inadyn is what a lot of routers and embedded boxes ship. Version 2
+// configs look like
+//
+//	period = 300
+//	allow-ipv6 = true
+//	provider default@dyndns.org {
+//	    username = someone
+//	    password = secret
+//	    hostname = { "home.example.com", "nas.example.com" }
+//	}
+//
+// with custom blocks for providers it doesn't know about. The settings that
+// matter here are the hostnames in each block, period, and allow-ipv6.
+
+// ImportInadyn reads an inadyn.conf.
+func ImportInadyn(r io.Reader) (*ImportedConfig, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read config: %v", err)
+	}
+	p := &inadynParser{tokens: inadynTokens(string(data))}
+
+	c := &ImportedConfig{From: "inadyn"}
+	var interval time.Duration
+	type block struct {
+		provider string
+		hosts    []string
+	}
+	var blocks []block
+	sawLogin := false
+
+	for !p.done() {
+		key := p.next()
+		if p.peek() == "=" {
+			p.next()
+			values, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			switch key {
+			case "period":
+				secs, err := strconv.Atoi(firstOf(values))
+				if err != nil {
+					c.warn("Ignored period = %s", firstOf(values))
+				} else {
+					interval = time.Duration(secs) * time.Second
+				}
+			case "allow-ipv6":
+				c.IPv6 = firstOf(values) == "true"
+			}
+			continue
+		}
+
+		// provider <name> { ... } or custom <name> { ... }
+		if key != "provider" && key != "custom" {
+			return nil, fmt.Errorf("Unexpected %q in inadyn config", key)
+		}
+		b := block{provider: p.next()}
+		if key == "custom" {
+			b.provider = "custom " + b.provider
+		}
+		if p.next() != "{" {
+			return nil, fmt.Errorf("Expected { after %s %s", key, b.provider)
+		}
+		for !p.done() && p.peek() != "}" {
+			setting := p.next()
+			if p.next() != "=" {
+				return nil, fmt.Errorf("Expected = after %s in %s", setting, b.provider)
+			}
+			values, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			switch setting {
+			case "hostname":
+				b.hosts = append(b.hosts, values...)
+			case "username", "password":
+				sawLogin = true
+			}
+		}
+		if p.next() != "}" {
+			return nil, fmt.Errorf("Missing } for %s", b.provider)
+		}
+		blocks = append(blocks, b)
+	}
+
+	// period is global but can come after the blocks, so add the names
+	// once the whole file's been read
+	for _, b := range blocks {
+		for _, h := range b.hosts {
+			c.add(h, b.provider, interval)
+		}
+	}
+	if sawLogin {
+		c.warn("Provider logins weren't imported, Route53 uses your AWS credentials")
+	}
+	return c, nil
+}
+
+func firstOf(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+type inadynParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *inadynParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *inadynParser) peek() string {
+	if p.done() {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *inadynParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+// A value is one word or string, or a { list, of, them }.
+func (p *inadynParser) value() ([]string, error) {
+	if p.peek() != "{" {
+		return []string{p.next()}, nil
+	}
+	p.next()
+	var values []string
+	for !p.done() && p.peek() != "}" {
+		if t := p.next(); t != "," {
+			values = append(values, t)
+		}
+	}
+	if p.next() != "}" {
+		return nil, fmt.Errorf("Missing } in list")
+	}
+	return values, nil
+}
+
+// Split an inadyn config into words, quoted strings, and the punctuation
+// libconfuse cares about. Comments are #, // and /* */.
+func inadynTokens(s string) []string {
+	var tokens []string
+	for i := 0; i < len(s); {
+		ch := s[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			i++
+		case ch == '#' || strings.HasPrefix(s[i:], "//"):
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(s[i:], "/*"):
+			end := strings.Index(s[i+2:], "*/")
+			if end < 0 {
+				return tokens
+			}
+			i += end + 4
+		case ch == '{' || ch == '}' || ch == '=' || ch == ',':
+			tokens = append(tokens, string(ch))
+			i++
+		case ch == '"' || ch == '\'':
+			end := strings.IndexByte(s[i+1:], ch)
+			if end < 0 {
+				end = len(s) - i - 1
+			}
+			tokens = append(tokens, s[i+1:i+1+end])
+			i += end + 2
+		default:
+			start := i
+			for i < len(s) && !strings.ContainsRune(" \t\r\n{}=,#\"'", rune(s[i])) {
+				i++
+			}
+			tokens = append(tokens, s[start:i])
+		}
+	}
+	return tokens
+}
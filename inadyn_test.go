@@ -0,0 +1,106 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestImportInadyn(t *testing.T) {
+	tests := []struct {
+		name     string
+		conf     string
+		domains  []string
+		ipv6     bool
+		warnings []string
+	}{
+		{
+			name: "provider block",
+			conf: `period = 300
+allow-ipv6 = true
+provider default@dyndns.org {
+    username = someone
+    password = secret
+    hostname = { "home.example.com", "nas.example.com" }
+}
+`,
+			domains:  []string{"home.example.com 5m0s", "nas.example.com 5m0s"},
+			ipv6:     true,
+			warnings: []string{"Provider logins weren't imported"},
+		},
+		{
+			name:    "period after the blocks",
+			conf:    "provider default@freedns.afraid.org { hostname = home.example.com }\nperiod = 600\n",
+			domains: []string{"home.example.com 10m0s"},
+		},
+		{
+			name:    "custom block",
+			conf:    "custom home { ddns-server = \"ddns.example.net\"\n ddns-path = \"/update?hostname=%h\"\n hostname = 'home.example.com' }\n",
+			domains: []string{"home.example.com 0s"},
+		},
+		{
+			name:    "comments",
+			conf:    "# inadyn.conf\n// period = 60\n/* allow-ipv6 = true */\nprovider dyndns { hostname = home.example.com } # the house\n",
+			domains: []string{"home.example.com 0s"},
+		},
+		{
+			name:     "bad period",
+			conf:     "period = often\nprovider dyndns { hostname = home.example.com }\n",
+			domains:  []string{"home.example.com 0s"},
+			warnings: []string{"Ignored period = often"},
+		},
+		{
+			name:     "duckdns bare name",
+			conf:     "provider default@duckdns.org { password = token\n hostname = myhost }\n",
+			warnings: []string{"Skipped myhost, it's a name under duckdns.org (default@duckdns.org)", "Provider logins"},
+		},
+		{
+			name:     "no-ip name",
+			conf:     "provider default@no-ip.com { hostname = { myhost.hopto.org, home.example.com } }\n",
+			domains:  []string{"home.example.com 0s"},
+			warnings: []string{"Skipped myhost.hopto.org, it's under hopto.org (default@no-ip.com)"},
+		},
+		{
+			name:     "not a full name",
+			conf:     "custom home { hostname = myhost }\n",
+			warnings: []string{"Skipped myhost, it isn't a full domain name (custom home)"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ImportInadyn(strings.NewReader(tt.conf))
+			if err != nil {
+				t.Fatalf("ImportInadyn: %v", err)
+			}
+			if got := importedDomains(c); !slices.Equal(got, tt.domains) {
+				t.Errorf("Got domains %q, want %q", got, tt.domains)
+			}
+			if c.IPv6 != tt.ipv6 {
+				t.Errorf("IPv6 is %v, want %v", c.IPv6, tt.ipv6)
+			}
+			checkWarnings(t, c.Warnings, tt.warnings)
+		})
+	}
+}
+
+func TestImportInadynErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		conf string
+		want string
+	}{
+		{"unknown section", "server foo { }\n", "Unexpected \"server\""},
+		{"no brace", "provider dyndns hostname = home.example.com\n", "Expected { after provider dyndns"},
+		{"no equals", "provider dyndns { hostname home.example.com }\n", "Expected = after hostname"},
+		{"unclosed block", "provider dyndns { hostname = home.example.com\n", "Missing } for dyndns"},
+		{"unclosed list", "provider dyndns { hostname = { home.example.com\n", "Missing } in list"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ImportInadyn(strings.NewReader(tt.conf))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Got %v, want an error with %q", err, tt.want)
+			}
+		})
+	}
+}
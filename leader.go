@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"sync"
+	"time"
+)
+
+// Two copies of watch, say one on the home server and a backup on a Pi,
+// can share the job with one of them doing the updates and the other
+// standing by. They agree on which through a lease in the shared state,
+// the same kind of object as the locks. The leader renews it every third
+// of the TTL, and if it stops, because it died or lost its connection, the
+// lease runs out and the standby takes it over on its next try:
+//
+//	leader:
+//	  lease: s3://my-bucket/route53update/leader
+//	  ttl: 30s
+//
+// A lease only keeps both from updating in the normal run of things. One
+// that loses the lease partway through a check still finishes it, so
+// locks are still worth having alongside. A leader that can't get through
+// to renew keeps going until its lease would have run out, since nobody
+// else can take it before then anyway, and one that's stopped hands the
+// lease back so the standby takes over on its next heartbeat instead of
+// waiting out the TTL.
+//
+// Only watch pays attention to the lease. serve answers whoever sends it
+// updates and the tui is run by hand, so neither checks it.
+
+// LeaderConfig is where the lease is kept and how long it lasts.
+type LeaderConfig struct {
+	// State path for the lease, somewhere both copies can get to
+	Lease string `yaml:"lease"`
+
+	// How long the lease lasts without a heartbeat, defaultLeaseTTL if
+	// it's not set
+	TTL time.Duration `yaml:"ttl"`
+
+	// What this copy goes by in the lease and the logs, the hostname and
+	// pid if it's not set
+	Name string `yaml:"name"`
+}
+
+const defaultLeaseTTL = 30 * time.Second
+
+// Leader keeps track of whether this copy holds the lease.
+type Leader struct {
+	path   string
+	holder string
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	leading bool
+
+	// When the lease we last wrote runs out
+	expires time.Time
+}
+
+// NewLeader sets up leader election from the config, nil if there's no
+// lease, in which case this copy always leads.
+func NewLeader(conf LeaderConfig) *Leader {
+	if conf.Lease == "" {
+		return nil
+	}
+	l := &Leader{path: conf.Lease, holder: conf.Name, ttl: conf.TTL}
+	if l.holder == "" {
+		l.holder = lockHolder()
+	}
+	if l.ttl == 0 {
+		l.ttl = defaultLeaseTTL
+	}
+	return l
+}
+
+// Leading says whether this copy should be doing updates.
+func (l *Leader) Leading() bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.leading
+}
+
+// Run heartbeats for as long as the program runs, for starting in the
+// background after the first Heartbeat.
+func (l *Leader) Run() {
+	for range time.Tick(l.ttl / 3) {
+		l.Heartbeat()
+	}
+}
+
+// The lease is someone else's, as opposed to not being able to tell.
+var errLeaseTaken = errors.New("the lease isn't ours")
+
+// Heartbeat renews the lease if this copy has it, or tries to take it if
+// it's run out.
+func (l *Leader) Heartbeat() {
+	l.mu.Lock()
+	leading, expires := l.leading, l.expires
+	l.mu.Unlock()
+
+	if leading {
+		err := l.renew()
+		switch {
+		case err == nil:
+		case errors.Is(err, errLeaseTaken), errors.Is(err, errStateConflict):
+			log.Printf("Lost the lease, standing by: %v", err)
+			l.setLeading(false, time.Time{})
+		case time.Now().Before(expires):
+			log.Printf("Unable to renew the lease, still good until %s: %v", expires.Format(time.RFC3339), err)
+		default:
+			log.Printf("Unable to renew the lease before it ran out, standing by: %v", err)
+			l.setLeading(false, time.Time{})
+		}
+		return
+	}
+
+	// Still ours from before, say after a restart with the same name
+	err := l.renew()
+	if err == nil {
+		log.Printf("Took the lease back as %s, doing updates", l.holder)
+		return
+	}
+	expires = time.Now().Add(l.ttl)
+	_, err = AcquireLock(l.path, l.holder, l.ttl)
+	switch {
+	case err == nil:
+		log.Printf("Took the lease as %s, doing updates", l.holder)
+		l.setLeading(true, expires)
+	case !errors.Is(err, errLocked):
+		log.Printf("Unable to check the lease: %v", err)
+	}
+}
+
+func (l *Leader) setLeading(leading bool, expires time.Time) {
+	l.mu.Lock()
+	l.leading, l.expires = leading, expires
+	l.mu.Unlock()
+}
+
+// Push the lease out another TTL, as long as it's still ours and nobody
+// changed it since we looked.
+func (l *Leader) renew() error {
+	store, err := storeFor(l.path)
+	if err != nil {
+		return err
+	}
+	data, version, err := store.Get(l.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w, nobody has it", errLeaseTaken)
+	}
+	if err != nil {
+		return err
+	}
+	var current lockState
+	if err := json.Unmarshal(data, &current); err != nil {
+		return err
+	}
+	if current.Holder != l.holder {
+		return fmt.Errorf("%w, %s has it", errLeaseTaken, current.Holder)
+	}
+	expires := time.Now().Add(l.ttl)
+	data, _ = json.Marshal(lockState{Holder: l.holder, Expires: expires})
+	if err := store.Put(l.path, data, version); err != nil {
+		return err
+	}
+	l.setLeading(true, expires)
+	return nil
+}
+
+// Release hands the lease back if this copy has it, for shutting down.
+func (l *Leader) Release() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	leading := l.leading
+	l.leading = false
+	l.mu.Unlock()
+	if !leading {
+		return
+	}
+	store, err := storeFor(l.path)
+	if err != nil {
+		return
+	}
+	data, version, err := store.Get(l.path)
+	var current lockState
+	if err == nil && json.Unmarshal(data, &current) == nil && current.Holder == l.holder {
+		if err := store.Delete(l.path, version); err != nil {
+			log.Printf("Failed to release the lease: %v", err)
+			return
+		}
+		log.Printf("Released the lease")
+	}
+}
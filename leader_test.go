@@ -0,0 +1,61 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLeaderFailover(t *testing.T) {
+	lease := filepath.Join(t.TempDir(), "leader")
+	a := NewLeader(LeaderConfig{Lease: lease, Name: "a", TTL: time.Minute})
+	b := NewLeader(LeaderConfig{Lease: lease, Name: "b", TTL: time.Minute})
+
+	a.Heartbeat()
+	b.Heartbeat()
+	if !a.Leading() || b.Leading() {
+		t.Fatalf("a leading %v, b leading %v, want only a", a.Leading(), b.Leading())
+	}
+
+	// Heartbeats keep it that way
+	a.Heartbeat()
+	b.Heartbeat()
+	if !a.Leading() || b.Leading() {
+		t.Fatalf("After renewing a leading %v, b leading %v, want only a", a.Leading(), b.Leading())
+	}
+
+	// a going away hands straight over, no waiting out the TTL
+	a.Release()
+	b.Heartbeat()
+	if a.Leading() || !b.Leading() {
+		t.Fatalf("After release a leading %v, b leading %v, want only b", a.Leading(), b.Leading())
+	}
+
+	// b coming back under the same name picks its lease back up
+	restarted := NewLeader(LeaderConfig{Lease: lease, Name: "b", TTL: time.Minute})
+	restarted.Heartbeat()
+	if !restarted.Leading() {
+		t.Fatalf("Restarted b didn't take its own lease back")
+	}
+}
+
+func TestLeaderLeaseRunsOut(t *testing.T) {
+	lease := filepath.Join(t.TempDir(), "leader")
+	a := NewLeader(LeaderConfig{Lease: lease, Name: "a", TTL: 3 * time.Second})
+	b := NewLeader(LeaderConfig{Lease: lease, Name: "b", TTL: 3 * time.Second})
+
+	a.Heartbeat()
+	if !a.Leading() {
+		t.Fatalf("a didn't take the lease")
+	}
+	// a stops heartbeating without letting go
+	time.Sleep(3*time.Second + 100*time.Millisecond)
+	b.Heartbeat()
+	if !b.Leading() {
+		t.Fatalf("b didn't take over the expired lease")
+	}
+	a.Heartbeat()
+	if a.Leading() {
+		t.Fatalf("a still thinks it's leading after b took over")
+	}
+}
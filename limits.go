@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+// Route53 has limits on how much can go into a change batch and how fast
+// batches can be sent, and the rate limit is for the whole account, not
+// just us. A big import run flat out can get other things in the account
+// throttled, so the bulk operations split changes by the real batch limits
+// and pace the batches.
+//
+// https://docs.aws.amazon.com/Route53/latest/DeveloperGuide/DNSLimitations.html
+
+const (
+	// ChangeResourceRecordSets calls allowed a second, per account
+	route53ChangeRate = 5
+
+	// Per batch limits. An UPSERT counts twice against both.
+	route53BatchRecords = 1000
+	route53BatchChars   = 32000
+)
+
+// Pace at half the account limit, to leave room for anything else in the
+// account making changes at the same time.
+const changeInterval = 2 * time.Second / route53ChangeRate
+
+// How much of the batch limits a change uses.
+func changeWeight(c types.Change) (records int, chars int) {
+	rec := c.ResourceRecordSet
+	if rec == nil {
+		return 0, 0
+	}
+	records = len(rec.ResourceRecords)
+	if records == 0 {
+		// Alias records still count as one
+		records = 1
+	}
+	for _, r := range rec.ResourceRecords {
+		if r.Value != nil {
+			chars += len(*r.Value)
+		}
+	}
+	if c.Action == types.ChangeActionUpsert {
+		records, chars = records*2, chars*2
+	}
+	return records, chars
+}
+
+// splitChanges breaks changes into batches that fit under the per batch
+// limits, and under max changes a batch if that's lower.
+func splitChanges(changes []types.Change, max int) [][]types.Change {
+	var batches [][]types.Change
+	var batch []types.Change
+	records, chars := 0, 0
+	for _, c := range changes {
+		r, n := changeWeight(c)
+		if len(batch) > 0 && (len(batch) == max || records+r > route53BatchRecords || chars+n > route53BatchChars) {
+			batches = append(batches, batch)
+			batch, records, chars = nil, 0, 0
+		}
+		batch = append(batch, c)
+		records += r
+		chars += n
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// ChangePacer spaces out change batches and keeps count of what's been sent.
+type ChangePacer struct {
+	Batches int
+	Changes int
+	last    time.Time
+}
+
+// Wait holds off until the next batch can go without going over our share
+// of the rate limit, then counts it as sent.
+func (p *ChangePacer) Wait(batch []types.Change) {
+	if !p.last.IsZero() {
+		time.Sleep(time.Until(p.last.Add(changeInterval)))
+	}
+	p.last = time.Now()
+	p.Batches++
+	p.Changes += len(batch)
+}
+
+// EstimateChanges describes how long sending some batches will take at the
+// paced rate, with a warning if it's a lot.
+func EstimateChanges(batches [][]types.Change) string {
+	changes := 0
+	for _, b := range batches {
+		changes += len(b)
+	}
+	took := time.Duration(max(len(batches)-1, 0)) * changeInterval
+	msg := fmt.Sprintf("%d changes in %d batches", changes, len(batches))
+	if len(batches) > route53ChangeRate {
+		msg += fmt.Sprintf(", paced to about %s to stay under the Route53 rate limit of %d requests a second for the account", took.Round(time.Second), route53ChangeRate)
+	}
+	return msg
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// A config can load fine and still be set up in a way that bites later: a
+// day long TTL on a record that changes whenever the ISP feels like it,
+// nothing telling anyone when updates start failing, or credentials that
+// can do anything to every zone in the account. config lint looks for
+// those. The credentials check asks STS who we are and IAM what that's
+// allowed, so it needs iam:List* and iam:Get* on the user or role, and
+// gets skipped with -offline.
+
+// TTLs longer than this mean a changed address takes too long to be seen.
+const lintMaxTTL = 3600
+
+// LintConfig returns what's risky about a config, without asking AWS.
+func LintConfig(conf *Config) []string {
+	var warnings []string
+	ttl := func(where string, ttl int64) {
+		if ttl > lintMaxTTL {
+			warnings = append(warnings, fmt.Sprintf("%s is %ds, over an hour, so resolvers can hold on to an old address that long after it changes", where, ttl))
+		}
+	}
+	ttl("ttl.normal", conf.TTL.Normal)
+	ttl("ttl.after_change", conf.TTL.AfterChange)
+	names := make([]string, 0, len(conf.Domains))
+	for name := range conf.Domains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ttl("The TTL for "+name, conf.Domains[name].TTL)
+	}
+
+	if !conf.Metadata && len(names) > 0 {
+		warnings = append(warnings, fmt.Sprintf("metadata is off, so nothing in DNS marks %s as kept up to date from here, and whoever looks at the zone next can't tell what's safe to touch", strings.Join(names, ", ")))
+	}
+
+	if !notifiesFailures(conf) {
+		warnings = append(warnings, "No notification channel hears about failures, so updates can stop working without anyone finding out")
+	}
+	return warnings
+}
+
+// Whether any notification channel gets failure events.
+func notifiesFailures(conf *Config) bool {
+	if len(conf.Notify.UptimeKuma) > 0 || conf.EventBridge.Bus != "" {
+		return true
+	}
+	wants := func(events []string, domains []string) bool {
+		f, err := NewNotifyFilter(events, domains)
+		return err == nil && (f == nil || f.Wants(Event{Type: EventFailure}))
+	}
+	for _, w := range conf.Notify.Webhooks {
+		if wants(w.Events, w.Domains) {
+			return true
+		}
+	}
+	for _, e := range conf.Notify.Email {
+		if wants(e.Events, e.Domains) {
+			return true
+		}
+	}
+	return false
+}
+
+// LintCredentials looks at what the AWS credentials are allowed to do, and
+// warns about the account root user and policies that allow every Route53
+// action.
+func LintCredentials(cfg aws.Config) ([]string, error) {
+	who, err := sts.NewFromConfig(cfg).GetCallerIdentity(context.TODO(), &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to find out who the credentials belong to: %v", err)
+	}
+	arn := aws.ToString(who.Arn)
+	if strings.HasSuffix(arn, ":root") {
+		return []string{"The credentials are the account root user's, which can do anything at all, use an IAM user or role that can only change the records"}, nil
+	}
+
+	// arn:aws:iam::123456789012:user/path/name, or for a role
+	// arn:aws:sts::123456789012:assumed-role/name/session
+	kind, resource, _ := strings.Cut(arn[strings.LastIndex(arn, ":")+1:], "/")
+	client := iam.NewFromConfig(cfg)
+	var docs map[string]string
+	switch kind {
+	case "user":
+		name := resource[strings.LastIndex(resource, "/")+1:]
+		docs, err = userPolicies(client, name)
+	case "assumed-role":
+		name, _, _ := strings.Cut(resource, "/")
+		docs, err = rolePolicies(client, name)
+	default:
+		return nil, fmt.Errorf("Don't know how to check the policies for %s", arn)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to check the policies for %s: %v", arn, err)
+	}
+
+	var warnings []string
+	for _, name := range sortedKeys(docs) {
+		action, err := wildcardRoute53(docs[name])
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("Couldn't read policy %s: %v", name, err))
+			continue
+		}
+		if action != "" {
+			warnings = append(warnings, fmt.Sprintf("Policy %s on %s allows %s, which covers deleting zones and every record in them, the updates only need route53:ChangeResourceRecordSets and a few reads", name, arn, action))
+		}
+	}
+	return warnings, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// The policy documents that apply to a user, its own and its groups', by
+// policy name.
+func userPolicies(client *iam.Client, user string) (map[string]string, error) {
+	docs := map[string]string{}
+	attached, err := client.ListAttachedUserPolicies(context.TODO(), &iam.ListAttachedUserPoliciesInput{UserName: aws.String(user)})
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range attached.AttachedPolicies {
+		if docs[aws.ToString(p.PolicyName)], err = managedPolicy(client, aws.ToString(p.PolicyArn)); err != nil {
+			return nil, err
+		}
+	}
+	inline, err := client.ListUserPolicies(context.TODO(), &iam.ListUserPoliciesInput{UserName: aws.String(user)})
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range inline.PolicyNames {
+		p, err := client.GetUserPolicy(context.TODO(), &iam.GetUserPolicyInput{UserName: aws.String(user), PolicyName: aws.String(name)})
+		if err != nil {
+			return nil, err
+		}
+		docs[name] = aws.ToString(p.PolicyDocument)
+	}
+
+	groups, err := client.ListGroupsForUser(context.TODO(), &iam.ListGroupsForUserInput{UserName: aws.String(user)})
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range groups.Groups {
+		group := g.GroupName
+		attached, err := client.ListAttachedGroupPolicies(context.TODO(), &iam.ListAttachedGroupPoliciesInput{GroupName: group})
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range attached.AttachedPolicies {
+			if docs[aws.ToString(p.PolicyName)], err = managedPolicy(client, aws.ToString(p.PolicyArn)); err != nil {
+				return nil, err
+			}
+		}
+		inline, err := client.ListGroupPolicies(context.TODO(), &iam.ListGroupPoliciesInput{GroupName: group})
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range inline.PolicyNames {
+			p, err := client.GetGroupPolicy(context.TODO(), &iam.GetGroupPolicyInput{GroupName: group, PolicyName: aws.String(name)})
+			if err != nil {
+				return nil, err
+			}
+			docs[name] = aws.ToString(p.PolicyDocument)
+		}
+	}
+	return docs, nil
+}
+
+// The policy documents on a role, by policy name.
+func rolePolicies(client *iam.Client, role string) (map[string]string, error) {
+	docs := map[string]string{}
+	attached, err := client.ListAttachedRolePolicies(context.TODO(), &iam.ListAttachedRolePoliciesInput{RoleName: aws.String(role)})
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range attached.AttachedPolicies {
+		if docs[aws.ToString(p.PolicyName)], err = managedPolicy(client, aws.ToString(p.PolicyArn)); err != nil {
+			return nil, err
+		}
+	}
+	inline, err := client.ListRolePolicies(context.TODO(), &iam.ListRolePoliciesInput{RoleName: aws.String(role)})
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range inline.PolicyNames {
+		p, err := client.GetRolePolicy(context.TODO(), &iam.GetRolePolicyInput{RoleName: aws.String(role), PolicyName: aws.String(name)})
+		if err != nil {
+			return nil, err
+		}
+		docs[name] = aws.ToString(p.PolicyDocument)
+	}
+	return docs, nil
+}
+
+// The current version of a managed policy's document.
+func managedPolicy(client *iam.Client, arn string) (string, error) {
+	p, err := client.GetPolicy(context.TODO(), &iam.GetPolicyInput{PolicyArn: aws.String(arn)})
+	if err != nil {
+		return "", err
+	}
+	v, err := client.GetPolicyVersion(context.TODO(), &iam.GetPolicyVersionInput{PolicyArn: aws.String(arn), VersionId: p.Policy.DefaultVersionId})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(v.PolicyVersion.Document), nil
+}
+
+// Policy documents let a statement be one statement or a list of them, and
+// an action be one action or a list.
+type oneOrMore []json.RawMessage
+
+func (o *oneOrMore) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '[' {
+		return json.Unmarshal(data, (*[]json.RawMessage)(o))
+	}
+	*o = oneOrMore{data}
+	return nil
+}
+
+// The action that allows everything in Route53, * or route53:*, if the
+// policy has one. IAM hands the documents back URL encoded.
+func wildcardRoute53(doc string) (string, error) {
+	if decoded, err := url.QueryUnescape(doc); err == nil {
+		doc = decoded
+	}
+	var policy struct {
+		Statement oneOrMore
+	}
+	if err := json.Unmarshal([]byte(doc), &policy); err != nil {
+		return "", err
+	}
+	for _, raw := range policy.Statement {
+		var statement struct {
+			Effect string
+			Action oneOrMore
+		}
+		if err := json.Unmarshal(raw, &statement); err != nil {
+			return "", err
+		}
+		if statement.Effect != "Allow" {
+			continue
+		}
+		for _, a := range statement.Action {
+			var action string
+			if err := json.Unmarshal(a, &action); err != nil {
+				return "", errors.New("an action isn't a string")
+			}
+			if action == "*" || strings.EqualFold(action, "route53:*") {
+				return action, nil
+			}
+		}
+	}
+	return "", nil
+}
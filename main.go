@@ -1,134 +1,1934 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/route53"
 	"github.com/aws/aws-sdk-go-v2/service/route53/types"
-	"github.com/rdegges/go-ipify"
+	"github.com/aws/smithy-go"
+	"github.com/mikerowehl/route53Update/route53update"
+	"golang.org/x/net/dns/dnsmessage"
+	"gopkg.in/yaml.v3"
 )
 
-// Looks up the HostedZone info for a group of records on route53. I've been
-// using this to update the apex record for the domain I use, so it checks to
-// see if the name of the hosted zone exactly matches the domain.
-func GetHostedZone(client *route53.Client, domain string) (*types.HostedZone, error) {
-	req := &route53.ListHostedZonesByNameInput{
-		DNSName: &domain,
+// Run the dyndns2 compatible update server, so routers on the network can
+// push their own address changes through to Route53.
+func runServe(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	confFlags := addConfigFlags(flags)
+	listen := flags.String("listen", "", "address to listen on, overrides the config")
+	fips := flags.Bool("fips", false, "use FIPS validated AWS endpoints")
+	flags.Parse(args)
+
+	if *confFlags.path == "" {
+		log.Fatalf("serve needs a config file, use -config")
+	}
+	conf := withFIPS(confFlags.load(), *fips)
+	if *listen != "" {
+		conf.Server.Listen = *listen
+	}
+	if conf.Server.Listen == "" {
+		conf.Server.Listen = ":8245"
+	}
+
+	creds, err := NewCredentialStore(conf.Server.Users)
+	if err != nil {
+		log.Fatalf("Unable to load users: %v", err)
+	}
+
+	cfg, _, err := LoadAWSConfig(conf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	client := route53.NewFromConfig(cfg)
+
+	var registry *ClientRegistry
+	if len(conf.Server.Clients) > 0 || conf.Server.Registry.DB != "" {
+		registry, err = NewClientRegistry(conf.Server.Clients, conf.Server.Registry)
+		if err != nil {
+			log.Fatalf("Unable to load clients: %v", err)
+		}
+	}
+
+	checker, err := NewAddressChecker(conf)
+	if err != nil {
+		log.Fatalf("Unable to set up address checks: %v", err)
+	}
+
+	reporter, err := NewReporter(conf)
+	if err != nil {
+		log.Fatalf("Unable to set up reporting: %v", err)
+	}
+
+	zones := NewZoneCache(client)
+	zones.Preload(conf.Server.ZoneNames())
+
+	approvals := NewApprovals(conf.Approval)
+	if approvals != nil {
+		approvals.State = conf.State
+	}
+	server := NewServer(client, zones, creds, registry, reporter, checker, approvals)
+	server.domains = conf.Domains
+	server.ttls = conf.TTL
+	server.policies, err = NewPolicies(conf)
+	if err != nil {
+		log.Fatalf("Unable to set up policies: %v", err)
+	}
+	server.providers, err = NewProviders(conf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if registry != nil {
+		go server.ExpireClients(time.Minute)
+	}
+	if changeBreaker != nil {
+		go func() {
+			for range time.Tick(time.Hour) {
+				changeBreaker.Reset()
+			}
+		}()
+	}
+	accessLog, err := NewAccessLog(conf.Server.AccessLog)
+	if err != nil {
+		log.Fatal(err)
+	}
+	handler := accessLog.Wrap(server.Handler())
+	if len(conf.Server.TLS.Hostnames) == 0 {
+		log.Printf("Listening for updates on %s", conf.Server.Listen)
+		log.Fatal(http.ListenAndServe(conf.Server.Listen, handler))
+	}
+
+	certs, err := NewCertManager(conf.Server.TLS, client, zones)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := certs.Renew(); err != nil {
+		log.Fatal(err)
+	}
+	go certs.KeepRenewed(12 * time.Hour)
+	httpsServer := &http.Server{
+		Addr:      conf.Server.Listen,
+		Handler:   handler,
+		TLSConfig: &tls.Config{GetCertificate: certs.GetCertificate},
+	}
+	log.Printf("Listening for updates on %s with TLS", conf.Server.Listen)
+	log.Fatal(httpsServer.ListenAndServeTLS("", ""))
+}
+
+// Print a bcrypt hash of a password for the users section of the config.
+// The password is read from stdin so it doesn't end up in shell history.
+func runHashPassword() {
+	fmt.Fprintf(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		log.Fatalf("Failed reading password: %v", err)
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		log.Fatalf("Password can't be empty")
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		log.Fatalf("Failed to hash password: %v", err)
+	}
+	fmt.Println(hash)
+}
+
+// Print a new random client token, along with the hash of it that goes in
+// the clients section of the config.
+func runNewToken() {
+	token, hash, err := NewToken()
+	if err != nil {
+		log.Fatalf("Failed to make token: %v", err)
+	}
+	fmt.Printf("token:        %s\ntoken_sha256: %s\n", token, hash)
+}
+
+// Manage the clients in the registry database while the server runs. add
+// prints the new client's token, which isn't kept anywhere, only its hash.
+func runClients(args []string) {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "usage: %s clients list [-history <n>] | add -name <name> -hostname <pattern> -zone <zone> | revoke <name> -config <file>\n", os.Args[0])
+		os.Exit(2)
 	}
+	if len(args) == 0 {
+		usage()
+	}
+	flags := flag.NewFlagSet("clients "+args[0], flag.ExitOnError)
+	confFlags := addConfigFlags(flags)
+	history := flags.Int("history", 0, "show this many of each client's latest changes")
+	name := flags.String("name", "", "name of the client to add")
+	hostname := flags.String("hostname", "", "hostname, or pattern like *.home.example.com, the client can update")
+	zone := flags.String("zone", "", "hosted zone the client's records go in")
+	positional := parseInterspersed(flags, args[1:])
 
-	res, err := client.ListHostedZonesByName(context.TODO(), req)
+	if *confFlags.path == "" {
+		log.Fatalf("clients needs the server's config file, use -config")
+	}
+	conf := confFlags.load()
+	if conf.Server.Registry.DB == "" {
+		log.Fatalf("The config doesn't have a registry db, set server.registry.db")
+	}
+	db, err := openClientDB(conf.Server.Registry.DB)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to get hosted zones: %v", err)
+		log.Fatal(err)
 	}
 
-	for _, zone := range res.HostedZones {
-		if *zone.Name == domain {
-			return &zone, nil
+	switch args[0] {
+	case "list":
+		clients, err := db.list()
+		if err != nil {
+			log.Fatalf("Failed to list clients: %v", err)
+		}
+		printClients(os.Stdout, clients)
+		if *history <= 0 {
+			return
+		}
+		for _, c := range clients {
+			updates, err := db.history(c.Name, *history)
+			if err != nil {
+				log.Fatalf("Failed to get history for %s: %v", c.Name, err)
+			}
+			fmt.Printf("\n%s:\n", c.Name)
+			for _, u := range updates {
+				oldIp := u.OldIp
+				if oldIp == "" {
+					oldIp = "none"
+				}
+				fmt.Printf("  %s  %s  %s -> %s\n", u.Time.Format(time.RFC3339), u.Hostname, oldIp, u.NewIp)
+			}
+		}
+	case "add":
+		if *name == "" || *hostname == "" || *zone == "" || len(positional) != 0 {
+			usage()
 		}
+		token, hash, err := NewToken()
+		if err != nil {
+			log.Fatalf("Failed to make token: %v", err)
+		}
+		if err := db.add(ClientConfig{Name: *name, TokenHash: hash, Hostname: *hostname, Zone: *zone}); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Added %s, its token is %s\n", *name, token)
+	case "revoke":
+		if len(positional) != 1 {
+			usage()
+		}
+		if err := db.revoke(positional[0]); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Revoked %s\n", positional[0])
+	default:
+		usage()
 	}
-	return nil, fmt.Errorf("Can't match domain %s to zone", domain)
 }
 
-// Return the ip address of the A rec for the overall domain. I use this with
-// a very simple setup, so I just return the first value for the resource
-// record set that matches the exact domain and has type A rec.
-func GetARecIp(client *route53.Client, zone string, domain string) (string, error) {
-	req := &route53.ListResourceRecordSetsInput{
-		HostedZoneId: aws.String(zone),
+func printClients(w io.Writer, clients []ClientRecord) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "CLIENT\tHOSTNAME\tZONE\tLAST SEEN\tSTATUS\tADDRESSES\n")
+	for _, c := range clients {
+		lastSeen := "never"
+		if !c.LastSeen.IsZero() {
+			lastSeen = c.LastSeen.Format(time.RFC3339)
+		}
+		status := "active"
+		switch {
+		case !c.Revoked.IsZero():
+			status = "revoked " + c.Revoked.Format("2006-01-02")
+		case c.Expired:
+			status = "expired"
+		}
+		var hosts []string
+		for hostname, ip := range c.Hostnames {
+			hosts = append(hosts, hostname+"="+ip)
+		}
+		sort.Strings(hosts)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", c.Name, c.Hostname, c.Zone, lastSeen, status, strings.Join(hosts, " "))
 	}
+	tw.Flush()
+}
 
-	recs, err := client.ListResourceRecordSets(context.TODO(), req)
+// Run the privileged half of a split setup, holding the AWS credentials and
+// making changes for the unprivileged half over a local socket.
+func runHelper(args []string) {
+	flags := flag.NewFlagSet("helper", flag.ExitOnError)
+	confFlags := addConfigFlags(flags)
+	socket := flags.String("socket", "", "socket to listen on, overrides the config")
+	fips := flags.Bool("fips", false, "use FIPS validated AWS endpoints")
+	flags.Parse(args)
+
+	if *confFlags.path == "" {
+		log.Fatalf("helper needs a config file, use -config")
+	}
+	conf := withFIPS(confFlags.load(), *fips)
+	if *socket != "" {
+		conf.Helper.Socket = *socket
+	}
+	if conf.Helper.Socket == "" {
+		log.Fatalf("helper needs a socket, set helper.socket in the config or use -socket")
+	}
+	helper, err := NewHelper(conf)
 	if err != nil {
-		return "", err
+		log.Fatal(err)
 	}
+	log.Fatal(helper.Listen(conf.Helper.Socket))
+}
 
-	for _, rec := range recs.ResourceRecordSets {
-		if *rec.Name == domain && rec.Type == types.RRTypeA {
-			return *rec.ResourceRecords[0].Value, nil
+func runAudit(args []string) {
+	if len(args) > 0 {
+		switch args[0] {
+		case "keygen":
+			runAuditKeygen(args[1:])
+			return
+		case "verify":
+			runAuditVerify(args[1:])
+			return
 		}
 	}
-	return "", fmt.Errorf("Could not find A rec for top level name")
+	fmt.Fprintf(os.Stderr, "usage: %s audit keygen <key file> | audit verify [-config <file>] [-public-key <key>] [<log file>]\n", os.Args[0])
+	os.Exit(2)
+}
+
+// Make a signing key for the audit log. The public key gets printed rather
+// than saved, it's for keeping somewhere away from this box.
+func runAuditKeygen(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: %s audit keygen <key file>\n", os.Args[0])
+		os.Exit(2)
+	}
+	key, pub, err := NewAuditKey()
+	if err != nil {
+		log.Fatalf("Failed to make key: %v", err)
+	}
+	f, err := os.OpenFile(args[0], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Fatalf("Failed to write key: %v", err)
+	}
+	if _, err := f.Write(key); err != nil {
+		log.Fatalf("Failed to write key: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalf("Failed to write key: %v", err)
+	}
+	fmt.Printf("public_key: %s\n", pub)
 }
 
-// Changes the top level A rec for the domain passed in to point to the ip
-// addr provided. Also, very simple and static, assume just a single record
-// for the current address and that's it.
-func UpdateIp(client *route53.Client, zone string, domain string, ip string) (*route53.ChangeResourceRecordSetsOutput, error) {
-	change := types.Change{
-		Action: types.ChangeActionUpsert,
-		ResourceRecordSet: &types.ResourceRecordSet{
-			Name: aws.String(domain),
-			Type: types.RRTypeA,
-			ResourceRecords: []types.ResourceRecord{
-				{
-					Value: aws.String(ip),
-				},
-			},
-			TTL: aws.Int64(300),
-		},
+// Check the audit log hasn't been tampered with. The log and key come from
+// the config unless they're given, and the exit code is 1 if anything's
+// wrong with it.
+func runAuditVerify(args []string) {
+	flags := flag.NewFlagSet("audit verify", flag.ExitOnError)
+	confFlags := addConfigFlags(flags)
+	pubKey := flags.String("public-key", "", "public key from audit keygen to check the signatures with")
+	positional := parseInterspersed(flags, args)
+	if len(positional) > 1 {
+		fmt.Fprintf(os.Stderr, "usage: %s audit verify [-config <file>] [-public-key <key>] [<log file>]\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	var path string
+	var pub ed25519.PublicKey
+	if conf := confFlags.load(); conf != nil {
+		path = conf.Audit.Path
+		if conf.Audit.Key != "" {
+			key, err := readAuditKey(conf.Audit.Key)
+			if err != nil {
+				log.Fatal(err)
+			}
+			pub = key.Public().(ed25519.PublicKey)
+		}
+	}
+	if len(positional) == 1 {
+		path = positional[0]
+	}
+	if path == "" {
+		log.Fatalf("No audit log to check, give one or a config with an audit path")
+	}
+	if *pubKey != "" {
+		var err error
+		if pub, err = ParseAuditPublicKey(*pubKey); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("Failed to open audit log: %v", err)
 	}
-	params := &route53.ChangeResourceRecordSetsInput{
-		ChangeBatch: &types.ChangeBatch{
-			Changes: []types.Change{change},
-		},
-		HostedZoneId: aws.String(zone),
+	defer f.Close()
+	sum, err := VerifyAuditLog(f, pub)
+	if err != nil {
+		fmt.Printf("%s: %v\n", path, err)
+		fmt.Printf("%d entries check out before that\n", sum.Entries)
+		os.Exit(1)
+	}
+	signed := "signatures not checked"
+	if sum.Signed {
+		signed = "all signed"
 	}
+	fmt.Printf("%s: %d entries, chain intact, %s\n", path, sum.Entries, signed)
+	if sum.LastHash != "" {
+		fmt.Printf("last hash: %s\n", sum.LastHash)
+	}
+}
 
-	res, err := client.ChangeResourceRecordSets(context.TODO(), params)
-	return res, err
+// The flags every command that reads the config takes.
+type configFlags struct {
+	path    *string
+	profile *string
+	set     Overrides
+	refresh *bool
 }
 
-func main() {
-	// All the calls want full domain format, but that's not what I
-	// normally give as a domain name, so tack on the period at the end
-	domain := os.Args[1] + "."
+func addConfigFlags(flags *flag.FlagSet) *configFlags {
+	c := &configFlags{
+		path:    flags.String("config", "", "path to the YAML config file"),
+		profile: flags.String("profile", "", "named profile from the config file"),
+		refresh: flags.Bool("refresh-zones", false, "look up hosted zones again instead of using the ones in the state file"),
+	}
+	flags.Var(&c.set, "set", "override a config key for this run, key=value, can be repeated")
+	return c
+}
+
+// Load the config if there is one, nil if not. Profiles and overrides only
+// make sense with a config file.
+func (c *configFlags) load() *Config {
+	if *c.path == "" {
+		if *c.profile != "" || len(c.set) > 0 {
+			log.Fatalf("-profile and -set need a config file, use -config")
+		}
+		return nil
+	}
+	conf, err := LoadConfig(*c.path, *c.profile, c.set)
+	if err != nil {
+		log.Fatalf("Unable to load config: %v", err)
+	}
+	stateConfig = conf
+	recycleBin = conf.RecycleBin
+	if conf.ZoneCacheTTL > 0 {
+		zoneState, zoneCacheTTL, refreshZones = conf.State, conf.ZoneCacheTTL, *c.refresh
+	}
+	setupBreaker(conf)
+	if auditLog, err = NewAuditLog(conf.Audit); err != nil {
+		log.Fatalf("Unable to set up the audit log: %v", err)
+	}
+	// Already checked over when the config was loaded
+	custom, _ := conf.httpSources()
+	if source, _ := ipSourceFrom(conf.IPSource, conf.IPConsensus, conf.IPInterface, conf.STUNServers, custom); source != nil {
+		route53update.SetIPSource(source)
+	}
+	return conf
+}
 
-	// Get our public IP by using the ipify server to tell us what it
-	// tooks like our IP address is
-	ip, err := ipify.GetIp()
+// The -fips flag turns on FIPS endpoints the same as setting it in the
+// config does, making up an empty config if there isn't one.
+func withFIPS(conf *Config, fips bool) *Config {
+	if !fips {
+		return conf
+	}
+	if conf == nil {
+		conf = &Config{}
+	}
+	conf.AWS.FIPS = true
+	return conf
+}
+
+// Set up an Updater with the AWS client and the reporting and checks from
+// the config.
+func newUpdater(conf *Config, source string) *Updater {
+	checker, err := NewAddressChecker(conf)
 	if err != nil {
-		log.Fatalf("Failed getting current ip: %v", err)
+		exitOn(fmt.Errorf("Unable to set up address checks: %w", err))
 	}
-	fmt.Printf("Current ip address: %s\n", ip)
 
 	// Load up the default AWS config, assuming it can read and write to
 	// route53 for the domain we want to use
-	cfg, err := config.LoadDefaultConfig(context.TODO())
+	cfg, partition, err := LoadAWSConfig(conf)
+	if err != nil {
+		exitOn(err)
+	}
+
+	reporter, err := NewReporter(conf)
+	if err != nil {
+		exitOn(fmt.Errorf("Unable to set up reporting: %w", err))
+	}
+
+	client := route53.NewFromConfig(cfg)
+	updater := &Updater{
+		Client:   client,
+		Zones:    NewZoneCache(client),
+		Reporter: reporter,
+		Checker:  checker,
+		Source:   source,
+		IPv6:     conf != nil && conf.IPv6,
+
+		MonitorOnly: conf != nil && conf.MonitorOnly,
+	}
+	if conf != nil {
+		updater.Domains = conf.Domains
+		updater.Approvals = NewApprovals(conf.Approval)
+		// Already checked over when the config was loaded
+		updater.Windows, _ = ParseTimeWindows(conf.Maintenance.Windows)
+		updater.TTL = conf.TTL
+		updater.Metadata = conf.Metadata
+		updater.Records = conf.Records
+		updater.PreferFamily = conf.PreferFamily
+		updater.Hooks = conf.Hooks
+		updater.State = conf.State
+		updater.Locks = conf.Locks
+		updater.CacheMaxAge = conf.CacheMaxAge
+	}
+	updater.Providers, err = NewProviders(conf)
+	if err != nil {
+		exitOn(err)
+	}
+	updater.Policies, err = NewPolicies(conf)
+	if err != nil {
+		exitOn(fmt.Errorf("Unable to set up policies: %w", err))
+	}
+	if conf != nil && conf.Drift.CloudTrail {
+		updater.Attributor = NewCloudTrailAttributor(cfg, partition)
+	}
+	return updater
+}
+
+// Keep checking the domains on an interval, for running as a service
+// instead of out of cron.
+func runWatch(args []string) {
+	flags := flag.NewFlagSet("watch", flag.ExitOnError)
+	confFlags := addConfigFlags(flags)
+	interval := flags.Duration("interval", 5*time.Minute, "how often to check, for domains without their own interval")
+	fips := flags.Bool("fips", false, "use FIPS validated AWS endpoints")
+	monitor := flags.Bool("monitor", false, "report mismatches but never change Route53")
+	dryRun := flags.Bool("dry-run", false, "do all the checks but don't change anything")
+	records := addRecordFlags(flags)
+	flags.Parse(args)
+
+	conf := withFIPS(confFlags.load(), *fips)
+	domains := watchedDomains(conf, flags.Args())
+	if len(domains) == 0 {
+		fmt.Fprintf(os.Stderr, "usage: %s watch [-config <file>] [-profile <name>] [-interval 5m] [-fips] [-monitor] [-dry-run] %s [<domain>...]\n", os.Args[0], recordUsage)
+		os.Exit(2)
+	}
+
+	updater := newUpdater(conf, "watch")
+	updater.MonitorOnly = updater.MonitorOnly || *monitor
+	if *dryRun {
+		updater.StartDryRun()
+	}
+	records.apply(updater)
+	digest, err := NewDigest(conf, updater.Reporter)
+	if err != nil {
+		log.Fatalf("Unable to set up digest: %v", err)
+	}
+
+	// A nil channel never fires, so without a digest configured the select
+	// below just waits on the schedule.
+	var digestTimer <-chan time.Time
+	if digest != nil {
+		next := digest.Next(time.Now())
+		log.Printf("Next digest at %s", next.Format(time.RFC1123))
+		digestTimer = time.After(time.Until(next))
+	}
+
+	updater.Zones.Preload(updater.zoneNames(domains))
+
+	schedule := watchSchedule(conf, domains, *interval)
+
+	// With a standby the checks still go by the schedule, but only the
+	// copy holding the lease does anything
+	var leader *Leader
+	if conf != nil {
+		leader = NewLeader(conf.Leader)
+	}
+	if leader != nil {
+		leader.Heartbeat()
+		if !leader.Leading() {
+			log.Printf("Standing by until the lease at %s runs out", leader.path)
+		}
+		go leader.Run()
+
+		// Hand the lease over on the way out rather than making the
+		// standby wait for it to run out
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-stop
+			leader.Release()
+			os.Exit(0)
+		}()
+	}
+
+	// Temporary records in the watched zones get cleaned up along the way
+	reapTicker := time.NewTicker(time.Minute)
+	defer reapTicker.Stop()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-reapTicker.C:
+			if leader.Leading() {
+				updater.reapZones(domains)
+			}
+		case now := <-timer.C:
+			due := schedule.Due(now)
+			if leader.Leading() {
+				changeBreaker.Reset()
+				for i, err := range updater.UpdateAll(context.Background(), due) {
+					if err != nil {
+						log.Printf("Update of %s failed: %v", due[i], err)
+					}
+				}
+				updater.Reporter.PushMetrics()
+			}
+			timer.Reset(time.Until(schedule.Next()))
+		case now := <-digestTimer:
+			if leader.Leading() {
+				if err := digest.Send(now); err != nil {
+					log.Printf("Failed to send digest: %v", err)
+				}
+			}
+			digestTimer = time.After(time.Until(digest.Next(now)))
+		}
+	}
+}
+
+// The flags for which records get managed and how, for the commands that
+// check records. They override what the config says.
+type recordFlags struct {
+	rtype     *string
+	prefer    *int
+	ttl       *int64
+	zoneId    *string
+	private   *bool
+	vpcId     *string
+	split     *bool
+	ipSource  *string
+	iface     *string
+	stun      *string
+	consensus *int
+}
+
+// Route53 takes TTLs up to a signed 32 bit number of seconds.
+const maxTTL = 1<<31 - 1
+
+func addRecordFlags(flags *flag.FlagSet) *recordFlags {
+	return &recordFlags{
+		rtype:     flags.String("type", "", "records to manage, A, AAAA, both, or auto, for domains without their own type"),
+		prefer:    flags.Int("prefer-family", 0, "manage one record, 4 for the A rec or 6 for the AAAA rec, falling back to the other"),
+		ttl:       flags.Int64("ttl", 0, "TTL in seconds for records that get changed, over any TTL in the config"),
+		zoneId:    flags.String("zone-id", "", "hosted zone id to use instead of looking one up by name"),
+		private:   flags.Bool("private", false, "use the private hosted zone with the name instead of the public one"),
+		vpcId:     flags.String("vpc-id", "", "use the private hosted zone associated with this VPC"),
+		split:     flags.Bool("split-horizon", false, "put the LAN address in the private zone too, along with the public address in the public zone"),
+		ipSource:  flags.String("ip-source", "", "where to find the public addresses, "+strings.Join(route53update.IPSourceNames(), ", ")+", or several separated by commas to try in order"),
+		iface:     flags.String("iface", "", "network interface for the interface -ip-source to read, which it defaults to"),
+		stun:      flags.String("stun-server", "", "STUN servers for the stun -ip-source to ask, host or host:port separated by commas, which it defaults to"),
+		consensus: flags.Int("ip-consensus", 0, "ask every -ip-source and need this many to agree"),
+	}
+}
+
+func (r *recordFlags) apply(updater *Updater) {
+	switch {
+	case *r.rtype != "" && *r.prefer != 0:
+		log.Fatalf("Use either -type or -prefer-family, not both")
+	case *r.rtype != "":
+		if !validRecords(*r.rtype) {
+			log.Fatalf("-type must be A, AAAA, both, or auto, not %q", *r.rtype)
+		}
+		updater.Records = *r.rtype
+		updater.PreferFamily = 0
+	case *r.prefer != 0:
+		if *r.prefer != 4 && *r.prefer != 6 {
+			log.Fatalf("-prefer-family must be 4 or 6, not %d", *r.prefer)
+		}
+		updater.PreferFamily = *r.prefer
+		updater.Records = ""
+	}
+	if *r.ttl < 0 || *r.ttl > maxTTL {
+		log.Fatalf("-ttl must be between 1 and %d, not %d", maxTTL, *r.ttl)
+	}
+	updater.FixedTTL = *r.ttl
+	updater.ZoneId = *r.zoneId
+	if *r.zoneId != "" && (*r.private || *r.vpcId != "") {
+		log.Fatalf("-zone-id already says which zone, it can't go with -private or -vpc-id")
+	}
+	updater.PrivateZone = *r.private
+	updater.VPCId = *r.vpcId
+	updater.SplitHorizon = *r.split
+	if *r.consensus != 0 && *r.ipSource == "" {
+		log.Fatalf("-ip-consensus needs a list of sources in -ip-source")
+	}
+	var stunServers []string
+	if *r.stun != "" {
+		stunServers = strings.Split(*r.stun, ",")
+	}
+	// The config was loaded before the flags get applied, and its own
+	// endpoints can be named on the command line too
+	custom, _ := stateConfig.httpSources()
+	source, err := ipSourceFrom(*r.ipSource, *r.consensus, *r.iface, stunServers, custom)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if source != nil {
+		route53update.SetIPSource(source)
+	}
+}
+
+// The IP source from the settings, nil if there aren't any. An interface or
+// STUN servers on their own mean that source.
+func ipSourceFrom(spec string, quorum int, iface string, stunServers []string, custom []route53update.IPSource) (route53update.IPSource, error) {
+	if spec == "" {
+		switch {
+		case iface != "" && len(stunServers) > 0:
+			return nil, fmt.Errorf("Give a list of IP sources to say how to use both an interface and STUN servers")
+		case iface != "":
+			spec = "interface"
+		case len(stunServers) > 0:
+			spec = "stun"
+		default:
+			return nil, nil
+		}
+	}
+	names := map[string]bool{}
+	for _, name := range strings.Split(spec, ",") {
+		names[strings.TrimSpace(name)] = true
+	}
+	if iface != "" && !names["interface"] {
+		return nil, fmt.Errorf("An interface to read the address from only makes sense with the interface IP source, not %s", spec)
+	}
+	if len(stunServers) > 0 && !names["stun"] {
+		return nil, fmt.Errorf("STUN servers only make sense with the stun IP source, not %s", spec)
+	}
+	return route53update.ParseIPSourceWith(spec, quorum, route53update.IPSourceOptions{Interface: iface, STUNServers: stunServers, Custom: custom})
+}
+
+// Domains on the command line, or everything in the domains section of the
+// config if there aren't any.
+func watchedDomains(conf *Config, args []string) []string {
+	var domains []string
+	for _, arg := range args {
+		domains = append(domains, mustExpandHostname(arg))
+	}
+	if len(domains) == 0 && conf != nil {
+		for name := range conf.Domains {
+			domains = append(domains, name)
+		}
+		sort.Strings(domains)
+	}
+	return domains
+}
+
+// Everything gets checked right away, then each domain on its own interval
+// after that.
+func watchSchedule(conf *Config, domains []string, interval time.Duration) *Schedule {
+	schedule := &Schedule{}
+	start := time.Now()
+	for _, name := range domains {
+		every := interval
+		if conf != nil && conf.Domains[name].Interval > 0 {
+			every = conf.Domains[name].Interval
+		}
+		schedule.Add(name, every, start)
+	}
+	return schedule
+}
+
+// Summarize the history file.
+func runStats(args []string) {
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	confFlags := addConfigFlags(flags)
+	domain := flags.String("domain", "", "only count events for this domain")
+	flags.Parse(args)
+
+	conf := confFlags.load()
+	if conf == nil || conf.History == "" {
+		log.Fatalf("stats needs a config file with a history file set")
+	}
+
+	now := time.Now()
+	events, err := ReadHistory(conf.History, now.AddDate(0, 0, -90))
+	if err != nil {
+		log.Fatalf("Unable to load history: %v", err)
+	}
+	if *domain != "" {
+		var filtered []Event
+		for _, e := range events {
+			if route53update.NormalizeHostname(e.Domain) == route53update.NormalizeHostname(*domain) {
+				filtered = append(filtered, e)
+			}
+		}
+		events = filtered
+	}
+	ComputeStats(events, now).Print(os.Stdout)
+}
+
+// Dump the history file for use somewhere else.
+func runHistory(args []string) {
+	if len(args) == 0 || args[0] != "export" {
+		fmt.Fprintf(os.Stderr, "usage: %s history export [-format csv|json] [-since 90d] [-domain <name>]\n", os.Args[0])
+		os.Exit(2)
+	}
+	flags := flag.NewFlagSet("history export", flag.ExitOnError)
+	confFlags := addConfigFlags(flags)
+	format := flags.String("format", "csv", "csv or json")
+	since := flags.String("since", "", "only events from this far back, like 90d, 12h, or 2006-01-02")
+	domain := flags.String("domain", "", "only events for this domain")
+	output := flags.String("o", "", "write to a file instead of stdout")
+	flags.Parse(args[1:])
+
+	conf := confFlags.load()
+	if conf == nil || conf.History == "" {
+		log.Fatalf("history export needs a config file with a history file set")
+	}
+	from, err := ParseSince(*since, time.Now())
 	if err != nil {
-		log.Fatalf("Unable to load AWS config: %v", err)
+		log.Fatal(err)
+	}
+	events, err := ReadHistory(conf.History, from)
+	if err != nil {
+		log.Fatalf("Unable to load history: %v", err)
+	}
+	if *domain != "" {
+		var filtered []Event
+		for _, e := range events {
+			if route53update.NormalizeHostname(e.Domain) == route53update.NormalizeHostname(*domain) {
+				filtered = append(filtered, e)
+			}
+		}
+		events = filtered
+	}
+
+	w := io.Writer(os.Stdout)
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Fatalf("Failed to create %s: %v", *output, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := ExportHistory(w, events, *format); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Go's flag package stops at the first positional argument, but commands
+// like "query-logging enable example.com -log-group foo" read better with
+// the flags at the end. This parses flags from anywhere in the args and
+// returns the positional ones.
+func parseInterspersed(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		flags.Parse(args)
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// Turn Route53 query logging on or off for a zone.
+func runQueryLogging(args []string) {
+	flags := flag.NewFlagSet("query-logging", flag.ExitOnError)
+	confFlags := addConfigFlags(flags)
+	fips := flags.Bool("fips", false, "use FIPS validated AWS endpoints")
+	logGroup := flags.String("log-group", "", "CloudWatch Logs group in the global region (us-east-1), defaults to /aws/route53/<zone>")
+	positional := parseInterspersed(flags, args)
+	if len(positional) != 2 || (positional[0] != "enable" && positional[0] != "disable") {
+		fmt.Fprintf(os.Stderr, "usage: %s query-logging enable|disable <zone> [-config <file>] [-profile <name>] [-fips] [-log-group <name>]\n", os.Args[0])
+		os.Exit(2)
+	}
+	action, zoneName := positional[0], route53update.NormalizeHostname(positional[1])
+
+	cfg, partition, err := LoadAWSConfig(withFIPS(confFlags.load(), *fips))
+	if err != nil {
+		log.Fatal(err)
 	}
 	client := route53.NewFromConfig(cfg)
 
-	// We need the zone id and not just the domain
-	zone, err := GetHostedZone(client, domain)
+	zone, err := route53update.GetHostedZone(client, zoneName+".")
 	if err != nil {
 		log.Fatalf("Failed to find zone: %v", err)
 	}
-	fmt.Printf("Found zone: %s\n", *zone.Id)
 
-	// Look up the IP address current in route53
-	configuredIp, err := GetARecIp(client, *zone.Id, domain)
+	if action == "disable" {
+		if err := DisableQueryLogging(client, *zone.Id); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Query logging disabled for %s\n", zoneName)
+		return
+	}
+
+	if *logGroup == "" {
+		*logGroup = "/aws/route53/" + zoneName
+	}
+	id, err := EnableQueryLogging(cfg, partition, client, *zone.Id, *logGroup)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Query logging enabled for %s into %s. Config: %s\n", zoneName, *logGroup, id)
+}
+
+// Check that a zone's registrar delegation matches its Route53 nameservers
+// and that they all answer for it. Any problem gets reported, so it goes
+// out to the notifiers, and exits with 1.
+func runZone(args []string) {
+	flags := flag.NewFlagSet("zone check", flag.ExitOnError)
+	confFlags := addConfigFlags(flags)
+	fips := flags.Bool("fips", false, "use FIPS validated AWS endpoints")
+	positional := parseInterspersed(flags, args)
+	if len(positional) != 2 || positional[0] != "check" {
+		fmt.Fprintf(os.Stderr, "usage: %s zone check <zone> [-config <file>] [-profile <name>] [-fips]\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	conf := withFIPS(confFlags.load(), *fips)
+	cfg, _, err := LoadAWSConfig(conf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	check, err := CheckDelegation(route53.NewFromConfig(cfg), positional[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	check.Print(os.Stdout)
+
+	problems := check.Problems()
+	if len(problems) == 0 {
+		fmt.Printf("%s is delegated to its Route53 nameservers and they all answer for it\n", check.Zone)
+		return
+	}
+	for _, p := range problems {
+		fmt.Printf("Problem: %s\n", p)
+	}
+	reporter, err := NewReporter(conf)
+	if err != nil {
+		log.Fatalf("Unable to set up reporting: %v", err)
+	}
+	reporter.Report(Event{Type: EventDelegation, Domain: check.Zone, Source: "zone check", Error: strings.Join(problems, "; ")})
+	os.Exit(1)
+}
+
+// Create a record that deletes itself later, for demos and testing.
+func runAddTemp(args []string) {
+	flags := flag.NewFlagSet("add-temp", flag.ExitOnError)
+	confFlags := addConfigFlags(flags)
+	expires := flags.Duration("expires", time.Hour, "how long until the record is removed")
+	positional := parseInterspersed(flags, args)
+	if len(positional) != 2 || *expires <= 0 {
+		fmt.Fprintf(os.Stderr, "usage: %s add-temp <fqdn> <ip> [-expires 4h]\n", os.Args[0])
+		os.Exit(2)
+	}
+	domain := route53update.NormalizeHostname(positional[0]) + "."
+
+	client, zone := clientAndZoneFor(confFlags.load(), domain)
+	at := time.Now().Add(*expires)
+	if _, err := AddTempRecord(client, *zone.Id, domain, positional[1], at); err != nil {
+		log.Fatalf("Failed to create temporary record: %v", err)
+	}
+	fmt.Printf("Created %s pointing at %s until %s\n", domain, positional[1], at.Format(time.RFC1123))
+	fmt.Printf("It gets removed by watch mode or by running reap-expired after that\n")
+}
+
+// Delete temporary records that have expired, for running out of cron or a
+// scheduled Lambda when there's no watch daemon doing it.
+func runReapExpired(args []string) {
+	flags := flag.NewFlagSet("reap-expired", flag.ExitOnError)
+	confFlags := addConfigFlags(flags)
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "usage: %s reap-expired <zone>...\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	cfg, _, err := LoadAWSConfig(confFlags.load())
 	if err != nil {
-		log.Fatalf("Error trying to check configured ip: %v", err)
+		log.Fatal(err)
 	}
-	fmt.Printf("Address in route53 is %s\n", configuredIp)
+	zones := NewZoneCache(route53.NewFromConfig(cfg))
+	if !reapZones(zones, flags.Args()) {
+		os.Exit(1)
+	}
+}
+
+// List what's been deleted, or put a deleted record set back.
+func runRestore(args []string) {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	confFlags := addConfigFlags(flags)
+	fips := flags.Bool("fips", false, "use FIPS validated AWS endpoints")
+	list := flags.Bool("list", false, "list what's in the recycle bin")
+	positional := parseInterspersed(flags, args)
+	if (*list && len(positional) > 1) || (!*list && len(positional) != 1) {
+		fmt.Fprintf(os.Stderr, "usage: %s restore -list [<fqdn>] | restore <id> [-config <file>] [-profile <name>] [-fips]\n", os.Args[0])
+		os.Exit(2)
+	}
+	conf := withFIPS(confFlags.load(), *fips)
 
-	// If our public IP and what's in route53 match we're done
-	if ip == configuredIp {
-		fmt.Printf("Address already up to date, done\n")
+	if *list {
+		deleted, err := ReadRecycleBin()
+		if err != nil {
+			log.Fatal(err)
+		}
+		name := ""
+		if len(positional) == 1 {
+			name = route53update.NormalizeHostname(positional[0])
+		}
+		PrintRecycleBin(os.Stdout, deleted, name)
 		return
 	}
 
-	// If the addresses don't match, update route53
-	change, err := UpdateIp(client, *zone.Id, domain, ip)
+	cfg, _, err := LoadAWSConfig(conf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	restored, err := RestoreRecord(route53.NewFromConfig(cfg), positional[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Restored %s %s, deleted %s (%s)\n", route53update.NormalizeHostname(*restored.Record.Name), restored.Record.Type, restored.Time.Local().Format(time.RFC1123), restored.Reason)
+}
+
+// Move a name from a consumer dynamic DNS provider to Route53, starting the
+// new record off with the address the old one has now.
+func runMigrate(args []string) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	confFlags := addConfigFlags(flags)
+	cname := flags.String("cname", "", "also create this name as a CNAME to the new record")
+	positional := parseInterspersed(flags, args)
+	if len(positional) != 2 {
+		fmt.Fprintf(os.Stderr, "usage: %s migrate <old hostname> <new fqdn> [-cname <name>] [-config <file>]\n", os.Args[0])
+		os.Exit(2)
+	}
+	old := route53update.NormalizeHostname(positional[0])
+	name := route53update.NormalizeHostname(positional[1])
+	if providerDomain(old) == "" {
+		log.Printf("Warning: %s isn't under a provider domain I know about, going ahead anyway", old)
+	}
+
+	v4, v6, err := LookupCurrent(old)
 	if err != nil {
-		log.Fatalf("Error trying to update record: %v", err)
+		log.Fatal(err)
 	}
+	fmt.Printf("%s currently points at %s\n", old, strings.TrimSpace(v4+" "+v6))
 
-	fmt.Printf("Updated. Change: %s\n", *change.ChangeInfo.Id)
+	// The config file gets written below, it doesn't have to exist yet
+	var conf *Config
+	if *confFlags.path != "" {
+		if _, err := os.Stat(*confFlags.path); err == nil {
+			conf = confFlags.load()
+		}
+	}
+	client, zone := clientAndZoneFor(conf, name+".")
+	cnameFqdn := ""
+	if *cname != "" {
+		cnameFqdn = route53update.NormalizeHostname(*cname) + "."
+	}
+	if err := MigrateHost(client, *zone.Id, name+".", v4, v6, cnameFqdn); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Created %s\n", name)
+	if cnameFqdn != "" {
+		fmt.Printf("Created %s as a CNAME to %s\n", *cname, name)
+	}
+
+	if *confFlags.path == "" {
+		fmt.Printf("Add this to your config to keep it updated:\n\ndomains:\n  %s: {}\n", name)
+		return
+	}
+	if err := AddDomainToConfig(*confFlags.path, name, v6 != ""); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Added %s to %s, once watch is running there you can turn off the %s updater\n", name, *confFlags.path, old)
+}
+
+// Load a BIND zone file into a hosted zone. Shows what it would do first,
+// and only does it with -apply.
+func runImport(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	confFlags := addConfigFlags(flags)
+	file := flags.String("file", "", "the zone file to import")
+	apply := flags.Bool("apply", false, "make the changes instead of just showing them")
+	prune := flags.Bool("prune", false, "delete names this tool made that aren't in the zone file")
+	maxChanges := flags.Int("max-changes", 0, "refuse to apply more than this many changes, 0 for no limit")
+	positional := parseInterspersed(flags, args)
+	if len(positional) != 1 || *file == "" {
+		fmt.Fprintf(os.Stderr, "usage: %s import <zone> -file <zone file> [-prune] [-max-changes <n>] [-apply]\n", os.Args[0])
+		os.Exit(2)
+	}
+	zoneName := route53update.NormalizeHostname(positional[0])
+
+	f, err := os.Open(*file)
+	if err != nil {
+		log.Fatalf("Failed to open %s: %v", *file, err)
+	}
+	recs, warnings, err := ParseZoneFile(f, zoneName)
+	f.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, w := range warnings {
+		log.Printf("Warning: %s", w)
+	}
+
+	cfg, _, err := LoadAWSConfig(confFlags.load())
+	if err != nil {
+		log.Fatal(err)
+	}
+	client := route53.NewFromConfig(cfg)
+	zone, err := route53update.GetHostedZone(client, zoneName+".")
+	if err != nil {
+		log.Fatalf("Failed to find zone: %v", err)
+	}
+
+	plan, err := PlanZoneImport(client, *zone.Id, recs, *prune)
+	if err != nil {
+		log.Fatal(err)
+	}
+	plan.Print()
+	changes := plan.Changes()
+	if len(changes) > 0 {
+		fmt.Printf("%s\n", EstimateChanges(splitChanges(changes, importBatchSize)))
+	}
+	if *maxChanges > 0 && len(changes) > *maxChanges {
+		log.Fatalf("That's %d changes, more than -max-changes %d, not applying", len(changes), *maxChanges)
+	}
+	if !*apply {
+		if len(changes) > 0 {
+			fmt.Printf("Run again with -apply to make these changes\n")
+		}
+		return
+	}
+	if err := changeBreaker.Plan(len(changes)); err != nil {
+		log.Fatal(err)
+	}
+	if err := plan.Apply(client, *zone.Id); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Imported %s into %s\n", *file, zoneName)
+}
+
+// Set records from a stream of JSON lines, for other systems to drive bulk
+// changes through the same checks and batching as updates. The results come
+// back on stdout, a JSON line for each request.
+func runApply(args []string) {
+	flags := flag.NewFlagSet("apply", flag.ExitOnError)
+	confFlags := addConfigFlags(flags)
+	stdin := flags.Bool("stdin", false, "read the requests from stdin")
+	file := flags.String("file", "", "read the requests from this file")
+	fips := flags.Bool("fips", false, "use FIPS validated AWS endpoints")
+	now := flags.Bool("now", false, "apply even if it's outside the maintenance windows")
+	dryRun := flags.Bool("dry-run", false, "do all the checks but don't change anything")
+	batchSize := flags.Int("batch-size", 100, "apply once this many requests have come in")
+	flushAfter := flags.Duration("flush-after", time.Second, "apply what's come in once the input has been quiet this long")
+	positional := parseInterspersed(flags, args)
+	if len(positional) > 0 || *stdin == (*file != "") || *batchSize < 1 || *flushAfter <= 0 {
+		fmt.Fprintf(os.Stderr, "usage: %s apply -stdin | -file <file> [-config <file>] [-profile <name>] [-fips] [-now] [-dry-run] [-batch-size 100] [-flush-after 1s]\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	in := io.Reader(os.Stdin)
+	if *file != "" {
+		f, err := os.Open(*file)
+		if err != nil {
+			log.Fatalf("Failed to open %s: %v", *file, err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	updater := newUpdater(withFIPS(confFlags.load(), *fips), "apply")
+	if *dryRun {
+		updater.StartDryRun()
+	}
+	updater.IgnoreWindows = *now
+	if err := updater.Apply(in, os.Stdout, *batchSize, *flushAfter); err != nil {
+		log.Fatalf("Failed reading requests: %v", err)
+	}
+	updater.Reporter.PushMetrics()
+}
+
+// Show what the authoritative servers and a set of resolvers have for a name.
+func runPropagation(args []string) {
+	flags := flag.NewFlagSet("propagation", flag.ExitOnError)
+	confFlags := addConfigFlags(flags)
+	resolverList := flags.String("resolvers", "", "comma separated resolvers to check instead of the configured ones")
+	positional := parseInterspersed(flags, args)
+	if len(positional) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: %s propagation <fqdn> [-resolvers 8.8.8.8,1.1.1.1,system]\n", os.Args[0])
+		os.Exit(2)
+	}
+	resolvers := resolversFor(confFlags.load(), *resolverList)
+
+	results := map[string][]ServerAnswers{}
+	for rtype, qtype := range map[string]dnsmessage.Type{"A": dnsmessage.TypeA, "AAAA": dnsmessage.TypeAAAA} {
+		servers, err := CheckPropagation(positional[0], qtype, resolvers)
+		if err != nil {
+			log.Fatal(err)
+		}
+		results[rtype] = servers
+	}
+	PrintPropagation(os.Stdout, results)
+}
+
+// Ask a DNS server for a name's records and show what comes back.
+func runQuery(args []string) {
+	flags := flag.NewFlagSet("query", flag.ExitOnError)
+	rtype := flags.String("type", "A", "record type to ask for")
+	server := flags.String("server", "system", "server to ask, an address, system, or @authoritative")
+	positional := parseInterspersed(flags, args)
+	if len(positional) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: %s query <fqdn> [-type A|AAAA|CNAME|TXT|NS|MX|SOA|PTR|SRV|CAA] [-server <ip>|system|@authoritative]\n", os.Args[0])
+		os.Exit(2)
+	}
+	qtype, err := ParseQueryType(*rtype)
+	if err != nil {
+		log.Fatal(err)
+	}
+	addr, err := queryServer(*server, positional[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	res, err := Query(addr, positional[0], qtype)
+	if err != nil {
+		log.Fatal(err)
+	}
+	res.Print(os.Stdout)
+}
+
+// Block until a name resolves to the expected address everywhere, for
+// gating deploy steps on DNS. Exits 1 on timeout.
+func runWait(args []string) {
+	flags := flag.NewFlagSet("wait", flag.ExitOnError)
+	confFlags := addConfigFlags(flags)
+	expect := flags.String("expect", "", "the address the name should resolve to")
+	resolverList := flags.String("resolvers", "", "comma separated resolvers that need to have it, instead of the configured ones")
+	timeout := flags.Duration("timeout", 10*time.Minute, "how long to wait before failing")
+	positional := parseInterspersed(flags, args)
+	if len(positional) != 1 || *expect == "" {
+		fmt.Fprintf(os.Stderr, "usage: %s wait <fqdn> -expect <ip> [-resolvers 8.8.8.8,1.1.1.1] [-timeout 10m]\n", os.Args[0])
+		os.Exit(2)
+	}
+	resolvers := resolversFor(confFlags.load(), *resolverList)
+	if err := WaitForValue(positional[0], *expect, resolvers, time.Now().Add(*timeout)); err != nil {
+		log.Print(err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s resolves to %s everywhere\n", positional[0], *expect)
+}
+
+// The resolvers to check, from the flag, the config, or the defaults.
+func resolversFor(conf *Config, flagValue string) []string {
+	if flagValue != "" {
+		return strings.Split(flagValue, ",")
+	}
+	if conf != nil && len(conf.Propagation.Resolvers) > 0 {
+		return conf.Propagation.Resolvers
+	}
+	return defaultResolvers()
+}
+
+// Config file tools. import translates other dynamic DNS clients' configs,
+// schema prints a JSON schema for the config, encrypt makes encrypted
+// values for it, and lint checks it over for risky settings.
+func runConfig(args []string) {
+	if len(args) == 0 || (args[0] != "import" && args[0] != "schema" && args[0] != "encrypt" && args[0] != "lint") {
+		fmt.Fprintf(os.Stderr, "usage: %s config import -from ddclient|inadyn <file> | schema | encrypt -kms <key> | -age <recipient> | lint -config <file>\n", os.Args[0])
+		os.Exit(2)
+	}
+	if args[0] == "lint" {
+		runConfigLint(args[1:])
+		return
+	}
+	if args[0] == "encrypt" {
+		runConfigEncrypt(args[1:])
+		return
+	}
+	if args[0] == "schema" {
+		out, err := json.MarshalIndent(ConfigSchema(), "", "  ")
+		if err != nil {
+			log.Fatalf("Failed to write schema: %v", err)
+		}
+		fmt.Println(string(out))
+		return
+	}
+	runConfigImport(args[1:])
+}
+
+// Check a config for risky settings, exiting 1 if there are any so it can
+// gate a deploy.
+func runConfigLint(args []string) {
+	flags := flag.NewFlagSet("config lint", flag.ExitOnError)
+	confFlags := addConfigFlags(flags)
+	offline := flags.Bool("offline", false, "skip checking what the AWS credentials are allowed to do")
+	flags.Parse(args)
+	if *confFlags.path == "" {
+		log.Fatalf("lint needs a config file, use -config")
+	}
+	conf := confFlags.load()
+
+	warnings := LintConfig(conf)
+	if !*offline {
+		cfg, _, err := LoadAWSConfig(conf)
+		if err != nil {
+			log.Fatal(err)
+		}
+		creds, err := LintCredentials(cfg)
+		if err != nil {
+			log.Printf("Skipping the credentials check: %v", err)
+		}
+		warnings = append(warnings, creds...)
+	}
+	for _, w := range warnings {
+		fmt.Printf("warning: %s\n", w)
+	}
+	if len(warnings) > 0 {
+		os.Exit(1)
+	}
+	fmt.Printf("No problems found\n")
+}
+
+// Encrypt a value for the config, read from stdin so it doesn't end up in
+// the shell history. The output goes in place of the plain value.
+func runConfigEncrypt(args []string) {
+	flags := flag.NewFlagSet("config encrypt", flag.ExitOnError)
+	keyId := flags.String("kms", "", "KMS key id, ARN, or alias to encrypt with")
+	recipients := flags.String("age", "", "age recipients to encrypt to, separated by commas")
+	flags.Parse(args)
+	if (*keyId == "") == (*recipients == "") || flags.NArg() != 0 {
+		fmt.Fprintf(os.Stderr, "usage: %s config encrypt -kms <key> | -age <recipient> < value\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		log.Fatalf("Failed to read the value: %v", err)
+	}
+	plain := strings.TrimRight(string(data), "\r\n")
+
+	var node *yaml.Node
+	if *keyId != "" {
+		cfg, _, err := LoadAWSConfig(nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		node, err = EncryptKMS(cfg, *keyId, plain)
+	} else {
+		node, err = EncryptAge(strings.Split(*recipients, ","), plain)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	out, err := yaml.Marshal(node)
+	if err != nil {
+		log.Fatalf("Failed to write the value: %v", err)
+	}
+	os.Stdout.Write(out)
+}
+
+func runConfigImport(args []string) {
+	flags := flag.NewFlagSet("config import", flag.ExitOnError)
+	from := flags.String("from", "", "format of the config being imported: ddclient or inadyn")
+	output := flags.String("o", "", "write the config to a file instead of stdout")
+	positional := parseInterspersed(flags, args)
+	if len(positional) != 1 || *from == "" {
+		fmt.Fprintf(os.Stderr, "usage: %s config import -from ddclient|inadyn [-o <file>] <file>\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	f, err := os.Open(positional[0])
+	if err != nil {
+		log.Fatalf("Failed to open %s: %v", positional[0], err)
+	}
+	defer f.Close()
+
+	var imported *ImportedConfig
+	switch *from {
+	case "ddclient":
+		imported, err = ImportDdclient(f)
+	case "inadyn":
+		imported, err = ImportInadyn(f)
+	default:
+		log.Fatalf("Don't know how to import from %s", *from)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	imported.From += " " + positional[0]
+	for _, w := range imported.Warnings {
+		log.Printf("Warning: %s", w)
+	}
+
+	out, err := imported.YAML()
+	if err != nil {
+		log.Fatalf("Failed to write config: %v", err)
+	}
+	if *output == "" {
+		os.Stdout.Write(out)
+		return
+	}
+	if err := os.WriteFile(*output, out, 0600); err != nil {
+		log.Fatalf("Failed to write %s: %v", *output, err)
+	}
+	fmt.Printf("Imported %d domains into %s\n", len(imported.Domains), *output)
+}
+
+// Reap expired temporary records in each zone, logging what happens.
+// Returns false if anything went wrong.
+func reapZones(zones *ZoneCache, names []string) bool {
+	ok := true
+	for _, name := range names {
+		zone, err := zones.Zone(route53update.NormalizeHostname(name) + ".")
+		if err != nil {
+			log.Printf("Failed to find zone %s: %v", name, err)
+			ok = false
+			continue
+		}
+		ok = reapZone(zones.client, name, *zone.Id) && ok
+	}
+	return ok
+}
+
+func reapZone(client *route53.Client, name string, zoneId string) bool {
+	reaped, err := ReapExpired(client, zoneId, time.Now())
+	for _, r := range reaped {
+		log.Printf("Removed expired temporary record %s", r)
+	}
+	if err != nil {
+		log.Printf("Reaping %s: %v", name, err)
+		return false
+	}
+	return true
+}
+
+// The same for the domains being watched, going by id for the ones with a
+// zone id so they don't need looking up.
+func (u *Updater) reapZones(names []string) bool {
+	ok := true
+	var lookup []string
+	for _, name := range names {
+		id := u.ZoneId
+		if id == "" {
+			id = u.Domains[name].ZoneId
+		}
+		if private, _ := u.private(name); id == "" && private {
+			zone, err := u.zone(name)
+			if err != nil {
+				log.Printf("Reaping %s: %v", name, err)
+				ok = false
+				continue
+			}
+			id = *zone.Id
+		}
+		if id == "" {
+			lookup = append(lookup, name)
+			continue
+		}
+		ok = reapZone(u.Client, name, id) && ok
+	}
+	return reapZones(u.Zones, lookup) && ok
+}
+
+// Register this machine's name, for running from boot hooks and then again
+// on a timer as a heartbeat.
+func runRegister(args []string) {
+	flags := flag.NewFlagSet("register", flag.ExitOnError)
+	confFlags := addConfigFlags(flags)
+	ip := flags.String("ip", "", "address to register, defaults to our public IPv4 address")
+	owner := flags.String("owner", "", "name to register as, defaults to the hostname")
+	lease := flags.Duration("lease", 15*time.Minute, "how long the record lasts without another register")
+	positional := parseInterspersed(flags, args)
+	if len(positional) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: %s register <fqdn> [-ip <addr>] [-owner <name>] [-lease 15m]\n", os.Args[0])
+		os.Exit(2)
+	}
+	domain := route53update.NormalizeHostname(mustExpandHostname(positional[0])) + "."
+	if *owner == "" {
+		*owner, _ = os.Hostname()
+	}
+	if *ip == "" {
+		var err error
+		if *ip, err = route53update.PublicIPv4(); err != nil {
+			log.Fatalf("Failed getting current ip: %v", err)
+		}
+	}
+
+	client, zone := clientAndZoneFor(confFlags.load(), domain)
+	if err := RegisterHost(client, *zone.Id, domain, *ip, *owner, *lease); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Registered %s at %s for %s\n", domain, *ip, *owner)
+}
+
+// Remove this machine's name, for running from shutdown hooks.
+func runDeregister(args []string) {
+	flags := flag.NewFlagSet("deregister", flag.ExitOnError)
+	confFlags := addConfigFlags(flags)
+	owner := flags.String("owner", "", "name registered as, defaults to the hostname")
+	positional := parseInterspersed(flags, args)
+	if len(positional) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: %s deregister <fqdn> [-owner <name>]\n", os.Args[0])
+		os.Exit(2)
+	}
+	domain := route53update.NormalizeHostname(mustExpandHostname(positional[0])) + "."
+	if *owner == "" {
+		*owner, _ = os.Hostname()
+	}
+
+	client, zone := clientAndZoneFor(confFlags.load(), domain)
+	if err := DeregisterHost(client, *zone.Id, domain, *owner); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Deregistered %s\n", domain)
+}
+
+// Stop changing a name until it's resumed, or list what's paused with no
+// name given.
+func runPause(args []string) {
+	flags := flag.NewFlagSet("pause", flag.ExitOnError)
+	confFlags := addConfigFlags(flags)
+	duration := flags.Duration("for", 0, "resume automatically after this long")
+	reason := flags.String("reason", "", "why, shown when an update is held")
+	positional := parseInterspersed(flags, args)
+	if len(positional) > 1 {
+		fmt.Fprintf(os.Stderr, "usage: %s pause [-config <file>] [-for 2h] [-reason <text>] [<fqdn>]\n", os.Args[0])
+		os.Exit(2)
+	}
+	conf := confFlags.load()
+	if conf == nil || conf.State == "" {
+		log.Fatalf("pause needs a config file with a state file set")
+	}
+
+	if len(positional) == 0 {
+		st, _, err := LoadRuntimeState(conf.State)
+		if err != nil {
+			log.Fatal(err)
+		}
+		now := time.Now()
+		for _, name := range st.PausedNames(now) {
+			fmt.Printf("%s %s\n", name, st.Paused[name])
+		}
+		return
+	}
+
+	p := Pause{Since: time.Now(), Reason: *reason}
+	p.By, _ = os.Hostname()
+	if user := os.Getenv("USER"); user != "" {
+		p.By = user + "@" + p.By
+	}
+	if *duration > 0 {
+		p.Until = p.Since.Add(*duration)
+	}
+	name := route53update.NormalizeHostname(positional[0])
+	if err := PauseName(conf.State, name, p); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%s %s\n", name, p)
+}
+
+// Let updates to a paused name go through again.
+func runResume(args []string) {
+	flags := flag.NewFlagSet("resume", flag.ExitOnError)
+	confFlags := addConfigFlags(flags)
+	positional := parseInterspersed(flags, args)
+	if len(positional) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: %s resume [-config <file>] <fqdn>\n", os.Args[0])
+		os.Exit(2)
+	}
+	conf := confFlags.load()
+	if conf == nil || conf.State == "" {
+		log.Fatalf("resume needs a config file with a state file set")
+	}
+	name := route53update.NormalizeHostname(positional[0])
+	if err := ResumeName(conf.State, name); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Resumed %s\n", name)
+}
+
+// Set up a Route53 client and find the zone a name is in, for the commands
+// that work on a single name.
+func clientAndZoneFor(conf *Config, domain string) (*route53.Client, *types.HostedZone) {
+	cfg, _, err := LoadAWSConfig(conf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	client := route53.NewFromConfig(cfg)
+	zone, err := route53update.FindHostedZone(client, domain)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return client, zone
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintf(os.Stderr, "usage: %s [update] [<domain>] | status [<domain>] | list | watch [<domain>...] | tui <domain>... | stats | history export | query-logging | zone check <zone> | add-temp | reap-expired | import <zone> | apply -stdin | migrate | register | deregister | restore | pause | resume | config import|schema|encrypt|lint | serve -config <file> | helper -config <file> | clients list|add|revoke | query <fqdn> | propagation <fqdn> | wait <fqdn> | audit keygen|verify | hash-password | new-token\n", os.Args[0])
+		os.Exit(2)
+	}
+	switch os.Args[1] {
+	case "serve":
+		runServe(os.Args[2:])
+		return
+	case "update":
+		runUpdate(os.Args[0]+" update", os.Args[2:])
+		return
+	case "status":
+		runStatus(os.Args[2:])
+		return
+	case "list":
+		runList(os.Args[2:])
+		return
+	case "watch":
+		runWatch(os.Args[2:])
+		return
+	case "tui":
+		runTUI(os.Args[2:])
+		return
+	case "stats":
+		runStats(os.Args[2:])
+		return
+	case "history":
+		runHistory(os.Args[2:])
+		return
+	case "query-logging":
+		runQueryLogging(os.Args[2:])
+		return
+	case "zone":
+		runZone(os.Args[2:])
+		return
+	case "add-temp":
+		runAddTemp(os.Args[2:])
+		return
+	case "reap-expired":
+		runReapExpired(os.Args[2:])
+		return
+	case "register":
+		runRegister(os.Args[2:])
+		return
+	case "deregister":
+		runDeregister(os.Args[2:])
+		return
+	case "pause":
+		runPause(os.Args[2:])
+		return
+	case "resume":
+		runResume(os.Args[2:])
+		return
+	case "config":
+		runConfig(os.Args[2:])
+		return
+	case "restore":
+		runRestore(os.Args[2:])
+		return
+	case "migrate":
+		runMigrate(os.Args[2:])
+		return
+	case "import":
+		runImport(os.Args[2:])
+		return
+	case "apply":
+		runApply(os.Args[2:])
+		return
+	case "propagation":
+		runPropagation(os.Args[2:])
+		return
+	case "wait":
+		runWait(os.Args[2:])
+		return
+	case "query":
+		runQuery(os.Args[2:])
+		return
+	case "helper":
+		runHelper(os.Args[2:])
+		return
+	case "audit":
+		runAudit(os.Args[2:])
+		return
+	case "hash-password":
+		runHashPassword()
+		return
+	case "new-token":
+		runNewToken()
+		return
+	case "clients":
+		runClients(os.Args[2:])
+		return
+	}
+
+	// Without a command it's an update, the way it's always worked
+	runUpdate(os.Args[0], os.Args[1:])
+}
+
+// The usage for the record flags, for all the commands that have them.
+const recordUsage = "[-type A|AAAA|both|auto | -prefer-family 4|6] [-ttl <seconds>] [-zone-id <id>] [-ip-source <name>,... [-ip-consensus <n>]]"
+
+// Exit codes for update beyond the usual 1 for a failure and 2 for bad
+// arguments, so a container entrypoint or init container can tell an
+// orchestrator more than pass or fail, and a script can tell what happened.
+// 0 is a record changed, or nothing needing to, unless -unchanged-exit says
+// to exit with something else for that.
+const (
+	exitDrift       = 3 // -fail-on-drift and a record was left not matching
+	exitDeadline    = 4 // -deadline went by before everything was done
+	exitIPDetection = 5 // couldn't work out our own address
+	exitAWS         = 6 // AWS refused a call or couldn't be reached
+)
+
+// How long past -deadline a run gets to finish what it's in the middle of
+// before it's given up on.
+const deadlineGrace = 5 * time.Second
+
+// The exit code for a failed run. Running out of time beats everything
+// else, since that's what cut the run short. A run with both kinds of
+// failure calls it an AWS failure, since that's the one that needs looking
+// at, the address usually sorts itself out.
+func exitCodeFor(err error) int {
+	var apiErr smithy.APIError
+	var opErr *smithy.OperationError
+	var ipErr *IPDetectionError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return exitDeadline
+	case errors.As(err, &apiErr), errors.As(err, &opErr), errors.Is(err, errAWSConfig):
+		return exitAWS
+	case errors.As(err, &ipErr):
+		return exitIPDetection
+	}
+	return 1
+}
+
+// Give up on a run, with the exit code for what went wrong.
+func exitOn(err error) {
+	log.Print(err)
+	os.Exit(exitCodeFor(err))
+}
+
+// Check the domain, or every domain in the config, and fix any record that
+// doesn't match, once.
+//
+// For running at container start, -wait -fail-on-drift -deadline 2m makes
+// one pass, waits for the changes to be live, and exits 0 only if every
+// record ends up right, all within two minutes.
+func runUpdate(command string, args []string) {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	confFlags := addConfigFlags(flags)
+	fips := flags.Bool("fips", false, "use FIPS validated AWS endpoints")
+	monitor := flags.Bool("monitor", false, "report a mismatch but never change Route53")
+	now := flags.Bool("now", false, "update even if it's outside the maintenance windows")
+	dryRun := flags.Bool("dry-run", false, "do all the checks but don't change anything")
+	reportFile := flags.String("report-file", "", "write a JSON report of the run to this file")
+	wait := flags.Bool("wait", false, "don't exit until Route53 says the changes are live everywhere")
+	waitTimeout := flags.Duration("wait-timeout", 5*time.Minute, "how long -wait waits before giving up")
+	failOnDrift := flags.Bool("fail-on-drift", false, fmt.Sprintf("exit %d if a record is left not matching, monitor only, held, or blocked", exitDrift))
+	deadline := flags.Duration("deadline", 0, fmt.Sprintf("exit %d if the whole run takes longer than this", exitDeadline))
+	unchangedExit := flags.Int("unchanged-exit", 0, "exit with this when every record was already right and nothing changed")
+	records := addRecordFlags(flags)
+	positional := parseInterspersed(flags, args)
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [-config <file>] [-profile <name>] [-fips] [-monitor] [-now] [-dry-run] [-wait [-wait-timeout 5m]] [-fail-on-drift] [-deadline <duration>] [-unchanged-exit <code>] %s [-report-file <file>] [<domain>]\n", command, recordUsage)
+		os.Exit(2)
+	}
+	if len(positional) > 1 || *waitTimeout <= 0 || *deadline < 0 || *unchangedExit < 0 || *unchangedExit > 125 {
+		usage()
+	}
+	// Lookups, retries, and waiting all count against the deadline
+	started := time.Now()
+	ctx := context.Background()
+	if *deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *deadline)
+		defer cancel()
+		*waitTimeout = min(*waitTimeout, *deadline)
+	}
+
+	// The domain on the command line, or every domain in the config
+	conf := withFIPS(confFlags.load(), *fips)
+	domains := watchedDomains(conf, positional)
+	if len(domains) == 0 {
+		usage()
+	}
+
+	// Start the report before any clients get made so it sees every call
+	var run *RunReport
+	if *reportFile != "" {
+		run = NewRunReport(RunInputs{
+			Args:    os.Args[1:],
+			Domains: domains,
+			Config:  *confFlags.path,
+			Profile: *confFlags.profile,
+		})
+	}
+
+	updater := newUpdater(conf, "cli")
+	updater.MonitorOnly = updater.MonitorOnly || *monitor
+	if *dryRun {
+		updater.StartDryRun()
+	}
+	records.apply(updater)
+	updater.IgnoreWindows = *now
+	if *wait {
+		updater.Wait = *waitTimeout
+	}
+	if len(domains) > 1 {
+		updater.Zones.Preload(updater.zoneNames(domains))
+	}
+	// The A and AAAA recs get checked side by side, so this needs a lock
+	var drifted []string
+	changed := false
+	var driftMu sync.Mutex
+	updater.Reporter.Watch(func(e Event) {
+		driftMu.Lock()
+		defer driftMu.Unlock()
+		switch e.Type {
+		case EventMismatch:
+			drifted = append(drifted, strings.TrimSpace(e.Domain+" "+e.Record))
+		case EventChange:
+			changed = true
+		}
+	})
+	// The run stops starting new work at the deadline, but a lookup or a
+	// wait already going doesn't know about it, so past the deadline it
+	// only gets a little longer before the run is called done without it
+	results := make(chan []error, 1)
+	go func() { results <- updater.UpdateAll(ctx, domains) }()
+	var errs, updateErrs []error
+	select {
+	case updateErrs = <-results:
+	case <-ctx.Done():
+		select {
+		case updateErrs = <-results:
+		case <-time.After(deadlineGrace):
+		}
+	}
+	for i, err := range updateErrs {
+		if err != nil {
+			if len(domains) > 1 {
+				err = fmt.Errorf("%s: %w", domains[i], err)
+			}
+			errs = append(errs, err)
+		}
+	}
+	if updateErrs == nil {
+		errs = append(errs, fmt.Errorf("Not done after %s, giving up: %w", time.Since(started).Round(time.Second), ctx.Err()))
+	}
+	err := errors.Join(errs...)
+	updater.Reporter.PushMetrics()
+	if run != nil {
+		run.Inputs.IPv6 = updater.IPv6
+		run.Inputs.Records = updater.Records
+		run.Inputs.MonitorOnly = updater.MonitorOnly
+		run.Inputs.IgnoreWindows = updater.IgnoreWindows
+		run.Inputs.FIPS = conf != nil && conf.AWS.FIPS
+		if ferr := run.Finish(*reportFile, err); ferr != nil {
+			log.Print(ferr)
+		}
+	}
+	if err != nil {
+		exitOn(err)
+	}
+	if *failOnDrift && len(drifted) > 0 {
+		log.Printf("Left not matching: %s", strings.Join(drifted, ", "))
+		os.Exit(exitDrift)
+	}
+	if !changed && !*dryRun {
+		os.Exit(*unchangedExit)
+	}
+}
+
+// The list command shows the domains in the config and how each one is kept
+// up to date, without talking to AWS.
+func runList(args []string) {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	confFlags := addConfigFlags(flags)
+	positional := parseInterspersed(flags, args)
+
+	conf := confFlags.load()
+	domains := watchedDomains(conf, positional)
+	if conf == nil || len(domains) == 0 {
+		fmt.Fprintf(os.Stderr, "usage: %s list -config <file> [<domain>...]\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DOMAIN\tRECORDS\tPROVIDER\tANNOTATIONS")
+	for _, name := range domains {
+		d := conf.Domains[name]
+		records := d.Type
+		switch {
+		case len(d.Uplinks) > 0:
+			mode := d.UplinkMode
+			if mode == "" {
+				mode = "weighted"
+			}
+			records = fmt.Sprintf("%d uplinks, %s", len(d.Uplinks), mode)
+		case d.Follow != "":
+			records = "following " + d.Follow
+		case records == "":
+			records = "default"
+		}
+		provider := d.Provider
+		if provider == "" {
+			provider = "route53"
+		}
+		annotations := formatAnnotations(d.Annotations)
+		if annotations == "" {
+			annotations = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", name, records, provider, annotations)
+	}
+	tw.Flush()
+}
+
+// Show where each record stands without changing anything. Exits with 1 if
+// any record is out of date or couldn't be checked, so scripts can tell.
+func runStatus(args []string) {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	confFlags := addConfigFlags(flags)
+	fips := flags.Bool("fips", false, "use FIPS validated AWS endpoints")
+	records := addRecordFlags(flags)
+	positional := parseInterspersed(flags, args)
+
+	conf := withFIPS(confFlags.load(), *fips)
+	domains := watchedDomains(conf, positional)
+	if len(domains) == 0 {
+		fmt.Fprintf(os.Stderr, "usage: %s status [-config <file>] [-profile <name>] [-fips] %s [<domain>...]\n", os.Args[0], recordUsage)
+		os.Exit(2)
+	}
+
+	updater := newUpdater(conf, "status")
+	records.apply(updater)
+	updater.Zones.Preload(updater.zoneNames(domains))
+	var statuses []RecordStatus
+	for _, name := range domains {
+		statuses = append(statuses, updater.annotatedStatus(name)...)
+	}
+	PrintStatus(os.Stdout, statuses)
+	for _, st := range statuses {
+		if !st.InSync() {
+			os.Exit(1)
+		}
+	}
 }
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+// Set at build time with -ldflags "-X main.version=1.2.3", otherwise it
+// comes from the module info if there is any.
+var version = ""
+
+// Version is what gets reported as the version of this program.
+func Version() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
+
+// The metadata TXT record lives under its own label next to the record it
+// describes, so it can't get mixed up with SPF or ownership TXT records on
+// the name itself.
+const metadataPrefix = "_route53update."
+
+// MetadataChange builds the change that writes the metadata TXT record for
+// a domain, so that dig alone can say when the record was last touched and
+// by what:
+//
+//	dig TXT _route53update.home.example.com
+//	"updated=2024-05-01T02:03:04Z" "version=v1.2.3" "host=nas"
+func MetadataChange(domain string, now time.Time, ttl int64) types.Change {
+	host, _ := os.Hostname()
+	values := []string{
+		"updated=" + now.UTC().Format(time.RFC3339),
+		"version=" + Version(),
+		"host=" + host,
+	}
+	var parts []string
+	for _, v := range values {
+		parts = append(parts, QuoteTXT(v))
+	}
+	txt := strings.Join(parts, " ")
+	return types.Change{
+		Action: types.ChangeActionUpsert,
+		ResourceRecordSet: &types.ResourceRecordSet{
+			Name:            aws.String(metadataPrefix + domain),
+			Type:            types.RRTypeTxt,
+			ResourceRecords: []types.ResourceRecord{{Value: aws.String(txt)}},
+			TTL:             aws.Int64(ttl),
+		},
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Pushgateway collects the results of a run and pushes them to a Prometheus
+// Pushgateway when the run is done. Cron jobs don't live long enough to be
+// scraped, so pushing is the way to get them into Prometheus.
+type Pushgateway struct {
+	url string
+
+	mu      sync.Mutex
+	start   time.Time
+	results map[string]Event
+}
+
+// NewPushgateway returns nil if there's no pushgateway configured.
+func NewPushgateway(conf PushgatewayConfig) *Pushgateway {
+	if conf.URL == "" {
+		return nil
+	}
+	job := conf.Job
+	if job == "" {
+		job = "route53update"
+	}
+	instance := conf.Instance
+	if instance == "" {
+		instance, _ = os.Hostname()
+	}
+
+	// The grouping key goes in the path, job first and then label pairs
+	target := strings.TrimRight(conf.URL, "/") + "/metrics/job/" + url.PathEscape(job)
+	if instance != "" {
+		target += "/instance/" + url.PathEscape(instance)
+	}
+	return &Pushgateway{
+		url:     target,
+		start:   time.Now(),
+		results: map[string]Event{},
+	}
+}
+
+// Record keeps the latest result for each domain and record type.
+func (p *Pushgateway) Record(e Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.results[e.Domain+" "+e.Record] = e
+}
+
+// Push sends everything recorded since the last push, then starts over for
+// the next run. It's a POST so metrics we leave out, like the last success
+// time when a run fails, keep their old values in the gateway.
+func (p *Pushgateway) Push() error {
+	p.mu.Lock()
+	body := p.render(time.Now())
+	p.start = time.Now()
+	p.results = map[string]Event{}
+	p.mu.Unlock()
+
+	res, err := notifyClient.Post(p.url, "text/plain; version=0.0.4", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Pushgateway push failed: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("Pushgateway returned %s", res.Status)
+	}
+	return nil
+}
+
+func (p *Pushgateway) render(now time.Time) []byte {
+	keys := make([]string, 0, len(p.results))
+	for key := range p.results {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# TYPE route53update_run_duration_seconds gauge\n")
+	fmt.Fprintf(&b, "route53update_run_duration_seconds %g\n", now.Sub(p.start).Seconds())
+	fmt.Fprintf(&b, "# TYPE route53update_last_run_timestamp_seconds gauge\n")
+	fmt.Fprintf(&b, "route53update_last_run_timestamp_seconds %d\n", now.Unix())
+
+	fmt.Fprintf(&b, "# TYPE route53update_success gauge\n")
+	for _, key := range keys {
+		success := 0
+		if p.results[key].Type != EventFailure {
+			success = 1
+		}
+		fmt.Fprintf(&b, "route53update_success{%s} %d\n", labels(p.results[key]), success)
+	}
+	fmt.Fprintf(&b, "# TYPE route53update_changed gauge\n")
+	for _, key := range keys {
+		changed := 0
+		if p.results[key].Type == EventChange {
+			changed = 1
+		}
+		fmt.Fprintf(&b, "route53update_changed{%s} %d\n", labels(p.results[key]), changed)
+	}
+	fmt.Fprintf(&b, "# TYPE route53update_last_success_timestamp_seconds gauge\n")
+	for _, key := range keys {
+		if e := p.results[key]; e.Type != EventFailure {
+			fmt.Fprintf(&b, "route53update_last_success_timestamp_seconds{%s} %d\n", labels(e), e.Time.Unix())
+		}
+	}
+	return b.Bytes()
+}
+
+// Events for the A rec don't carry a record type, but the label should
+// always be there so A and AAAA series line up.
+func labels(e Event) string {
+	record := e.Record
+	if record == "" {
+		record = "A"
+	}
+	return fmt.Sprintf("domain=%q,record=%q", e.Domain, record)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/mikerowehl/route53Update/route53update"
+	"gopkg.in/yaml.v3"
+)
+
+// Moving off DuckDNS or No-IP means a new name in a zone of your own, since
+// their names can't come along. migrate does the fiddly part, it looks up
+// where the old name points right now and starts the new record off with the
+// same address, so there's no gap while the watcher gets set up, and it adds
+// the new name to the config.
+
+// LookupCurrent finds the addresses a name points at now, at most one IPv4
+// and one IPv6, which is all a dynamic DNS name ever has.
+func LookupCurrent(name string) (v4 string, v6 string, err error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(context.TODO(), name)
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to look up %s: %v", name, err)
+	}
+	for _, a := range addrs {
+		if a.IP.To4() != nil {
+			if v4 == "" {
+				v4 = a.IP.String()
+			}
+		} else if v6 == "" {
+			v6 = a.IP.String()
+		}
+	}
+	return v4, v6, nil
+}
+
+// MigrateHost creates the address records for a migrated name, plus a CNAME
+// pointing at it if cname is set. Creates and not upserts, so it fails
+// instead of replacing anything that's already in the zone.
+func MigrateHost(client *route53.Client, zone string, domain string, v4 string, v6 string, cname string) error {
+	var changes []types.Change
+	add := func(name string, rtype types.RRType, value string) {
+		changes = append(changes, types.Change{
+			Action: types.ChangeActionCreate,
+			ResourceRecordSet: &types.ResourceRecordSet{
+				Name:            aws.String(name),
+				Type:            rtype,
+				ResourceRecords: []types.ResourceRecord{{Value: aws.String(value)}},
+				TTL:             aws.Int64(route53update.DefaultTTL),
+			},
+		})
+	}
+	if v4 != "" {
+		add(domain, types.RRTypeA, v4)
+	}
+	if v6 != "" {
+		add(domain, types.RRTypeAaaa, v6)
+	}
+	if len(changes) == 0 {
+		return fmt.Errorf("No addresses to create %s with", domain)
+	}
+	if cname != "" {
+		add(cname, types.RRTypeCname, domain)
+	}
+
+	_, err := route53update.ChangeRecordSets(client, &route53.ChangeResourceRecordSetsInput{
+		ChangeBatch:  &types.ChangeBatch{Changes: changes},
+		HostedZoneId: aws.String(zone),
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to create records: %v", err)
+	}
+	return nil
+}
+
+// AddDomainToConfig adds a name to the domains section of a config file,
+// making the file if it isn't there. It goes through the YAML node tree so
+// comments and everything else in the file stay the way they were.
+func AddDomainToConfig(path string, name string, ipv6 bool) error {
+	var doc yaml.Node
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("Failed to read config: %v", err)
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("Failed to parse config %s: %v", path, err)
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("Config %s isn't a mapping", path)
+	}
+
+	if ipv6 && mappingValue(root, "ipv6") == nil {
+		root.Content = append(root.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: "ipv6"},
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "true"})
+	}
+	domains := mappingValue(root, "domains")
+	if domains == nil || (domains.Kind == yaml.ScalarNode && domains.Tag == "!!null") {
+		if domains == nil {
+			domains = &yaml.Node{}
+			root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "domains"}, domains)
+		}
+		*domains = yaml.Node{Kind: yaml.MappingNode}
+	}
+	if mappingValue(domains, name) != nil {
+		return nil
+	}
+	domains.Content = append(domains.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Value: name},
+		&yaml.Node{Kind: yaml.MappingNode, Style: yaml.FlowStyle})
+
+	out, err := yaml.Marshal(&doc)
+	if err != nil {
+		return fmt.Errorf("Failed to write config: %v", err)
+	}
+	if err := os.WriteFile(path, out, 0600); err != nil {
+		return fmt.Errorf("Failed to write config: %v", err)
+	}
+	return nil
+}
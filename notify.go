@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mikerowehl/route53Update/route53update"
+)
+
+// Notifier sends events somewhere a person will see them.
+type Notifier interface {
+	Notify(e Event) error
+}
+
+// NewNotifiers builds all the notifiers listed in the config. Channels with
+// quiet hours get wrapped so they hold things back during them, and ones
+// with filters so they only hear about what they asked for.
+func NewNotifiers(conf NotifyConfig) []Notifier {
+	var notifiers []Notifier
+	for _, w := range conf.Webhooks {
+		notifiers = append(notifiers, withFilter(withQuiet(&WebhookNotifier{url: w.URL}, w.Quiet), w.Events, w.Domains))
+	}
+	for _, e := range conf.Email {
+		notifiers = append(notifiers, withFilter(withQuiet(&EmailNotifier{conf: e}, e.Quiet), e.Events, e.Domains))
+	}
+	return notifiers
+}
+
+func withQuiet(n Notifier, quiet []WindowConfig) Notifier {
+	// Already checked over when the config was loaded
+	windows, _ := ParseTimeWindows(quiet)
+	if len(windows) == 0 {
+		return n
+	}
+	return NewQuietNotifier(n, windows)
+}
+
+func withFilter(n Notifier, events []string, domains []string) Notifier {
+	// Already checked over when the config was loaded
+	filter, _ := NewNotifyFilter(events, domains)
+	if filter == nil {
+		return n
+	}
+	filter.inner = n
+	return filter
+}
+
+// NotifyFilter only passes on the events a channel wants, so an on call
+// channel can get just failures and drift while a hobby records channel
+// gets the changes:
+//
+//	notify:
+//	  webhooks:
+//	    - url: https://hooks.slack.com/services/...
+//	      events: [failure, drift]
+//	      domains: ["*.prod.example.com"]
+//
+// Events are change, failure, drift, mismatch, rejected, expired,
+// delegation, breaker, and digest, and domains can have * wildcards. Either one left out means
+// everything. Events that aren't about one domain, like digests, only go
+// by the event list.
+type NotifyFilter struct {
+	inner   Notifier
+	events  map[string]bool
+	domains []string
+}
+
+// NewNotifyFilter checks the lists over, and returns nil if they're both
+// empty since there's nothing to filter.
+func NewNotifyFilter(events []string, domains []string) (*NotifyFilter, error) {
+	if len(events) == 0 && len(domains) == 0 {
+		return nil, nil
+	}
+	f := &NotifyFilter{}
+	for _, e := range events {
+		switch e {
+		case EventChange, EventFailure, EventDrift, EventMismatch, EventRejected, EventClientExpired, EventDelegation, EventBreaker, EventDigest:
+		default:
+			return nil, fmt.Errorf("Don't know the event %q", e)
+		}
+		if f.events == nil {
+			f.events = map[string]bool{}
+		}
+		f.events[e] = true
+	}
+	for _, d := range domains {
+		pattern := route53update.NormalizeHostname(d)
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Bad domain pattern %q: %v", d, err)
+		}
+		f.domains = append(f.domains, pattern)
+	}
+	return f, nil
+}
+
+// Wants says if the channel wants to hear about the event.
+func (f *NotifyFilter) Wants(e Event) bool {
+	if f.events != nil && !f.events[e.Type] {
+		return false
+	}
+	if len(f.domains) == 0 || e.Domain == "" {
+		return true
+	}
+	name := route53update.NormalizeHostname(e.Domain)
+	for _, pattern := range f.domains {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *NotifyFilter) Notify(e Event) error {
+	if !f.Wants(e) {
+		return nil
+	}
+	return f.inner.Notify(e)
+}
+
+// NewHeartbeats builds the monitors that get told about every check.
+func NewHeartbeats(conf NotifyConfig) []Notifier {
+	var heartbeats []Notifier
+	for _, k := range conf.UptimeKuma {
+		heartbeats = append(heartbeats, &UptimeKumaNotifier{url: k.URL})
+	}
+	return heartbeats
+}
+
+// WebhookNotifier posts events as JSON. The message goes in a "text" field,
+// which is what Slack, Mattermost, and friends look for, and the full event is
+// included for anything that wants to do its own formatting.
+type WebhookNotifier struct {
+	url string
+}
+
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+func (w *WebhookNotifier) Notify(e Event) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"text":  e.Message(),
+		"event": e,
+	})
+	if err != nil {
+		return err
+	}
+
+	res, err := notifyClient.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Webhook post failed: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("Webhook returned %s", res.Status)
+	}
+	return nil
+}
+
+// EmailNotifier sends events as plain text mail. The first line of the
+// message makes the subject.
+type EmailNotifier struct {
+	conf EmailConfig
+}
+
+func (n *EmailNotifier) Notify(e Event) error {
+	text := e.Message()
+	subject := strings.SplitN(text, "\n", 2)[0]
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", n.conf.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.conf.To, ", "))
+	fmt.Fprintf(&msg, "Subject: route53Update: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(text, "\n", "\r\n"))
+	msg.WriteString("\r\n")
+
+	var auth smtp.Auth
+	if n.conf.Username != "" {
+		host, _, err := net.SplitHostPort(n.conf.Server)
+		if err != nil {
+			return fmt.Errorf("Bad email server %s: %v", n.conf.Server, err)
+		}
+		auth = smtp.PlainAuth("", n.conf.Username, n.conf.Password, host)
+	}
+	if err := smtp.SendMail(n.conf.Server, auth, n.conf.From, n.conf.To, []byte(msg.String())); err != nil {
+		return fmt.Errorf("Sending email failed: %v", err)
+	}
+	return nil
+}
+
+// UptimeKumaNotifier hits an Uptime Kuma push monitor after every check. A
+// check that worked (changed or not) is up, a failure is down, and the
+// message shows up in the Kuma dashboard. If the pushes stop coming Kuma
+// marks the monitor down on its own, which catches the updater dying.
+type UptimeKumaNotifier struct {
+	url string
+}
+
+func (k *UptimeKumaNotifier) Notify(e Event) error {
+	u, err := url.Parse(k.url)
+	if err != nil {
+		return fmt.Errorf("Bad Uptime Kuma URL: %v", err)
+	}
+	status := "up"
+	if e.Type == EventFailure {
+		status = "down"
+	}
+	q := url.Values{}
+	q.Set("status", status)
+	q.Set("msg", e.Message())
+	q.Set("ping", "")
+	u.RawQuery = q.Encode()
+
+	res, err := notifyClient.Get(u.String())
+	if err != nil {
+		return fmt.Errorf("Uptime Kuma push failed: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("Uptime Kuma returned %s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Overrides collects -set key=value flags, which patch the config for one
+// run without editing the file. Keys are paths into the config, with dots
+// between sections, [n] for list entries, and ["..."] for keys that have
+// dots in them, like domain names:
+//
+//	-set ttl.normal=60
+//	-set notify.webhooks[0].url=http://localhost:8080/
+//	-set 'domains["home.example.com"].update=notify'
+//
+// Values are YAML, so numbers, true/false, durations, and lists all work.
+type Overrides []string
+
+func (o *Overrides) String() string {
+	return strings.Join(*o, ",")
+}
+
+func (o *Overrides) Set(s string) error {
+	if !strings.Contains(s, "=") {
+		return fmt.Errorf("should look like key=value")
+	}
+	*o = append(*o, s)
+	return nil
+}
+
+// Patch one key=value into the config tree.
+func applyOverride(root *yaml.Node, expr string) error {
+	key, value, _ := strings.Cut(expr, "=")
+	path, err := parseConfigPath(key)
+	if err != nil {
+		return fmt.Errorf("Bad config key %q: %v", key, err)
+	}
+
+	var parsed yaml.Node
+	if err := yaml.Unmarshal([]byte(value), &parsed); err != nil {
+		return fmt.Errorf("Bad value for %s: %v", key, err)
+	}
+	replacement := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"}
+	if len(parsed.Content) > 0 {
+		replacement = parsed.Content[0]
+	}
+
+	node := root
+	for i, part := range path {
+		last := i == len(path)-1
+		if index, ok := part.(int); ok {
+			if node.Kind != yaml.SequenceNode || index >= len(node.Content) {
+				return fmt.Errorf("No entry %d for %s", index, key)
+			}
+			if last {
+				*node.Content[index] = *replacement
+				return nil
+			}
+			node = node.Content[index]
+			continue
+		}
+
+		name := part.(string)
+		if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+			*node = yaml.Node{Kind: yaml.MappingNode}
+		}
+		if node.Kind != yaml.MappingNode {
+			return fmt.Errorf("Can't set %s, %s isn't a section", key, name)
+		}
+		next := mappingValue(node, name)
+		if next == nil {
+			// Sections that aren't in the file yet get made on the way
+			next = &yaml.Node{Kind: yaml.MappingNode}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: name}, next)
+		}
+		if last {
+			*next = *replacement
+			return nil
+		}
+		node = next
+	}
+	return nil
+}
+
+// Split a config key into map keys (strings) and list indexes (ints).
+func parseConfigPath(key string) ([]any, error) {
+	var path []any
+	for key != "" {
+		switch {
+		case strings.HasPrefix(key, `["`):
+			end := strings.Index(key, `"]`)
+			if end < 0 {
+				return nil, fmt.Errorf("missing \"]")
+			}
+			path = append(path, key[2:end])
+			key = key[end+2:]
+		case strings.HasPrefix(key, "["):
+			end := strings.Index(key, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("missing ]")
+			}
+			index, err := strconv.Atoi(key[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("%q isn't a list index", key[1:end])
+			}
+			path = append(path, index)
+			key = key[end+1:]
+		case strings.HasPrefix(key, "."):
+			key = key[1:]
+		default:
+			end := strings.IndexAny(key, ".[")
+			if end < 0 {
+				end = len(key)
+			}
+			path = append(path, key[:end])
+			key = key[end:]
+		}
+	}
+	if len(path) == 0 {
+		return nil, fmt.Errorf("empty key")
+	}
+	return path, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseConfigPath(t *testing.T) {
+	tests := []struct {
+		key     string
+		want    []any
+		wantErr bool
+	}{
+		{key: "ttl.normal", want: []any{"ttl", "normal"}},
+		{key: "notify.webhooks[0].url", want: []any{"notify", "webhooks", 0, "url"}},
+		{key: `domains["home.example.com"].update`, want: []any{"domains", "home.example.com", "update"}},
+		{key: `domains["a.example.com"]["b"]`, want: []any{"domains", "a.example.com", "b"}},
+		{key: "lists[2][1]", want: []any{"lists", 2, 1}},
+		{key: "", wantErr: true},
+		{key: "webhooks[x]", wantErr: true},
+		{key: "webhooks[-1]", wantErr: true},
+		{key: "webhooks[0", wantErr: true},
+		{key: `domains["open`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			got, err := parseConfigPath(tt.key)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseConfigPath(%q) = %v, want an error", tt.key, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseConfigPath(%q): %v", tt.key, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseConfigPath(%q) = %#v, want %#v", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyOverride(t *testing.T) {
+	const config = `ttl:
+  normal: 300
+notify:
+  webhooks:
+    - url: http://example.com/
+domains:
+  home.example.com:
+    update: auto
+empty:
+`
+	tests := []struct {
+		name string
+		set  string
+		want string // the config after, as YAML
+		err  string
+	}{
+		{name: "existing key", set: "ttl.normal=60", want: "ttl:\n    normal: 60\n"},
+		{name: "list entry", set: "notify.webhooks[0].url=http://localhost:8080/", want: "url: http://localhost:8080/"},
+		{name: "dotted key", set: `domains["home.example.com"].update=notify`, want: "home.example.com:\n        update: notify"},
+		{name: "new section", set: "leader.ttl=1m", want: "leader:\n    ttl: 1m\n"},
+		{name: "under a null", set: "empty.key=x", want: "empty:\n    key: x\n"},
+		{name: "list value", set: "records=[A, AAAA]", want: "records: [A, AAAA]"},
+		{name: "no value", set: "ttl.normal=", want: "ttl:\n    normal:\nnotify:"},
+		{name: "past the end of a list", set: "notify.webhooks[1].url=x", err: "No entry 1"},
+		{name: "into a value", set: "ttl.normal.x=1", err: "isn't a section"},
+		{name: "bad key", set: "ttl[x]=1", err: "Bad config key"},
+		{name: "bad value", set: "ttl.normal=[", err: "Bad value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var doc yaml.Node
+			if err := yaml.Unmarshal([]byte(config), &doc); err != nil {
+				t.Fatal(err)
+			}
+			err := applyOverride(doc.Content[0], tt.set)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Got %v, want an error with %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyOverride(%q): %v", tt.set, err)
+			}
+			out, err := yaml.Marshal(&doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(out), tt.want) {
+				t.Errorf("After %s the config is\n%s\nwant it to have\n%s", tt.set, out, tt.want)
+			}
+		})
+	}
+}
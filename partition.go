@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/mikerowehl/route53Update/route53update"
+)
+
+// Partition is one of the separate AWS worlds, regular AWS, GovCloud, or
+// China. Route53 is a global service, but global only means global within a
+// partition, each one has its own endpoint, its own home region where the
+// global stuff like CloudTrail events and query logs lives, and its own
+// prefix in ARNs.
+type Partition struct {
+	ID string
+
+	// Where the partition wide Route53 bits live
+	GlobalRegion string
+}
+
+var partitions = map[string]Partition{
+	"aws":        {ID: "aws", GlobalRegion: "us-east-1"},
+	"aws-us-gov": {ID: "aws-us-gov", GlobalRegion: "us-gov-west-1"},
+	"aws-cn":     {ID: "aws-cn", GlobalRegion: "cn-northwest-1"},
+}
+
+// PartitionForRegion figures out the partition from a region name.
+func PartitionForRegion(region string) Partition {
+	switch {
+	case strings.HasPrefix(region, "us-gov-"):
+		return partitions["aws-us-gov"]
+	case strings.HasPrefix(region, "cn-"):
+		return partitions["aws-cn"]
+	default:
+		return partitions["aws"]
+	}
+}
+
+// LoadAWSConfig loads the default AWS config and works out which partition
+// it's for. Normally that comes from the region, but it can be set in the
+// config too, which also picks a region if the environment doesn't have one.
+// A nil config is the same as an empty one. With FIPS on, every client made
+// from the result uses the FIPS endpoints, and the same goes for dual stack.
+// errAWSConfig is the AWS config or credentials not loading, which exits
+// like any other AWS failure.
+var errAWSConfig = errors.New("Unable to load AWS config")
+
+func LoadAWSConfig(conf *Config) (aws.Config, Partition, error) {
+	var awsConf AWSConfig
+	if conf != nil {
+		awsConf = conf.AWS
+	}
+
+	var opts []func(*config.LoadOptions) error
+	if awsConf.FIPS {
+		opts = append(opts, config.WithUseFIPSEndpoint(aws.FIPSEndpointStateEnabled))
+	}
+	if awsConf.Profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(awsConf.Profile))
+	}
+	if awsConf.DualStack || route53update.IPv6Only() {
+		opts = append(opts, config.WithUseDualStackEndpoint(aws.DualStackEndpointStateEnabled))
+	}
+	cfg, err := config.LoadDefaultConfig(context.TODO(), opts...)
+	if err != nil {
+		return aws.Config{}, Partition{}, fmt.Errorf("%w: %v", errAWSConfig, err)
+	}
+	if currentRun != nil {
+		cfg.APIOptions = append(cfg.APIOptions, currentRun.trackCalls)
+	}
+	if auditLog != nil {
+		cfg.APIOptions = append(cfg.APIOptions, auditLog.trackCalls)
+	}
+
+	if awsConf.Partition == "" {
+		return cfg, PartitionForRegion(cfg.Region), nil
+	}
+	part, ok := partitions[awsConf.Partition]
+	if !ok {
+		return aws.Config{}, Partition{}, fmt.Errorf("%w: unknown AWS partition %s", errAWSConfig, awsConf.Partition)
+	}
+	if cfg.Region == "" {
+		cfg.Region = part.GlobalRegion
+	} else if PartitionForRegion(cfg.Region).ID != part.ID {
+		return aws.Config{}, Partition{}, fmt.Errorf("%w: region %s isn't in the %s partition", errAWSConfig, cfg.Region, part.ID)
+	}
+	return cfg, part, nil
+}
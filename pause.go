@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"time"
+
+	"github.com/mikerowehl/route53Update/route53update"
+)
+
+// Pausing a name stops anything from changing it without touching the config
+// or stopping the daemon, for when a record needs to be left alone during
+// maintenance. Pauses live in the state file, so the daemon sees them on its
+// next check and they stick around through restarts. A paused record is still
+// checked, a mismatch just gets reported instead of fixed, the same as in
+// monitor only mode.
+
+// Pause is one paused name.
+type Pause struct {
+	Since  time.Time `json:"since"`
+	Until  time.Time `json:"until,omitempty"` // zero means until resumed
+	By     string    `json:"by,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// Active says if the pause is still in effect.
+func (p Pause) Active(now time.Time) bool {
+	return p.Until.IsZero() || now.Before(p.Until)
+}
+
+func (p Pause) String() string {
+	s := "paused"
+	if p.By != "" {
+		s += " by " + p.By
+	}
+	if !p.Until.IsZero() {
+		s += " until " + p.Until.Format(time.RFC1123)
+	}
+	if p.Reason != "" {
+		s += ": " + p.Reason
+	}
+	return s
+}
+
+// RuntimeState is the state file, things changed while running rather than
+// in the config.
+type RuntimeState struct {
+	Paused map[string]Pause `json:"paused"`
+
+	// Checks in a row each uplink has passed, or failed if negative, by
+	// domain and uplink name, see failover.go
+	UplinkStreaks map[string]int `json:"uplink_streaks,omitempty"`
+
+	// The address each record was last seen to have, by domain and record
+	// type, see addrcache.go
+	Pushed map[string]PushedAddress `json:"pushed,omitempty"`
+
+	// Approval links that have been used, by id, see approval.go
+	UsedApprovals map[string]UsedApproval `json:"used_approvals,omitempty"`
+
+	// The hosted zone each domain was found in, see zones.go
+	Zones map[string]CachedZone `json:"zones,omitempty"`
+}
+
+// LoadRuntimeState reads the state file, which might not exist yet. The
+// version is for passing to SaveRuntimeState.
+func LoadRuntimeState(path string) (*RuntimeState, string, error) {
+	st := &RuntimeState{Paused: map[string]Pause{}}
+	data, version, err := readState(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return st, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to read state: %v", err)
+	}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, "", fmt.Errorf("Failed to parse state %s: %v", path, err)
+	}
+	if st.Paused == nil {
+		st.Paused = map[string]Pause{}
+	}
+	return st, version, nil
+}
+
+// UpdateRuntimeState makes a change to the state file, starting over with a
+// fresh copy if something else changed it in the meantime.
+func UpdateRuntimeState(path string, change func(*RuntimeState) error) error {
+	for attempt := 1; ; attempt++ {
+		st, version, err := LoadRuntimeState(path)
+		if err != nil {
+			return err
+		}
+		if err := change(st); err != nil {
+			return err
+		}
+		// Clear out pauses that ran out while we're at it
+		for name, p := range st.Paused {
+			if !p.Active(time.Now()) {
+				delete(st.Paused, name)
+			}
+		}
+		data, err := json.MarshalIndent(st, "", "  ")
+		if err != nil {
+			return fmt.Errorf("Failed to encode state: %v", err)
+		}
+		err = writeState(path, data, version)
+		if err != errStateConflict || attempt == 5 {
+			if err != nil {
+				return fmt.Errorf("Failed to save state: %v", err)
+			}
+			return nil
+		}
+	}
+}
+
+// PauseName pauses a name, replacing any pause already on it.
+func PauseName(path string, name string, p Pause) error {
+	return UpdateRuntimeState(path, func(st *RuntimeState) error {
+		st.Paused[route53update.NormalizeHostname(name)] = p
+		return nil
+	})
+}
+
+// ResumeName takes the pause off a name.
+func ResumeName(path string, name string) error {
+	name = route53update.NormalizeHostname(name)
+	return UpdateRuntimeState(path, func(st *RuntimeState) error {
+		if _, ok := st.Paused[name]; !ok {
+			return fmt.Errorf("%s isn't paused", name)
+		}
+		delete(st.Paused, name)
+		return nil
+	})
+}
+
+// PausedNames lists the pauses in effect, sorted by name.
+func (st *RuntimeState) PausedNames(now time.Time) []string {
+	var names []string
+	for name, p := range st.Paused {
+		if p.Active(now) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Check the state file for a pause on a name. It's read fresh every time so
+// a pause takes effect on the next check, and if it can't be read the
+// record is left alone, better to miss an update than to change a record
+// someone asked us not to.
+func (u *Updater) pausedBecause(name string) string {
+	if u.State == "" {
+		return ""
+	}
+	st, _, err := LoadRuntimeState(u.State)
+	if err != nil {
+		return fmt.Sprintf("can't check for a pause: %v", err)
+	}
+	if p, ok := st.Paused[route53update.NormalizeHostname(name)]; ok && p.Active(time.Now()) {
+		return p.String()
+	}
+	return ""
+}
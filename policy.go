@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/mikerowehl/route53Update/route53update"
+)
+
+// Policies put hard limits on what a record can be changed to, unlike the
+// checks in checks.go which only warn. An address that breaks a policy gets
+// an alert and the record is left alone:
+//
+//	policy:
+//	  no_private: true
+//	domains:
+//	  home.example.com:
+//	    policy:
+//	      cidrs: [203.0.113.0/24, 2001:db8::/32]
+//	      countries: [US]
+//
+// The top level policy covers every domain and a domain's own policy is on
+// top of that, so an address has to pass both.
+
+// The ranges that aren't reachable from the internet, beyond what netip
+// already knows about. Carrier grade NAT addresses come back from some
+// ISPs' routers, and publishing one is always a mistake.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// policy is a PolicyConfig ready to check addresses against.
+type policy struct {
+	cidrs     []netip.Prefix
+	noPrivate bool
+	countries []string
+}
+
+func newPolicy(conf PolicyConfig) (*policy, error) {
+	p := &policy{noPrivate: conf.NoPrivate}
+	for _, c := range conf.CIDRs {
+		prefix, err := netip.ParsePrefix(c)
+		if err != nil {
+			return nil, fmt.Errorf("Bad policy CIDR %q: %v", c, err)
+		}
+		p.cidrs = append(p.cidrs, prefix.Masked())
+	}
+	for _, c := range conf.Countries {
+		if len(c) != 2 {
+			return nil, fmt.Errorf("Policy countries are two letter codes like US, not %q", c)
+		}
+		p.countries = append(p.countries, strings.ToUpper(c))
+	}
+	return p, nil
+}
+
+func isPrivate(addr netip.Addr) bool {
+	return addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsUnspecified() || sharedAddressSpace.Contains(addr)
+}
+
+// check says what's wrong with the address, or nil if it's allowed.
+func (p *policy) check(addr netip.Addr, geo *GeoLookup) error {
+	if p.noPrivate && isPrivate(addr) {
+		return fmt.Errorf("%s is a private address", addr)
+	}
+	if len(p.cidrs) > 0 && !slices.ContainsFunc(p.cidrs, func(c netip.Prefix) bool { return c.Contains(addr) }) {
+		return fmt.Errorf("%s isn't in any of the allowed ranges", addr)
+	}
+	if len(p.countries) > 0 {
+		var country string
+		if info := geo.Lookup(addr.String()); info != nil {
+			country = info.Country
+		}
+		if country == "" {
+			return fmt.Errorf("Can't tell what country %s is in", addr)
+		}
+		if !slices.Contains(p.countries, country) {
+			return fmt.Errorf("%s is in %s, not %s", addr, country, strings.Join(p.countries, " or "))
+		}
+	}
+	return nil
+}
+
+// Policies is every policy in the config.
+type Policies struct {
+	top     *policy
+	domains map[string]*policy
+	geo     *GeoLookup
+}
+
+// NewPolicies sets up the policies from the config. A nil config gives
+// policies that allow anything.
+func NewPolicies(conf *Config) (*Policies, error) {
+	ps := &Policies{domains: map[string]*policy{}}
+	if conf == nil {
+		return ps, nil
+	}
+	var err error
+	needGeo := len(conf.Policy.Countries) > 0
+	if ps.top, err = newPolicy(conf.Policy); err != nil {
+		return nil, err
+	}
+	for name, d := range conf.Domains {
+		if ps.domains[name], err = newPolicy(d.Policy); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		needGeo = needGeo || len(d.Policy.Countries) > 0
+	}
+	if needGeo {
+		ps.geo = NewGeoLookup(conf.GeoIP)
+		if ps.geo == nil {
+			return nil, fmt.Errorf("Country policies need a GeoIP city or country database")
+		}
+	}
+	return ps, nil
+}
+
+// Check says if the domain's record can be set to ip, returning what's
+// wrong with it if not.
+func (ps *Policies) Check(name string, ip string) error {
+	if ps == nil {
+		return nil
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return fmt.Errorf("%q isn't an address", ip)
+	}
+	addr = addr.Unmap()
+	for _, p := range []*policy{ps.top, ps.domains[route53update.NormalizeHostname(name)]} {
+		if p == nil {
+			continue
+		}
+		if err := p.check(addr, ps.geo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Alert about an address a policy won't allow, once per address so watch
+// mode doesn't send the same alert every check.
+func (u *Updater) reportPolicy(name string, record string, configuredIp string, ip string, err error, report AddressReport) {
+	fmt.Printf("Not updating %s to %s, blocked by policy: %v\n", name, ip, err)
+	if !u.newMismatch(name+" "+record, ip) {
+		return
+	}
+	u.Reporter.Report(Event{Type: EventMismatch, Domain: name, Record: record, Source: u.Source, OldIp: configuredIp, NewIp: ip, Summary: "blocked by policy: " + err.Error(), AddressReport: report})
+}
+
+// The same for addresses sent to the update server.
+func (s *Server) reportPolicy(hostname string, source string, configuredIp string, ip string, err error, report AddressReport) {
+	log.Printf("Not updating %s to %s, blocked by policy: %v", hostname, ip, err)
+	s.reporter.Report(Event{Type: EventMismatch, Domain: hostname, Source: source, OldIp: configuredIp, NewIp: ip, Summary: "blocked by policy: " + err.Error(), AddressReport: report})
+}
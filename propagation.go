@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mikerowehl/route53Update/route53update"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// When a change doesn't seem to have taken, the first thing I always do is
+// ask a handful of public resolvers and the authoritative servers what they
+// have, and how long they're going to keep having it. The Go resolver
+// doesn't hand back TTLs, so these queries are done by hand.
+
+// DefaultResolvers are checked when the config doesn't list any. "system"
+// is whatever /etc/resolv.conf points at, usually the ISP or the router.
+var DefaultResolvers = []string{"8.8.8.8", "1.1.1.1", "9.9.9.9", "system"}
+
+// The same resolvers over IPv6, for hosts that can't get to IPv4 at all.
+var DefaultIPv6Resolvers = []string{"2001:4860:4860::8888", "2606:4700:4700::1111", "2620:fe::fe", "system"}
+
+func defaultResolvers() []string {
+	if route53update.IPv6Only() {
+		return DefaultIPv6Resolvers
+	}
+	return DefaultResolvers
+}
+
+var resolverNames = map[string]string{
+	"8.8.8.8": "Google", "8.8.4.4": "Google",
+	"1.1.1.1": "Cloudflare", "1.0.0.1": "Cloudflare",
+	"9.9.9.9": "Quad9", "149.112.112.112": "Quad9",
+	"208.67.222.222": "OpenDNS", "208.67.220.220": "OpenDNS",
+	"2001:4860:4860::8888": "Google", "2001:4860:4860::8844": "Google",
+	"2606:4700:4700::1111": "Cloudflare", "2606:4700:4700::1001": "Cloudflare",
+	"2620:fe::fe": "Quad9", "2620:fe::9": "Quad9",
+}
+
+// DNSAnswer is one value a server gave for a name, with the TTL it has left.
+type DNSAnswer struct {
+	Value string
+	TTL   uint32
+}
+
+// ServerAnswers is what one server said for a record type.
+type ServerAnswers struct {
+	Server        string
+	Label         string
+	Authoritative bool
+	Answers       []DNSAnswer
+	Err           error
+}
+
+// Values is the answers without the TTLs, sorted for comparing.
+func (s ServerAnswers) Values() []string {
+	var values []string
+	for _, a := range s.Answers {
+		values = append(values, a.Value)
+	}
+	slices.Sort(values)
+	return values
+}
+
+// QueryDNS asks one server for one record type, over UDP and then TCP if the
+// answer doesn't fit.
+func QueryDNS(server string, name string, qtype dnsmessage.Type) ([]DNSAnswer, error) {
+	res, err := exchangeQuery(server, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+	if res.RCode != dnsmessage.RCodeSuccess && res.RCode != dnsmessage.RCodeNameError {
+		return nil, fmt.Errorf("server said %s", res.RCode)
+	}
+
+	var answers []DNSAnswer
+	for _, rr := range res.Answers {
+		switch body := rr.Body.(type) {
+		case *dnsmessage.AResource:
+			answers = append(answers, DNSAnswer{Value: net.IP(body.A[:]).String(), TTL: rr.Header.TTL})
+		case *dnsmessage.AAAAResource:
+			answers = append(answers, DNSAnswer{Value: net.IP(body.AAAA[:]).String(), TTL: rr.Header.TTL})
+		case *dnsmessage.TXTResource:
+			answers = append(answers, DNSAnswer{Value: strings.Join(body.TXT, ""), TTL: rr.Header.TTL})
+		case *dnsmessage.CNAMEResource:
+			if qtype == dnsmessage.TypeCNAME {
+				answers = append(answers, DNSAnswer{Value: body.CNAME.String(), TTL: rr.Header.TTL})
+			}
+		}
+	}
+	return answers, nil
+}
+
+// Send the query and get back the whole answer, whatever the server said.
+func exchangeQuery(server string, name string, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	fqdn, err := dnsmessage.NewName(route53update.NormalizeHostname(name) + ".")
+	if err != nil {
+		return nil, err
+	}
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: uint16(rand.Intn(1 << 16)), RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: fqdn, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	query, err := msg.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := exchangeDNS("udp", server, query)
+	if err == nil && res.Truncated {
+		res, err = exchangeDNS("tcp", server, query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if res.ID != msg.ID {
+		return nil, fmt.Errorf("answer doesn't match the query")
+	}
+	return res, nil
+}
+
+func exchangeDNS(network string, server string, query []byte) (*dnsmessage.Message, error) {
+	conn, err := net.DialTimeout(network, net.JoinHostPort(route53update.ReachableHost(server), "53"), 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	buf := make([]byte, 65535)
+	var n int
+	if network == "tcp" {
+		// Over TCP each message has a two byte length in front
+		framed := append([]byte{byte(len(query) >> 8), byte(len(query))}, query...)
+		if _, err := conn.Write(framed); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			return nil, err
+		}
+		n = int(buf[0])<<8 | int(buf[1])
+		if _, err := io.ReadFull(conn, buf[:n]); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		if n, err = conn.Read(buf); err != nil {
+			return nil, err
+		}
+	}
+	var res dnsmessage.Message
+	if err := res.Unpack(buf[:n]); err != nil {
+		return nil, fmt.Errorf("bad answer: %v", err)
+	}
+	return &res, nil
+}
+
+// The nameservers in /etc/resolv.conf.
+func systemResolvers() []string {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var servers []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, fields[1])
+		}
+	}
+	return servers
+}
+
+// AuthoritativeServers finds the nameservers for the zone a name is in by
+// walking up until something has NS records.
+func AuthoritativeServers(name string) ([]string, error) {
+	name = route53update.NormalizeHostname(name)
+	for zone := name; strings.Contains(zone, "."); zone = zone[strings.Index(zone, ".")+1:] {
+		nss, err := net.DefaultResolver.LookupNS(context.TODO(), zone)
+		if err == nil && len(nss) > 0 {
+			var servers []string
+			for _, ns := range nss {
+				servers = append(servers, strings.TrimSuffix(ns.Host, "."))
+			}
+			slices.Sort(servers)
+			return servers, nil
+		}
+	}
+	return nil, fmt.Errorf("Can't find nameservers for %s", name)
+}
+
+// ExpandResolvers turns the configured resolver list into addresses with
+// labels, swapping system for the actual system resolvers.
+func ExpandResolvers(resolvers []string) [][2]string {
+	var out [][2]string
+	for _, r := range resolvers {
+		if r == "system" {
+			for _, s := range systemResolvers() {
+				out = append(out, [2]string{s, "system"})
+			}
+			continue
+		}
+		out = append(out, [2]string{r, resolverNames[r]})
+	}
+	return out
+}
+
+// CheckPropagation asks the authoritative servers and each resolver for a
+// record, all at the same time so a slow one doesn't hold up the rest.
+func CheckPropagation(name string, qtype dnsmessage.Type, resolvers []string) ([]ServerAnswers, error) {
+	auth, err := AuthoritativeServers(name)
+	if err != nil {
+		return nil, err
+	}
+	var servers []ServerAnswers
+	for _, ns := range auth {
+		servers = append(servers, ServerAnswers{Server: ns, Label: "authoritative", Authoritative: true})
+	}
+	for _, r := range ExpandResolvers(resolvers) {
+		servers = append(servers, ServerAnswers{Server: r[0], Label: r[1]})
+	}
+
+	done := make(chan struct{})
+	for i := range servers {
+		go func() {
+			servers[i].Answers, servers[i].Err = QueryDNS(servers[i].Server, name, qtype)
+			done <- struct{}{}
+		}()
+	}
+	for range servers {
+		<-done
+	}
+	return servers, nil
+}
+
+// PrintPropagation writes the matrix, one line per server per record type,
+// marking resolvers that don't agree with the authoritative servers.
+func PrintPropagation(w io.Writer, results map[string][]ServerAnswers) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVER\tTYPE\tVALUE\tTTL\t")
+	for _, rtype := range []string{"A", "AAAA"} {
+		servers, ok := results[rtype]
+		if !ok {
+			continue
+		}
+		var authValues []string
+		for _, s := range servers {
+			if s.Authoritative && s.Err == nil {
+				authValues = s.Values()
+				break
+			}
+		}
+		for _, s := range servers {
+			label := s.Server
+			if s.Label != "" {
+				label += " (" + s.Label + ")"
+			}
+			switch {
+			case s.Err != nil:
+				fmt.Fprintf(tw, "%s\t%s\terror: %v\t\t\n", label, rtype, s.Err)
+			case len(s.Answers) == 0:
+				fmt.Fprintf(tw, "%s\t%s\t-\t\t%s\n", label, rtype, staleMark(s, authValues))
+			default:
+				for i, a := range s.Answers {
+					mark := ""
+					if i == 0 {
+						mark = staleMark(s, authValues)
+					}
+					fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", label, rtype, a.Value, a.TTL, mark)
+					label = ""
+				}
+			}
+		}
+	}
+	tw.Flush()
+}
+
+func staleMark(s ServerAnswers, authValues []string) string {
+	if s.Authoritative || authValues == nil || slices.Equal(s.Values(), authValues) {
+		return ""
+	}
+	return "differs from authoritative"
+}
+
+// WaitForValue polls the resolvers until every one of them answers with the
+// expected address, or gives up at the deadline. Resolvers that already have
+// it don't get asked again, caches don't go backwards.
+func WaitForValue(name string, expect string, resolvers []string, deadline time.Time) error {
+	ip := net.ParseIP(expect)
+	if ip == nil {
+		return fmt.Errorf("%s isn't an IP address", expect)
+	}
+	qtype := dnsmessage.TypeAAAA
+	if ip.To4() != nil {
+		qtype = dnsmessage.TypeA
+	}
+
+	pending := ExpandResolvers(resolvers)
+	if len(pending) == 0 {
+		return fmt.Errorf("No resolvers to check")
+	}
+	delay := 5 * time.Second
+	for {
+		var still [][2]string
+		for _, r := range pending {
+			answers, err := QueryDNS(r[0], name, qtype)
+			if err == nil && len(answers) > 0 && slices.ContainsFunc(answers, func(a DNSAnswer) bool { return net.ParseIP(a.Value).Equal(ip) }) {
+				fmt.Printf("%s has %s\n", r[0], expect)
+				continue
+			}
+			still = append(still, r)
+		}
+		pending = still
+		if len(pending) == 0 {
+			return nil
+		}
+		if !time.Now().Add(delay).Before(deadline) {
+			var names []string
+			for _, r := range pending {
+				names = append(names, r[0])
+			}
+			return fmt.Errorf("Timed out waiting for %s on %s", expect, strings.Join(names, ", "))
+		}
+		time.Sleep(delay)
+		delay = min(delay*2, 30*time.Second)
+	}
+}
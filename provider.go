@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/mikerowehl/route53Update/route53update"
+)
+
+// Route53 is still the main event, but not every domain in a household is
+// necessarily hosted there. A DNSProvider is anything else that can hold a
+// domain's address records, picked per domain with provider: in the config.
+// Providers get the basic update, the address check, holds for monitor
+// mode, approvals, and maintenance windows, and the usual reporting. The
+// Route53 only extras like canaries, metadata records, and drift
+// attribution stay Route53 only.
+type DNSProvider interface {
+	// GetRecord returns the address in a record, or route53update.ErrRecordNotFound.
+	GetRecord(domain string, rtype types.RRType) (string, error)
+	// SetRecord creates or updates a record to point at the address.
+	SetRecord(domain string, rtype types.RRType, ip string, ttl int64) error
+}
+
+// NewProviders sets up every provider the config has settings for.
+func NewProviders(conf *Config) (map[string]DNSProvider, error) {
+	providers := map[string]DNSProvider{}
+	if conf == nil {
+		return providers, nil
+	}
+	if conf.Cloudflare.APIToken != "" {
+		providers["cloudflare"] = NewCloudflareProvider(conf.Cloudflare.APIToken, conf.Domains)
+	}
+	if g := conf.Google; g.Enabled || g.Project != "" || g.CredentialsFile != "" {
+		p, err := NewCloudDNSProvider(g)
+		if err != nil {
+			return nil, err
+		}
+		providers["google"] = p
+	}
+	if conf.Helper.Socket != "" {
+		providers["helper"] = NewHelperProvider(conf.Helper.Socket)
+	}
+	for name, d := range conf.Domains {
+		if d.Provider != "" && d.Provider != "route53" && providers[d.Provider] == nil {
+			return nil, fmt.Errorf("%s uses provider %s, which isn't set up", name, d.Provider)
+		}
+	}
+	return providers, nil
+}
+
+// The update for a domain on some other provider, a simpler version of the
+// Route53 one in updateRecord.
+func (u *Updater) updateWithProvider(p DNSProvider, name string, record string, rtype types.RRType, ip string) error {
+	fail := func(err error) {
+		u.Reporter.Report(Event{Type: EventFailure, Domain: name, Record: record, Source: u.Source, Error: err.Error()})
+	}
+
+	configuredIp, err := p.GetRecord(name, rtype)
+	if err != nil && !(rtype == types.RRTypeAaaa && errors.Is(err, route53update.ErrRecordNotFound)) {
+		fail(err)
+		return fmt.Errorf("Error trying to check configured %s ip: %v", rtype, err)
+	}
+	fmt.Printf("%s address in %s is %s\n", rtype, u.Domains[name].Provider, configuredIp)
+
+	if ip == configuredIp {
+		u.newMismatch(name+" "+record, "")
+		u.Reporter.Report(Event{Type: EventNoChange, Domain: name, Record: record, Source: u.Source, OldIp: configuredIp, NewIp: ip})
+		fmt.Printf("%s address already up to date, done\n", rtype)
+		return nil
+	}
+
+	report := u.Checker.Check(ip)
+	if err := u.Policies.Check(name, ip); err != nil {
+		u.reportPolicy(name, record, configuredIp, ip, err, report)
+		return nil
+	}
+	if u.DryRun {
+		if held := u.heldBecause(name); held != "" {
+			fmt.Printf("A real run wouldn't change this yet: %s\n", held)
+		}
+		var current *types.ResourceRecordSet
+		if configuredIp != "" {
+			current = &types.ResourceRecordSet{ResourceRecords: []types.ResourceRecord{{Value: aws.String(configuredIp)}}}
+		}
+		printPlannedChange(os.Stdout, current, types.Change{
+			Action: types.ChangeActionUpsert,
+			ResourceRecordSet: &types.ResourceRecordSet{
+				Name:            aws.String(name + "."),
+				Type:            rtype,
+				TTL:             aws.Int64(u.ttl(name).For(true)),
+				ResourceRecords: []types.ResourceRecord{{Value: aws.String(ip)}},
+			},
+		})
+		return nil
+	}
+	if held := u.heldBecause(name); held != "" {
+		fmt.Printf("%s address should be %s, not updating: %s\n", rtype, ip, held)
+		u.reportHeld(name, record, configuredIp, ip, held, report)
+		return nil
+	}
+
+	hookChange := HookChange{Domain: name, Record: string(rtype), OldIp: configuredIp, NewIp: ip}
+	if err := runHooks("pre_change", u.hooksFor(name, "pre_change"), hookChange); err != nil {
+		fail(err)
+		u.changeFailed(name, hookChange, err)
+		return fmt.Errorf("Not updating %s record: %v", rtype, err)
+	}
+	if err := p.SetRecord(name, rtype, ip, u.ttl(name).For(true)); err != nil {
+		fail(err)
+		u.changeFailed(name, hookChange, err)
+		return fmt.Errorf("Error trying to update %s record: %v", rtype, err)
+	}
+	u.Reporter.Report(Event{Type: EventChange, Domain: name, Record: record, Source: u.Source, OldIp: configuredIp, NewIp: ip, TTL: u.ttl(name).For(true), AddressReport: report})
+	fmt.Printf("Updated %s at %s\n", rtype, u.Domains[name].Provider)
+
+	// Other providers don't say when a change is out, so the post change
+	// hooks go right away
+	if err := runHooks("post_change", u.hooksFor(name, "post_change"), hookChange); err != nil {
+		fail(err)
+		return fmt.Errorf("Updated %s record but %v", rtype, err)
+	}
+	return nil
+}
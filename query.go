@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Plenty of the boxes this runs on are routers and containers without dig
+// or nslookup, and that's just where it'd be nice to see what DNS is really
+// handing out. The query command is a small dig using the same queries the
+// propagation check makes by hand:
+//
+//	route53Update query home.example.com -type AAAA -server @authoritative
+//
+// The server can be any resolver, system for the first one in resolv.conf
+// (the default), or @authoritative for the first of the zone's nameservers.
+
+// The record types query knows how to ask for.
+var queryTypes = map[string]dnsmessage.Type{
+	"A":     dnsmessage.TypeA,
+	"AAAA":  dnsmessage.TypeAAAA,
+	"CNAME": dnsmessage.TypeCNAME,
+	"TXT":   dnsmessage.TypeTXT,
+	"NS":    dnsmessage.TypeNS,
+	"MX":    dnsmessage.TypeMX,
+	"SOA":   dnsmessage.TypeSOA,
+	"PTR":   dnsmessage.TypePTR,
+	"SRV":   dnsmessage.TypeSRV,
+	"CAA":   dnsmessage.Type(257),
+}
+
+// ParseQueryType turns A, aaaa, and so on into the type to ask for.
+func ParseQueryType(s string) (dnsmessage.Type, error) {
+	if t, ok := queryTypes[strings.ToUpper(s)]; ok {
+		return t, nil
+	}
+	return 0, fmt.Errorf("Don't know how to query %s records", s)
+}
+
+// The server to ask, from what was given on the command line.
+func queryServer(server string, name string) (string, error) {
+	server = strings.TrimPrefix(server, "@")
+	switch server {
+	case "", "system":
+		if servers := systemResolvers(); len(servers) > 0 {
+			return servers[0], nil
+		}
+		// No resolv.conf, on Windows say, so one of the public ones
+		return defaultResolvers()[0], nil
+	case "authoritative":
+		servers, err := AuthoritativeServers(name)
+		if err != nil {
+			return "", err
+		}
+		return servers[0], nil
+	}
+	return server, nil
+}
+
+// QueryResult is the whole answer from a server, for showing as is.
+type QueryResult struct {
+	Server string
+	Took   time.Duration
+	*dnsmessage.Message
+}
+
+// Query asks the server for the name's records of one type.
+func Query(server string, name string, qtype dnsmessage.Type) (*QueryResult, error) {
+	start := time.Now()
+	res, err := exchangeQuery(server, name, qtype)
+	if err != nil {
+		return nil, fmt.Errorf("Query to %s failed: %v", server, err)
+	}
+	return &QueryResult{Server: server, Took: time.Since(start), Message: res}, nil
+}
+
+// Print writes the answer out about the way dig does.
+func (q *QueryResult) Print(w io.Writer) {
+	var flags []string
+	for _, f := range []struct {
+		set  bool
+		name string
+	}{
+		{q.Response, "qr"}, {q.Authoritative, "aa"}, {q.Truncated, "tc"},
+		{q.RecursionDesired, "rd"}, {q.RecursionAvailable, "ra"},
+	} {
+		if f.set {
+			flags = append(flags, f.name)
+		}
+	}
+	fmt.Fprintf(w, ";; %s from %s in %dms, flags: %s\n", rcodeName(q.RCode), q.Server, q.Took.Milliseconds(), strings.Join(flags, " "))
+
+	for _, section := range []struct {
+		name string
+		rrs  []dnsmessage.Resource
+	}{
+		{"ANSWER", q.Answers}, {"AUTHORITY", q.Authorities}, {"ADDITIONAL", q.Additionals},
+	} {
+		var rrs []dnsmessage.Resource
+		for _, rr := range section.rrs {
+			// The EDNS pseudo record isn't anything anyone asked about
+			if rr.Header.Type != dnsmessage.TypeOPT {
+				rrs = append(rrs, rr)
+			}
+		}
+		if len(rrs) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n;; %s\n", section.name)
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		for _, rr := range rrs {
+			fmt.Fprintf(tw, "%s\t%d\tIN\t%s\t%s\n", rr.Header.Name, rr.Header.TTL, typeName(rr.Header.Type), formatRR(rr.Body))
+		}
+		tw.Flush()
+	}
+}
+
+// The names dig uses for the response codes.
+func rcodeName(rcode dnsmessage.RCode) string {
+	switch rcode {
+	case dnsmessage.RCodeSuccess:
+		return "NOERROR"
+	case dnsmessage.RCodeFormatError:
+		return "FORMERR"
+	case dnsmessage.RCodeServerFailure:
+		return "SERVFAIL"
+	case dnsmessage.RCodeNameError:
+		return "NXDOMAIN"
+	case dnsmessage.RCodeNotImplemented:
+		return "NOTIMP"
+	case dnsmessage.RCodeRefused:
+		return "REFUSED"
+	}
+	return "RCODE" + strconv.Itoa(int(rcode))
+}
+
+func typeName(t dnsmessage.Type) string {
+	for name, qt := range queryTypes {
+		if qt == t {
+			return name
+		}
+	}
+	return "TYPE" + strconv.Itoa(int(t))
+}
+
+// A record's value in zone file format.
+func formatRR(body dnsmessage.ResourceBody) string {
+	switch b := body.(type) {
+	case *dnsmessage.AResource:
+		return net.IP(b.A[:]).String()
+	case *dnsmessage.AAAAResource:
+		return net.IP(b.AAAA[:]).String()
+	case *dnsmessage.CNAMEResource:
+		return b.CNAME.String()
+	case *dnsmessage.NSResource:
+		return b.NS.String()
+	case *dnsmessage.PTRResource:
+		return b.PTR.String()
+	case *dnsmessage.MXResource:
+		return fmt.Sprintf("%d %s", b.Pref, b.MX)
+	case *dnsmessage.SRVResource:
+		return fmt.Sprintf("%d %d %d %s", b.Priority, b.Weight, b.Port, b.Target)
+	case *dnsmessage.SOAResource:
+		return fmt.Sprintf("%s %s %d %d %d %d %d", b.NS, b.MBox, b.Serial, b.Refresh, b.Retry, b.Expire, b.MinTTL)
+	case *dnsmessage.TXTResource:
+		var parts []string
+		for _, t := range b.TXT {
+			parts = append(parts, strconv.Quote(t))
+		}
+		return strings.Join(parts, " ")
+	case *dnsmessage.UnknownResource:
+		// The generic format from RFC 3597, for CAA and anything else
+		return fmt.Sprintf("\\# %d %s", len(b.Data), hex.EncodeToString(b.Data))
+	}
+	return fmt.Sprint(body)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	logtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+// Name of the resource policy that lets Route53 write to the log groups.
+const queryLogPolicyName = "route53Update-query-logging"
+
+// EnableQueryLogging turns on DNS query logging for a hosted zone, doing all
+// the setup the console normally does behind the scenes: make the log group,
+// give Route53 permission to write to it, and then create the logging config
+// on the zone. Returns the id of the query logging config. Route53 only
+// delivers query logs to log groups in the partition's global region, which
+// is us-east-1 for regular AWS.
+func EnableQueryLogging(cfg aws.Config, partition Partition, client *route53.Client, zone string, logGroup string) (string, error) {
+	logs := cloudwatchlogs.NewFromConfig(cfg, func(o *cloudwatchlogs.Options) {
+		o.Region = partition.GlobalRegion
+	})
+
+	_, err := logs.CreateLogGroup(context.TODO(), &cloudwatchlogs.CreateLogGroupInput{
+		LogGroupName: aws.String(logGroup),
+	})
+	var exists *logtypes.ResourceAlreadyExistsException
+	if err != nil && !errors.As(err, &exists) {
+		return "", fmt.Errorf("Failed to create log group: %v", err)
+	}
+
+	groupArn, err := logGroupArn(logs, logGroup)
+	if err != nil {
+		return "", err
+	}
+	parsed, err := arn.Parse(groupArn)
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse log group ARN: %v", err)
+	}
+
+	// Route53 needs to be able to create streams and put events in the
+	// group. Groups under /aws/route53/ share one policy covering all of
+	// them, so enabling logging on more zones doesn't eat up the limit of
+	// ten resource policies per account.
+	resource := groupArn + ":*"
+	if strings.HasPrefix(logGroup, "/aws/route53/") {
+		resource = arn.ARN{
+			Partition: partition.ID,
+			Service:   "logs",
+			Region:    parsed.Region,
+			AccountID: parsed.AccountID,
+			Resource:  "log-group:/aws/route53/*",
+		}.String()
+	}
+	policy, err := json.Marshal(map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []map[string]interface{}{
+			{
+				"Sid":       "Route53LogsToCloudWatchLogs",
+				"Effect":    "Allow",
+				"Principal": map[string]string{"Service": "route53.amazonaws.com"},
+				"Action":    []string{"logs:CreateLogStream", "logs:PutLogEvents"},
+				"Resource":  resource,
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	_, err = logs.PutResourcePolicy(context.TODO(), &cloudwatchlogs.PutResourcePolicyInput{
+		PolicyName:     aws.String(queryLogPolicyName),
+		PolicyDocument: aws.String(string(policy)),
+	})
+	if err != nil {
+		return "", fmt.Errorf("Failed to set log group policy: %v", err)
+	}
+
+	res, err := client.CreateQueryLoggingConfig(context.TODO(), &route53.CreateQueryLoggingConfigInput{
+		HostedZoneId:              aws.String(zone),
+		CloudWatchLogsLogGroupArn: aws.String(groupArn),
+	})
+	var already *types.QueryLoggingConfigAlreadyExists
+	if errors.As(err, &already) {
+		return "", fmt.Errorf("Query logging is already enabled for this zone")
+	}
+	if err != nil {
+		return "", fmt.Errorf("Failed to enable query logging: %v", err)
+	}
+	return *res.QueryLoggingConfig.Id, nil
+}
+
+// DisableQueryLogging removes the query logging config from a zone. The log
+// group and whatever is already in it are left alone.
+func DisableQueryLogging(client *route53.Client, zone string) error {
+	res, err := client.ListQueryLoggingConfigs(context.TODO(), &route53.ListQueryLoggingConfigsInput{
+		HostedZoneId: aws.String(zone),
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to list query logging configs: %v", err)
+	}
+	if len(res.QueryLoggingConfigs) == 0 {
+		return fmt.Errorf("Query logging isn't enabled for this zone")
+	}
+	for _, qlc := range res.QueryLoggingConfigs {
+		_, err := client.DeleteQueryLoggingConfig(context.TODO(), &route53.DeleteQueryLoggingConfigInput{
+			Id: qlc.Id,
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to delete query logging config %s: %v", *qlc.Id, err)
+		}
+	}
+	return nil
+}
+
+// Find the ARN of a log group. DescribeLogGroups hands back the ARN with a
+// ":*" on the end, which Route53 won't accept, so that gets trimmed off.
+func logGroupArn(logs *cloudwatchlogs.Client, logGroup string) (string, error) {
+	res, err := logs.DescribeLogGroups(context.TODO(), &cloudwatchlogs.DescribeLogGroupsInput{
+		LogGroupNamePrefix: aws.String(logGroup),
+	})
+	if err != nil {
+		return "", fmt.Errorf("Failed to look up log group: %v", err)
+	}
+	for _, group := range res.LogGroups {
+		if aws.ToString(group.LogGroupName) == logGroup {
+			return strings.TrimSuffix(aws.ToString(group.Arn), ":*"), nil
+		}
+	}
+	return "", fmt.Errorf("Can't find log group %s", logGroup)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QuietNotifier holds back notifications for a channel during its quiet
+// hours. The one exception is a domain that keeps failing, a second failure
+// in the same quiet stretch goes straight out since that's probably worth
+// waking up for. Everything held back gets sent as one summary when the
+// quiet hours end.
+//
+// Held events only live in memory, so the summary only happens in the long
+// running modes. A one shot run during quiet hours just stays quiet, the
+// history file still has everything.
+type QuietNotifier struct {
+	inner   Notifier
+	windows []TimeWindow
+
+	mu    sync.Mutex
+	held  []Event
+	timer *time.Timer
+}
+
+func NewQuietNotifier(inner Notifier, windows []TimeWindow) *QuietNotifier {
+	return &QuietNotifier{inner: inner, windows: windows}
+}
+
+func (q *QuietNotifier) Notify(e Event) error {
+	now := e.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+	if !InWindows(q.windows, now) {
+		q.flush()
+		return q.inner.Notify(e)
+	}
+
+	q.mu.Lock()
+	repeated := false
+	if e.Type == EventFailure {
+		for _, h := range q.held {
+			if h.Type == EventFailure && h.Domain == e.Domain && h.Record == e.Record {
+				repeated = true
+			}
+		}
+	}
+	q.held = append(q.held, e)
+	if end := NextOutsideWindows(q.windows, now); q.timer == nil && !end.IsZero() {
+		q.timer = time.AfterFunc(time.Until(end), q.flush)
+	}
+	q.mu.Unlock()
+
+	if repeated {
+		return q.inner.Notify(e)
+	}
+	return nil
+}
+
+// Send a summary of whatever got held back.
+func (q *QuietNotifier) flush() {
+	q.mu.Lock()
+	held := q.held
+	q.held = nil
+	if q.timer != nil {
+		q.timer.Stop()
+		q.timer = nil
+	}
+	q.mu.Unlock()
+	if len(held) == 0 {
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d notifications held during quiet hours:\n", len(held))
+	for _, e := range held {
+		fmt.Fprintf(&b, "  %s %s\n", e.Time.Format("01-02 15:04"), e.summary())
+	}
+	err := q.inner.Notify(Event{
+		Time:    time.Now(),
+		Type:    EventDigest,
+		Summary: strings.TrimRight(b.String(), "\n"),
+	})
+	if err != nil {
+		log.Printf("Failed to send quiet hours summary: %v", err)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/mikerowehl/route53Update/route53update"
+)
+
+// Everything that deletes records, pruning an import, deregistering,
+// reaping expired records, removing an expired client's records, or a
+// followed name dropping a family, first copies the whole record set into
+// the recycle bin. A bad zone file or a confused sync can take out a lot of
+// records at once, and with the exact record sets saved restore can put
+// them back without anyone having to remember what they were. The bin is a
+// state path, a JSON line for each record set, and a delete doesn't go
+// ahead if the copy can't be saved. A TXT set that has other things in it
+// only loses our markers, so only the markers go in the bin, and restoring
+// them merges them back in with whatever is in the set by then.
+
+// DeletedRecord is a record set in the recycle bin.
+type DeletedRecord struct {
+	ID     string                  `json:"id"`
+	Time   time.Time               `json:"time"`
+	Zone   string                  `json:"zone"`
+	Reason string                  `json:"reason"`
+	Record types.ResourceRecordSet `json:"record"`
+
+	// The set itself stayed in the zone, and Record is just the values
+	// taken out of it
+	Shared bool `json:"shared,omitempty"`
+}
+
+// Where the recycle bin is, from the config if it says, see recycleBinPath.
+var recycleBin string
+
+// The recycle bin from the config, or a file in the user's config
+// directory if it doesn't have one.
+func recycleBinPath() (string, error) {
+	if recycleBin != "" {
+		return recycleBin, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("No recycle_bin set and no config directory for one: %v", err)
+	}
+	dir = filepath.Join(dir, "route53update")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "recycle-bin.jsonl"), nil
+}
+
+// RecycleRecords saves record sets that are about to be deleted from the
+// zone. The error means they weren't saved, and shouldn't be deleted.
+func RecycleRecords(zone string, reason string, recs ...*types.ResourceRecordSet) error {
+	path, err := recycleBinPath()
+	if err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		removed, shared := recycledPart(rec)
+		if removed == nil {
+			continue
+		}
+		id := make([]byte, 4)
+		rand.Read(id)
+		line, err := json.Marshal(DeletedRecord{
+			ID:     hex.EncodeToString(id),
+			Time:   time.Now().UTC(),
+			Zone:   strings.TrimPrefix(zone, "/hostedzone/"),
+			Reason: reason,
+			Record: *removed,
+			Shared: shared,
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to encode %s for the recycle bin: %v", aws.ToString(rec.Name), err)
+		}
+		if err := appendState(path, append(line, '\n')); err != nil {
+			return fmt.Errorf("Failed to save %s to the recycle bin: %v", aws.ToString(rec.Name), err)
+		}
+	}
+	return nil
+}
+
+// The part of a record set a delete takes out, the way deleteChanges does
+// it: the whole set, or for a TXT set with other things in it just our
+// markers, which is shared. Nil if the delete doesn't take anything out.
+func recycledPart(rec *types.ResourceRecordSet) (*types.ResourceRecordSet, bool) {
+	if rec == nil {
+		return nil, false
+	}
+	if rec.Type != types.RRTypeTxt {
+		return rec, false
+	}
+	rest := WithMarkers(rec)
+	if len(rest) == 0 {
+		return rec, false
+	}
+	if len(rest) == len(rec.ResourceRecords) {
+		return nil, false
+	}
+	removed := *rec
+	removed.ResourceRecords = nil
+	for _, rr := range rec.ResourceRecords {
+		if !slices.ContainsFunc(rest, func(kept types.ResourceRecord) bool {
+			return aws.ToString(kept.Value) == aws.ToString(rr.Value)
+		}) {
+			removed.ResourceRecords = append(removed.ResourceRecords, rr)
+		}
+	}
+	return &removed, true
+}
+
+// ReadRecycleBin lists what's in the recycle bin, oldest first.
+func ReadRecycleBin() ([]DeletedRecord, error) {
+	path, err := recycleBinPath()
+	if err != nil {
+		return nil, err
+	}
+	data, _, err := readState(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the recycle bin: %v", err)
+	}
+	var deleted []DeletedRecord
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var d DeletedRecord
+		if err := json.Unmarshal(scanner.Bytes(), &d); err != nil {
+			continue
+		}
+		deleted = append(deleted, d)
+	}
+	return deleted, scanner.Err()
+}
+
+// PrintRecycleBin shows the deleted records, the ones for the name if it's
+// given.
+func PrintRecycleBin(w io.Writer, deleted []DeletedRecord, name string) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tDELETED\tNAME\tTYPE\tVALUES\tREASON")
+	for _, d := range deleted {
+		recName := route53update.NormalizeHostname(aws.ToString(d.Record.Name))
+		if name != "" && recName != name {
+			continue
+		}
+		values := strings.Join(sortedValues(d.Record), ", ")
+		if d.Record.AliasTarget != nil {
+			values = "alias " + aws.ToString(d.Record.AliasTarget.DNSName)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", d.ID, d.Time.Local().Format("2006-01-02 15:04"), recName, d.Record.Type, values, d.Reason)
+	}
+	tw.Flush()
+}
+
+// RestoreRecord puts a record set from the recycle bin back and takes it
+// out of the bin. A whole set is created rather than upserted, so if
+// something has taken its place since, that stays and this fails. Values
+// taken out of a shared set get merged back into whatever it has now.
+func RestoreRecord(client *route53.Client, id string) (*DeletedRecord, error) {
+	deleted, err := ReadRecycleBin()
+	if err != nil {
+		return nil, err
+	}
+	var found *DeletedRecord
+	for i := range deleted {
+		if deleted[i].ID == id {
+			found = &deleted[i]
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("Nothing in the recycle bin with id %s", id)
+	}
+	rec := found.Record
+	var current *types.ResourceRecordSet
+	if found.Shared {
+		current, err = route53update.GetRecord(client, found.Zone, aws.ToString(rec.Name), rec.Type)
+		if err != nil && !errors.Is(err, route53update.ErrRecordNotFound) {
+			return nil, fmt.Errorf("Failed to look up %s to restore into: %v", aws.ToString(rec.Name), err)
+		}
+	}
+	_, err = route53update.ChangeRecordSets(client, &route53.ChangeResourceRecordSetsInput{
+		ChangeBatch: &types.ChangeBatch{
+			Changes: []types.Change{restoreChange(rec, current)},
+			Comment: aws.String("route53Update restore " + id),
+		},
+		HostedZoneId: aws.String(found.Zone),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to restore %s: %v", aws.ToString(rec.Name), err)
+	}
+	if err := dropRecycled(id); err != nil {
+		return found, fmt.Errorf("Restored %s, but failed to take it out of the recycle bin: %v", aws.ToString(rec.Name), err)
+	}
+	return found, nil
+}
+
+// The change that puts a record set back, creating it if there's nothing
+// there now, or adding the values back into the set that's there.
+func restoreChange(rec types.ResourceRecordSet, current *types.ResourceRecordSet) types.Change {
+	if current == nil {
+		return types.Change{Action: types.ChangeActionCreate, ResourceRecordSet: &rec}
+	}
+	merged := *current
+	merged.ResourceRecords = slices.Clone(current.ResourceRecords)
+	for _, rr := range rec.ResourceRecords {
+		if !slices.ContainsFunc(merged.ResourceRecords, func(have types.ResourceRecord) bool {
+			return aws.ToString(have.Value) == aws.ToString(rr.Value)
+		}) {
+			merged.ResourceRecords = append(merged.ResourceRecords, rr)
+		}
+	}
+	return types.Change{Action: types.ChangeActionUpsert, ResourceRecordSet: &merged}
+}
+
+// Take an entry out of the recycle bin once it's been restored, so it
+// doesn't get restored twice. Lines that don't parse are left alone.
+func dropRecycled(id string) error {
+	path, err := recycleBinPath()
+	if err != nil {
+		return err
+	}
+	data, version, err := readState(path)
+	if err != nil {
+		return err
+	}
+	var kept []byte
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		var d DeletedRecord
+		if json.Unmarshal(bytes.TrimSpace(line), &d) == nil && d.ID == id {
+			continue
+		}
+		kept = append(kept, line...)
+	}
+	return writeState(path, kept, version)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+func txtSet(values ...string) *types.ResourceRecordSet {
+	rec := &types.ResourceRecordSet{Name: aws.String("home.example.com."), Type: types.RRTypeTxt, TTL: aws.Int64(300)}
+	for _, v := range values {
+		rec.ResourceRecords = append(rec.ResourceRecords, types.ResourceRecord{Value: aws.String(QuoteTXT(v))})
+	}
+	return rec
+}
+
+// Deleting from a TXT set with other things in it only takes our marker out,
+// so that's all that goes in the bin, and restoring it puts the marker back
+// next to whatever is there by then rather than trying to create the set.
+func TestRecycleSharedTXT(t *testing.T) {
+	recycleBin = filepath.Join(t.TempDir(), "recycle-bin.jsonl")
+	defer func() { recycleBin = "" }()
+
+	marker := markerHeritage + "owner=test"
+	shared := txtSet("v=spf1 -all", marker)
+	ours := txtSet(marker)
+	untouched := txtSet("v=spf1 -all")
+	if err := RecycleRecords("/hostedzone/ZONE", "test", shared, ours, untouched); err != nil {
+		t.Fatalf("RecycleRecords: %v", err)
+	}
+	deleted, err := ReadRecycleBin()
+	if err != nil {
+		t.Fatalf("ReadRecycleBin: %v", err)
+	}
+	if len(deleted) != 2 {
+		t.Fatalf("Got %d entries in the bin, want 2, the set with nothing of ours shouldn't be there", len(deleted))
+	}
+	if !deleted[0].Shared || !slices.Equal(recordValues(&deleted[0].Record), []string{QuoteTXT(marker)}) {
+		t.Errorf("Shared set saved as %v shared %v, want just the marker", recordValues(&deleted[0].Record), deleted[0].Shared)
+	}
+	if deleted[1].Shared || deleted[1].Zone != "ZONE" {
+		t.Errorf("Set of only ours saved as shared %v in %s, want the whole set in ZONE", deleted[1].Shared, deleted[1].Zone)
+	}
+
+	change := restoreChange(deleted[0].Record, txtSet("v=spf1 -all", "google-site-verification=abc"))
+	want := []string{QuoteTXT("v=spf1 -all"), QuoteTXT("google-site-verification=abc"), QuoteTXT(marker)}
+	slices.Sort(want)
+	if change.Action != types.ChangeActionUpsert || !slices.Equal(recordValues(change.ResourceRecordSet), want) {
+		t.Errorf("Restore is %s %v, want UPSERT %v", change.Action, recordValues(change.ResourceRecordSet), want)
+	}
+	if change := restoreChange(deleted[0].Record, txtSet(marker)); len(change.ResourceRecordSet.ResourceRecords) != 1 {
+		t.Errorf("Restore into a set that has the marker again doubled it: %v", recordValues(change.ResourceRecordSet))
+	}
+	if change := restoreChange(deleted[1].Record, nil); change.Action != types.ChangeActionCreate {
+		t.Errorf("Restore with nothing there is %s, want CREATE", change.Action)
+	}
+
+	if err := dropRecycled(deleted[0].ID); err != nil {
+		t.Fatalf("dropRecycled: %v", err)
+	}
+	left, err := ReadRecycleBin()
+	if err != nil {
+		t.Fatalf("ReadRecycleBin: %v", err)
+	}
+	if len(left) != 1 || left[0].ID != deleted[1].ID {
+		t.Errorf("Bin after dropping %s has %v, want only %s", deleted[0].ID, left, deleted[1].ID)
+	}
+}
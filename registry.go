@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/mikerowehl/route53Update/route53update"
+)
+
+// Prefix of the TXT value we put next to every record a client owns. Anything
+// without one of these wasn't made by the registry and we leave it alone.
+const ownerMarkerPrefix = markerHeritage + "owner="
+
+// ClientState is what we remember about a client between check-ins.
+type ClientState struct {
+	LastSeen  time.Time         `json:"last_seen"`
+	Hostnames map[string]string `json:"hostnames"`
+	Expired   bool              `json:"expired"`
+}
+
+// ClientRegistry keeps track of the token based clients, which hostnames
+// they've claimed, and when each one last checked in. The state is saved to
+// a JSON file after every change so restarts don't forget about devices, or
+// with a database everything is kept in that, see clientdb.go.
+type ClientRegistry struct {
+	conf    RegistryConfig
+	clients map[string]ClientConfig
+	db      *clientDB
+
+	mu    sync.Mutex
+	state map[string]*ClientState
+}
+
+// NewClientRegistry sets up the registry from the server config and loads
+// any state saved by a previous run.
+func NewClientRegistry(clients []ClientConfig, conf RegistryConfig) (*ClientRegistry, error) {
+	switch conf.ExpireAction {
+	case "":
+		conf.ExpireAction = "alert"
+	case "alert", "remove":
+	default:
+		return nil, fmt.Errorf("Unknown expire_action %q, must be alert or remove", conf.ExpireAction)
+	}
+	if conf.State != "" && conf.DB != "" {
+		return nil, fmt.Errorf("The registry can have a state file or a db, not both")
+	}
+
+	reg := &ClientRegistry{
+		conf:    conf,
+		clients: map[string]ClientConfig{},
+		state:   map[string]*ClientState{},
+	}
+	names := map[string]bool{}
+	for _, c := range clients {
+		if c.Name == "" || c.Hostname == "" || c.Zone == "" {
+			return nil, fmt.Errorf("Client entries need a name, hostname, and zone")
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("Client %s is listed more than once", c.Name)
+		}
+		names[c.Name] = true
+		hash := strings.ToLower(c.TokenHash)
+		if len(hash) != sha256.Size*2 {
+			return nil, fmt.Errorf("Client %s token_sha256 is not a sha256 hex digest", c.Name)
+		}
+		c.TokenHash = hash
+		reg.clients[hash] = c
+	}
+
+	if conf.DB != "" {
+		db, err := openClientDB(conf.DB)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range reg.clients {
+			if err := db.sync(c); err != nil {
+				return nil, err
+			}
+		}
+		reg.db = db
+	}
+
+	if conf.State != "" {
+		data, _, err := readState(conf.State)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("Failed to read registry state: %v", err)
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, &reg.state); err != nil {
+				return nil, fmt.Errorf("Failed to parse registry state %s: %v", conf.State, err)
+			}
+		}
+	}
+	return reg, nil
+}
+
+// Lookup finds the client a token belongs to.
+func (r *ClientRegistry) Lookup(token string) (ClientConfig, bool) {
+	sum := sha256.Sum256([]byte(token))
+	if r.db != nil {
+		c, ok, err := r.db.lookup(hex.EncodeToString(sum[:]))
+		if err != nil {
+			log.Printf("Failed to look up client token: %v", err)
+		}
+		return c, ok
+	}
+	c, ok := r.clients[hex.EncodeToString(sum[:])]
+	return c, ok
+}
+
+// Client finds a configured client by name.
+func (r *ClientRegistry) Client(name string) (ClientConfig, bool) {
+	if r.db != nil {
+		c, ok, err := r.db.client(name)
+		if err != nil {
+			log.Printf("Failed to look up client %s: %v", name, err)
+		}
+		return c, ok
+	}
+	for _, c := range r.clients {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return ClientConfig{}, false
+}
+
+// Owns reports if the hostname falls under the client's pattern.
+func (c ClientConfig) Owns(hostname string) bool {
+	matched, err := path.Match(route53update.NormalizeHostname(c.Hostname), route53update.NormalizeHostname(hostname))
+	return err == nil && matched
+}
+
+// Seen records a check-in from the client for a hostname.
+func (r *ClientRegistry) Seen(name string, hostname string, ip string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.db != nil {
+		wasExpired, err := r.db.seen(name, route53update.NormalizeHostname(hostname), ip, time.Now())
+		if err != nil {
+			log.Printf("Failed to save check-in from %s: %v", name, err)
+		}
+		if wasExpired {
+			log.Printf("Client %s is checking in again", name)
+		}
+		return
+	}
+
+	st, ok := r.state[name]
+	if !ok {
+		st = &ClientState{Hostnames: map[string]string{}}
+		r.state[name] = st
+	}
+	if st.Expired {
+		log.Printf("Client %s is checking in again", name)
+	}
+	st.LastSeen = time.Now()
+	st.Expired = false
+	st.Hostnames[route53update.NormalizeHostname(hostname)] = ip
+	r.save()
+}
+
+// Expired returns the clients that haven't checked in within the expiry
+// period and haven't already been handled, marking them as handled.
+func (r *ClientRegistry) Expired(now time.Time) map[string]ClientState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	expired := map[string]ClientState{}
+	if r.conf.ExpireAfter <= 0 {
+		return expired
+	}
+	if r.db != nil {
+		expired, err := r.db.expired(now.Add(-r.conf.ExpireAfter))
+		if err != nil {
+			log.Printf("Failed to check for expired clients: %v", err)
+		}
+		return expired
+	}
+	for name, st := range r.state {
+		if st.Expired || now.Sub(st.LastSeen) < r.conf.ExpireAfter {
+			continue
+		}
+		st.Expired = true
+		expired[name] = *st
+	}
+	if len(expired) > 0 {
+		r.save()
+	}
+	return expired
+}
+
+// Updated records a change a client made, for its history. Only the database
+// keeps that, the history file already has it otherwise.
+func (r *ClientRegistry) Updated(name string, hostname string, oldIp string, newIp string) {
+	if r.db == nil {
+		return
+	}
+	err := r.db.updated(ClientUpdate{Client: name, Hostname: route53update.NormalizeHostname(hostname), OldIp: oldIp, NewIp: newIp, Time: time.Now()})
+	if err != nil {
+		log.Printf("Failed to save update from %s: %v", name, err)
+	}
+}
+
+// Write the state out to a temp file and move it into place, so a crash
+// halfway through a write can't leave us with a truncated file. In S3 this
+// server is the only writer, so it just overwrites whatever is there.
+func (r *ClientRegistry) save() {
+	if r.conf.State == "" {
+		return
+	}
+	data, err := json.MarshalIndent(r.state, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode registry state: %v", err)
+		return
+	}
+	_, version, err := readState(r.conf.State)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Failed to check registry state: %v", err)
+		return
+	}
+	if err := writeState(r.conf.State, data, version); err != nil {
+		log.Printf("Failed to save registry state: %v", err)
+	}
+}
+
+// NewToken makes a random token for a client, along with the hash of it that
+// goes in the config.
+func NewToken() (string, string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token := hex.EncodeToString(buf)
+	sum := sha256.Sum256([]byte(token))
+	return token, hex.EncodeToString(sum[:]), nil
+}
+
+func ownerMarker(owner string) string {
+	return ownerMarkerPrefix + owner
+}
+
+// Pull back the A, AAAA, and TXT record sets for a name, any of which might
+// not exist. Route53 returns records sorted by name, so starting the listing at
+// the name gets us just the records we care about, paging until the name
+// changes in case there are a lot of them.
+func GetOwnedRecords(client *route53.Client, zone string, domain string) (*types.ResourceRecordSet, *types.ResourceRecordSet, *types.ResourceRecordSet, error) {
+	paginator := route53.NewListResourceRecordSetsPaginator(client, &route53.ListResourceRecordSetsInput{
+		HostedZoneId:    aws.String(zone),
+		StartRecordName: aws.String(domain),
+		MaxItems:        aws.Int32(20),
+	})
+
+	var a, aaaa, txt *types.ResourceRecordSet
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for i, rec := range page.ResourceRecordSets {
+			if !strings.EqualFold(*rec.Name, domain) {
+				return a, aaaa, txt, nil
+			}
+			if rec.SetIdentifier != nil {
+				continue
+			}
+			switch rec.Type {
+			case types.RRTypeA:
+				a = &page.ResourceRecordSets[i]
+			case types.RRTypeAaaa:
+				aaaa = &page.ResourceRecordSets[i]
+			case types.RRTypeTxt:
+				txt = &page.ResourceRecordSets[i]
+			}
+		}
+	}
+	return a, aaaa, txt, nil
+}
+
+// RecordOwner returns the client named in an ownership TXT record, or an empty
+// string if the record isn't one of ours.
+func RecordOwner(txt *types.ResourceRecordSet) string {
+	if txt == nil {
+		return ""
+	}
+	for _, value := range TXTValues(txt) {
+		if strings.HasPrefix(value, ownerMarkerPrefix) {
+			return strings.TrimPrefix(value, ownerMarkerPrefix)
+		}
+	}
+	return ""
+}
+
+// Upsert the A or AAAA rec for a client owned name along with the TXT marker saying
+// who owns it, in one batch so we never have one without the other. Other
+// values in the existing TXT record stay put.
+func UpdateOwnedIp(client *route53.Client, zone string, domain string, rtype types.RRType, ip string, owner string, txt *types.ResourceRecordSet) (*route53.ChangeResourceRecordSetsOutput, error) {
+	changes := []types.Change{
+		{
+			Action: types.ChangeActionUpsert,
+			ResourceRecordSet: &types.ResourceRecordSet{
+				Name:            aws.String(domain),
+				Type:            rtype,
+				ResourceRecords: []types.ResourceRecord{{Value: aws.String(ip)}},
+				TTL:             aws.Int64(route53update.DefaultTTL),
+			},
+		},
+		{
+			Action: types.ChangeActionUpsert,
+			ResourceRecordSet: &types.ResourceRecordSet{
+				Name:            aws.String(domain),
+				Type:            types.RRTypeTxt,
+				ResourceRecords: WithMarkers(txt, ownerMarker(owner)),
+				TTL:             aws.Int64(route53update.DefaultTTL),
+			},
+		},
+	}
+	params := &route53.ChangeResourceRecordSetsInput{
+		ChangeBatch: &types.ChangeBatch{
+			Changes: changes,
+		},
+		HostedZoneId: aws.String(zone),
+	}
+	return route53update.ChangeRecordSets(client, params)
+}
+
+// Delete the record sets passed in. Route53 wants the exact current contents
+// of a record to delete it, so these need to come from a fresh lookup. TXT
+// records only lose our markers, if anything else is in there it stays. The
+// record sets go in the recycle bin first, with the reason for deleting them.
+func DeleteRecords(client *route53.Client, zone string, reason string, recs ...*types.ResourceRecordSet) (*route53.ChangeResourceRecordSetsOutput, error) {
+	changes := deleteChanges(recs...)
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	if err := RecycleRecords(zone, reason, recs...); err != nil {
+		return nil, err
+	}
+	params := &route53.ChangeResourceRecordSetsInput{
+		ChangeBatch: &types.ChangeBatch{
+			Changes: changes,
+		},
+		HostedZoneId: aws.String(zone),
+	}
+	return route53update.ChangeRecordSets(client, params)
+}
+
+// The changes that delete record sets, or strip our markers out of them.
+func deleteChanges(recs ...*types.ResourceRecordSet) []types.Change {
+	var changes []types.Change
+	for _, rec := range recs {
+		if rec == nil {
+			continue
+		}
+		if rec.Type == types.RRTypeTxt {
+			if rest := WithMarkers(rec); len(rest) > 0 {
+				if len(rest) < len(rec.ResourceRecords) {
+					kept := *rec
+					kept.ResourceRecords = rest
+					changes = append(changes, types.Change{Action: types.ChangeActionUpsert, ResourceRecordSet: &kept})
+				}
+				continue
+			}
+		}
+		changes = append(changes, types.Change{
+			Action:            types.ChangeActionDelete,
+			ResourceRecordSet: rec,
+		})
+	}
+	return changes
+}
@@ -0,0 +1,58 @@
+//go:build linux
+
+package route53update
+
+import (
+	"bufio"
+	"encoding/hex"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The kernel's flags on IPv6 addresses that say not to publish one, from
+// linux/if_addr.h. Temporary addresses are the privacy ones that change
+// every day or so, deprecated ones are on their way out, and the other two
+// haven't passed duplicate address detection.
+const (
+	ifaFlagTemporary  = 0x01
+	ifaFlagDADFailed  = 0x08
+	ifaFlagDeprecated = 0x20
+	ifaFlagTentative  = 0x40
+)
+
+// Go doesn't say which IPv6 addresses are temporary, but Linux lists every
+// address with its flags in /proc/net/if_inet6:
+//
+//	20010db8000000000000000000000001 02 40 00 80 eth0
+//
+// which is the address, interface index, prefix length, scope, flags, and
+// interface name.
+func unpublishableIPv6() map[netip.Addr]bool {
+	f, err := os.Open("/proc/net/if_inet6")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	skip := map[netip.Addr]bool{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		raw, err := hex.DecodeString(fields[0])
+		if err != nil || len(raw) != 16 {
+			continue
+		}
+		flags, err := strconv.ParseUint(fields[4], 16, 32)
+		if err != nil {
+			continue
+		}
+		if flags&(ifaFlagTemporary|ifaFlagDADFailed|ifaFlagDeprecated|ifaFlagTentative) != 0 {
+			skip[netip.AddrFrom16([16]byte(raw))] = true
+		}
+	}
+	return skip
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package route53update
+
+import "net/netip"
+
+// Only Linux says which addresses are temporary, see addrflags_linux.go.
+func unpublishableIPv6() map[netip.Addr]bool {
+	return nil
+}
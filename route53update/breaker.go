@@ -0,0 +1,143 @@
+package route53update
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// A config that's wrong in just the right way, or a sync file that's gone
+// bad, can have every record in a zone changed in one run, and a run that
+// keeps failing usually means something is wrong that more tries won't
+// fix. The circuit breaker sits in front of ChangeRecordSets and stops any
+// more changes going out once either happens, until it's reset for the
+// next run.
+
+// ErrCircuitOpen is what ChangeRecordSets returns once the breaker has
+// tripped, wrapped with the reason.
+var ErrCircuitOpen = errors.New("Circuit breaker tripped, no more changes this run")
+
+// CircuitBreaker counts the changes made in a run and the failures in a
+// row. Either limit can be zero to not have one.
+type CircuitBreaker struct {
+	// Failed change calls in a row before stopping
+	MaxFailures int
+
+	// Record changes in a run, a call with more than this in it, or that
+	// would go over it, gets stopped before it's sent
+	MaxChanges int
+
+	// Called once when it trips, with why
+	OnTrip func(reason string)
+
+	mu       sync.Mutex
+	failures int
+	changes  int
+	tripped  string
+}
+
+// The breaker ChangeRecordSets goes through, nil for none.
+var breaker *CircuitBreaker
+
+// SetCircuitBreaker has every change go through the breaker. Like
+// SetIPSource it's for calling once at startup.
+func SetCircuitBreaker(b *CircuitBreaker) {
+	breaker = b
+}
+
+// Reset starts a new run, closing the breaker if it tripped.
+func (b *CircuitBreaker) Reset() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures, b.changes, b.tripped = 0, 0, ""
+}
+
+// Plan checks a number of changes about to be made before any of them
+// are, so a bulk operation can stop before it starts rather than partway
+// through.
+func (b *CircuitBreaker) Plan(changes int) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	var tripped string
+	if b.tripped == "" && b.MaxChanges > 0 && b.changes+changes > b.MaxChanges {
+		tripped = b.trip(fmt.Sprintf("%d changes planned, more than the limit of %d for a run", b.changes+changes, b.MaxChanges))
+	}
+	err := b.err()
+	b.mu.Unlock()
+	b.notify(tripped)
+	return err
+}
+
+// Count the changes in a call about to go out, or stop it.
+func (b *CircuitBreaker) before(changes int) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	var tripped string
+	if b.tripped == "" && b.MaxChanges > 0 && b.changes+changes > b.MaxChanges {
+		tripped = b.trip(fmt.Sprintf("%d changes would go over the limit of %d for a run", b.changes+changes, b.MaxChanges))
+	}
+	err := b.err()
+	if err == nil {
+		b.changes += changes
+	}
+	b.mu.Unlock()
+	b.notify(tripped)
+	return err
+}
+
+// Count how a call went.
+func (b *CircuitBreaker) after(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	var tripped string
+	switch {
+	case err == nil:
+		b.failures = 0
+	case errors.Is(err, ErrCircuitOpen):
+	default:
+		b.failures++
+		if b.tripped == "" && b.MaxFailures > 0 && b.failures >= b.MaxFailures {
+			tripped = b.trip(fmt.Sprintf("%d changes in a row failed, the last with: %v", b.failures, err))
+		}
+	}
+	b.mu.Unlock()
+	b.notify(tripped)
+}
+
+// Tripped says why the breaker tripped, "" if it hasn't.
+func (b *CircuitBreaker) Tripped() string {
+	if b == nil {
+		return ""
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tripped
+}
+
+// Trip it, with the lock held. OnTrip gets called once it's let go.
+func (b *CircuitBreaker) trip(reason string) string {
+	b.tripped = reason
+	return reason
+}
+
+func (b *CircuitBreaker) notify(tripped string) {
+	if tripped != "" && b.OnTrip != nil {
+		b.OnTrip(tripped)
+	}
+}
+
+func (b *CircuitBreaker) err() error {
+	if b.tripped == "" {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrCircuitOpen, b.tripped)
+}
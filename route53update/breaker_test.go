@@ -0,0 +1,118 @@
+package route53update
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	failed := errors.New("throttled")
+	// Each step is a call with some changes that either works or fails,
+	// or a Plan for some changes
+	type step struct {
+		plan    bool
+		changes int
+		err     error
+		stopped bool // the breaker stopped it before it went out
+	}
+	tests := []struct {
+		name        string
+		maxFailures int
+		maxChanges  int
+		steps       []step
+		tripped     bool
+	}{
+		{
+			name:  "no limits",
+			steps: []step{{changes: 1000}, {changes: 1, err: failed}, {changes: 1, err: failed}},
+		},
+		{
+			name:        "failures in a row",
+			maxFailures: 2,
+			steps:       []step{{changes: 1, err: failed}, {changes: 1, err: failed}, {changes: 1, stopped: true}},
+			tripped:     true,
+		},
+		{
+			name:        "a success in between starts the count over",
+			maxFailures: 2,
+			steps:       []step{{changes: 1, err: failed}, {changes: 1}, {changes: 1, err: failed}, {changes: 1}},
+		},
+		{
+			name:       "up to the change limit",
+			maxChanges: 3,
+			steps:      []step{{changes: 2}, {changes: 1}},
+		},
+		{
+			name:       "over the change limit",
+			maxChanges: 3,
+			steps:      []step{{changes: 2}, {changes: 2, stopped: true}, {changes: 1, stopped: true}},
+			tripped:    true,
+		},
+		{
+			name:       "one call over the limit",
+			maxChanges: 3,
+			steps:      []step{{changes: 4, stopped: true}},
+			tripped:    true,
+		},
+		{
+			name:       "plan within the limit",
+			maxChanges: 3,
+			steps:      []step{{plan: true, changes: 3}, {changes: 3}},
+		},
+		{
+			name:       "plan over the limit",
+			maxChanges: 3,
+			steps:      []step{{changes: 1}, {plan: true, changes: 3, stopped: true}, {changes: 1, stopped: true}},
+			tripped:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trips := 0
+			b := &CircuitBreaker{MaxFailures: tt.maxFailures, MaxChanges: tt.maxChanges, OnTrip: func(string) { trips++ }}
+			for i, s := range tt.steps {
+				var err error
+				if s.plan {
+					err = b.Plan(s.changes)
+				} else if err = b.before(s.changes); err == nil {
+					b.after(s.err)
+				} else {
+					b.after(err)
+				}
+				if stopped := errors.Is(err, ErrCircuitOpen); stopped != s.stopped {
+					t.Fatalf("Step %d stopped is %v, want %v (%v)", i, stopped, s.stopped, err)
+				}
+			}
+			if tripped := b.Tripped() != ""; tripped != tt.tripped {
+				t.Errorf("Tripped is %q, want tripped %v", b.Tripped(), tt.tripped)
+			}
+			if want := map[bool]int{true: 1}[tt.tripped]; trips != want {
+				t.Errorf("OnTrip called %d times, want %d", trips, want)
+			}
+
+			b.Reset()
+			if b.Tripped() != "" {
+				t.Errorf("Still tripped after Reset: %s", b.Tripped())
+			}
+			if err := b.before(1); err != nil {
+				t.Errorf("Change stopped after Reset: %v", err)
+			}
+		})
+	}
+}
+
+// Everything on a nil breaker lets changes through.
+func TestNilCircuitBreaker(t *testing.T) {
+	var b *CircuitBreaker
+	if err := b.Plan(100); err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	if err := b.before(100); err != nil {
+		t.Fatalf("before: %v", err)
+	}
+	b.after(errors.New("failed"))
+	b.Reset()
+	if b.Tripped() != "" {
+		t.Fatalf("A nil breaker tripped")
+	}
+}
@@ -0,0 +1,81 @@
+package route53update
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+// Route53 won't take a change to a zone while an earlier change to it is
+// still going out, it answers PriorRequestNotComplete instead. The SDK
+// retries that a couple of times and gives up within a few seconds, but a
+// change can take a minute to go in sync, so two cron runs that overlap, or
+// a batch right after another, would fail for no good reason. This waits
+// the earlier change out and tries again.
+
+// How long to keep trying a change that's waiting on an earlier one.
+var PriorRequestWait = 2 * time.Minute
+
+// The last change made to each zone from here, which is what to wait on
+// when the zone says it's busy. A change from somewhere else can't be
+// looked up, so then it's just backing off and trying again.
+var (
+	lastChangeMu sync.Mutex
+	lastChange   = map[string]string{}
+)
+
+// ChangeRecordSets makes the change, waiting out an earlier change to the
+// same zone if there's one still going. It goes through the circuit breaker
+// if there is one, see SetCircuitBreaker.
+func ChangeRecordSets(client *route53.Client, params *route53.ChangeResourceRecordSetsInput) (*route53.ChangeResourceRecordSetsOutput, error) {
+	changes := 0
+	if params.ChangeBatch != nil {
+		changes = len(params.ChangeBatch.Changes)
+	}
+	if err := breaker.before(changes); err != nil {
+		return nil, err
+	}
+	res, err := changeRecordSets(client, params)
+	breaker.after(err)
+	return res, err
+}
+
+func changeRecordSets(client *route53.Client, params *route53.ChangeResourceRecordSetsInput) (*route53.ChangeResourceRecordSetsOutput, error) {
+	zone := ""
+	if params.HostedZoneId != nil {
+		zone = strings.TrimPrefix(*params.HostedZoneId, "/hostedzone/")
+	}
+	deadline := time.Now().Add(PriorRequestWait)
+	delay := 2 * time.Second
+	for {
+		res, err := client.ChangeResourceRecordSets(context.TODO(), params)
+		var busy *types.PriorRequestNotComplete
+		if !errors.As(err, &busy) || !time.Now().Add(delay).Before(deadline) {
+			if err == nil && res.ChangeInfo != nil && res.ChangeInfo.Id != nil {
+				lastChangeMu.Lock()
+				lastChange[zone] = *res.ChangeInfo.Id
+				lastChangeMu.Unlock()
+			}
+			return res, err
+		}
+
+		lastChangeMu.Lock()
+		prior := lastChange[zone]
+		lastChangeMu.Unlock()
+		if prior != "" {
+			if _, err := WaitForChange(client, prior, time.Until(deadline)); err == nil {
+				lastChangeMu.Lock()
+				delete(lastChange, zone)
+				lastChangeMu.Unlock()
+				continue
+			}
+		}
+		time.Sleep(delay)
+		delay = min(delay*2, 15*time.Second)
+	}
+}
@@ -0,0 +1,91 @@
+package route53update
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"time"
+)
+
+// On a dual stack host a request goes out over whichever family gets there
+// first, so asking a web service for the IPv4 address could come back with the IPv6
+// one or the other way around. Discovery requests get their own clients
+// that only dial one family. They also skip any proxy, which would just
+// report its own address.
+func familyClient(network string) *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _ string, addr string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}
+}
+
+var (
+	ipv4Client = familyClient("tcp4")
+	ipv6Client = familyClient("tcp6")
+
+	// For IPv4 through NAT64, where the request has to go over IPv6 and
+	// the gateway's IPv4 address is what comes back
+	nat64Client = familyClient("tcp6")
+)
+
+// How many times to ask before giving up, every source has the odd hiccup.
+const discoveryTries = 3
+
+// PublicIPv4 asks the IP source what our public IPv4 address is. Failures
+// come back as a *DiscoveryError.
+func PublicIPv4() (string, error) {
+	return PublicIP(ipSource, false)
+}
+
+// PublicIPv6 is the same for the IPv6 address.
+func PublicIPv6() (string, error) {
+	return PublicIP(ipSource, true)
+}
+
+// PublicIP asks a particular source for the address of one family, trying a
+// few times and making sure what comes back really is an address of that
+// family.
+func PublicIP(source IPSource, v6 bool) (string, error) {
+	family := "IPv4"
+	if v6 {
+		family = "IPv6"
+	}
+	var err error
+	for try := 1; try <= discoveryTries; try++ {
+		var ip string
+		ip, err = source.PublicIP(v6)
+		if err == nil {
+			if ip, err = checkFamily(source, ip, v6); err == nil {
+				return ip, nil
+			}
+			// A wrong answer won't get any more right by asking again
+			break
+		}
+		if errors.Is(err, errNoIPv6) {
+			break
+		}
+		if try < discoveryTries {
+			time.Sleep(time.Duration(try) * time.Second)
+		}
+	}
+	return "", &DiscoveryError{Family: family, Err: err}
+}
+
+// Make sure a source's answer is an address of the family asked for, and
+// put it in its usual form.
+func checkFamily(source IPSource, ip string, v6 bool) (string, error) {
+	parsed, err := netip.ParseAddr(ip)
+	if err != nil || parsed.Unmap().Is6() != v6 {
+		return "", fmt.Errorf("%s returned something that isn't an address of that family: %q", source, ip)
+	}
+	return parsed.Unmap().String(), nil
+}
@@ -0,0 +1,33 @@
+// Package route53update keeps Route53 address records pointed at the public
+// addresses of the machine it runs on. It's the part of the route53Update
+// command that talks to Route53 and works out the addresses, for using from
+// other programs.
+//
+// The simplest way in is an Updater:
+//
+//	cfg, err := config.LoadDefaultConfig(context.TODO())
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	u := route53update.New(route53.NewFromConfig(cfg), route53update.Options{
+//		Records: []types.RRType{types.RRTypeA, types.RRTypeAaaa},
+//	})
+//	results, err := u.Update("home.example.com")
+//
+// Update is Zone, Check, Recheck, and Changes in a row, and those are there
+// on their own for doing more in between, the way the command runs its
+// hooks and policies and batches changes across domains. The pieces below
+// that are here too. FindHostedZone, GetRecIp, and UpdateRecIpTTL work on
+// records directly, and PublicIPv4 and PublicIPv6 find the addresses, each
+// forced over its own family so a dual stack host gets the right one for
+// each. On hosts with only IPv6, IPv4 discovery goes through NAT64 if the
+// network has it. Addresses come from ipify unless SetIPSource picks
+// another IPSource, like STUN, DNS, or RouterIP, or several of them with
+// FallbackSource or ConsensusSource.
+//
+// Errors for a missing record are ErrRecordNotFound, a record that moved
+// between Check and Recheck is ErrRecordChanged, a record type other than
+// A or AAAA is ErrUnsupportedRecord, an alias record that can't be updated
+// is ErrAliasRecord, a missing hosted zone is a *ZoneNotFoundError, and
+// failing to find an address is a *DiscoveryError.
+package route53update
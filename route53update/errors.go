@@ -0,0 +1,65 @@
+package route53update
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+// ErrRecordNotFound is returned by GetRecord and GetRecIp when the zone
+// doesn't have the record for the name yet.
+var ErrRecordNotFound = errors.New("Could not find record for top level name")
+
+// ErrAliasRecord is returned for a record that's an alias, or has no values
+// at all. There's no address in it to compare, and changing it would throw
+// away whatever it points at, so it's left alone.
+var ErrAliasRecord = errors.New("Record is an alias, not managing it")
+
+// ZoneNotFoundError is returned when there's no hosted zone with exactly the
+// name asked for.
+type ZoneNotFoundError struct {
+	Domain string
+}
+
+func (e *ZoneNotFoundError) Error() string {
+	return fmt.Sprintf("Can't match domain %s to zone", e.Domain)
+}
+
+// AmbiguousZoneError is returned when more than one hosted zone has the
+// name and there's no telling which one is meant, like two public zones
+// with the same name. Giving the zone id gets around it.
+type AmbiguousZoneError struct {
+	Domain     string
+	Candidates []types.HostedZone
+}
+
+func (e *AmbiguousZoneError) Error() string {
+	var ids []string
+	for _, z := range e.Candidates {
+		kind := "public"
+		if z.Config != nil && z.Config.PrivateZone {
+			kind = "private"
+		}
+		ids = append(ids, fmt.Sprintf("%s (%s)", strings.TrimPrefix(aws.ToString(z.Id), "/hostedzone/"), kind))
+	}
+	return fmt.Sprintf("More than one hosted zone is named %s, pick one by id: %s", e.Domain, strings.Join(ids, ", "))
+}
+
+// DiscoveryError is returned when the public address for a family couldn't
+// be found, either because the request failed or because what came back
+// wasn't an address of that family.
+type DiscoveryError struct {
+	Family string // IPv4 or IPv6
+	Err    error
+}
+
+func (e *DiscoveryError) Error() string {
+	return fmt.Sprintf("Failed to discover public %s address: %v", e.Family, e.Err)
+}
+
+func (e *DiscoveryError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,44 @@
+//go:build linux
+
+package route53update
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// The default route's gateway out of /proc/net/route:
+//
+//	Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask ...
+//	eth0	00000000	0101A8C0	0003	0	0	100	00000000 ...
+//
+// The addresses are hex in the host's byte order, which is little endian
+// everywhere this runs. "" if there's no default route.
+func defaultGateway() string {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 || fields[1] != "00000000" || fields[7] != "00000000" {
+			continue
+		}
+		raw, err := hex.DecodeString(fields[2])
+		if err != nil || len(raw) != 4 {
+			continue
+		}
+		var ip [4]byte
+		binary.BigEndian.PutUint32(ip[:], binary.LittleEndian.Uint32(raw))
+		if gw := netip.AddrFrom4(ip); !gw.IsUnspecified() {
+			return gw.String()
+		}
+	}
+	return ""
+}
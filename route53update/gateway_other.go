@@ -0,0 +1,10 @@
+//go:build !linux
+
+package route53update
+
+// Only Linux has the routing table somewhere easy to read, see
+// gateway_linux.go. Elsewhere NAT-PMP needs the gateway given, like
+// router:192.168.1.1, and UPnP finds the router on its own.
+func defaultGateway() string {
+	return ""
+}
@@ -0,0 +1,111 @@
+package route53update
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// HTTPSourceConfig describes a what's my IP service of your own, for not
+// having to depend on somebody else's. Plenty of them send back more than
+// the bare address, so the address can be a field in a JSON answer, or
+// whatever a regular expression picks out of the body.
+type HTTPSourceConfig struct {
+	// The name it goes by in the list of sources
+	Name string
+
+	// Where to ask over IPv4 and IPv6. Either one can be left out if the
+	// service only does one family.
+	URL4 string
+	URL6 string
+
+	// A path to the field with the address in a JSON answer, keys and
+	// array indexes separated by dots, like ip or data.addresses.0
+	JSONPath string
+
+	// A regular expression to find the address with, the first group if it
+	// has one and the whole match if it doesn't
+	Regex string
+
+	// Headers to send, like an API key, and basic auth if Username is set
+	Headers  map[string]string
+	Username string
+	Password string
+}
+
+// NewHTTPSource makes an IPSource from the config, checking it over first.
+func NewHTTPSource(c HTTPSourceConfig) (IPSource, error) {
+	if c.Name == "" {
+		return nil, errors.New("An HTTP IP source needs a name")
+	}
+	if c.URL4 == "" && c.URL6 == "" {
+		return nil, fmt.Errorf("IP source %s needs a url to ask", c.Name)
+	}
+	if c.JSONPath != "" && c.Regex != "" {
+		return nil, fmt.Errorf("IP source %s can have a JSON path or a regex, not both", c.Name)
+	}
+	s := httpSource{name: c.Name, url4: c.URL4, url6: c.URL6, username: c.Username, password: c.Password}
+	if len(c.Headers) > 0 {
+		s.header = http.Header{}
+		for key, value := range c.Headers {
+			s.header.Set(key, value)
+		}
+	}
+	switch {
+	case c.JSONPath != "":
+		path := strings.Split(strings.TrimPrefix(strings.TrimPrefix(c.JSONPath, "$"), "."), ".")
+		s.extract = func(body []byte) (string, error) {
+			return jsonField(body, path)
+		}
+	case c.Regex != "":
+		re, err := regexp.Compile(c.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("Bad regex for IP source %s: %v", c.Name, err)
+		}
+		s.extract = func(body []byte) (string, error) {
+			m := re.FindSubmatch(body)
+			switch {
+			case m == nil:
+				return "", fmt.Errorf("%q doesn't match the answer", c.Regex)
+			case len(m) > 1:
+				return strings.TrimSpace(string(m[1])), nil
+			}
+			return strings.TrimSpace(string(m[0])), nil
+		}
+	}
+	return s, nil
+}
+
+// Follow the path down through a JSON answer to a string.
+func jsonField(body []byte, path []string) (string, error) {
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return "", fmt.Errorf("Answer isn't JSON: %v", err)
+	}
+	for i, key := range path {
+		switch node := v.(type) {
+		case map[string]any:
+			v = node[key]
+		case []any:
+			n, err := strconv.Atoi(key)
+			if err != nil || n < 0 || n >= len(node) {
+				return "", fmt.Errorf("No %s in the answer", strings.Join(path[:i+1], "."))
+			}
+			v = node[n]
+		default:
+			v = nil
+		}
+		if v == nil {
+			return "", fmt.Errorf("No %s in the answer", strings.Join(path[:i+1], "."))
+		}
+	}
+	str, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%s in the answer isn't a string", strings.Join(path, "."))
+	}
+	return strings.TrimSpace(str), nil
+}
@@ -0,0 +1,319 @@
+package route53update
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+)
+
+// IPSource is somewhere to find out this machine's public address. ipify is
+// the default, and the others are there so an ipify outage doesn't have to
+// mean an outage here too.
+type IPSource interface {
+	// PublicIP returns the address for one family, IPv6 if v6 is set.
+	// PublicIPv4, PublicIPv6, and PublicIP take care of retries and of
+	// checking the answer is an address of the right family.
+	PublicIP(v6 bool) (string, error)
+
+	// The name it goes by on the command line
+	String() string
+}
+
+var (
+	// Ipify asks api.ipify.org and api6.ipify.org.
+	Ipify IPSource = httpSource{name: "ipify", url4: "https://api.ipify.org", url6: "https://api6.ipify.org"}
+
+	// AWSCheckIP asks checkip.amazonaws.com, which only does IPv4.
+	AWSCheckIP IPSource = httpSource{name: "aws-checkip", url4: "https://checkip.amazonaws.com"}
+
+	// InterfaceIP uses the address on one of this machine's own network
+	// interfaces, for hosts that have a public address right on them and
+	// don't need to ask anybody. InterfaceSource picks the interface.
+	InterfaceIP IPSource = interfaceSource{}
+
+	// STUN sends a STUN binding request to Google's public STUN server,
+	// which answers with the address the request came from. STUNSource
+	// picks other servers.
+	STUN IPSource = STUNSource("stun.l.google.com:19302")
+
+	// DNS looks up myip.opendns.com on the OpenDNS resolvers, which answer
+	// with the address the query came from. It's one small UDP packet each
+	// way, and port 53 gets out of plenty of networks that block HTTP.
+	DNS IPSource = dnsSource{name: "myip.opendns.com", server4: "208.67.222.222", server6: "2620:119:35::35"}
+
+	// CloudflareDNS asks Cloudflare's resolvers for whoami.cloudflare, the
+	// same idea as DNS run by somebody else.
+	CloudflareDNS IPSource = whoamiSource{name: "whoami.cloudflare.", server4: "1.1.1.1", server6: "2606:4700:4700::1111"}
+
+	// RouterIP asks the home router for its WAN address with NAT-PMP, PCP,
+	// or UPnP, without anything outside the LAN. RouterSource picks the
+	// router.
+	RouterIP IPSource = routerSource{}
+)
+
+// For sources that only know about IPv4, there's no point asking again.
+var errNoIPv6 = errors.New("doesn't do IPv6")
+
+// The sources by the names the command line uses.
+var ipSources = []IPSource{Ipify, AWSCheckIP, InterfaceIP, STUN, DNS, CloudflareDNS, RouterIP}
+
+// The source PublicIPv4 and PublicIPv6 use.
+var ipSource = Ipify
+
+// SetIPSource changes the source PublicIPv4 and PublicIPv6 ask. It's meant
+// to be called once at startup, before any lookups.
+func SetIPSource(source IPSource) {
+	ipSource = source
+}
+
+// IPSourceNamed finds a source by the name it goes by on the command line.
+// interface:eth0 is the interface source for eth0, stun:host:port the STUN
+// source asking that server, and router:192.168.1.1 the router source
+// asking that gateway.
+func IPSourceNamed(name string) (IPSource, error) {
+	if iface, ok := strings.CutPrefix(name, "interface:"); ok && iface != "" {
+		return InterfaceSource(iface), nil
+	}
+	if server, ok := strings.CutPrefix(name, "stun:"); ok && server != "" {
+		return STUNSource(server), nil
+	}
+	if gateway, ok := strings.CutPrefix(name, "router:"); ok && gateway != "" {
+		return RouterSource(gateway), nil
+	}
+	for _, s := range ipSources {
+		if s.String() == name {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown IP source %q, use one of %s", name, strings.Join(IPSourceNames(), ", "))
+}
+
+// IPSourceNames is the names of every source.
+func IPSourceNames() []string {
+	var names []string
+	for _, s := range ipSources {
+		names = append(names, s.String())
+	}
+	return names
+}
+
+// A web service that sends back the address the request came from, as
+// plain text unless there's a way to pull it out of something else.
+type httpSource struct {
+	name string
+	url4 string
+	url6 string // empty if it doesn't do IPv6
+
+	// For services of your own, see NewHTTPSource
+	header   http.Header
+	username string
+	password string
+	extract  func(body []byte) (string, error)
+}
+
+func (s httpSource) String() string {
+	return s.name
+}
+
+func (s httpSource) PublicIP(v6 bool) (string, error) {
+	url, client := s.url4, ipv4Client
+	if v6 {
+		url, client = s.url6, ipv6Client
+	} else if n := detectedNetwork(); !n.ipv4 && n.nat64.IsValid() {
+		client = nat64Client
+	}
+	if url == "" {
+		return "", fmt.Errorf("%s %w", s.name, errNoIPv6)
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	for key, values := range s.header {
+		req.Header[key] = values
+	}
+	if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned %s", s.name, res.Status)
+	}
+	limit := int64(256)
+	if s.extract != nil {
+		limit = 64 * 1024
+	}
+	body, err := io.ReadAll(io.LimitReader(res.Body, limit))
+	if err != nil {
+		return "", err
+	}
+	if s.extract != nil {
+		return s.extract(body)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// The addresses that can't be anybody's public address, beyond the private
+// ranges netip knows about. Carrier grade NAT addresses end up on the WAN
+// side of plenty of home routers.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+type interfaceSource struct {
+	iface string // any interface if empty
+}
+
+// InterfaceSource reads the address right off the named interface.
+func InterfaceSource(iface string) IPSource {
+	return interfaceSource{iface: iface}
+}
+
+func (s interfaceSource) String() string {
+	if s.iface != "" {
+		return "interface:" + s.iface
+	}
+	return "interface"
+}
+
+// The first public address of the family on the interface, or on any
+// interface. For IPv6 that's a global one, and the stable address is the
+// one to publish rather than a temporary privacy address that'll be gone
+// in a day. Linux says which ones are temporary, elsewhere this goes by
+// the order the system lists them in.
+func (s interfaceSource) PublicIP(v6 bool) (string, error) {
+	var addrs []net.Addr
+	var err error
+	if s.iface != "" {
+		var i *net.Interface
+		i, err = net.InterfaceByName(s.iface)
+		if err != nil {
+			return "", err
+		}
+		addrs, err = i.Addrs()
+	} else {
+		addrs, err = net.InterfaceAddrs()
+	}
+	if err != nil {
+		return "", err
+	}
+	var skip map[netip.Addr]bool
+	if v6 {
+		skip = unpublishableIPv6()
+	}
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		addr, ok := netip.AddrFromSlice(ipnet.IP)
+		if !ok {
+			continue
+		}
+		addr = addr.Unmap()
+		if addr.Is6() != v6 || !addr.IsGlobalUnicast() || addr.IsPrivate() || sharedAddressSpace.Contains(addr) || skip[addr] {
+			continue
+		}
+		return addr.String(), nil
+	}
+	family := "IPv4"
+	if v6 {
+		family = "IPv6"
+	}
+	if s.iface != "" {
+		return "", fmt.Errorf("%s has no public %s address", s.iface, family)
+	}
+	return "", fmt.Errorf("No interface has a public %s address", family)
+}
+
+// LANIP is this machine's address on the local network, the first private
+// address of the family on the named interface, or on any interface if the
+// name is empty. It's the address to publish in a private zone for the
+// machines on the same LAN, the way the public address goes in the public
+// zone.
+func LANIP(iface string, v6 bool) (string, error) {
+	var addrs []net.Addr
+	var err error
+	if iface != "" {
+		var i *net.Interface
+		i, err = net.InterfaceByName(iface)
+		if err != nil {
+			return "", err
+		}
+		addrs, err = i.Addrs()
+	} else {
+		addrs, err = net.InterfaceAddrs()
+	}
+	if err != nil {
+		return "", err
+	}
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		addr, ok := netip.AddrFromSlice(ipnet.IP)
+		if !ok {
+			continue
+		}
+		addr = addr.Unmap()
+		if addr.Is6() != v6 || !addr.IsPrivate() {
+			continue
+		}
+		return addr.String(), nil
+	}
+	family := "IPv4"
+	if v6 {
+		family = "IPv6"
+	}
+	if iface != "" {
+		return "", fmt.Errorf("%s has no private %s address", iface, family)
+	}
+	return "", fmt.Errorf("No interface has a private %s address", family)
+}
+
+// A resolver that answers a special name with the address of whoever asked.
+// Each family has to ask over that family to get its own address back.
+type dnsSource struct {
+	name    string
+	server4 string
+	server6 string
+}
+
+func (s dnsSource) String() string {
+	return "dns"
+}
+
+func (s dnsSource) PublicIP(v6 bool) (string, error) {
+	network, server := "ip4", ReachableHost(s.server4)
+	if v6 {
+		network, server = "ip6", s.server6
+	}
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, proto string, _ string) (net.Conn, error) {
+			d := net.Dialer{Timeout: 5 * time.Second}
+			return d.DialContext(ctx, proto, net.JoinHostPort(server, "53"))
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	addrs, err := resolver.LookupNetIP(ctx, network, s.name)
+	if err != nil {
+		return "", err
+	}
+	addrs = slices.DeleteFunc(addrs, func(a netip.Addr) bool { return a.Unmap().Is6() != v6 })
+	if len(addrs) == 0 {
+		return "", fmt.Errorf("%s didn't come back with an address", s.name)
+	}
+	return addrs[0].Unmap().String(), nil
+}
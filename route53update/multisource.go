@@ -0,0 +1,145 @@
+package route53update
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// One source being down shouldn't stop updates, and one source giving a bad
+// answer shouldn't get it published. So several sources can be used at
+// once, either one after the other until one answers, or all together with
+// enough of them having to agree.
+
+// FallbackSource asks each source in turn, and the first answer wins.
+type FallbackSource []IPSource
+
+func (f FallbackSource) String() string {
+	return joinSources(f)
+}
+
+func (f FallbackSource) PublicIP(v6 bool) (string, error) {
+	var errs []error
+	for _, s := range f {
+		ip, err := s.PublicIP(v6)
+		if err == nil {
+			if ip, err = checkFamily(s, ip, v6); err == nil {
+				return ip, nil
+			}
+		}
+		errs = append(errs, fmt.Errorf("%s: %v", s, err))
+	}
+	return "", errors.Join(errs...)
+}
+
+// ConsensusSource asks every source at once, and at least Quorum of them
+// have to come back with the same address. Sources that fail don't count
+// either way, but they don't help reach the quorum.
+type ConsensusSource struct {
+	Sources []IPSource
+	Quorum  int
+}
+
+func (c ConsensusSource) String() string {
+	return fmt.Sprintf("%d of %s", c.Quorum, joinSources(c.Sources))
+}
+
+func (c ConsensusSource) PublicIP(v6 bool) (string, error) {
+	answers := make([]string, len(c.Sources))
+	errs := make([]error, len(c.Sources))
+	var wg sync.WaitGroup
+	for i, s := range c.Sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ip, err := s.PublicIP(v6)
+			if err == nil {
+				ip, err = checkFamily(s, ip, v6)
+			}
+			answers[i], errs[i] = ip, err
+		}()
+	}
+	wg.Wait()
+
+	votes := map[string]int{}
+	var said []string
+	for i, s := range c.Sources {
+		if errs[i] != nil {
+			said = append(said, fmt.Sprintf("%s failed (%v)", s, errs[i]))
+			continue
+		}
+		votes[answers[i]]++
+		if votes[answers[i]] >= c.Quorum {
+			return answers[i], nil
+		}
+		said = append(said, fmt.Sprintf("%s said %s", s, answers[i]))
+	}
+	return "", fmt.Errorf("Fewer than %d sources agree: %s", c.Quorum, strings.Join(said, ", "))
+}
+
+// The custom source with the name, or the built in one if there isn't one.
+func customSource(name string, custom []IPSource) (IPSource, error) {
+	for _, s := range custom {
+		if s.String() == name {
+			return s, nil
+		}
+	}
+	return IPSourceNamed(name)
+}
+
+func joinSources(sources []IPSource) string {
+	var names []string
+	for _, s := range sources {
+		names = append(names, s.String())
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseIPSource reads a list of source names separated by commas. With a
+// quorum over one they all get asked and that many have to agree, otherwise
+// they're tried in order.
+func ParseIPSource(spec string, quorum int) (IPSource, error) {
+	return ParseIPSourceWith(spec, quorum, IPSourceOptions{})
+}
+
+// IPSourceOptions fill in the details for the sources that have some.
+type IPSourceOptions struct {
+	// The interface the interface source reads, any of them if it's empty
+	Interface string
+
+	// The servers the STUN source asks, in order, instead of Google's
+	STUNServers []string
+
+	// More sources to pick from by name, like ones from NewHTTPSource
+	Custom []IPSource
+}
+
+// ParseIPSourceWith is ParseIPSource with the options applied to the
+// sources they're for.
+func ParseIPSourceWith(spec string, quorum int, opts IPSourceOptions) (IPSource, error) {
+	var sources []IPSource
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		s, err := customSource(name, opts.Custom)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case name == "interface" && opts.Interface != "":
+			s = InterfaceSource(opts.Interface)
+		case name == "stun" && len(opts.STUNServers) > 0:
+			s = STUNSource(opts.STUNServers...)
+		}
+		sources = append(sources, s)
+	}
+	switch {
+	case quorum < 0 || quorum > len(sources):
+		return nil, fmt.Errorf("Can't have %d of %d IP sources agree", quorum, len(sources))
+	case quorum > 1:
+		return ConsensusSource{Sources: sources, Quorum: quorum}, nil
+	case len(sources) == 1:
+		return sources[0], nil
+	}
+	return FallbackSource(sources), nil
+}
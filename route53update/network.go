@@ -0,0 +1,130 @@
+package route53update
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// Plenty of homelab hosts only have IPv6 now, some with NAT64 on the router
+// to get to the IPv4 internet and some without. Most of what we talk to
+// goes by name and works either way, the Go dialer tries IPv6 addresses as
+// readily as IPv4 ones. What doesn't are IPv4 literals, like the public
+// resolvers and the addresses we're checking, and AWS endpoints that don't
+// have an IPv6 address. So the first time it matters we work out whether
+// there's an IPv4 route, and if not, whether there's NAT64 and what its
+// prefix is (RFC 7050, by looking up ipv4only.arpa). IPv4 literals then get
+// dialed through the NAT64 prefix, and with no way to IPv4 at all the AWS
+// clients switch to their dual stack endpoints.
+
+// hostNetwork is what the host can reach.
+type hostNetwork struct {
+	ipv4  bool
+	nat64 netip.Prefix // not valid without NAT64
+}
+
+var detectedNetwork = sync.OnceValue(detectNetwork)
+
+func detectNetwork() hostNetwork {
+	var n hostNetwork
+	// Dialing UDP doesn't send anything, it just fails if there's no route
+	if conn, err := net.Dial("udp4", "8.8.8.8:53"); err == nil {
+		conn.Close()
+		n.ipv4 = true
+		return n
+	}
+	n.nat64, _ = DiscoverNAT64()
+	return n
+}
+
+// Where the well known addresses for ipv4only.arpa end up inside a NAT64
+// prefix, for each prefix length RFC 6052 allows.
+var (
+	ipv4onlyAddrs = []netip.Addr{netip.MustParseAddr("192.0.0.170"), netip.MustParseAddr("192.0.0.171")}
+	nat64Lengths  = []int{96, 64, 56, 48, 40, 32}
+)
+
+// DiscoverNAT64 finds the NAT64 prefix from the DNS64 resolver's answer for
+// ipv4only.arpa, a name that only has A records, so any AAAA answer is one
+// the resolver made up by putting the IPv4 address in its prefix.
+func DiscoverNAT64() (netip.Prefix, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip6", "ipv4only.arpa")
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	for _, addr := range addrs {
+		if addr.Is4In6() {
+			continue
+		}
+		for _, bits := range nat64Lengths {
+			embedded := extractIPv4(addr, bits)
+			for _, known := range ipv4onlyAddrs {
+				if embedded == known {
+					return netip.PrefixFrom(addr, bits).Masked(), true
+				}
+			}
+		}
+	}
+	return netip.Prefix{}, false
+}
+
+// The bytes of an IPv6 address an IPv4 address goes in, for a prefix
+// length. Bits 64 to 71 are always zero, so anything that would land there
+// moves along one byte.
+func nat64Positions(bits int) []int {
+	var pos []int
+	for i := bits / 8; len(pos) < 4; i++ {
+		if i != 8 {
+			pos = append(pos, i)
+		}
+	}
+	return pos
+}
+
+func extractIPv4(addr netip.Addr, bits int) netip.Addr {
+	b := addr.As16()
+	var v4 [4]byte
+	for i, p := range nat64Positions(bits) {
+		v4[i] = b[p]
+	}
+	return netip.AddrFrom4(v4)
+}
+
+func embedIPv4(prefix netip.Prefix, v4 netip.Addr) netip.Addr {
+	b := prefix.Addr().As16()
+	src := v4.As4()
+	for i, p := range nat64Positions(prefix.Bits()) {
+		b[p] = src[i]
+	}
+	return netip.AddrFrom16(b)
+}
+
+// ReachableHost turns an IPv4 literal into its NAT64 address when that's
+// the only way to reach it. Anything else comes back as it is.
+func ReachableHost(host string) string {
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !addr.Is4() {
+		return host
+	}
+	n := detectedNetwork()
+	if n.ipv4 || !n.nat64.IsValid() {
+		return host
+	}
+	return embedIPv4(n.nat64, addr).String()
+}
+
+// CanReachIPv4 says if IPv4 addresses can be reached, directly or through
+// NAT64.
+func CanReachIPv4() bool {
+	n := detectedNetwork()
+	return n.ipv4 || n.nat64.IsValid()
+}
+
+// IPv6Only says if there's no way to reach IPv4 at all, not even NAT64.
+func IPv6Only() bool {
+	return !CanReachIPv4()
+}
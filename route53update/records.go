@@ -0,0 +1,292 @@
+package route53update
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+// The TTL records get unless something says otherwise.
+const DefaultTTL = 300
+
+// NormalizeHostname lower cases the name and drops any trailing period, so
+// names from a config and names from a router compare the same way.
+func NormalizeHostname(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
+
+// Looks up the HostedZone info for a group of records on route53. I've been
+// using this to update the apex record for the domain I use, so it checks to
+// see if the name of the hosted zone exactly matches the domain. Returns a
+// *ZoneNotFoundError if there isn't one.
+//
+// The same name can be more than one zone, a public one and a private one
+// for a VPC say, and the listing comes back a page at a time, so this keeps
+// going as long as the names still match and then picks one with PickZone.
+func GetHostedZone(client *route53.Client, domain string) (*types.HostedZone, error) {
+	domain = NormalizeHostname(domain) + "."
+	matches, err := zonesNamed(client, domain)
+	if err != nil {
+		return nil, err
+	}
+	return PickZone(domain, matches)
+}
+
+// GetPrivateHostedZone is GetHostedZone for when it's the private zone
+// that's wanted. With a VPC id it has to be the private zone associated
+// with that VPC, which takes a call per zone to find out, since listing
+// zones doesn't say which VPCs they're for.
+func GetPrivateHostedZone(client *route53.Client, domain string, vpcId string) (*types.HostedZone, error) {
+	domain = NormalizeHostname(domain) + "."
+	matches, err := zonesNamed(client, domain)
+	if err != nil {
+		return nil, err
+	}
+	var private []types.HostedZone
+	for _, z := range matches {
+		if z.Config == nil || !z.Config.PrivateZone {
+			continue
+		}
+		if vpcId != "" {
+			res, err := client.GetHostedZone(context.TODO(), &route53.GetHostedZoneInput{Id: z.Id})
+			if err != nil {
+				return nil, fmt.Errorf("Failed to get the VPCs for zone %s: %v", aws.ToString(z.Id), err)
+			}
+			if !slices.ContainsFunc(res.VPCs, func(v types.VPC) bool { return aws.ToString(v.VPCId) == vpcId }) {
+				continue
+			}
+		}
+		private = append(private, z)
+	}
+	switch len(private) {
+	case 0:
+		return nil, &ZoneNotFoundError{Domain: domain}
+	case 1:
+		return &private[0], nil
+	}
+	return nil, &AmbiguousZoneError{Domain: domain, Candidates: private}
+}
+
+// All the zones with exactly the name, public and private.
+func zonesNamed(client *route53.Client, domain string) ([]types.HostedZone, error) {
+	req := &route53.ListHostedZonesByNameInput{
+		DNSName: aws.String(domain),
+	}
+
+	var matches []types.HostedZone
+	for {
+		res, err := client.ListHostedZonesByName(context.TODO(), req)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get hosted zones: %w", err)
+		}
+		past := false
+		for _, zone := range res.HostedZones {
+			// Zones come back sorted by name starting at ours, so the
+			// first one with another name means there aren't any more
+			if NormalizeHostname(*zone.Name)+"." != domain {
+				past = true
+				break
+			}
+			matches = append(matches, zone)
+		}
+		if past || !res.IsTruncated {
+			break
+		}
+		req.DNSName = res.NextDNSName
+		req.HostedZoneId = res.NextHostedZoneId
+	}
+	return matches, nil
+}
+
+// PickZone chooses between the hosted zones that have a name. The records
+// we keep are for getting to this machine from the internet, so a public
+// zone wins over private ones, and one private zone will do if that's all
+// there is. Any other mix is an *AmbiguousZoneError, since picking one
+// would just be a guess.
+func PickZone(domain string, zones []types.HostedZone) (*types.HostedZone, error) {
+	if len(zones) == 0 {
+		return nil, &ZoneNotFoundError{Domain: domain}
+	}
+	if len(zones) == 1 {
+		return &zones[0], nil
+	}
+	var public []types.HostedZone
+	for _, z := range zones {
+		if z.Config == nil || !z.Config.PrivateZone {
+			public = append(public, z)
+		}
+	}
+	if len(public) == 1 {
+		return &public[0], nil
+	}
+	candidates := append([]types.HostedZone{}, zones...)
+	sort.Slice(candidates, func(i, j int) bool { return aws.ToString(candidates[i].Id) < aws.ToString(candidates[j].Id) })
+	return nil, &AmbiguousZoneError{Domain: domain, Candidates: candidates}
+}
+
+// FindHostedZone finds the hosted zone a name lives in, the one with the
+// longest name the domain ends with, so home.example.com goes in a
+// home.example.com zone if there is one and example.com if not. Returns a
+// *ZoneNotFoundError if none of them are zones.
+func FindHostedZone(client *route53.Client, domain string) (*types.HostedZone, error) {
+	return findZone(domain, func(name string) (*types.HostedZone, error) {
+		return GetHostedZone(client, name)
+	})
+}
+
+// FindPrivateHostedZone is FindHostedZone for private zones, see
+// GetPrivateHostedZone.
+func FindPrivateHostedZone(client *route53.Client, domain string, vpcId string) (*types.HostedZone, error) {
+	return findZone(domain, func(name string) (*types.HostedZone, error) {
+		return GetPrivateHostedZone(client, name, vpcId)
+	})
+}
+
+func findZone(domain string, get func(name string) (*types.HostedZone, error)) (*types.HostedZone, error) {
+	for _, name := range ParentDomains(domain) {
+		zone, err := get(name)
+		var notFound *ZoneNotFoundError
+		if errors.As(err, &notFound) {
+			continue
+		}
+		return zone, err
+	}
+	return nil, &ZoneNotFoundError{Domain: domain}
+}
+
+// ParentDomains is the domain and each domain above it, longest first, in
+// the full domain format. The top level domain is left off, nobody has a
+// hosted zone for com.
+func ParentDomains(domain string) []string {
+	name := NormalizeHostname(domain)
+	var names []string
+	for strings.Contains(name, ".") {
+		names = append(names, name+".")
+		name = name[strings.Index(name, ".")+1:]
+	}
+	return names
+}
+
+// Return the ip address of the A rec for the overall domain. I use this with
+// a very simple setup, so I just return the first value for the resource
+// record set that matches the exact domain and has type A rec.
+func GetARecIp(client *route53.Client, zone string, domain string) (string, error) {
+	return GetRecIp(client, zone, domain, types.RRTypeA)
+}
+
+// Same as GetARecIp but for any record type, so the AAAA rec can be checked
+// the same way.
+func GetRecIp(client *route53.Client, zone string, domain string, rtype types.RRType) (string, error) {
+	rec, err := GetRecord(client, zone, domain, rtype)
+	if err != nil {
+		return "", err
+	}
+	return RecordIp(rec)
+}
+
+// RecordIp is the address in a record set, the first value if there's more
+// than one. Aliases don't have one, so they're an ErrAliasRecord.
+func RecordIp(rec *types.ResourceRecordSet) (string, error) {
+	if rec.AliasTarget != nil || len(rec.ResourceRecords) == 0 {
+		return "", fmt.Errorf("%w: %s %s", ErrAliasRecord, aws.ToString(rec.Name), rec.Type)
+	}
+	return aws.ToString(rec.ResourceRecords[0].Value), nil
+}
+
+// GetRecord returns the whole record set, for when more than the address
+// matters. Records come back sorted by name and type, so the listing starts
+// at the one we want and asks for just one record, which is a small quick
+// call even in a zone with thousands of records. It only goes on to the
+// next one when there are weighted or failover sets for the same name in
+// front of the plain one, and stops at the first record past them. Names
+// come back from Route53 in lower case, whatever case they were created in.
+func GetRecord(client *route53.Client, zone string, domain string, rtype types.RRType) (*types.ResourceRecordSet, error) {
+	paginator := route53.NewListResourceRecordSetsPaginator(client, &route53.ListResourceRecordSetsInput{
+		HostedZoneId:    aws.String(zone),
+		StartRecordName: aws.String(domain),
+		StartRecordType: rtype,
+		MaxItems:        aws.Int32(1),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		for _, rec := range page.ResourceRecordSets {
+			if !strings.EqualFold(*rec.Name, domain) || rec.Type != rtype {
+				return nil, ErrRecordNotFound
+			}
+			if rec.SetIdentifier == nil {
+				return &rec, nil
+			}
+		}
+	}
+	return nil, ErrRecordNotFound
+}
+
+// Changes the top level A rec for the domain passed in to point to the ip
+// addr provided. Also, very simple and static, assume just a single record
+// for the current address and that's it.
+func UpdateIp(client *route53.Client, zone string, domain string, ip string) (*route53.ChangeResourceRecordSetsOutput, error) {
+	return UpdateRecIp(client, zone, domain, types.RRTypeA, ip)
+}
+
+// Same as UpdateIp but for any record type.
+func UpdateRecIp(client *route53.Client, zone string, domain string, rtype types.RRType, ip string) (*route53.ChangeResourceRecordSetsOutput, error) {
+	return UpdateRecIpTTL(client, zone, domain, rtype, ip, DefaultTTL)
+}
+
+// Same as UpdateRecIp with a TTL other than the usual one. Any extra changes
+// go in the same batch, so they happen along with the update or not at all.
+func UpdateRecIpTTL(client *route53.Client, zone string, domain string, rtype types.RRType, ip string, ttl int64, extra ...types.Change) (*route53.ChangeResourceRecordSetsOutput, error) {
+	params := &route53.ChangeResourceRecordSetsInput{
+		ChangeBatch: &types.ChangeBatch{
+			Changes: append([]types.Change{AddressChange(domain, rtype, ip, ttl)}, extra...),
+		},
+		HostedZoneId: aws.String(zone),
+	}
+
+	res, err := ChangeRecordSets(client, params)
+	return res, err
+}
+
+// AddressChange is the upsert UpdateRecIpTTL makes, for putting in a batch
+// along with other changes to the same zone.
+func AddressChange(domain string, rtype types.RRType, ip string, ttl int64) types.Change {
+	return types.Change{
+		Action: types.ChangeActionUpsert,
+		ResourceRecordSet: &types.ResourceRecordSet{
+			Name: aws.String(domain),
+			Type: rtype,
+			ResourceRecords: []types.ResourceRecord{
+				{
+					Value: aws.String(ip),
+				},
+			},
+			TTL: aws.Int64(ttl),
+		},
+	}
+}
+
+// WaitForChange polls Route53 until the change is INSYNC, meaning every one
+// of its nameservers is answering with it, and returns how long that took.
+// The polls start a couple of seconds apart and back off from there, since
+// most changes go out within a minute but some take several. Gives up with
+// an error once the timeout runs out.
+func WaitForChange(client *route53.Client, changeId string, timeout time.Duration) (time.Duration, error) {
+	start := time.Now()
+	waiter := route53.NewResourceRecordSetsChangedWaiter(client, func(o *route53.ResourceRecordSetsChangedWaiterOptions) {
+		o.MinDelay = 2 * time.Second
+		o.MaxDelay = 30 * time.Second
+	})
+	err := waiter.Wait(context.TODO(), &route53.GetChangeInput{Id: aws.String(changeId)}, timeout)
+	return time.Since(start), err
+}
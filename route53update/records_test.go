@@ -0,0 +1,39 @@
+package route53update
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+func TestRecordIp(t *testing.T) {
+	tests := []struct {
+		name string
+		rec  types.ResourceRecordSet
+		want string
+		err  error
+	}{
+		{
+			"address",
+			types.ResourceRecordSet{ResourceRecords: []types.ResourceRecord{{Value: aws.String("203.0.113.1")}, {Value: aws.String("203.0.113.2")}}},
+			"203.0.113.1", nil,
+		},
+		{
+			"alias",
+			types.ResourceRecordSet{AliasTarget: &types.AliasTarget{DNSName: aws.String("lb.example.com.")}},
+			"", ErrAliasRecord,
+		},
+		{"no values", types.ResourceRecordSet{}, "", ErrAliasRecord},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rec.Name, tt.rec.Type = aws.String("home.example.com."), types.RRTypeA
+			got, err := RecordIp(&tt.rec)
+			if got != tt.want || !errors.Is(err, tt.err) {
+				t.Errorf("Got %q %v, want %q %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,332 @@
+package route53update
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Most home routers will say what their WAN address is if they're asked.
+// NAT-PMP (RFC 6886) and PCP (RFC 6887) are a UDP packet to the gateway,
+// and UPnP IGD is a multicast search for the router followed by a SOAP
+// call. Nothing outside the house is involved, and it's the router's own
+// idea of its address, which HTTP probes can get wrong when some carrier
+// box in the middle sends different traffic out different ways. It's only
+// IPv4, routers don't NAT IPv6.
+
+const (
+	natpmpPort = "5351"
+
+	natpmpVersion         = 0
+	natpmpExternalAddress = 0
+	pcpVersion            = 2
+	pcpOpMap              = 1
+	pcpLifetime           = 30 // seconds, just long enough to read the answer
+
+	ssdpAddr = "239.255.255.250:1900"
+)
+
+// The UPnP services that know the router's external address.
+var upnpWANServices = []string{"urn:schemas-upnp-org:service:WANIPConnection:", "urn:schemas-upnp-org:service:WANPPPConnection:"}
+
+type routerSource struct {
+	gateway string // the default gateway if empty
+}
+
+// RouterSource asks the router at the gateway address instead of the
+// default gateway. UPnP finds routers on its own, so the address is only
+// for NAT-PMP and PCP.
+func RouterSource(gateway string) IPSource {
+	return routerSource{gateway: gateway}
+}
+
+func (s routerSource) String() string {
+	if s.gateway != "" {
+		return "router:" + s.gateway
+	}
+	return "router"
+}
+
+// NAT-PMP first since it's one packet, then UPnP.
+func (s routerSource) PublicIP(v6 bool) (string, error) {
+	if v6 {
+		return "", fmt.Errorf("router %w", errNoIPv6)
+	}
+	var errs []error
+	gateway := s.gateway
+	if gateway == "" {
+		gateway = defaultGateway()
+	}
+	if gateway != "" {
+		addr, err := natpmpExternal(gateway)
+		if err == nil {
+			return routerAddress(addr)
+		}
+		errs = append(errs, fmt.Errorf("NAT-PMP: %v", err))
+	} else {
+		errs = append(errs, errors.New("NAT-PMP: no default gateway to ask"))
+	}
+	addr, err := upnpExternal()
+	if err == nil {
+		return routerAddress(addr)
+	}
+	errs = append(errs, fmt.Errorf("UPnP: %v", err))
+	return "", errors.Join(errs...)
+}
+
+// A router that's itself behind carrier grade NAT knows an address, just
+// not the public one.
+func routerAddress(addr netip.Addr) (string, error) {
+	addr = addr.Unmap()
+	if !addr.Is4() || !addr.IsGlobalUnicast() || addr.IsPrivate() || sharedAddressSpace.Contains(addr) {
+		return "", fmt.Errorf("The router's WAN address %s isn't public, it's behind another NAT", addr)
+	}
+	return addr.String(), nil
+}
+
+// Ask for the external address with NAT-PMP. A router that only does PCP
+// answers with its own version number, and gets asked again in PCP.
+func natpmpExternal(gateway string) (netip.Addr, error) {
+	conn, err := net.DialTimeout("udp4", net.JoinHostPort(gateway, natpmpPort), 5*time.Second)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+
+	if _, err := conn.Write([]byte{natpmpVersion, natpmpExternalAddress}); err != nil {
+		return netip.Addr{}, err
+	}
+	buf := make([]byte, 1100)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	msg := buf[:n]
+	if n >= 1 && msg[0] == pcpVersion {
+		return pcpExternal(conn)
+	}
+	// version, opcode, result code, seconds since start, address
+	if n < 12 || msg[0] != natpmpVersion || msg[1] != 128+natpmpExternalAddress {
+		return netip.Addr{}, errors.New("Bad response")
+	}
+	if code := binary.BigEndian.Uint16(msg[2:]); code != 0 {
+		return netip.Addr{}, fmt.Errorf("Router said no, result code %d", code)
+	}
+	return netip.AddrFrom4([4]byte(msg[8:12])), nil
+}
+
+// PCP has no request that just asks for the address, but a MAP request
+// gets one back with the mapping. So this maps our own UDP port for a few
+// seconds, reads the address, and deletes the mapping again.
+func pcpExternal(conn net.Conn) (netip.Addr, error) {
+	local := conn.LocalAddr().(*net.UDPAddr)
+	client, _ := netip.AddrFromSlice(local.IP.To16())
+	nonce := make([]byte, 12)
+	rand.Read(nonce)
+
+	mapRequest := func(lifetime uint32) []byte {
+		req := make([]byte, 60)
+		req[0], req[1] = pcpVersion, pcpOpMap
+		binary.BigEndian.PutUint32(req[4:], lifetime)
+		clientBytes := client.As16()
+		copy(req[8:24], clientBytes[:])
+		copy(req[24:36], nonce)
+		req[36] = 17 // UDP
+		binary.BigEndian.PutUint16(req[40:], uint16(local.Port))
+		return req
+	}
+	if _, err := conn.Write(mapRequest(pcpLifetime)); err != nil {
+		return netip.Addr{}, err
+	}
+	buf := make([]byte, 1100)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	msg := buf[:n]
+	if n < 60 || msg[0] != pcpVersion || msg[1] != 0x80|pcpOpMap || !bytes.Equal(msg[24:36], nonce) {
+		return netip.Addr{}, errors.New("Bad PCP response")
+	}
+	if msg[3] != 0 {
+		return netip.Addr{}, fmt.Errorf("Router said no to PCP, result code %d", msg[3])
+	}
+	addr := netip.AddrFrom16([16]byte(msg[44:60]))
+	conn.Write(mapRequest(0))
+	return addr, nil
+}
+
+// Find an internet gateway device on the LAN and ask it for its external
+// address. The first router that answers with one wins.
+func upnpExternal() (netip.Addr, error) {
+	locations, err := ssdpSearch()
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if len(locations) == 0 {
+		return netip.Addr{}, errors.New("No router answered the search")
+	}
+	var errs []error
+	for _, location := range locations {
+		addr, err := upnpAsk(location)
+		if err == nil {
+			return addr, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %v", location, err))
+	}
+	return netip.Addr{}, errors.Join(errs...)
+}
+
+// Multicast an SSDP search for internet gateway devices and collect where
+// their descriptions are, for as long as answers keep coming in.
+func ssdpSearch() ([]string, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	dest, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return nil, err
+	}
+	for _, st := range []string{"urn:schemas-upnp-org:device:InternetGatewayDevice:1", "urn:schemas-upnp-org:device:InternetGatewayDevice:2"} {
+		search := "M-SEARCH * HTTP/1.1\r\n" +
+			"HOST: " + ssdpAddr + "\r\n" +
+			"MAN: \"ssdp:discover\"\r\n" +
+			"MX: 2\r\n" +
+			"ST: " + st + "\r\n\r\n"
+		if _, err := conn.WriteTo([]byte(search), dest); err != nil {
+			return nil, err
+		}
+	}
+
+	var locations []string
+	seen := map[string]bool{}
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			// Running out of time is how the search ends
+			break
+		}
+		res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		res.Body.Close()
+		if location := res.Header.Get("Location"); location != "" && !seen[location] {
+			seen[location] = true
+			locations = append(locations, location)
+		}
+	}
+	return locations, nil
+}
+
+// The parts of a UPnP device description that say where to send calls.
+type upnpDescription struct {
+	URLBase string     `xml:"URLBase"`
+	Device  upnpDevice `xml:"device"`
+}
+
+type upnpDevice struct {
+	Services []upnpService `xml:"serviceList>service"`
+	Devices  []upnpDevice  `xml:"deviceList>device"`
+}
+
+type upnpService struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+// The WAN connection service, wherever it is in the tree of devices.
+func (d upnpDevice) wanService() (upnpService, bool) {
+	for _, s := range d.Services {
+		for _, prefix := range upnpWANServices {
+			if strings.HasPrefix(s.ServiceType, prefix) {
+				return s, true
+			}
+		}
+	}
+	for _, child := range d.Devices {
+		if s, ok := child.wanService(); ok {
+			return s, true
+		}
+	}
+	return upnpService{}, false
+}
+
+var upnpClient = &http.Client{Timeout: 5 * time.Second}
+
+// Read the device description and call GetExternalIPAddress on its WAN
+// connection service.
+func upnpAsk(location string) (netip.Addr, error) {
+	res, err := upnpClient.Get(location)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return netip.Addr{}, fmt.Errorf("Description returned %s", res.Status)
+	}
+	var desc upnpDescription
+	if err := xml.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&desc); err != nil {
+		return netip.Addr{}, fmt.Errorf("Bad description: %v", err)
+	}
+	service, ok := desc.Device.wanService()
+	if !ok {
+		return netip.Addr{}, errors.New("No WAN connection service")
+	}
+	base := location
+	if desc.URLBase != "" {
+		base = desc.URLBase
+	}
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	control, err := baseURL.Parse(service.ControlURL)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	body := `<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:GetExternalIPAddress xmlns:u="` + service.ServiceType + `"/></s:Body></s:Envelope>`
+	req, err := http.NewRequest(http.MethodPost, control.String(), strings.NewReader(body))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+service.ServiceType+`#GetExternalIPAddress"`)
+	soapRes, err := upnpClient.Do(req)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	defer soapRes.Body.Close()
+	if soapRes.StatusCode != http.StatusOK {
+		return netip.Addr{}, fmt.Errorf("GetExternalIPAddress returned %s", soapRes.Status)
+	}
+	var envelope struct {
+		Address string `xml:"Body>GetExternalIPAddressResponse>NewExternalIPAddress"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(soapRes.Body, 1<<16)).Decode(&envelope); err != nil {
+		return netip.Addr{}, fmt.Errorf("Bad response: %v", err)
+	}
+	addr, err := netip.ParseAddr(strings.TrimSpace(envelope.Address))
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("Router doesn't have an external address: %q", envelope.Address)
+	}
+	return addr, nil
+}
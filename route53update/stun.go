@@ -0,0 +1,173 @@
+package route53update
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// Just enough of STUN (RFC 5389) to send a binding request and read the
+// address out of the response. It's UDP, so it still works on networks
+// that only let DNS and the like out, and a lost packet is taken care of
+// by PublicIP trying again. It's one round trip with no TLS, so it's quick
+// too, and there are plenty of public servers run by different people.
+
+const (
+	stunBindingRequest  = 0x0001
+	stunBindingResponse = 0x0101
+	stunMagicCookie     = 0x2112A442
+	stunHeaderLen       = 20
+
+	stunMappedAddress    = 0x0001
+	stunXorMappedAddress = 0x0020
+
+	stunDefaultPort = "3478"
+)
+
+type stunSource struct {
+	servers []string
+}
+
+// STUNSource asks the STUN servers, host or host:port, in order until one
+// answers. The port is 3478 if it's left off.
+func STUNSource(servers ...string) IPSource {
+	s := stunSource{}
+	for _, server := range servers {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(strings.Trim(server, "[]"), stunDefaultPort)
+		}
+		s.servers = append(s.servers, server)
+	}
+	return s
+}
+
+func (s stunSource) String() string {
+	return "stun"
+}
+
+func (s stunSource) PublicIP(v6 bool) (string, error) {
+	var errs []error
+	for _, server := range s.servers {
+		ip, err := stunBinding(server, v6)
+		if err == nil {
+			return ip, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %v", server, err))
+	}
+	return "", errors.Join(errs...)
+}
+
+// Send a binding request to one server and read our address out of the
+// answer.
+func stunBinding(server string, v6 bool) (string, error) {
+	network := "udp4"
+	if v6 {
+		network = "udp6"
+	}
+	conn, err := net.DialTimeout(network, server, 10*time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req := make([]byte, stunHeaderLen)
+	binary.BigEndian.PutUint16(req[0:], stunBindingRequest)
+	binary.BigEndian.PutUint32(req[4:], stunMagicCookie)
+	rand.Read(req[8:stunHeaderLen])
+	if _, err := conn.Write(req); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 1500)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	addr, err := parseSTUNResponse(buf[:n], req[8:stunHeaderLen])
+	if err != nil {
+		return "", fmt.Errorf("Bad response: %v", err)
+	}
+	return addr.String(), nil
+}
+
+// Pull the mapped address out of a binding response. Servers send the XOR
+// version, which NATs that rewrite addresses in packets leave alone, and
+// old ones the plain version, so the XOR one wins if both are there.
+func parseSTUNResponse(msg []byte, txid []byte) (netip.Addr, error) {
+	if len(msg) < stunHeaderLen {
+		return netip.Addr{}, errors.New("too short")
+	}
+	if binary.BigEndian.Uint16(msg[0:]) != stunBindingResponse {
+		return netip.Addr{}, fmt.Errorf("message type %#04x isn't a binding response", binary.BigEndian.Uint16(msg[0:]))
+	}
+	if binary.BigEndian.Uint32(msg[4:]) != stunMagicCookie || !bytes.Equal(msg[8:stunHeaderLen], txid) {
+		return netip.Addr{}, errors.New("not an answer to our request")
+	}
+	length := int(binary.BigEndian.Uint16(msg[2:]))
+	if stunHeaderLen+length > len(msg) {
+		return netip.Addr{}, errors.New("truncated")
+	}
+
+	var mapped, xorMapped netip.Addr
+	attrs := msg[stunHeaderLen : stunHeaderLen+length]
+	for len(attrs) >= 4 {
+		atype := binary.BigEndian.Uint16(attrs[0:])
+		alen := int(binary.BigEndian.Uint16(attrs[2:]))
+		if 4+alen > len(attrs) {
+			return netip.Addr{}, errors.New("truncated attribute")
+		}
+		value := attrs[4 : 4+alen]
+		switch atype {
+		case stunMappedAddress:
+			mapped = stunAddress(value, nil)
+		case stunXorMappedAddress:
+			// The address is XORed with the cookie and transaction id
+			mask := append(binary.BigEndian.AppendUint32(nil, stunMagicCookie), txid...)
+			xorMapped = stunAddress(value, mask)
+		}
+		// Attributes are padded out to four bytes
+		next := 4 + (alen+3)&^3
+		if next > len(attrs) {
+			break
+		}
+		attrs = attrs[next:]
+	}
+	switch {
+	case xorMapped.IsValid():
+		return xorMapped, nil
+	case mapped.IsValid():
+		return mapped, nil
+	}
+	return netip.Addr{}, errors.New("no mapped address in it")
+}
+
+// An address attribute is a reserved byte, the family, the port, and then
+// the address. The zero Addr if it's malformed.
+func stunAddress(value []byte, mask []byte) netip.Addr {
+	if len(value) < 4 {
+		return netip.Addr{}
+	}
+	size := 4
+	if value[1] == 0x02 {
+		size = 16
+	} else if value[1] != 0x01 {
+		return netip.Addr{}
+	}
+	if len(value) < 4+size {
+		return netip.Addr{}
+	}
+	ip := bytes.Clone(value[4 : 4+size])
+	for i := range ip {
+		if mask != nil {
+			ip[i] ^= mask[i]
+		}
+	}
+	addr, _ := netip.AddrFromSlice(ip)
+	return addr
+}
@@ -0,0 +1,150 @@
+package route53update
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var stunTestTxid = []byte("0123456789ab")
+
+type stunTestAttr struct {
+	atype uint16
+	value []byte
+}
+
+// A binding response with the attributes in it.
+func stunTestMessage(txid []byte, attrs ...stunTestAttr) []byte {
+	var body []byte
+	for _, a := range attrs {
+		body = binary.BigEndian.AppendUint16(body, a.atype)
+		body = binary.BigEndian.AppendUint16(body, uint16(len(a.value)))
+		body = append(body, a.value...)
+		for len(body)%4 != 0 {
+			body = append(body, 0)
+		}
+	}
+	msg := binary.BigEndian.AppendUint16(nil, stunBindingResponse)
+	msg = binary.BigEndian.AppendUint16(msg, uint16(len(body)))
+	msg = binary.BigEndian.AppendUint32(msg, stunMagicCookie)
+	msg = append(msg, txid...)
+	return append(msg, body...)
+}
+
+// An address attribute value, XORed the way XOR-MAPPED-ADDRESS is if xor
+// is set.
+func stunTestAddress(addr string, xor bool) []byte {
+	ip := netip.MustParseAddr(addr)
+	family := byte(0x01)
+	if ip.Is6() {
+		family = 0x02
+	}
+	raw := ip.AsSlice()
+	if xor {
+		mask := append(binary.BigEndian.AppendUint32(nil, stunMagicCookie), stunTestTxid...)
+		for i := range raw {
+			raw[i] ^= mask[i]
+		}
+	}
+	return append([]byte{0, family, 0x12, 0x34}, raw...)
+}
+
+func TestParseSTUNResponse(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  []byte
+		want string
+		err  string
+	}{
+		{
+			name: "XOR mapped IPv4",
+			msg:  stunTestMessage(stunTestTxid, stunTestAttr{stunXorMappedAddress, stunTestAddress("203.0.113.7", true)}),
+			want: "203.0.113.7",
+		},
+		{
+			name: "XOR mapped IPv6",
+			msg:  stunTestMessage(stunTestTxid, stunTestAttr{stunXorMappedAddress, stunTestAddress("2001:db8::7", true)}),
+			want: "2001:db8::7",
+		},
+		{
+			name: "plain mapped",
+			msg:  stunTestMessage(stunTestTxid, stunTestAttr{stunMappedAddress, stunTestAddress("198.51.100.1", false)}),
+			want: "198.51.100.1",
+		},
+		{
+			name: "XOR beats plain",
+			msg: stunTestMessage(stunTestTxid,
+				stunTestAttr{stunMappedAddress, stunTestAddress("10.0.0.1", false)},
+				stunTestAttr{stunXorMappedAddress, stunTestAddress("203.0.113.7", true)}),
+			want: "203.0.113.7",
+		},
+		{
+			name: "other attributes padded in front",
+			msg: stunTestMessage(stunTestTxid,
+				stunTestAttr{0x8022, []byte("server")},
+				stunTestAttr{stunXorMappedAddress, stunTestAddress("203.0.113.7", true)}),
+			want: "203.0.113.7",
+		},
+		{
+			name: "too short",
+			msg:  []byte{1, 1, 0},
+			err:  "too short",
+		},
+		{
+			name: "not a response",
+			msg: func() []byte {
+				msg := stunTestMessage(stunTestTxid)
+				binary.BigEndian.PutUint16(msg, stunBindingRequest)
+				return msg
+			}(),
+			err: "isn't a binding response",
+		},
+		{
+			name: "someone else's transaction",
+			msg:  stunTestMessage([]byte("ba9876543210"), stunTestAttr{stunXorMappedAddress, stunTestAddress("203.0.113.7", true)}),
+			err:  "not an answer",
+		},
+		{
+			name: "truncated",
+			msg:  stunTestMessage(stunTestTxid, stunTestAttr{stunXorMappedAddress, stunTestAddress("203.0.113.7", true)})[:24],
+			err:  "truncated",
+		},
+		{
+			name: "no address",
+			msg:  stunTestMessage(stunTestTxid, stunTestAttr{0x8022, []byte("server")}),
+			err:  "no mapped address",
+		},
+		{
+			name: "unknown family",
+			msg:  stunTestMessage(stunTestTxid, stunTestAttr{stunXorMappedAddress, []byte{0, 0x03, 0, 0, 1, 2, 3, 4}}),
+			err:  "no mapped address",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, err := parseSTUNResponse(tt.msg, stunTestTxid)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Got %v, %v, want an error with %q", addr, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseSTUNResponse: %v", err)
+			}
+			if addr.String() != tt.want {
+				t.Errorf("Got %s, want %s", addr, tt.want)
+			}
+		})
+	}
+}
+
+func TestSTUNSourcePorts(t *testing.T) {
+	s := STUNSource("stun.example.com", "stun.example.net:19302", "2001:db8::1", "[2001:db8::2]:3479").(stunSource)
+	want := []string{"stun.example.com:3478", "stun.example.net:19302", "[2001:db8::1]:3478", "[2001:db8::2]:3479"}
+	if !slices.Equal(s.servers, want) {
+		t.Errorf("Servers are %q, want %q", s.servers, want)
+	}
+}
@@ -0,0 +1,239 @@
+package route53update
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+// ErrRecordChanged is returned by Recheck, and so by Update, when the record
+// isn't what Check saw anymore, which means someone else is updating it.
+var ErrRecordChanged = errors.New("Record changed since it was checked")
+
+// ErrUnsupportedRecord is returned for records other than A and AAAA.
+var ErrUnsupportedRecord = errors.New("Only A and AAAA records can be updated")
+
+// Options are the settings for an Updater. The zero value keeps the A rec of
+// a domain in the closest hosted zone it's part of, with the default TTL.
+type Options struct {
+	// The records to keep up to date, the A rec if empty
+	Records []types.RRType
+
+	// TTL for records that get changed, DefaultTTL if zero
+	TTL int64
+
+	// The hosted zone the records go in. Without it the records go in the
+	// closest zone the domain is part of.
+	Zone string
+
+	// The hosted zone's id, which beats Zone and skips looking the zone up,
+	// for credentials that can only change records in the one zone
+	ZoneId string
+
+	// Use the private zone with the name rather than the public one, the
+	// one associated with VPCId if that's set
+	Private bool
+	VPCId   string
+
+	// Find out what would change without changing anything
+	DryRun bool
+
+	// Where to find the public addresses, the one SetIPSource picked if nil
+	Source IPSource
+
+	// How long to wait for each change to go in sync before Update returns,
+	// zero to return as soon as Route53 takes it
+	Wait time.Duration
+}
+
+// Updater keeps the address records for domains pointed at this machine's
+// public addresses. Update does the whole thing for a domain, and Check,
+// Recheck, and Changes are the steps it's made of, for callers that do
+// more around each step, like the route53Update command with its hooks,
+// policies, and batching.
+type Updater struct {
+	client *route53.Client
+	opts   Options
+}
+
+// Result is what happened to one record, or what would happen to it.
+type Result struct {
+	Domain   string // without the dot on the end
+	Record   types.RRType
+	ZoneId   string
+	OldIp    string // empty if the record didn't exist
+	NewIp    string
+	TTL      int64  // the TTL the record should have
+	Changed  bool   // the address or the TTL was different
+	ChangeId string // only set when the record was changed
+
+	// The record set as Check found it, nil if it didn't exist
+	Existing *types.ResourceRecordSet
+}
+
+// New makes an Updater using a Route53 client.
+func New(client *route53.Client, opts Options) *Updater {
+	if len(opts.Records) == 0 {
+		opts.Records = []types.RRType{types.RRTypeA}
+	}
+	if opts.TTL == 0 {
+		opts.TTL = DefaultTTL
+	}
+	return &Updater{client: client, opts: opts}
+}
+
+// Zone finds the hosted zone for the domain's records, going by the zone
+// options.
+func (u *Updater) Zone(domain string) (*types.HostedZone, error) {
+	name := NormalizeHostname(domain)
+	switch {
+	case u.opts.ZoneId != "":
+		return &types.HostedZone{Id: aws.String(u.opts.ZoneId)}, nil
+	case u.opts.Private || u.opts.VPCId != "":
+		if u.opts.Zone != "" {
+			return GetPrivateHostedZone(u.client, u.opts.Zone, u.opts.VPCId)
+		}
+		return FindPrivateHostedZone(u.client, name, u.opts.VPCId)
+	case u.opts.Zone != "":
+		return GetHostedZone(u.client, NormalizeHostname(u.opts.Zone)+".")
+	}
+	return FindHostedZone(u.client, name)
+}
+
+// Check looks up the record and compares it with the address it should
+// have, without changing anything. A record that isn't there yet counts as
+// changed, it's not an error.
+func (u *Updater) Check(zoneId string, domain string, rtype types.RRType, ip string) (Result, error) {
+	res := Result{Domain: NormalizeHostname(domain), Record: rtype, ZoneId: zoneId, NewIp: ip, TTL: u.opts.TTL}
+	if rtype != types.RRTypeA && rtype != types.RRTypeAaaa {
+		return res, fmt.Errorf("%w, not %s", ErrUnsupportedRecord, rtype)
+	}
+	rec, err := GetRecord(u.client, zoneId, res.Domain+".", rtype)
+	if err != nil && !errors.Is(err, ErrRecordNotFound) {
+		return res, err
+	}
+	if rec != nil {
+		if res.OldIp, err = RecordIp(rec); err != nil {
+			return res, err
+		}
+		res.Existing = rec
+	}
+	// A record with the right address but the wrong TTL gets fixed too
+	res.Changed = res.OldIp != res.NewIp || (rec != nil && rec.TTL != nil && *rec.TTL != res.TTL)
+	return res, nil
+}
+
+// Recheck looks at the record again right before changing it. Route53
+// doesn't have a conditional UPSERT, so with more than one machine sharing
+// a zone this is the best there is, and if the address moved since Check
+// it's an ErrRecordChanged, so the caller can back off and start over
+// rather than stomp on someone else's change.
+func (u *Updater) Recheck(res Result) error {
+	current, err := GetRecIp(u.client, res.ZoneId, res.Domain+".", res.Record)
+	if err != nil && !errors.Is(err, ErrRecordNotFound) {
+		return err
+	}
+	if current != res.OldIp {
+		return fmt.Errorf("%w, %s went from %s to %s", ErrRecordChanged, res.Record, res.OldIp, current)
+	}
+	return nil
+}
+
+// Changes is what to send to Route53 to bring the record in line, for
+// putting in a batch.
+func (u *Updater) Changes(res Result) []types.Change {
+	return []types.Change{AddressChange(res.Domain+".", res.Record, res.NewIp, res.TTL)}
+}
+
+// Update checks each record for the domain against the public address for
+// its family and changes the ones that don't match. The changes all go in
+// one batch, so the records change together or not at all. The results
+// cover every record that got checked, even when a later one fails.
+func (u *Updater) Update(domain string) ([]Result, error) {
+	zone, err := u.Zone(domain)
+	if err != nil {
+		return nil, err
+	}
+	name := NormalizeHostname(domain)
+
+	var results []Result
+	var changes []types.Change
+	var errs []error
+	for _, rtype := range u.opts.Records {
+		res, err := u.checkPublic(*zone.Id, name, rtype)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s %s: %w", name, rtype, err))
+			continue
+		}
+		results = append(results, res)
+	}
+	if u.opts.DryRun {
+		return results, errors.Join(errs...)
+	}
+	for _, res := range results {
+		if !res.Changed {
+			continue
+		}
+		if err := u.Recheck(res); err != nil {
+			return unchanged(results), errors.Join(append(errs, fmt.Errorf("%s %s: %w", name, res.Record, err))...)
+		}
+		changes = append(changes, u.Changes(res)...)
+	}
+	if len(changes) == 0 {
+		return results, errors.Join(errs...)
+	}
+
+	change, err := ChangeRecordSets(u.client, &route53.ChangeResourceRecordSetsInput{
+		ChangeBatch:  &types.ChangeBatch{Changes: changes},
+		HostedZoneId: zone.Id,
+	})
+	if err != nil {
+		return unchanged(results), errors.Join(append(errs, fmt.Errorf("%s: %w", name, err))...)
+	}
+	changeId := *change.ChangeInfo.Id
+	for i := range results {
+		if results[i].Changed {
+			results[i].ChangeId = changeId
+		}
+	}
+	if u.opts.Wait > 0 {
+		if _, err := WaitForChange(u.client, changeId, u.opts.Wait); err != nil {
+			errs = append(errs, fmt.Errorf("Change %s never went in sync: %w", changeId, err))
+		}
+	}
+	return results, errors.Join(errs...)
+}
+
+// Check a record against the public address of its family.
+func (u *Updater) checkPublic(zoneId string, name string, rtype types.RRType) (Result, error) {
+	source := u.opts.Source
+	if source == nil {
+		source = ipSource
+	}
+	var ip string
+	var err error
+	switch rtype {
+	case types.RRTypeA:
+		ip, err = PublicIP(source, false)
+	case types.RRTypeAaaa:
+		ip, err = PublicIP(source, true)
+	default:
+		return Result{Domain: name, Record: rtype}, fmt.Errorf("%w, not %s", ErrUnsupportedRecord, rtype)
+	}
+	if err != nil {
+		return Result{Domain: name, Record: rtype}, err
+	}
+	return u.Check(zoneId, name, rtype, ip)
+}
+
+// None of the changes happened, the batch is all or nothing.
+func unchanged(results []Result) []Result {
+	for i := range results {
+		results[i].Changed = false
+	}
+	return results
+}
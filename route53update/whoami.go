@@ -0,0 +1,82 @@
+package route53update
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Cloudflare's resolvers answer a TXT query for whoami.cloudflare in the
+// CHAOS class with the address the query came from, the way OpenDNS does
+// with an A query for myip.opendns.com. The Go resolver only asks INET
+// class questions, so this one gets sent by hand.
+type whoamiSource struct {
+	name    string
+	server4 string
+	server6 string
+}
+
+func (s whoamiSource) String() string {
+	return "dns-cloudflare"
+}
+
+func (s whoamiSource) PublicIP(v6 bool) (string, error) {
+	network, server := "udp4", ReachableHost(s.server4)
+	if v6 {
+		network, server = "udp6", s.server6
+	}
+	name, err := dnsmessage.NewName(s.name)
+	if err != nil {
+		return "", err
+	}
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: uint16(rand.Intn(1 << 16)), RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassCHAOS}},
+	}
+	query, err := msg.Pack()
+	if err != nil {
+		return "", err
+	}
+
+	conn, err := net.DialTimeout(network, net.JoinHostPort(server, "53"), 5*time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write(query); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 1500)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	var res dnsmessage.Message
+	if err := res.Unpack(buf[:n]); err != nil {
+		return "", fmt.Errorf("Bad answer: %v", err)
+	}
+	if res.ID != msg.ID {
+		return "", errors.New("Answer doesn't match the query")
+	}
+	if res.RCode != dnsmessage.RCodeSuccess {
+		return "", fmt.Errorf("%s answered %s", server, res.RCode)
+	}
+	for _, rr := range res.Answers {
+		txt, ok := rr.Body.(*dnsmessage.TXTResource)
+		if !ok {
+			continue
+		}
+		addr, err := netip.ParseAddr(strings.Join(txt.TXT, ""))
+		if err == nil {
+			return addr.Unmap().String(), nil
+		}
+	}
+	return "", fmt.Errorf("%s didn't come back with an address", s.name)
+}
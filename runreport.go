@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// When an update needs explaining later, like attached to a change ticket or
+// picked over by an audit, the log output isn't much to go on. A run report
+// is the whole story of one run in JSON: what it was asked to do, what it
+// decided for each record, every AWS call it made and how long each took,
+// and how it ended up.
+
+// RunReport collects everything about one run of the command line mode.
+type RunReport struct {
+	Started         time.Time `json:"started"`
+	Finished        time.Time `json:"finished"`
+	DurationSeconds float64   `json:"duration_seconds"`
+
+	Inputs RunInputs `json:"inputs"`
+
+	// Every event reported during the run, changes, no changes, held
+	// updates, and failures alike
+	Decisions []Event `json:"decisions"`
+
+	APICalls []APICall `json:"api_calls"`
+
+	// Hooks run around changes, with their output
+	Hooks []HookRun `json:"hooks"`
+
+	Result string `json:"result"` // ok or failed
+	Error  string `json:"error,omitempty"`
+
+	mu sync.Mutex
+}
+
+// RunInputs is what the run was asked to do.
+type RunInputs struct {
+	Args          []string `json:"args"`
+	Domains       []string `json:"domains"`
+	Config        string   `json:"config,omitempty"`
+	Profile       string   `json:"profile,omitempty"`
+	IPv6          bool     `json:"ipv6"`
+	Records       string   `json:"records,omitempty"`
+	MonitorOnly   bool     `json:"monitor_only"`
+	IgnoreWindows bool     `json:"ignore_windows"`
+	FIPS          bool     `json:"fips"`
+}
+
+// APICall is one call to an AWS API.
+type APICall struct {
+	Service         string    `json:"service"`
+	Operation       string    `json:"operation"`
+	Started         time.Time `json:"started"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Error           string    `json:"error,omitempty"`
+}
+
+// The report for the run in progress, if one was asked for. There's only
+// ever one run per process, and every AWS client and the reporter need to
+// find it, so it lives here rather than getting passed all over.
+var currentRun *RunReport
+
+// NewRunReport starts a report and makes it the current one.
+func NewRunReport(inputs RunInputs) *RunReport {
+	currentRun = &RunReport{Started: time.Now(), Inputs: inputs, Decisions: []Event{}, APICalls: []APICall{}, Hooks: []HookRun{}}
+	return currentRun
+}
+
+func (r *RunReport) addEvent(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Decisions = append(r.Decisions, e)
+}
+
+func (r *RunReport) addHook(h HookRun) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Hooks = append(r.Hooks, h)
+}
+
+// Add a middleware to an AWS client's stack that times each call and adds
+// it to the report. Goes in the config's APIOptions so every client made
+// from it is covered.
+func (r *RunReport) trackCalls(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("RunReport",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			call := APICall{
+				Service:   awsmiddleware.GetServiceID(ctx),
+				Operation: awsmiddleware.GetOperationName(ctx),
+				Started:   time.Now(),
+			}
+			out, md, err := next.HandleInitialize(ctx, in)
+			call.DurationSeconds = time.Since(call.Started).Seconds()
+			if err != nil {
+				call.Error = err.Error()
+			}
+			r.mu.Lock()
+			r.APICalls = append(r.APICalls, call)
+			r.mu.Unlock()
+			return out, md, err
+		}), middleware.Before)
+}
+
+// Finish records how the run ended and writes the report to path.
+func (r *RunReport) Finish(path string, runErr error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Finished = time.Now()
+	r.DurationSeconds = r.Finished.Sub(r.Started).Seconds()
+	r.Result = "ok"
+	if runErr != nil {
+		r.Result = "failed"
+		r.Error = runErr.Error()
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Failed to encode run report: %v", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("Failed to write run report: %v", err)
+	}
+	return nil
+}
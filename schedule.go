@@ -0,0 +1,78 @@
+package main
+
+import (
+	"container/heap"
+	"time"
+)
+
+// Schedule keeps track of when each domain is next due for a check, so watch
+// mode can run everything off one timer set to whatever comes up next, no
+// matter how many domains there are or how different their intervals are.
+type Schedule struct {
+	entries scheduleHeap
+}
+
+type scheduled struct {
+	name  string
+	every time.Duration
+	at    time.Time
+}
+
+// Add puts a domain on the schedule, first due at the given time.
+func (s *Schedule) Add(name string, every time.Duration, at time.Time) {
+	heap.Push(&s.entries, &scheduled{name: name, every: every, at: at})
+}
+
+// Next is when the next domain is due, or the zero time with nothing
+// scheduled.
+func (s *Schedule) Next() time.Time {
+	if len(s.entries) == 0 {
+		return time.Time{}
+	}
+	return s.entries[0].at
+}
+
+// At is when a domain is next due.
+func (s *Schedule) At(name string) (time.Time, bool) {
+	for _, e := range s.entries {
+		if e.name == name {
+			return e.at, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Due takes everything that's due as of now off the schedule and puts it
+// back for its next check. A check that's running late gets its next one
+// an interval from now, rather than a burst of catch up checks.
+func (s *Schedule) Due(now time.Time) []string {
+	var names []string
+	for len(s.entries) > 0 && !s.entries[0].at.After(now) {
+		e := s.entries[0]
+		names = append(names, e.name)
+		e.at = e.at.Add(e.every)
+		if !e.at.After(now) {
+			e.at = now.Add(e.every)
+		}
+		heap.Fix(&s.entries, 0)
+	}
+	return names
+}
+
+// scheduleHeap is a min heap on due time for container/heap.
+type scheduleHeap []*scheduled
+
+func (h scheduleHeap) Len() int           { return len(h) }
+func (h scheduleHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h scheduleHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *scheduleHeap) Push(x any) {
+	*h = append(*h, x.(*scheduled))
+}
+
+func (h *scheduleHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The YAML decoder quietly skips keys it doesn't know about, so a typo like
+// tttl under a domain just means the TTL never gets set and nothing says
+// why. Loading the config now walks it against the Config struct and picks
+// out every key that doesn't go anywhere. Normally those get a warning and
+// are skipped, which lets an older copy still run with a config written for
+// a newer one. With strict: true at the top level they're an error instead.
+// Keys starting with x- are always left alone, for holding YAML anchors and
+// notes the way docker compose files do.
+//
+// The same walk of the Config struct gives a JSON schema, which config
+// schema prints for editors that check YAML against one.
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// The fields of a struct by the key they have in the config.
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f
+	}
+	return fields
+}
+
+// Every key in the config that doesn't match anything in Config, with the
+// line it's on. Profiles are partial configs, so they get checked the same
+// way as the top level.
+func unknownConfigKeys(root *yaml.Node) []string {
+	configType := reflect.TypeOf(Config{})
+	var unknown []string
+	if profiles := mappingValue(root, "profiles"); profiles != nil && profiles.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(profiles.Content); i += 2 {
+			unknown = append(unknown, unknownKeys(profiles.Content[i+1], configType, "profiles."+profiles.Content[i].Value)...)
+		}
+	}
+	return append(unknownKeys(root, configType, ""), unknown...)
+}
+
+func unknownKeys(node *yaml.Node, t reflect.Type, path string) []string {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	join := func(key string) string {
+		if path == "" {
+			return key
+		}
+		return path + "." + key
+	}
+
+	var unknown []string
+	switch {
+	case t.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Value == "<<" {
+				// A merge key pulls in another mapping's keys
+				unknown = append(unknown, unknownKeys(value, t, path)...)
+				continue
+			}
+			if (path == "" && key.Value == "profiles") || strings.HasPrefix(key.Value, "x-") {
+				continue
+			}
+			f, ok := fields[key.Value]
+			if !ok {
+				unknown = append(unknown, fmt.Sprintf("line %d: unknown key %s", key.Line, join(key.Value)))
+				continue
+			}
+			unknown = append(unknown, unknownKeys(value, f.Type, join(key.Value))...)
+		}
+	case t.Kind() == reflect.Map && node.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			unknown = append(unknown, unknownKeys(node.Content[i+1], t.Elem(), join(node.Content[i].Value))...)
+		}
+	case t.Kind() == reflect.Slice && node.Kind == yaml.SequenceNode:
+		for i, item := range node.Content {
+			unknown = append(unknown, unknownKeys(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+	return unknown
+}
+
+// ConfigSchema is a JSON schema for the config file.
+func ConfigSchema() map[string]any {
+	schema := typeSchema(reflect.TypeOf(Config{}))
+	profiles := map[string]any{
+		"type":                 "object",
+		"description":          "Named sets of settings that -profile lays over the top level ones",
+		"additionalProperties": typeSchema(reflect.TypeOf(Config{})),
+	}
+	schema["properties"].(map[string]any)["profiles"] = profiles
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "route53Update config"
+	return schema
+}
+
+func typeSchema(t reflect.Type) map[string]any {
+	if t == durationType {
+		return map[string]any{"type": "string", "description": "A duration like 90s, 5m, or 1h30m"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		props := map[string]any{}
+		for name, f := range yamlFields(t) {
+			props[name] = typeSchema(f.Type)
+		}
+		return map[string]any{
+			"type":                 "object",
+			"properties":           props,
+			"patternProperties":    map[string]any{"^x-": map[string]any{}},
+			"additionalProperties": false,
+		}
+	}
+	// Anything goes
+	return map[string]any{}
+}
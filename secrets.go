@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"gopkg.in/yaml.v3"
+)
+
+// API tokens and webhook URLs are as good as passwords, which makes the
+// config awkward to keep in a dotfiles repo. Any string in the config can
+// be encrypted instead, tagged with what it's encrypted with:
+//
+//	cloudflare:
+//	  api_token: !kms AQICAHh...
+//	notify:
+//	  webhooks:
+//	    - url: !age |
+//	        -----BEGIN AGE ENCRYPTED FILE-----
+//	        ...
+//	        -----END AGE ENCRYPTED FILE-----
+//
+// !kms values are base64 KMS ciphertext, decrypted with the same AWS
+// credentials as everything else, so they need kms:Decrypt on the key. !age
+// values are armored age files, decrypted with the identities in the file
+// secrets.age_identity points at, or ROUTE53UPDATE_AGE_IDENTITY if the
+// config doesn't say. It all gets decrypted once as the config loads, and
+// config encrypt makes the values in the first place.
+
+// SecretsConfig says where the keys for encrypted config values are.
+type SecretsConfig struct {
+	AgeIdentity string `yaml:"age_identity"`
+}
+
+const (
+	kmsTag = "!kms"
+	ageTag = "!age"
+)
+
+// Replace every encrypted value under root with what it decrypts to. The
+// KMS client and age identities only get set up if something needs them.
+func decryptSecrets(root *yaml.Node) error {
+	d := &secretDecrypter{root: root}
+	return d.walk(root)
+}
+
+type secretDecrypter struct {
+	root       *yaml.Node
+	kms        *kms.Client
+	identities []age.Identity
+}
+
+func (d *secretDecrypter) walk(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode && (node.Tag == kmsTag || node.Tag == ageTag) {
+		var plain string
+		var err error
+		if node.Tag == kmsTag {
+			plain, err = d.decryptKMS(node.Value)
+		} else {
+			plain, err = d.decryptAge(node.Value)
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %v", node.Line, err)
+		}
+		node.Tag = "!!str"
+		node.Style = 0
+		node.Value = plain
+		return nil
+	}
+	// An alias's value gets decrypted where its anchor is
+	for _, child := range node.Content {
+		if err := d.walk(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *secretDecrypter) decryptKMS(value string) (string, error) {
+	blob, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(value), ""))
+	if err != nil {
+		return "", fmt.Errorf("Bad !kms value: %v", err)
+	}
+	if d.kms == nil {
+		// The aws section can pick the profile, so it's used even though
+		// the rest of the config isn't loaded yet
+		var conf Config
+		if awsNode := mappingValue(d.root, "aws"); awsNode != nil {
+			if err := awsNode.Decode(&conf.AWS); err != nil {
+				return "", fmt.Errorf("Failed to parse aws settings: %v", err)
+			}
+		}
+		cfg, _, err := LoadAWSConfig(&conf)
+		if err != nil {
+			return "", err
+		}
+		d.kms = kms.NewFromConfig(cfg)
+	}
+	res, err := d.kms.Decrypt(context.TODO(), &kms.DecryptInput{CiphertextBlob: blob})
+	if err != nil {
+		return "", fmt.Errorf("Failed to decrypt with KMS: %v", err)
+	}
+	return string(res.Plaintext), nil
+}
+
+func (d *secretDecrypter) decryptAge(value string) (string, error) {
+	if d.identities == nil {
+		path := os.Getenv("ROUTE53UPDATE_AGE_IDENTITY")
+		if secrets := mappingValue(d.root, "secrets"); secrets != nil {
+			var conf SecretsConfig
+			if err := secrets.Decode(&conf); err != nil {
+				return "", fmt.Errorf("Failed to parse secrets settings: %v", err)
+			}
+			if conf.AgeIdentity != "" {
+				path = conf.AgeIdentity
+			}
+		}
+		if path == "" {
+			return "", fmt.Errorf("Found an !age value but no identity to decrypt it, set secrets.age_identity or ROUTE53UPDATE_AGE_IDENTITY")
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return "", fmt.Errorf("Failed to open age identity: %v", err)
+		}
+		defer f.Close()
+		d.identities, err = age.ParseIdentities(f)
+		if err != nil {
+			return "", fmt.Errorf("Failed to read age identity %s: %v", path, err)
+		}
+	}
+	r, err := age.Decrypt(armor.NewReader(strings.NewReader(strings.TrimSpace(value)+"\n")), d.identities...)
+	if err != nil {
+		return "", fmt.Errorf("Failed to decrypt with age: %v", err)
+	}
+	plain, err := io.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("Failed to decrypt with age: %v", err)
+	}
+	return string(plain), nil
+}
+
+// EncryptKMS encrypts a config value with a KMS key, for a !kms value.
+func EncryptKMS(cfg aws.Config, keyId string, plain string) (*yaml.Node, error) {
+	res, err := kms.NewFromConfig(cfg).Encrypt(context.TODO(), &kms.EncryptInput{
+		KeyId:     aws.String(keyId),
+		Plaintext: []byte(plain),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encrypt with KMS: %v", err)
+	}
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: kmsTag, Value: base64.StdEncoding.EncodeToString(res.CiphertextBlob)}, nil
+}
+
+// EncryptAge encrypts a config value to age recipients, for an !age value.
+func EncryptAge(recipients []string, plain string) (*yaml.Node, error) {
+	var to []age.Recipient
+	for _, r := range recipients {
+		parsed, err := age.ParseRecipients(strings.NewReader(r))
+		if err != nil {
+			return nil, fmt.Errorf("Bad age recipient %s: %v", r, err)
+		}
+		to = append(to, parsed...)
+	}
+	var buf bytes.Buffer
+	a := armor.NewWriter(&buf)
+	w, err := age.Encrypt(a, to...)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encrypt with age: %v", err)
+	}
+	if _, err := io.WriteString(w, plain); err != nil {
+		return nil, fmt.Errorf("Failed to encrypt with age: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("Failed to encrypt with age: %v", err)
+	}
+	if err := a.Close(); err != nil {
+		return nil, fmt.Errorf("Failed to encrypt with age: %v", err)
+	}
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: ageTag, Value: buf.String(), Style: yaml.LiteralStyle}, nil
+}
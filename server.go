@@ -0,0 +1,703 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/mikerowehl/route53Update/route53update"
+)
+
+// Most routers and DynDNS clients refuse to send more than this many
+// hostnames in one request anyway, and the dyndns2 protocol says to reply
+// numhost if they do.
+const maxHostsPerRequest = 20
+
+// Server implements the dyndns2 update protocol on top of Route53, so routers
+// that only know how to talk to DynDNS style providers can keep records up to
+// date for us.
+type Server struct {
+	client   *route53.Client
+	zones    *ZoneCache
+	creds    *CredentialStore
+	registry *ClientRegistry
+	reporter *Reporter
+	checker  *AddressChecker
+
+	// Optional, lets notify only alerts be approved with a link
+	approvals *Approvals
+
+	// Approved domains that don't live in Route53 go to their provider
+	domains   map[string]DomainConfig
+	providers map[string]DNSProvider
+
+	// Limits on the addresses anyone can set
+	policies *Policies
+
+	// The TTLs from the config, with each domain's own normal TTL beating it
+	ttls TTLConfig
+
+	// Route53 changes for the same record shouldn't overlap, and the volume
+	// here is tiny, so just do one update at a time.
+	mu sync.Mutex
+}
+
+func NewServer(client *route53.Client, zones *ZoneCache, creds *CredentialStore, registry *ClientRegistry, reporter *Reporter, checker *AddressChecker, approvals *Approvals) *Server {
+	return &Server{
+		client:    client,
+		zones:     zones,
+		creds:     creds,
+		registry:  registry,
+		reporter:  reporter,
+		checker:   checker,
+		approvals: approvals,
+	}
+}
+
+// Handler returns the http handler with the update paths that clients
+// commonly use.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/nic/update", s.handleUpdate)
+	mux.HandleFunc("/v3/update", s.handleUpdate)
+	if s.registry != nil {
+		mux.HandleFunc("/client/update", s.handleClientUpdate)
+	}
+	if s.approvals != nil {
+		mux.HandleFunc("/approve", s.handleApprove)
+	}
+	return mux
+}
+
+func (s *Server) handleUpdate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	username, password, ok := r.BasicAuth()
+	noteAccess(r, username)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="route53Update"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintln(w, "badauth")
+		return
+	}
+	records, ok := s.creds.Authenticate(username, password)
+	if !ok {
+		log.Printf("Bad credentials for user %s from %s", username, r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", `Basic realm="route53Update"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintln(w, "badauth")
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Get("hostname") == "" {
+		fmt.Fprintln(w, "notfqdn")
+		return
+	}
+	hostnames := strings.Split(query.Get("hostname"), ",")
+	noteAccess(r, "", hostnames...)
+	if len(hostnames) > maxHostsPerRequest {
+		fmt.Fprintln(w, "numhost")
+		return
+	}
+
+	ips, ok := requestIps(r)
+	if !ok {
+		log.Printf("User %s sent an address we can't use: %s", username, query.Get("myip"))
+		fmt.Fprintln(w, "911")
+		return
+	}
+
+	for _, hostname := range hostnames {
+		var answers []string
+		for _, ip := range ips {
+			answers = append(answers, s.updateHost(username, records, hostname, ip))
+		}
+		fmt.Fprintln(w, combineAnswers(answers))
+	}
+}
+
+// Figure out the addresses a request wants published, at most one of each
+// family. Dual stack clients send both at once, like
+// myip=203.0.113.7,2001:db8::7, and each goes in the record for its family.
+// If the client doesn't tell us the address to use, the address the request
+// came from is what the protocol says to use.
+func requestIps(r *http.Request) ([]string, bool) {
+	myip := r.URL.Query().Get("myip")
+	if myip == "" {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		myip = host
+	}
+	var ips []string
+	seen := map[types.RRType]bool{}
+	for _, ip := range strings.Split(myip, ",") {
+		parsed := net.ParseIP(strings.TrimSpace(ip))
+		if parsed == nil {
+			return nil, false
+		}
+		if v4 := parsed.To4(); v4 != nil {
+			parsed = v4
+		}
+		ip = parsed.String()
+		if seen[addressType(ip)] {
+			return nil, false
+		}
+		seen[addressType(ip)] = true
+		ips = append(ips, ip)
+	}
+	return ips, true
+}
+
+// The record an address goes in, A or AAAA.
+func addressType(ip string) types.RRType {
+	if strings.Contains(ip, ":") {
+		return types.RRTypeAaaa
+	}
+	return types.RRTypeA
+}
+
+// The record name events use for an address, empty for the A rec.
+func addressRecord(ip string) string {
+	if rtype := addressType(ip); rtype != types.RRTypeA {
+		return string(rtype)
+	}
+	return ""
+}
+
+// The answer for a hostname out of the answers for each of its addresses.
+// An error for either one is the answer, since the client has to try again
+// anyway, and otherwise it's good if either address changed, with both
+// addresses on the end the way they came in.
+func combineAnswers(answers []string) string {
+	if len(answers) == 1 {
+		return answers[0]
+	}
+	code := "nochg"
+	var ips []string
+	for _, answer := range answers {
+		c, ip, _ := strings.Cut(answer, " ")
+		switch c {
+		case "good":
+			code = "good"
+		case "nochg":
+		default:
+			return answer
+		}
+		ips = append(ips, ip)
+	}
+	return code + " " + strings.Join(ips, ",")
+}
+
+// Clients in the registry send their token as a bearer token, or as the
+// password in basic auth for things that can only do dyndns style requests.
+func requestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+	return ""
+}
+
+func (s *Server) handleClientUpdate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	c, ok := s.registry.Lookup(requestToken(r))
+	if !ok {
+		log.Printf("Bad client token from %s", r.RemoteAddr)
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintln(w, "badauth")
+		return
+	}
+	noteAccess(r, "client:"+c.Name)
+
+	query := r.URL.Query()
+	if query.Get("hostname") == "" {
+		fmt.Fprintln(w, "notfqdn")
+		return
+	}
+	hostnames := strings.Split(query.Get("hostname"), ",")
+	noteAccess(r, "", hostnames...)
+	if len(hostnames) > maxHostsPerRequest {
+		fmt.Fprintln(w, "numhost")
+		return
+	}
+	ips, ok := requestIps(r)
+	if !ok {
+		log.Printf("Client %s sent an address we can't use: %s", c.Name, query.Get("myip"))
+		fmt.Fprintln(w, "911")
+		return
+	}
+
+	for _, hostname := range hostnames {
+		var answers []string
+		for _, ip := range ips {
+			answers = append(answers, s.updateClientHost(c, hostname, ip))
+		}
+		fmt.Fprintln(w, combineAnswers(answers))
+	}
+}
+
+// Update a hostname on behalf of a registered client. The client has to own
+// the name by pattern, and if the record already exists it has to carry the
+// client's ownership marker, so one device can never take over a record that
+// belongs to another device or that someone made by hand.
+func (s *Server) updateClientHost(c ClientConfig, hostname string, ip string) string {
+	hostname = route53update.NormalizeHostname(hostname)
+	if !strings.Contains(hostname, ".") {
+		return "notfqdn"
+	}
+	if !c.Owns(hostname) {
+		log.Printf("Client %s does not own %s", c.Name, hostname)
+		return "nohost"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	domain := hostname + "."
+	source := "client:" + c.Name
+	zone, err := s.zones.Zone(route53update.NormalizeHostname(c.Zone) + ".")
+	if err != nil {
+		log.Printf("Failed to find zone for %s: %v", hostname, err)
+		s.reporter.Failure(hostname, source, err)
+		return "dnserr"
+	}
+
+	rtype, record := addressType(ip), addressRecord(ip)
+	a, aaaa, txt, err := GetOwnedRecords(s.client, *zone.Id, domain)
+	if err != nil {
+		log.Printf("Error checking records for %s: %v", hostname, err)
+		s.reporter.Failure(hostname, source, err)
+		return "dnserr"
+	}
+	owner := RecordOwner(txt)
+	// Other TXT values on the name are fine, the marker goes in next to them
+	if (a != nil || aaaa != nil || owner != "") && owner != c.Name {
+		log.Printf("Client %s tried to update %s, which is owned by %q", c.Name, hostname, owner)
+		return "nohost"
+	}
+
+	current := a
+	if rtype == types.RRTypeAaaa {
+		current = aaaa
+	}
+	oldIp := ""
+	if current != nil {
+		if oldIp, err = route53update.RecordIp(current); err != nil {
+			log.Printf("Not updating %s for client %s: %v", hostname, c.Name, err)
+			s.reporter.Failure(hostname, source, err)
+			return "nohost"
+		}
+	}
+	if current != nil && len(current.ResourceRecords) == 1 && oldIp == ip {
+		s.registry.Seen(c.Name, hostname, ip)
+		s.reporter.Report(Event{Type: EventNoChange, Domain: hostname, Record: record, Source: source, OldIp: oldIp, NewIp: ip})
+		return "nochg " + ip
+	}
+
+	report := s.checker.Check(ip)
+	if err := s.policies.Check(hostname, ip); err != nil {
+		s.reportPolicy(hostname, source, oldIp, ip, err, report)
+		return "911"
+	}
+	change, err := UpdateOwnedIp(s.client, *zone.Id, domain, rtype, ip, c.Name, txt)
+	if err != nil {
+		log.Printf("Error updating %s: %v", hostname, err)
+		s.reporter.Failure(hostname, source, err)
+		return "dnserr"
+	}
+	s.registry.Seen(c.Name, hostname, ip)
+	s.registry.Updated(c.Name, hostname, oldIp, ip)
+	s.reporter.Report(Event{Type: EventChange, Domain: hostname, Record: record, Source: source, OldIp: oldIp, NewIp: ip, AddressReport: report})
+	log.Printf("Client %s updated %s %s to %s. Change: %s", c.Name, hostname, rtype, ip, *change.ChangeInfo.Id)
+	return "good " + ip
+}
+
+// ExpireClients runs forever, checking for clients that have stopped
+// checking in. Depending on the registry config they either just get logged
+// or have their records removed too.
+func (s *Server) ExpireClients(interval time.Duration) {
+	for range time.Tick(interval) {
+		for name, st := range s.registry.Expired(time.Now()) {
+			log.Printf("Client %s has not checked in since %s", name, st.LastSeen.Format(time.RFC3339))
+			for hostname, ip := range st.Hostnames {
+				s.reporter.Report(Event{
+					Type:   EventClientExpired,
+					Domain: hostname,
+					Source: "client:" + name,
+					OldIp:  ip,
+					Error:  fmt.Sprintf("client %s has not checked in since %s", name, st.LastSeen.Format(time.RFC3339)),
+				})
+			}
+			if s.registry.conf.ExpireAction != "remove" {
+				continue
+			}
+			for hostname := range st.Hostnames {
+				s.removeClientHost(name, hostname)
+			}
+		}
+	}
+}
+
+func (s *Server) removeClientHost(name string, hostname string) {
+	c, ok := s.registry.Client(name)
+	if !ok {
+		log.Printf("Client %s is no longer configured, leaving %s alone", name, hostname)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	domain := hostname + "."
+	zone, err := s.zones.Zone(route53update.NormalizeHostname(c.Zone) + ".")
+	if err != nil {
+		log.Printf("Failed to find zone for %s: %v", hostname, err)
+		return
+	}
+	a, aaaa, txt, err := GetOwnedRecords(s.client, *zone.Id, domain)
+	if err != nil {
+		log.Printf("Error checking records for %s: %v", hostname, err)
+		return
+	}
+	if RecordOwner(txt) != name {
+		log.Printf("Record %s is not owned by %s anymore, leaving it alone", hostname, name)
+		return
+	}
+	if _, err := DeleteRecords(s.client, *zone.Id, "client "+name+" expired", a, aaaa, txt); err != nil {
+		log.Printf("Error removing %s for expired client %s: %v", hostname, name, err)
+		return
+	}
+	log.Printf("Removed %s for expired client %s", hostname, name)
+}
+
+// The hosted zone for a hostname, skipping the lookup if it's one of the
+// domains with a zone_id. A domain with private_zone or a vpc_id goes in
+// the private zone the same way it does for the watcher, except a split
+// horizon one, whose public records are the ones anyone sends us.
+func (s *Server) zoneFor(hostname string, zone string) (*types.HostedZone, error) {
+	d := s.domains[hostname]
+	if d.ZoneId != "" {
+		return &types.HostedZone{Id: aws.String(d.ZoneId)}, nil
+	}
+	if (d.PrivateZone || d.VPCId != "") && !d.SplitHorizon {
+		if zone == "" {
+			zone = d.Zone
+		}
+		return s.zones.Private(hostname, zone, d.VPCId)
+	}
+	return s.zones.For(hostname, zone)
+}
+
+// The TTL a record the server sets should have. The server doesn't keep the
+// history the short after change TTL needs, so it's always the normal one,
+// and the watcher shortens it for domains it also looks after.
+func (s *Server) ttl(hostname string) int64 {
+	t := s.ttls
+	if d := s.domains[hostname].TTL; d > 0 {
+		t.Normal = d
+	}
+	return t.For(false)
+}
+
+// Whether a record already has the TTL it should, a missing one counts since
+// it's getting created with the right one anyway.
+func ttlMatches(rec *types.ResourceRecordSet, ttl int64) bool {
+	return rec == nil || rec.TTL == nil || *rec.TTL == ttl
+}
+
+// Update a single hostname for an authenticated user, returning the dyndns2
+// response code for it.
+func (s *Server) updateHost(username string, records map[string]RecordConfig, hostname string, ip string) string {
+	hostname = route53update.NormalizeHostname(hostname)
+	if !strings.Contains(hostname, ".") {
+		return "notfqdn"
+	}
+	rec, ok := records[hostname]
+	if !ok {
+		log.Printf("User %s is not allowed to update %s", username, hostname)
+		return "nohost"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	source := "user:" + username
+	if p := s.providers[s.domains[hostname].Provider]; p != nil {
+		return s.updateHostWithProvider(p, username, hostname, ip)
+	}
+
+	domain := hostname + "."
+	zone, err := s.zoneFor(hostname, rec.Zone)
+	if err != nil {
+		log.Printf("Failed to find zone for %s: %v", hostname, err)
+		s.reporter.Failure(hostname, source, err)
+		return "dnserr"
+	}
+
+	rtype, record := addressType(ip), addressRecord(ip)
+	ttl := s.ttl(hostname)
+	existing, err := route53update.GetRecord(s.client, *zone.Id, domain, rtype)
+	if err != nil && !errors.Is(err, route53update.ErrRecordNotFound) {
+		log.Printf("Error checking configured ip for %s: %v", hostname, err)
+		s.reporter.Failure(hostname, source, err)
+		return "dnserr"
+	}
+	configuredIp := ""
+	if existing != nil {
+		if configuredIp, err = route53update.RecordIp(existing); err != nil {
+			log.Printf("Not updating %s for user %s: %v", hostname, username, err)
+			s.reporter.Failure(hostname, source, err)
+			return "nohost"
+		}
+	}
+	if configuredIp == ip {
+		// Same address, but the TTL still gets brought in line with the
+		// config. The client only cares that the address is right, so
+		// failing that is just logged.
+		if !ttlMatches(existing, ttl) {
+			if _, err := route53update.UpdateRecIpTTL(s.client, *zone.Id, domain, rtype, ip, ttl); err != nil {
+				log.Printf("Failed to change TTL on %s: %v", hostname, err)
+			} else {
+				log.Printf("Changed %s TTL on %s from %d to %d", rtype, hostname, *existing.TTL, ttl)
+			}
+		}
+		s.reporter.Report(Event{Type: EventNoChange, Domain: hostname, Record: record, Source: source, OldIp: configuredIp, NewIp: ip})
+		return "nochg " + ip
+	}
+
+	report := s.checker.Check(ip)
+	if err := s.policies.Check(hostname, ip); err != nil {
+		s.reportPolicy(hostname, source, configuredIp, ip, err, report)
+		return "911"
+	}
+	change, err := route53update.UpdateRecIpTTL(s.client, *zone.Id, domain, rtype, ip, ttl)
+	if err != nil {
+		log.Printf("Error updating %s: %v", hostname, err)
+		s.reporter.Failure(hostname, source, err)
+		return "dnserr"
+	}
+	s.reporter.Report(Event{Type: EventChange, Domain: hostname, Record: record, Source: source, OldIp: configuredIp, NewIp: ip, TTL: ttl, AddressReport: report})
+	log.Printf("User %s updated %s %s to %s. Change: %s", username, hostname, rtype, ip, *change.ChangeInfo.Id)
+	return "good " + ip
+}
+
+// The same for a hostname that's also a domain in the config on another
+// provider, like the helper.
+func (s *Server) updateHostWithProvider(p DNSProvider, username string, hostname string, ip string) string {
+	source := "user:" + username
+	rtype, record := addressType(ip), addressRecord(ip)
+	configuredIp, err := p.GetRecord(hostname, rtype)
+	if err != nil && !errors.Is(err, route53update.ErrRecordNotFound) {
+		log.Printf("Error checking configured ip for %s: %v", hostname, err)
+		s.reporter.Failure(hostname, source, err)
+		return "dnserr"
+	}
+	if configuredIp == ip {
+		s.reporter.Report(Event{Type: EventNoChange, Domain: hostname, Record: record, Source: source, OldIp: configuredIp, NewIp: ip})
+		return "nochg " + ip
+	}
+
+	report := s.checker.Check(ip)
+	if err := s.policies.Check(hostname, ip); err != nil {
+		s.reportPolicy(hostname, source, configuredIp, ip, err, report)
+		return "911"
+	}
+	ttl := s.ttl(hostname)
+	if err := p.SetRecord(hostname, rtype, ip, ttl); err != nil {
+		log.Printf("Error updating %s: %v", hostname, err)
+		s.reporter.Failure(hostname, source, err)
+		return "dnserr"
+	}
+	s.reporter.Report(Event{Type: EventChange, Domain: hostname, Record: record, Source: source, OldIp: configuredIp, NewIp: ip, TTL: ttl, AddressReport: report})
+	log.Printf("User %s updated %s %s to %s at %s", username, hostname, rtype, ip, s.domains[hostname].Provider)
+	return "good " + ip
+}
+
+// Approval links get opened by people, but also by mail scanners checking
+// every link in a message, so opening the link just shows what it would do
+// and only the POST from the button actually changes anything.
+func (s *Server) handleApprove(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	domain, record, ip, action, err := s.approvals.Verify(query, time.Now())
+	if err != nil {
+		log.Printf("Rejected approval from %s: %v", r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	noteAccess(r, "approval", domain)
+	name := domain
+	if record != "" {
+		name += " " + record
+	}
+
+	// Following the link just asks, mail scanners that open every link
+	// shouldn't be able to approve or reject anything
+	if r.Method != http.MethodPost {
+		if done, ok := s.approvals.Used(query); ok {
+			http.Error(w, fmt.Sprintf("Setting %s to %s has already been %s", name, ip, done), http.StatusConflict)
+			return
+		}
+		question, button := "Set %s to %s?", "Approve"
+		if action == "reject" {
+			question, button = "Reject setting %s to %s?", "Reject"
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, "<!DOCTYPE html>\n<title>route53Update</title>\n<form method=\"post\" action=\"%s\">\n<p>%s</p>\n<button type=\"submit\">%s</button>\n</form>\n",
+			html.EscapeString(r.URL.RequestURI()), html.EscapeString(fmt.Sprintf(question, name, ip)), button)
+		return
+	}
+
+	if err := s.approvals.Use(query, action); err != nil {
+		log.Printf("Refused approval link for %s from %s: %v", name, r.RemoteAddr, err)
+		if errors.Is(err, errApprovalUsed) {
+			http.Error(w, err.Error(), http.StatusConflict)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if action == "reject" {
+		s.reporter.Report(Event{Type: EventRejected, Domain: domain, Record: record, Source: "approval", NewIp: ip})
+		log.Printf("Rejected update of %s to %s", name, ip)
+		fmt.Fprintf(w, "%s left alone, %s rejected\n", name, ip)
+		return
+	}
+	if err := s.approveUpdate(domain, record, ip); err != nil {
+		// Nothing changed, so the link can be tried again, and a stale
+		// address will just get turned down again
+		s.approvals.Unuse(query)
+		log.Printf("Approved update of %s failed: %v", name, err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	fmt.Fprintf(w, "%s set to %s\n", name, ip)
+}
+
+// Make the change someone approved. Like the watcher, the zone comes from
+// the ZoneCache, the domain's zone id or zone from the config if it has
+// one, otherwise the closest hosted zone the name is in, and the private
+// zone for private domains and LAN addresses.
+func (s *Server) approveUpdate(domain string, record string, ip string) error {
+	name := route53update.NormalizeHostname(domain)
+	// The watcher holds split horizon LAN addresses too, as "LAN A" and
+	// "LAN AAAA", and those go in the private zone
+	kind := strings.TrimPrefix(record, "LAN ")
+	lan := kind != record
+	var rtype types.RRType
+	switch {
+	case kind == string(types.RRTypeAaaa):
+		rtype = types.RRTypeAaaa
+	case kind == "" && !lan, kind == string(types.RRTypeA) && lan:
+		rtype = types.RRTypeA
+	default:
+		return fmt.Errorf("Can't approve %s records", record)
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil || (parsed.To4() != nil) != (rtype == types.RRTypeA) {
+		return fmt.Errorf("%s isn't a usable %s address", ip, rtype)
+	}
+	// Approving a change doesn't get it past the policies. They're about
+	// public addresses, so like the watcher LAN addresses skip them.
+	if !lan {
+		if err := s.policies.Check(domain, ip); err != nil {
+			return fmt.Errorf("Blocked by policy: %v", err)
+		}
+	}
+	// The address could have moved on since the alert went out, and the
+	// one in the link isn't worth putting back
+	current, err := currentAddress(rtype, lan, s.domains[name].LANInterface)
+	if err != nil {
+		return fmt.Errorf("Can't check the address is still %s: %v", ip, err)
+	}
+	if current != ip {
+		return fmt.Errorf("The address is %s now, not %s, so this approval is out of date", current, ip)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p := s.providers[s.domains[name].Provider]; p != nil && !lan {
+		return s.approveWithProvider(p, domain, record, rtype, ip)
+	}
+
+	fqdn := name + "."
+	var zone *types.HostedZone
+	if lan {
+		zone, err = s.zones.Private(name, s.domains[name].Zone, s.domains[name].VPCId)
+	} else {
+		zone, err = s.zoneFor(name, s.domains[name].Zone)
+	}
+	if err != nil {
+		return err
+	}
+	ttl := s.ttl(name)
+	rec, err := route53update.GetRecord(s.client, *zone.Id, fqdn, rtype)
+	if err != nil && !errors.Is(err, route53update.ErrRecordNotFound) {
+		return err
+	}
+	configuredIp := ""
+	if rec != nil {
+		if configuredIp, err = route53update.RecordIp(rec); err != nil {
+			return err
+		}
+	}
+	if configuredIp == ip && ttlMatches(rec, ttl) {
+		return nil
+	}
+	change, err := route53update.UpdateRecIpTTL(s.client, *zone.Id, fqdn, rtype, ip, ttl)
+	if err != nil {
+		s.reporter.Report(Event{Type: EventFailure, Domain: domain, Record: record, Source: "approval", Error: err.Error()})
+		return err
+	}
+	s.reporter.Report(Event{Type: EventChange, Domain: domain, Record: record, Source: "approval", OldIp: configuredIp, NewIp: ip, TTL: ttl})
+	log.Printf("Approved update of %s %s to %s. Change: %s", domain, rtype, ip, *change.ChangeInfo.Id)
+	return nil
+}
+
+// The address of the family the watcher would find right now, the public
+// one or the one on the LAN interface.
+func currentAddress(rtype types.RRType, lan bool, iface string) (string, error) {
+	if lan {
+		return route53update.LANIP(iface, rtype == types.RRTypeAaaa)
+	}
+	if rtype == types.RRTypeAaaa {
+		return route53update.PublicIPv6()
+	}
+	return route53update.PublicIPv4()
+}
+
+func (s *Server) approveWithProvider(p DNSProvider, domain string, record string, rtype types.RRType, ip string) error {
+	name := route53update.NormalizeHostname(domain)
+	configuredIp, err := p.GetRecord(name, rtype)
+	if err != nil && !errors.Is(err, route53update.ErrRecordNotFound) {
+		return err
+	}
+	if configuredIp == ip {
+		return nil
+	}
+	ttl := s.ttl(name)
+	if err := p.SetRecord(name, rtype, ip, ttl); err != nil {
+		s.reporter.Report(Event{Type: EventFailure, Domain: domain, Record: record, Source: "approval", Error: err.Error()})
+		return err
+	}
+	s.reporter.Report(Event{Type: EventChange, Domain: domain, Record: record, Source: "approval", OldIp: configuredIp, NewIp: ip, TTL: ttl})
+	log.Printf("Approved update of %s %s to %s at %s", domain, rtype, ip, s.domains[name].Provider)
+	return nil
+}
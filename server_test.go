@@ -0,0 +1,260 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/mikerowehl/route53Update/route53update"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// A zone cache that already knows the public example.com zone and the
+// private one for home.example.com, so nothing gets looked up.
+func testZoneCache() *ZoneCache {
+	zones := NewZoneCache(nil)
+	zones.zones["example.com."] = types.HostedZone{Id: aws.String("/hostedzone/PUBLIC"), Name: aws.String("example.com.")}
+	zones.within["home.example.com."] = "example.com."
+	zones.within["www.example.com."] = "example.com."
+	zones.private[" home.example.com."] = types.HostedZone{Id: aws.String("/hostedzone/PRIVATE"), Name: aws.String("example.com.")}
+	zones.private["vpc-1 home.example.com."] = types.HostedZone{Id: aws.String("/hostedzone/VPC1"), Name: aws.String("example.com.")}
+	return zones
+}
+
+func TestServerZoneFor(t *testing.T) {
+	tests := []struct {
+		name   string
+		domain DomainConfig
+		want   string
+	}{
+		{"public", DomainConfig{}, "/hostedzone/PUBLIC"},
+		{"zone id", DomainConfig{ZoneId: "/hostedzone/GIVEN"}, "/hostedzone/GIVEN"},
+		{"private", DomainConfig{PrivateZone: true}, "/hostedzone/PRIVATE"},
+		{"vpc", DomainConfig{VPCId: "vpc-1"}, "/hostedzone/VPC1"},
+		{"split horizon", DomainConfig{PrivateZone: true, SplitHorizon: true}, "/hostedzone/PUBLIC"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{zones: testZoneCache(), domains: map[string]DomainConfig{"home.example.com": tt.domain}}
+			zone, err := s.zoneFor("home.example.com", "")
+			if err != nil {
+				t.Fatalf("zoneFor: %v", err)
+			}
+			if got := aws.ToString(zone.Id); got != tt.want {
+				t.Errorf("Zone is %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApproveUpdateRecords(t *testing.T) {
+	tests := []struct {
+		record string
+		ip     string
+		want   string
+	}{
+		{"MX", "203.0.113.1", "Can't approve MX records"},
+		{"A", "203.0.113.1", "Can't approve A records"},
+		{"LAN", "192.168.1.10", "Can't approve LAN records"},
+		{"LAN MX", "192.168.1.10", "Can't approve LAN MX records"},
+		{"", "2001:db8::1", "isn't a usable A address"},
+		{"LAN A", "fd00::10", "isn't a usable A address"},
+		{"LAN AAAA", "192.168.1.10", "isn't a usable AAAA address"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.record, func(t *testing.T) {
+			s := &Server{zones: testZoneCache(), domains: map[string]DomainConfig{}}
+			err := s.approveUpdate("home.example.com", tt.record, tt.ip)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Got %v, want an error with %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// A provider that keeps records in a map, so updates can go through the
+// server without Route53.
+type testProvider map[string]string
+
+func (p testProvider) GetRecord(domain string, rtype types.RRType) (string, error) {
+	if ip, ok := p[domain+" "+string(rtype)]; ok {
+		return ip, nil
+	}
+	return "", route53update.ErrRecordNotFound
+}
+
+func (p testProvider) SetRecord(domain string, rtype types.RRType, ip string, ttl int64) error {
+	p[domain+" "+string(rtype)] = ip
+	return nil
+}
+
+// A server with one user, alice, who can update home and office in
+// example.com, both kept by a testProvider.
+func testServer(t *testing.T) (*Server, testProvider) {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := NewCredentialStore([]UserConfig{{
+		Username: "alice",
+		Password: string(hash),
+		Records:  []RecordConfig{{Name: "home.example.com"}, {Name: "office.example.com"}},
+	}})
+	if err != nil {
+		t.Fatalf("NewCredentialStore: %v", err)
+	}
+	reporter, _ := NewReporter(nil)
+	s := NewServer(nil, testZoneCache(), creds, nil, reporter, &AddressChecker{}, nil)
+	p := testProvider{"home.example.com A": "203.0.113.1"}
+	s.providers = map[string]DNSProvider{"test": p}
+	s.domains = map[string]DomainConfig{
+		"home.example.com":   {Provider: "test"},
+		"office.example.com": {Provider: "test"},
+	}
+	return s, p
+}
+
+// Send a request to the server, with basic auth unless the user is empty,
+// and return the status and the body.
+func serverRequest(t *testing.T, s *Server, user string, password string, query string) (int, string) {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/nic/update?"+query, nil)
+	r.RemoteAddr = "198.51.100.7:40000"
+	if user != "" {
+		r.SetBasicAuth(user, password)
+	}
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	body, _ := io.ReadAll(w.Result().Body)
+	return w.Code, string(body)
+}
+
+func TestServerUpdate(t *testing.T) {
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		query    string
+		status   int
+		want     string
+	}{
+		{"no auth", "", "", "hostname=home.example.com&myip=203.0.113.2", http.StatusUnauthorized, "badauth\n"},
+		{"wrong password", "alice", "wrong", "hostname=home.example.com&myip=203.0.113.2", http.StatusUnauthorized, "badauth\n"},
+		{"unknown user", "bob", "secret", "hostname=home.example.com&myip=203.0.113.2", http.StatusUnauthorized, "badauth\n"},
+		{"no hostname", "alice", "secret", "myip=203.0.113.2", http.StatusOK, "notfqdn\n"},
+		{"not a fqdn", "alice", "secret", "hostname=home&myip=203.0.113.2", http.StatusOK, "notfqdn\n"},
+		{"not allowed", "alice", "secret", "hostname=www.example.com&myip=203.0.113.2", http.StatusOK, "nohost\n"},
+		{"good", "alice", "secret", "hostname=home.example.com&myip=203.0.113.2", http.StatusOK, "good 203.0.113.2\n"},
+		{"nochg", "alice", "secret", "hostname=home.example.com&myip=203.0.113.1", http.StatusOK, "nochg 203.0.113.1\n"},
+		{"from the request address", "alice", "secret", "hostname=home.example.com", http.StatusOK, "good 198.51.100.7\n"},
+		{
+			"multiple hostnames", "alice", "secret", "hostname=home.example.com,office.example.com,www.example.com&myip=203.0.113.1", http.StatusOK,
+			"nochg 203.0.113.1\ngood 203.0.113.1\nnohost\n",
+		},
+		{"too many hostnames", "alice", "secret", "hostname=" + strings.Repeat("home.example.com,", maxHostsPerRequest) + "home.example.com&myip=203.0.113.2", http.StatusOK, "numhost\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := testServer(t)
+			status, body := serverRequest(t, s, tt.user, tt.password, tt.query)
+			if status != tt.status || body != tt.want {
+				t.Errorf("Got %d %q, want %d %q", status, body, tt.status, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestIps(t *testing.T) {
+	tests := []struct {
+		myip string
+		want string
+		ok   bool
+	}{
+		{"", "198.51.100.7", true},
+		{"203.0.113.2", "203.0.113.2", true},
+		{"2001:db8::7", "2001:db8::7", true},
+		{"203.0.113.2,2001:db8::7", "203.0.113.2,2001:db8::7", true},
+		{"2001:db8::7, 203.0.113.2", "2001:db8::7,203.0.113.2", true},
+		{"::ffff:203.0.113.2", "203.0.113.2", true},
+		{"203.0.113.2,203.0.113.3", "", false},
+		{"2001:db8::7,2001:db8::8", "", false},
+		{"203.0.113.2,nonsense", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.myip, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/nic/update?myip="+url.QueryEscape(tt.myip), nil)
+			r.RemoteAddr = "198.51.100.7:40000"
+			ips, ok := requestIps(r)
+			if ok != tt.ok || strings.Join(ips, ",") != tt.want {
+				t.Errorf("Got %v %v, want %s %v", ips, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+// Both families in one request go in their own records, and the answer
+// covers both.
+func TestServerUpdateBothFamilies(t *testing.T) {
+	tests := []struct {
+		name  string
+		myip  string
+		want  string
+		after map[string]string
+	}{
+		{
+			"both change", "203.0.113.2,2001:db8::7", "good 203.0.113.2,2001:db8::7\n",
+			map[string]string{"home.example.com A": "203.0.113.2", "home.example.com AAAA": "2001:db8::7"},
+		},
+		{
+			"only AAAA changes", "203.0.113.1,2001:db8::7", "good 203.0.113.1,2001:db8::7\n",
+			map[string]string{"home.example.com A": "203.0.113.1", "home.example.com AAAA": "2001:db8::7"},
+		},
+		{
+			"neither changes", "203.0.113.1,2001:db8::1", "nochg 203.0.113.1,2001:db8::1\n",
+			map[string]string{"home.example.com A": "203.0.113.1", "home.example.com AAAA": "2001:db8::1"},
+		},
+		{
+			"two of one family", "203.0.113.2,203.0.113.3", "911\n",
+			map[string]string{"home.example.com A": "203.0.113.1", "home.example.com AAAA": "2001:db8::1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, p := testServer(t)
+			p["home.example.com AAAA"] = "2001:db8::1"
+			_, body := serverRequest(t, s, "alice", "secret", "hostname=home.example.com&myip="+tt.myip)
+			if body != tt.want {
+				t.Errorf("Got %q, want %q", body, tt.want)
+			}
+			for key, ip := range tt.after {
+				if p[key] != ip {
+					t.Errorf("%s is %s, want %s", key, p[key], ip)
+				}
+			}
+		})
+	}
+}
+
+func TestCombineAnswers(t *testing.T) {
+	tests := []struct {
+		answers []string
+		want    string
+	}{
+		{[]string{"good 203.0.113.2"}, "good 203.0.113.2"},
+		{[]string{"nochg 203.0.113.2", "good 2001:db8::7"}, "good 203.0.113.2,2001:db8::7"},
+		{[]string{"nochg 203.0.113.2", "nochg 2001:db8::7"}, "nochg 203.0.113.2,2001:db8::7"},
+		{[]string{"good 203.0.113.2", "dnserr"}, "dnserr"},
+		{[]string{"911", "good 2001:db8::7"}, "911"},
+	}
+	for _, tt := range tests {
+		if got := combineAnswers(tt.answers); got != tt.want {
+			t.Errorf("combineAnswers(%q) is %q, want %q", tt.answers, got, tt.want)
+		}
+	}
+}
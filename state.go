@@ -0,0 +1,385 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// State that needs to stick around, the history file, the registry state,
+// the paused names, and locks, normally lives in local files. That doesn't
+// work for containers that get thrown away or Lambda functions that never
+// run in the same place twice, so any of those paths can point at another
+// store instead, picked by how the path starts:
+//
+//	history: s3://my-bucket/route53update/history.jsonl
+//	state: dynamodb://route53update/state.json
+//	locks: sqlite:///var/lib/route53update/state.db#locks
+//
+// S3 takes a bucket and key. DynamoDB takes a table and key, where the
+// table has a string partition key called key and a string sort key called
+// part. SQLite takes the database file and, after the #, the key to keep
+// things under, and creates its table the first time.
+//
+// Writes are conditional on what's there not having changed since it was
+// read, so several copies running at once can't lose each other's updates,
+// they just retry. The same conditional writes make locks work, see
+// AcquireLock. Setting locks to a directory or a prefix in another store
+// has each check of a domain take a lock first, so two copies never update
+// the same record at once.
+
+// Store is somewhere state can be kept. Keys are the whole path, scheme and
+// all, so a store can tell which bucket, table, or database they're for.
+type Store interface {
+	// Get returns what's under a key and a version to pass to Put, or an
+	// error matching fs.ErrNotExist if there's nothing there.
+	Get(key string) ([]byte, string, error)
+
+	// Put replaces what's under a key if it's still the version that was
+	// read, or still doesn't exist if version is empty, and returns
+	// errStateConflict if not.
+	Put(key string, data []byte, version string) error
+
+	// Delete removes a key, if it's still the version that was read.
+	Delete(key string, version string) error
+
+	// Append adds a line to the end of what's under a key.
+	Append(key string, line []byte) error
+
+	// Lock takes a named lock, see AcquireLock.
+	Lock(key string, holder string, ttl time.Duration) (func(), error)
+}
+
+var errStateConflict = errors.New("state was changed by someone else")
+
+// errLocked means someone else is holding a lock, as opposed to the lock
+// itself not working.
+var errLocked = errors.New("locked")
+
+// The AWS config for S3 and DynamoDB state comes from the config file,
+// which is loaded before anything touches state. Stores get made the first
+// time a path needs them.
+var (
+	stateConfig   *Config
+	stateStoresMu sync.Mutex
+	stateStores   = map[string]Store{}
+)
+
+// storeFor picks the store for a state path.
+func storeFor(path string) (Store, error) {
+	scheme, _, ok := strings.Cut(path, "://")
+	if !ok {
+		scheme = "file"
+	}
+	name := scheme
+	if scheme == "sqlite" {
+		// Each database file is its own store
+		file, _, err := parseSQLitePath(path)
+		if err != nil {
+			return nil, err
+		}
+		name += ":" + file
+	}
+
+	stateStoresMu.Lock()
+	defer stateStoresMu.Unlock()
+	if store, ok := stateStores[name]; ok {
+		return store, nil
+	}
+	var store Store
+	var err error
+	switch scheme {
+	case "file":
+		store = fileStore{}
+	case "s3":
+		store, err = newS3Store(stateConfig)
+	case "dynamodb":
+		store, err = newDynamoDBStore(stateConfig)
+	case "sqlite":
+		file, _, _ := parseSQLitePath(path)
+		store, err = newSQLiteStore(file)
+	default:
+		return nil, fmt.Errorf("Unknown state store %s in %s", scheme, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	stateStores[name] = store
+	return store, nil
+}
+
+// readState returns what's at a state path and a version to pass to
+// writeState, or an error matching fs.ErrNotExist if there's nothing there.
+func readState(path string) ([]byte, string, error) {
+	store, err := storeFor(path)
+	if err != nil {
+		return nil, "", err
+	}
+	return store.Get(path)
+}
+
+// writeState replaces what's at a state path, if it's still the version
+// that was read.
+func writeState(path string, data []byte, version string) error {
+	store, err := storeFor(path)
+	if err != nil {
+		return err
+	}
+	return store.Put(path, data, version)
+}
+
+// deleteState removes a state path, if it's still the version that was
+// read.
+func deleteState(path string, version string) error {
+	store, err := storeFor(path)
+	if err != nil {
+		return err
+	}
+	return store.Delete(path, version)
+}
+
+// appendState adds a line to the end of a state path.
+func appendState(path string, line []byte) error {
+	store, err := storeFor(path)
+	if err != nil {
+		return err
+	}
+	return store.Append(path, line)
+}
+
+// For stores that can't append, a read and a conditional write, starting
+// over if someone else got a write in first.
+var stateAppendMu sync.Mutex
+
+func appendByRewrite(store Store, key string, line []byte) error {
+	stateAppendMu.Lock()
+	defer stateAppendMu.Unlock()
+
+	for attempt := 1; ; attempt++ {
+		data, version, err := store.Get(key)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		err = store.Put(key, append(data, line...), version)
+		if err != errStateConflict || attempt == 5 {
+			return err
+		}
+		time.Sleep(time.Duration(attempt) * 200 * time.Millisecond)
+	}
+}
+
+// Local files, the way state has always been kept.
+type fileStore struct{}
+
+// The version of a local file is a hash of what's in it, which changes with
+// any write no matter how close together, unlike the mtime.
+func fileVersion(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
+
+func (fileStore) Get(path string) ([]byte, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, fileVersion(data), nil
+}
+
+// Whether the file is still the version that was read, with the flock held.
+func checkFileVersion(path string, version string) error {
+	current, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		if version != "" {
+			return errStateConflict
+		}
+		return nil
+	case err != nil:
+		return err
+	case fileVersion(current) != version:
+		return errStateConflict
+	}
+	return nil
+}
+
+// Local files get written to a temp file of their own and moved into place,
+// as long as what's there is still the version that was read.
+func (fileStore) Put(path string, data []byte, version string) error {
+	unlock, err := flockState(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := checkFileVersion(path, version); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (fileStore) Delete(path string, version string) error {
+	unlock, err := flockState(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := checkFileVersion(path, version); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func (fileStore) Append(path string, line []byte) error {
+	stateAppendMu.Lock()
+	defer stateAppendMu.Unlock()
+	unlock, err := flockState(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(line)
+	return err
+}
+
+func (s fileStore) Lock(path string, holder string, ttl time.Duration) (func(), error) {
+	return takeLock(s, path, holder, ttl, createLockFile)
+}
+
+// A check that takes longer than this has probably died, and its lock is
+// fair game.
+const lockTTL = 5 * time.Minute
+
+// Who's holding a lock, for the error when someone else wants it.
+func lockHolder() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s/%d", host, os.Getpid())
+}
+
+type lockState struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+// AcquireLock takes a named lock, for making sure only one copy at a time
+// updates a record. A lock is an object that only gets created if it's not
+// already there. Locks left behind by something that died expire after ttl
+// and get taken over. Returns the function that releases the lock.
+func AcquireLock(path string, holder string, ttl time.Duration) (func(), error) {
+	store, err := storeFor(path)
+	if err != nil {
+		return nil, err
+	}
+	return store.Lock(path, holder, ttl)
+}
+
+// Creates a lock, replacing an expired one if exists is set, and returns
+// errStateConflict if someone else got there first.
+type lockCreator func(path string, data []byte, version string, exists bool) error
+
+// Stores with conditional writes lock with a plain Put.
+func putLock(store Store) lockCreator {
+	return func(path string, data []byte, version string, exists bool) error {
+		return store.Put(path, data, version)
+	}
+}
+
+func takeLock(store Store, path string, holder string, ttl time.Duration, create lockCreator) (func(), error) {
+	for attempt := 1; ; attempt++ {
+		data, version, err := store.Get(path)
+		exists := err == nil
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+		case err != nil:
+			return nil, fmt.Errorf("Failed to check lock %s: %v", path, err)
+		default:
+			var current lockState
+			if json.Unmarshal(data, &current) == nil && time.Now().Before(current.Expires) {
+				return nil, fmt.Errorf("%w: %s is held by %s until %s", errLocked, path, current.Holder, current.Expires.Format(time.RFC3339))
+			}
+			// Expired, take it over from the version we saw
+		}
+
+		data, _ = json.Marshal(lockState{Holder: holder, Expires: time.Now().Add(ttl)})
+		err = create(path, data, version, exists)
+		if err == nil {
+			return func() {
+				current, v, err := store.Get(path)
+				var st lockState
+				if err == nil && json.Unmarshal(current, &st) == nil && st.Holder == holder {
+					store.Delete(path, v)
+				}
+			}, nil
+		}
+		if err == errStateConflict && attempt == 3 {
+			return nil, fmt.Errorf("%w: %s keeps getting taken by someone else", errLocked, path)
+		}
+		if err != errStateConflict {
+			return nil, fmt.Errorf("Failed to take lock %s: %v", path, err)
+		}
+	}
+}
+
+// Local locks use an exclusive create. Taking over an expired one happens
+// under a flock, and only if it's still expired once we have that, so two
+// copies that both saw it run out can't both remove it and make their own.
+func createLockFile(path string, data []byte, version string, expired bool) error {
+	unlock, err := flockState(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if expired {
+		current, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		var st lockState
+		if err == nil && json.Unmarshal(current, &st) == nil && time.Now().Before(st.Expires) {
+			return errStateConflict
+		}
+		if err == nil {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if errors.Is(err, fs.ErrExist) {
+		return errStateConflict
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(data)
+	return err
+}
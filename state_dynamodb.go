@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// State in a DynamoDB table. Whatever gets Put is one item with part "0"
+// and a random version that changes with every write. Items are limited to
+// 400KB, too small for a history that keeps growing, so each appended line
+// is an item of its own with a part that sorts by when it was added, and a
+// Get puts them all back together.
+type dynamoDBStore struct {
+	client *dynamodb.Client
+}
+
+func newDynamoDBStore(conf *Config) (*dynamoDBStore, error) {
+	cfg, _, err := LoadAWSConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	return &dynamoDBStore{client: dynamodb.NewFromConfig(cfg)}, nil
+}
+
+// Paths look like dynamodb://table/key.
+func parseDynamoDBPath(path string) (table string, key string, err error) {
+	table, key, ok := strings.Cut(strings.TrimPrefix(path, "dynamodb://"), "/")
+	if !ok || table == "" || key == "" {
+		return "", "", fmt.Errorf("%s should look like dynamodb://table/key", path)
+	}
+	return table, key, nil
+}
+
+const dynamoDBWholePart = "0"
+
+func newStateVersion() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (s *dynamoDBStore) Get(path string) ([]byte, string, error) {
+	table, key, err := parseDynamoDBPath(path)
+	if err != nil {
+		return nil, "", err
+	}
+	paginator := dynamodb.NewQueryPaginator(s.client, &dynamodb.QueryInput{
+		TableName:                 aws.String(table),
+		KeyConditionExpression:    aws.String("#k = :k"),
+		ExpressionAttributeNames:  map[string]string{"#k": "key"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":k": &types.AttributeValueMemberS{Value: key}},
+		ConsistentRead:            aws.Bool(true),
+	})
+	var data []byte
+	var version string
+	found := false
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, "", err
+		}
+		for _, item := range page.Items {
+			found = true
+			if b, ok := item["data"].(*types.AttributeValueMemberB); ok {
+				data = append(data, b.Value...)
+			}
+			if part, ok := item["part"].(*types.AttributeValueMemberS); ok && part.Value == dynamoDBWholePart {
+				if v, ok := item["version"].(*types.AttributeValueMemberS); ok {
+					version = v.Value
+				}
+			}
+		}
+	}
+	if !found {
+		return nil, "", fmt.Errorf("%s: %w", path, fs.ErrNotExist)
+	}
+	return data, version, nil
+}
+
+func (s *dynamoDBStore) Put(path string, data []byte, version string) error {
+	table, key, err := parseDynamoDBPath(path)
+	if err != nil {
+		return err
+	}
+	input := &dynamodb.PutItemInput{
+		TableName: aws.String(table),
+		Item: map[string]types.AttributeValue{
+			"key":     &types.AttributeValueMemberS{Value: key},
+			"part":    &types.AttributeValueMemberS{Value: dynamoDBWholePart},
+			"data":    &types.AttributeValueMemberB{Value: data},
+			"version": &types.AttributeValueMemberS{Value: newStateVersion()},
+		},
+	}
+	if version == "" {
+		input.ConditionExpression = aws.String("attribute_not_exists(#k)")
+		input.ExpressionAttributeNames = map[string]string{"#k": "key"}
+	} else {
+		input.ConditionExpression = aws.String("version = :v")
+		input.ExpressionAttributeValues = map[string]types.AttributeValue{":v": &types.AttributeValueMemberS{Value: version}}
+	}
+	_, err = s.client.PutItem(context.TODO(), input)
+	return dynamoDBWriteErr(err)
+}
+
+func (s *dynamoDBStore) Delete(path string, version string) error {
+	table, key, err := parseDynamoDBPath(path)
+	if err != nil {
+		return err
+	}
+	input := &dynamodb.DeleteItemInput{
+		TableName: aws.String(table),
+		Key: map[string]types.AttributeValue{
+			"key":  &types.AttributeValueMemberS{Value: key},
+			"part": &types.AttributeValueMemberS{Value: dynamoDBWholePart},
+		},
+	}
+	if version != "" {
+		input.ConditionExpression = aws.String("version = :v")
+		input.ExpressionAttributeValues = map[string]types.AttributeValue{":v": &types.AttributeValueMemberS{Value: version}}
+	}
+	_, err = s.client.DeleteItem(context.TODO(), input)
+	return dynamoDBWriteErr(err)
+}
+
+// Every line is its own item, so appends never conflict. The random bit on
+// the end keeps two lines added in the same instant apart.
+func (s *dynamoDBStore) Append(path string, line []byte) error {
+	table, key, err := parseDynamoDBPath(path)
+	if err != nil {
+		return err
+	}
+	part := time.Now().UTC().Format("20060102T150405.000000000") + "-" + newStateVersion()
+	_, err = s.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(table),
+		Item: map[string]types.AttributeValue{
+			"key":  &types.AttributeValueMemberS{Value: key},
+			"part": &types.AttributeValueMemberS{Value: part},
+			"data": &types.AttributeValueMemberB{Value: line},
+		},
+	})
+	return err
+}
+
+func (s *dynamoDBStore) Lock(path string, holder string, ttl time.Duration) (func(), error) {
+	return takeLock(s, path, holder, ttl, putLock(s))
+}
+
+func dynamoDBWriteErr(err error) error {
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		return errStateConflict
+	}
+	return err
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import "sync"
+
+// Without flock this only keeps goroutines in this process from getting in
+// each other's way, which is all a single copy running as a service needs.
+var flockStateMu sync.Mutex
+
+func flockState(path string) (func(), error) {
+	flockStateMu.Lock()
+	return flockStateMu.Unlock, nil
+}
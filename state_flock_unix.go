@@ -0,0 +1,27 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// flockState holds an exclusive flock on a file next to a local state
+// path, for read, check, and replace steps that have to happen without
+// another process getting in between. It can't be the state file itself,
+// since replacing that swaps in a new inode that nobody has locked.
+func flockState(path string) (func(), error) {
+	f, err := os.OpenFile(path+".flock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+// State in S3 objects, using conditional writes on the ETag.
+type s3Store struct {
+	client *s3.Client
+}
+
+func newS3Store(conf *Config) (*s3Store, error) {
+	cfg, _, err := LoadAWSConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Store{client: s3.NewFromConfig(cfg)}, nil
+}
+
+func parseS3Path(path string) (bucket string, key string, err error) {
+	u, err := url.Parse(path)
+	if err != nil || u.Host == "" || strings.TrimPrefix(u.Path, "/") == "" {
+		return "", "", fmt.Errorf("%s should look like s3://bucket/key", path)
+	}
+	return u.Host, strings.TrimPrefix(u.Path, "/"), nil
+}
+
+func (s *s3Store) Get(path string) ([]byte, string, error) {
+	bucket, key, err := parseS3Path(path)
+	if err != nil {
+		return nil, "", err
+	}
+	res, err := s.client.GetObject(context.TODO(), &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchKey" {
+			return nil, "", fmt.Errorf("%s: %w", path, fs.ErrNotExist)
+		}
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	return data, aws.ToString(res.ETag), err
+}
+
+func (s *s3Store) Put(path string, data []byte, version string) error {
+	bucket, key, err := parseS3Path(path)
+	if err != nil {
+		return err
+	}
+	input := &s3.PutObjectInput{Bucket: aws.String(bucket), Key: aws.String(key), Body: bytes.NewReader(data)}
+	if version == "" {
+		input.IfNoneMatch = aws.String("*")
+	} else {
+		input.IfMatch = aws.String(version)
+	}
+	_, err = s.client.PutObject(context.TODO(), input)
+	return s3WriteErr(err)
+}
+
+func (s *s3Store) Delete(path string, version string) error {
+	bucket, key, err := parseS3Path(path)
+	if err != nil {
+		return err
+	}
+	input := &s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}
+	if version != "" {
+		input.IfMatch = aws.String(version)
+	}
+	_, err = s.client.DeleteObject(context.TODO(), input)
+	return s3WriteErr(err)
+}
+
+// S3 can't append, so it's a read and a conditional write.
+func (s *s3Store) Append(path string, line []byte) error {
+	return appendByRewrite(s, path, line)
+}
+
+func (s *s3Store) Lock(path string, holder string, ttl time.Duration) (func(), error) {
+	return takeLock(s, path, holder, ttl, putLock(s))
+}
+
+// S3 says a condition failed with 412, or 409 if another conditional write
+// to the same key was happening at the same moment.
+func s3WriteErr(err error) error {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "PreconditionFailed", "ConditionalRequestConflict":
+			return errStateConflict
+		}
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// State in a SQLite database, for a single machine that wants everything in
+// one file, or a few processes sharing a disk. The version is a counter
+// that goes up with every write.
+type sqliteStore struct {
+	db *sql.DB
+}
+
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS state (
+	key TEXT PRIMARY KEY,
+	data BLOB NOT NULL,
+	version INTEGER NOT NULL
+)`
+
+func newSQLiteStore(file string) (*sqliteStore, error) {
+	// Wait on other processes writing instead of failing right away
+	db, err := sql.Open("sqlite3", file+"?_busy_timeout=5000")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open %s: %v", file, err)
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Failed to set up %s: %v", file, err)
+	}
+	return &sqliteStore{db: db}, nil
+}
+
+// Paths look like sqlite:///path/to/state.db#key.
+func parseSQLitePath(path string) (file string, key string, err error) {
+	file, key, ok := strings.Cut(strings.TrimPrefix(path, "sqlite://"), "#")
+	if !ok || file == "" || key == "" {
+		return "", "", fmt.Errorf("%s should look like sqlite:///path/to/file.db#key", path)
+	}
+	return file, key, nil
+}
+
+func (s *sqliteStore) Get(path string) ([]byte, string, error) {
+	_, key, err := parseSQLitePath(path)
+	if err != nil {
+		return nil, "", err
+	}
+	var data []byte
+	var version int64
+	err = s.db.QueryRow(`SELECT data, version FROM state WHERE key = ?`, key).Scan(&data, &version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, "", fmt.Errorf("%s: %w", path, fs.ErrNotExist)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return data, strconv.FormatInt(version, 10), nil
+}
+
+func (s *sqliteStore) Put(path string, data []byte, version string) error {
+	_, key, err := parseSQLitePath(path)
+	if err != nil {
+		return err
+	}
+	var res sql.Result
+	if version == "" {
+		res, err = s.db.Exec(`INSERT INTO state (key, data, version) VALUES (?, ?, 1) ON CONFLICT (key) DO NOTHING`, key, data)
+	} else {
+		res, err = s.db.Exec(`UPDATE state SET data = ?, version = version + 1 WHERE key = ? AND version = ?`, data, key, version)
+	}
+	return sqliteWriteErr(res, err)
+}
+
+func (s *sqliteStore) Delete(path string, version string) error {
+	_, key, err := parseSQLitePath(path)
+	if err != nil {
+		return err
+	}
+	if version == "" {
+		_, err = s.db.Exec(`DELETE FROM state WHERE key = ?`, key)
+		return err
+	}
+	res, err := s.db.Exec(`DELETE FROM state WHERE key = ? AND version = ?`, key, version)
+	return sqliteWriteErr(res, err)
+}
+
+// SQLite can append in place, so there's nothing to retry.
+func (s *sqliteStore) Append(path string, line []byte) error {
+	_, key, err := parseSQLitePath(path)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO state (key, data, version) VALUES (?, ?, 1)
+		ON CONFLICT (key) DO UPDATE SET data = CAST(data || excluded.data AS BLOB), version = version + 1`, key, line)
+	return err
+}
+
+func (s *sqliteStore) Lock(path string, holder string, ttl time.Duration) (func(), error) {
+	return takeLock(s, path, holder, ttl, putLock(s))
+}
+
+// A conditional write that didn't touch a row lost to someone else.
+func sqliteWriteErr(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errStateConflict
+	}
+	return nil
+}
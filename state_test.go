@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.lock")
+	release, err := fileStore{}.Lock(path, "one", time.Minute)
+	if err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	if _, err := (fileStore{}).Lock(path, "two", time.Minute); err == nil {
+		t.Fatalf("Lock held by one was taken by two")
+	}
+	release()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("Lock still there after release: %v", err)
+	}
+	if _, err := (fileStore{}).Lock(path, "two", time.Minute); err != nil {
+		t.Fatalf("Lock after release: %v", err)
+	}
+}
+
+// The race taking over an expired lock used to lose: B sees the lock has
+// expired, A takes it over, then B goes to take over what is now A's lock.
+func TestFileLockTakeoverAfterSomeoneElse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.lock")
+	expired, _ := json.Marshal(lockState{Holder: "dead", Expires: time.Now().Add(-time.Minute)})
+	if err := os.WriteFile(path, expired, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	a, _ := json.Marshal(lockState{Holder: "a", Expires: time.Now().Add(time.Minute)})
+	if err := createLockFile(path, a, "", true); err != nil {
+		t.Fatalf("A taking over: %v", err)
+	}
+	b, _ := json.Marshal(lockState{Holder: "b", Expires: time.Now().Add(time.Minute)})
+	if err := createLockFile(path, b, "", true); err != errStateConflict {
+		t.Fatalf("B taking over A's lock got %v, want errStateConflict", err)
+	}
+	data, _ := os.ReadFile(path)
+	var st lockState
+	json.Unmarshal(data, &st)
+	if st.Holder != "a" {
+		t.Fatalf("Lock is held by %q, want a", st.Holder)
+	}
+}
+
+// Everyone sees the same expired lock at once, only one of them gets to
+// take it over.
+func TestFileLockTakeoverRace(t *testing.T) {
+	for round := 0; round < 20; round++ {
+		path := filepath.Join(t.TempDir(), "test.lock")
+		expired, _ := json.Marshal(lockState{Holder: "dead", Expires: time.Now().Add(-time.Minute)})
+		if err := os.WriteFile(path, expired, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		taken := 0
+		start := make(chan struct{})
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				if _, err := (fileStore{}).Lock(path, "racer", time.Minute); err == nil {
+					mu.Lock()
+					taken++
+					mu.Unlock()
+				}
+			}()
+		}
+		close(start)
+		wg.Wait()
+		if taken != 1 {
+			t.Fatalf("Round %d: expired lock taken over %d times", round, taken)
+		}
+	}
+}
+
+func TestFileStoreVersions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	store := fileStore{}
+
+	if err := store.Put(path, []byte("one"), ""); err != nil {
+		t.Fatalf("Creating: %v", err)
+	}
+	if err := store.Put(path, []byte("again"), ""); err != errStateConflict {
+		t.Fatalf("Creating over an existing file got %v, want errStateConflict", err)
+	}
+
+	data, version, err := store.Get(path)
+	if err != nil || string(data) != "one" || version == "" {
+		t.Fatalf("Get = %q, %q, %v", data, version, err)
+	}
+	if err := store.Put(path, []byte("two"), version); err != nil {
+		t.Fatalf("Writing the version that was read: %v", err)
+	}
+	if err := store.Put(path, []byte("three"), version); err != errStateConflict {
+		t.Fatalf("Writing over a newer version got %v, want errStateConflict", err)
+	}
+	if err := store.Delete(path, version); err != errStateConflict {
+		t.Fatalf("Deleting a newer version got %v, want errStateConflict", err)
+	}
+	if data, _, _ := store.Get(path); string(data) != "two" {
+		t.Fatalf("File has %q after conflicts, want two", data)
+	}
+
+	_, version, _ = store.Get(path)
+	if err := store.Delete(path, version); err != nil {
+		t.Fatalf("Deleting the version that was read: %v", err)
+	}
+	if err := store.Put(path, []byte("four"), version); err != errStateConflict {
+		t.Fatalf("Writing a version of a deleted file got %v, want errStateConflict", err)
+	}
+}
+
+// Read, change, and write from lots of goroutines at once, starting over on
+// a conflict the way UpdateRuntimeState does. No increment can get lost.
+func TestFileStoreConcurrentUpdates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "count.json")
+	store := fileStore{}
+	if err := store.Put(path, []byte("0"), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 10; n++ {
+				for {
+					data, version, err := store.Get(path)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					var count int
+					json.Unmarshal(data, &count)
+					next, _ := json.Marshal(count + 1)
+					err = store.Put(path, next, version)
+					if err == nil {
+						break
+					}
+					if err != errStateConflict {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	data, _, _ := store.Get(path)
+	if string(data) != "80" {
+		t.Fatalf("Count is %s, want 80", data)
+	}
+	if leftover, _ := filepath.Glob(path + ".*.tmp"); len(leftover) > 0 {
+		t.Fatalf("Temp files left behind: %v", leftover)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Stats is a summary of the history file, mostly to have numbers handy when
+// arguing with the ISP about how often my address changes.
+type Stats struct {
+	// Address changes in the last 7, 30, and 90 days
+	Changes7  int
+	Changes30 int
+	Changes90 int
+
+	// How long an address stuck around on average, from the gaps between
+	// changes to the same record
+	AverageLifetime time.Duration
+	Lifetimes       int
+
+	Checks   int
+	Failures int
+}
+
+// ComputeStats works out the stats from history events as of now.
+func ComputeStats(events []Event, now time.Time) Stats {
+	var st Stats
+	changes := map[string][]time.Time{}
+	for _, e := range events {
+		age := now.Sub(e.Time)
+		switch e.Type {
+		case EventChange:
+			if age <= 7*24*time.Hour {
+				st.Changes7++
+			}
+			if age <= 30*24*time.Hour {
+				st.Changes30++
+			}
+			if age <= 90*24*time.Hour {
+				st.Changes90++
+			}
+			changes[e.Domain+" "+e.Record] = append(changes[e.Domain+" "+e.Record], e.Time)
+			st.Checks++
+		case EventNoChange, EventMismatch:
+			st.Checks++
+		case EventFailure:
+			st.Checks++
+			st.Failures++
+		}
+	}
+
+	var total time.Duration
+	for _, times := range changes {
+		sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+		for i := 1; i < len(times); i++ {
+			total += times[i].Sub(times[i-1])
+			st.Lifetimes++
+		}
+	}
+	if st.Lifetimes > 0 {
+		st.AverageLifetime = total / time.Duration(st.Lifetimes)
+	}
+	return st
+}
+
+// Print writes the stats out in a human friendly format.
+func (st Stats) Print(w io.Writer) {
+	fmt.Fprintf(w, "Address changes:  %d (7 days), %d (30 days), %d (90 days)\n", st.Changes7, st.Changes30, st.Changes90)
+	if st.Lifetimes > 0 {
+		fmt.Fprintf(w, "Average lifetime: %s (from %d changes)\n", formatDuration(st.AverageLifetime), st.Lifetimes)
+	} else {
+		fmt.Fprintf(w, "Average lifetime: not enough changes yet\n")
+	}
+	if st.Checks > 0 {
+		rate := float64(st.Checks-st.Failures) / float64(st.Checks) * 100
+		fmt.Fprintf(w, "Success rate:     %.1f%% (%d of %d checks)\n", rate, st.Checks-st.Failures, st.Checks)
+	} else {
+		fmt.Fprintf(w, "Success rate:     no checks recorded\n")
+	}
+}
+
+// Durations like 1091h23m12.5s aren't very readable, days are what matter
+// for address lifetimes.
+func formatDuration(d time.Duration) string {
+	days := int(d / (24 * time.Hour))
+	hours := int((d % (24 * time.Hour)) / time.Hour)
+	if days > 0 {
+		return fmt.Sprintf("%dd %dh", days, hours)
+	}
+	return d.Round(time.Minute).String()
+}
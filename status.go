@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/mikerowehl/route53Update/route53update"
+)
+
+// The status command is a look at where things stand without changing or
+// reporting anything: each record, what it has now, and what it should have.
+
+// RecordStatus is one record of a domain compared to the public address.
+type RecordStatus struct {
+	Domain string
+	Record types.RRType
+	Have   string // empty if the record doesn't exist
+	TTL    int64
+	Want   string
+	Held   string // why a mismatch wouldn't get fixed right now
+	Err    error
+
+	Annotations map[string]string
+}
+
+// InSync says if the record already has the address it should.
+func (s RecordStatus) InSync() bool {
+	return s.Err == nil && s.Have == s.Want
+}
+
+// Status looks up each record the domain manages, without changing them.
+func (u *Updater) Status(name string) []RecordStatus {
+	if len(u.Domains[name].Uplinks) > 0 {
+		return []RecordStatus{{Domain: name, Record: types.RRTypeA, Err: errors.New("has uplinks, status only covers single address records")}}
+	}
+	if target := u.Domains[name].Follow; target != "" {
+		return u.followStatus(name, route53update.NormalizeHostname(target))
+	}
+	var statuses []RecordStatus
+	for _, rtype := range u.recordTypes(name) {
+		statuses = append(statuses, u.recordStatus(name, rtype))
+	}
+	return statuses
+}
+
+// Status with the domain's annotations filled in.
+func (u *Updater) annotatedStatus(name string) []RecordStatus {
+	statuses := u.Status(name)
+	for i := range statuses {
+		statuses[i].Annotations = u.Domains[name].Annotations
+	}
+	return statuses
+}
+
+func (u *Updater) recordStatus(name string, rtype types.RRType) RecordStatus {
+	st := RecordStatus{Domain: name, Record: rtype, Held: u.heldBecause(name)}
+	if rtype == types.RRTypeAaaa {
+		st.Want, st.Err = route53update.PublicIPv6()
+	} else {
+		st.Want, st.Err = route53update.PublicIPv4()
+	}
+	if st.Err != nil {
+		return st
+	}
+
+	if p := u.Providers[u.Domains[name].Provider]; p != nil {
+		st.Have, st.Err = p.GetRecord(name, rtype)
+		if errors.Is(st.Err, route53update.ErrRecordNotFound) {
+			st.Err = nil
+		}
+		return st
+	}
+	st.Err = u.fillHave(&st)
+	return st
+}
+
+// Fill in what the Route53 record has now.
+func (u *Updater) fillHave(st *RecordStatus) error {
+	zone, err := u.zone(st.Domain)
+	if err != nil {
+		return fmt.Errorf("Failed to find zone: %v", err)
+	}
+	rec, err := route53update.GetRecord(u.Client, *zone.Id, st.Domain+".", st.Record)
+	switch {
+	case errors.Is(err, route53update.ErrRecordNotFound):
+		return nil
+	case err != nil:
+		return err
+	}
+	st.Have = strings.Join(recordValues(rec), " ")
+	if rec.TTL != nil {
+		st.TTL = *rec.TTL
+	}
+	return nil
+}
+
+// For a followed domain what the records should have is the target's
+// addresses, which can be more than one.
+func (u *Updater) followStatus(name string, target string) []RecordStatus {
+	rtypes := followTypes(u.Domains[name])
+	wants, err := resolveFollowAll(target, rtypes)
+	var statuses []RecordStatus
+	for _, rtype := range rtypes {
+		st := RecordStatus{Domain: name, Record: rtype, Held: u.heldBecause(name), Want: strings.Join(wants[rtype], " "), Err: err}
+		if err == nil {
+			st.Err = u.fillHave(&st)
+		}
+		statuses = append(statuses, st)
+	}
+	return statuses
+}
+
+// PrintStatus writes one line per record, with the annotations at the end
+// if any of the domains have them.
+func PrintStatus(w io.Writer, statuses []RecordStatus) {
+	annotated := slices.ContainsFunc(statuses, func(s RecordStatus) bool { return len(s.Annotations) > 0 })
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	if annotated {
+		fmt.Fprintln(tw, "DOMAIN\tTYPE\tRECORD\tTTL\tPUBLIC\tSTATUS\tANNOTATIONS")
+	} else {
+		fmt.Fprintln(tw, "DOMAIN\tTYPE\tRECORD\tTTL\tPUBLIC\tSTATUS")
+	}
+	for _, s := range statuses {
+		have, ttl, want := s.Have, fmt.Sprint(s.TTL), s.Want
+		if have == "" {
+			have, ttl = "-", "-"
+		}
+		if want == "" {
+			want = "-"
+		}
+		status := "ok"
+		switch {
+		case s.Err != nil:
+			status = "error: " + s.Err.Error()
+		case !s.InSync() && s.Held != "":
+			status = "out of date, " + s.Held
+		case !s.InSync():
+			status = "out of date"
+		}
+		line := fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s", s.Domain, s.Record, have, ttl, want, status)
+		if annotated {
+			line += "\t" + formatAnnotations(s.Annotations)
+		}
+		fmt.Fprintln(tw, line)
+	}
+	tw.Flush()
+}
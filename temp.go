@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/mikerowehl/route53Update/route53update"
+)
+
+// Temporary records carry a TXT marker saying when they expire, in the same
+// heritage style as the client ownership markers. Keeping the expiry in
+// Route53 itself means anything that can read the zone can clean them up, a
+// watch daemon, a cron job, or a scheduled Lambda running reap-expired.
+const expiresMarkerPrefix = markerHeritage + "expires="
+
+// AddTempRecord creates an address record along with the marker saying when
+// it expires. It's a create and not an upsert, a temporary record should
+// never replace a real one that happens to have the same name.
+func AddTempRecord(client *route53.Client, zone string, domain string, ip string, expires time.Time) (*route53.ChangeResourceRecordSetsOutput, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return nil, fmt.Errorf("%s isn't an IP address", ip)
+	}
+	rtype := types.RRTypeAaaa
+	if parsed.To4() != nil {
+		rtype = types.RRTypeA
+	}
+	marker := QuoteTXT(expiresMarkerPrefix + expires.UTC().Format(time.RFC3339))
+
+	changes := []types.Change{
+		{
+			Action: types.ChangeActionCreate,
+			ResourceRecordSet: &types.ResourceRecordSet{
+				Name:            aws.String(domain),
+				Type:            rtype,
+				ResourceRecords: []types.ResourceRecord{{Value: aws.String(ip)}},
+				TTL:             aws.Int64(60),
+			},
+		},
+		{
+			Action: types.ChangeActionCreate,
+			ResourceRecordSet: &types.ResourceRecordSet{
+				Name:            aws.String(domain),
+				Type:            types.RRTypeTxt,
+				ResourceRecords: []types.ResourceRecord{{Value: aws.String(marker)}},
+				TTL:             aws.Int64(60),
+			},
+		},
+	}
+	params := &route53.ChangeResourceRecordSetsInput{
+		ChangeBatch: &types.ChangeBatch{
+			Changes: changes,
+		},
+		HostedZoneId: aws.String(zone),
+	}
+	return route53update.ChangeRecordSets(client, params)
+}
+
+// RecordExpiry returns when a record marked as temporary expires, and false
+// if the TXT record isn't an expiry marker.
+func RecordExpiry(txt *types.ResourceRecordSet) (time.Time, bool) {
+	if txt == nil {
+		return time.Time{}, false
+	}
+	for _, value := range TXTValues(txt) {
+		if strings.HasPrefix(value, expiresMarkerPrefix) {
+			t, err := time.Parse(time.RFC3339, strings.TrimPrefix(value, expiresMarkerPrefix))
+			return t, err == nil
+		}
+	}
+	return time.Time{}, false
+}
+
+// ReapExpired goes through a zone and deletes every temporary record whose
+// time is up, marker and all. Returns the names it deleted.
+func ReapExpired(client *route53.Client, zone string, now time.Time) ([]string, error) {
+	byName := map[string][]types.ResourceRecordSet{}
+	var expired []string
+
+	paginator := route53.NewListResourceRecordSetsPaginator(client, &route53.ListResourceRecordSetsInput{
+		HostedZoneId: aws.String(zone),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list records: %v", err)
+		}
+		for _, rec := range page.ResourceRecordSets {
+			switch rec.Type {
+			case types.RRTypeA, types.RRTypeAaaa, types.RRTypeTxt:
+			default:
+				continue
+			}
+			name := *rec.Name
+			byName[name] = append(byName[name], rec)
+			if rec.Type == types.RRTypeTxt {
+				if t, ok := RecordExpiry(&rec); ok && !t.After(now) {
+					expired = append(expired, name)
+				}
+			}
+		}
+	}
+
+	var reaped []string
+	for _, name := range expired {
+		var recs []*types.ResourceRecordSet
+		for i := range byName[name] {
+			recs = append(recs, &byName[name][i])
+		}
+		if _, err := DeleteRecords(client, zone, "expired", recs...); err != nil {
+			return reaped, fmt.Errorf("Failed to delete expired %s: %v", name, err)
+		}
+		reaped = append(reaped, name)
+	}
+	return reaped, nil
+}
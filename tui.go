@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// The tui is watch mode with a screen to look at. It runs the same schedule,
+// reaping, and digests as watch, so it can stand in for the daemon while
+// someone's logged in, and shows how each domain is doing, how long until
+// it's checked again, and the latest events as they happen. A check can be
+// forced or a domain paused from the keyboard. Anything the checks print
+// goes in the output pane instead of scribbling over the screen.
+
+// How many events and output lines to hang on to for the screen.
+const tuiKeep = 50
+
+type tuiDomain struct {
+	name     string
+	last     map[string]Event // by record, empty for the A rec
+	checked  time.Time
+	checking bool
+	paused   bool
+}
+
+type tui struct {
+	updater  *Updater
+	schedule *Schedule
+	checks   chan string
+
+	mu       sync.Mutex
+	domains  []*tuiDomain
+	selected int
+	events   []Event
+	output   []string
+}
+
+func runTUI(args []string) {
+	flags := flag.NewFlagSet("tui", flag.ExitOnError)
+	confFlags := addConfigFlags(flags)
+	interval := flags.Duration("interval", 5*time.Minute, "how often to check, for domains without their own interval")
+	fips := flags.Bool("fips", false, "use FIPS validated AWS endpoints")
+	monitor := flags.Bool("monitor", false, "report mismatches but never change Route53")
+	dryRun := flags.Bool("dry-run", false, "do all the checks but don't change anything")
+	records := addRecordFlags(flags)
+	flags.Parse(args)
+
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		log.Fatalf("tui needs a terminal, use watch to run in the background")
+	}
+	conf := withFIPS(confFlags.load(), *fips)
+	domains := watchedDomains(conf, flags.Args())
+	if len(domains) == 0 {
+		fmt.Fprintf(os.Stderr, "usage: %s tui [-config <file>] [-profile <name>] [-interval 5m] [-fips] [-monitor] [-dry-run] %s [<domain>...]\n", os.Args[0], recordUsage)
+		os.Exit(2)
+	}
+
+	updater := newUpdater(conf, "tui")
+	updater.MonitorOnly = updater.MonitorOnly || *monitor
+	if *dryRun {
+		updater.StartDryRun()
+	}
+	records.apply(updater)
+	digest, err := NewDigest(conf, updater.Reporter)
+	if err != nil {
+		log.Fatalf("Unable to set up digest: %v", err)
+	}
+
+	t := &tui{
+		updater:  updater,
+		schedule: watchSchedule(conf, domains, *interval),
+		checks:   make(chan string, len(domains)),
+	}
+	for _, name := range domains {
+		t.domains = append(t.domains, &tuiDomain{name: name, last: map[string]Event{}})
+	}
+	updater.Reporter.Watch(t.event)
+
+	// Grab everything the checks print before touching the terminal, the
+	// screen gets drawn on the real stdout
+	screen := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Stdout = w
+	log.SetOutput(w)
+	go t.capture(r)
+
+	fd := int(os.Stdin.Fd())
+	saved, err := term.MakeRaw(fd)
+	if err != nil {
+		log.SetOutput(os.Stderr)
+		log.Fatalf("Unable to set up the terminal: %v", err)
+	}
+	// Alternate screen, no cursor
+	fmt.Fprint(screen, "\x1b[?1049h\x1b[?25l")
+	defer func() {
+		fmt.Fprint(screen, "\x1b[?25h\x1b[?1049l")
+		term.Restore(fd, saved)
+	}()
+
+	updater.Zones.Preload(updater.zoneNames(domains))
+	go t.worker()
+
+	keys := make(chan byte)
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				close(keys)
+				return
+			}
+			for _, b := range buf[:n] {
+				keys <- b
+			}
+		}
+	}()
+
+	var digestTimer <-chan time.Time
+	if digest != nil {
+		digestTimer = time.After(time.Until(digest.Next(time.Now())))
+	}
+	reapTicker := time.NewTicker(time.Minute)
+	defer reapTicker.Stop()
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+
+	t.queueDue(time.Now())
+	t.draw(screen)
+	var escape []byte
+	for {
+		select {
+		case b, ok := <-keys:
+			if !ok {
+				return
+			}
+			// Arrow keys come in as ESC [ A and ESC [ B
+			if b == 0x1b || len(escape) > 0 {
+				escape = append(escape, b)
+				if len(escape) < 3 {
+					continue
+				}
+				switch string(escape) {
+				case "\x1b[A":
+					b = 'k'
+				case "\x1b[B":
+					b = 'j'
+				}
+				escape = nil
+			}
+			if !t.key(b) {
+				return
+			}
+		case now := <-tick.C:
+			t.queueDue(now)
+		case <-reapTicker.C:
+			go updater.reapZones(domains)
+		case now := <-digestTimer:
+			if err := digest.Send(now); err != nil {
+				log.Printf("Failed to send digest: %v", err)
+			}
+			digestTimer = time.After(time.Until(digest.Next(now)))
+		}
+		t.draw(screen)
+	}
+}
+
+// Handle a key press, false means quit.
+func (t *tui) key(b byte) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch b {
+	case 'q', 3: // ctrl-c too, raw mode turns off the signal
+		return false
+	case 'k':
+		if t.selected > 0 {
+			t.selected--
+		}
+	case 'j':
+		if t.selected < len(t.domains)-1 {
+			t.selected++
+		}
+	case 'c', '\r':
+		t.queue(t.domains[t.selected])
+	case 'a':
+		for _, d := range t.domains {
+			t.queue(d)
+		}
+	case 'p':
+		d := t.domains[t.selected]
+		d.paused = !d.paused
+	}
+	return true
+}
+
+// Queue up everything that's due, skipping anything paused. Paused domains
+// still come off the schedule so they don't all fire at once on resume.
+func (t *tui) queueDue(now time.Time) {
+	due := t.schedule.Due(now)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, name := range due {
+		for _, d := range t.domains {
+			if d.name == name && !d.paused {
+				t.queue(d)
+			}
+		}
+	}
+}
+
+// Called with the lock held.
+func (t *tui) queue(d *tuiDomain) {
+	if d.checking {
+		return
+	}
+	d.checking = true
+	t.checks <- d.name
+}
+
+// Checks run one at a time in the background, the same as watch runs them,
+// so the screen keeps up while a check waits on the network.
+func (t *tui) worker() {
+	for name := range t.checks {
+		if err := t.updater.Update(name); err != nil {
+			log.Printf("Update of %s failed: %v", name, err)
+		}
+		t.updater.Reporter.PushMetrics()
+
+		t.mu.Lock()
+		for _, d := range t.domains {
+			if d.name == name {
+				d.checking = false
+				d.checked = time.Now()
+			}
+		}
+		t.mu.Unlock()
+	}
+}
+
+func (t *tui) event(e Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, d := range t.domains {
+		if d.name == e.Domain {
+			d.last[e.Record] = e
+		}
+	}
+	t.events = append(t.events, e)
+	if len(t.events) > tuiKeep {
+		t.events = t.events[len(t.events)-tuiKeep:]
+	}
+}
+
+func (t *tui) capture(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		t.mu.Lock()
+		t.output = append(t.output, scanner.Text())
+		if len(t.output) > tuiKeep {
+			t.output = t.output[len(t.output)-tuiKeep:]
+		}
+		t.mu.Unlock()
+	}
+}
+
+// What a domain's last check came to, worst record first.
+func domainStatus(d *tuiDomain) string {
+	status := ""
+	for _, e := range d.last {
+		switch e.Type {
+		case EventFailure:
+			return "failed"
+		case EventMismatch:
+			status = "held"
+		case EventChange:
+			if status == "" || status == "ok" {
+				status = "changed"
+			}
+		case EventNoChange:
+			if status == "" {
+				status = "ok"
+			}
+		}
+	}
+	if status == "" {
+		return "-"
+	}
+	return status
+}
+
+func domainAddress(d *tuiDomain) string {
+	var addrs []string
+	for _, record := range []string{"", "AAAA"} {
+		if e, ok := d.last[record]; ok && e.NewIp != "" {
+			addrs = append(addrs, e.NewIp)
+		}
+	}
+	if len(addrs) == 0 {
+		return "-"
+	}
+	return strings.Join(addrs, " ")
+}
+
+func ago(t time.Time, now time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return now.Sub(t).Truncate(time.Second).String() + " ago"
+}
+
+// Redraw the whole screen. It's a handful of lines once a second, not worth
+// being clever about only drawing what changed.
+func (t *tui) draw(screen io.Writer) {
+	width, height, err := term.GetSize(int(os.Stdin.Fd()))
+	if err != nil {
+		width, height = 80, 24
+	}
+	height = max(height, 2)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	var lines []string
+	title := fmt.Sprintf("route53Update  %s  %d domains", now.Format("15:04:05"), len(t.domains))
+	if t.updater.MonitorOnly {
+		title += "  [monitor only]"
+	}
+	lines = append(lines, title, "")
+	lines = append(lines, fmt.Sprintf("  %-32s %-8s %-40s %-12s %s", "DOMAIN", "STATUS", "ADDRESS", "CHECKED", "NEXT"))
+	for i, d := range t.domains {
+		next := "-"
+		switch {
+		case d.checking:
+			next = "checking"
+		case d.paused:
+			next = "paused"
+		default:
+			if at, ok := t.schedule.At(d.name); ok {
+				next = "in " + max(time.Until(at), 0).Truncate(time.Second).String()
+			}
+		}
+		cursor := "  "
+		if i == t.selected {
+			cursor = "> "
+		}
+		lines = append(lines, fmt.Sprintf("%s%-32s %-8s %-40s %-12s %s", cursor, d.name, domainStatus(d), domainAddress(d), ago(d.checked, now), next))
+	}
+
+	footer := "up/down select  c check  a check all  p pause/resume  q quit"
+	room := height - len(lines) - 4
+	if room > 0 {
+		lines = append(lines, "", "Recent events")
+		events := t.events
+		if len(events) > room/2 {
+			events = events[len(events)-room/2:]
+		}
+		for i := len(events) - 1; i >= 0; i-- {
+			e := events[i]
+			lines = append(lines, e.Time.Format("15:04:05")+" "+strings.ReplaceAll(e.Message(), "\n", " "))
+		}
+		if rest := height - len(lines) - 3; rest > 0 && len(t.output) > 0 {
+			lines = append(lines, "", "Output")
+			output := t.output
+			if len(output) > rest-1 {
+				output = output[len(output)-(rest-1):]
+			}
+			lines = append(lines, output...)
+		}
+	}
+	for len(lines) < height-1 {
+		lines = append(lines, "")
+	}
+	lines = append(lines[:height-1], footer)
+
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	for i, line := range lines {
+		if len(line) > width {
+			line = line[:width]
+		}
+		b.WriteString(line)
+		b.WriteString("\x1b[K")
+		if i < len(lines)-1 {
+			b.WriteString("\r\n")
+		}
+	}
+	io.WriteString(screen, b.String())
+}
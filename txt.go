@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+// A TXT record set can have several values, and each value is one or more
+// quoted character-strings of at most 255 bytes, which DNS glues back
+// together. Route53 hands values back in that presentation format, with
+// escapes for quotes, backslashes, and anything unprintable as \DDD. The
+// markers we keep in TXT records are short, but they share the name with
+// whatever else is there, SPF or site verification strings or DKIM keys
+// that run well past 255, and all of that has to survive us writing ours.
+
+// Every marker value we write starts with this.
+const markerHeritage = "heritage=route53Update,"
+
+// QuoteTXT turns a value into Route53's format, split into 255 byte
+// character-strings if it's long.
+func QuoteTXT(value string) string {
+	var parts []string
+	for {
+		chunk := value
+		if len(chunk) > 255 {
+			chunk = chunk[:255]
+		}
+		parts = append(parts, quoteTXTString(chunk))
+		value = value[len(chunk):]
+		if value == "" {
+			break
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func quoteTXTString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case ch == '"' || ch == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(ch)
+		case ch < 0x20 || ch > 0x7e:
+			fmt.Fprintf(&b, "\\%03d", ch)
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// UnquoteTXT splits a value from Route53 into its character-strings, with
+// the escapes undone. Anything outside quotes counts as its own string.
+func UnquoteTXT(value string) []string {
+	var strs []string
+	for i := 0; i < len(value); {
+		switch value[i] {
+		case ' ', '\t':
+			i++
+			continue
+		}
+		var b strings.Builder
+		quoted := value[i] == '"'
+		if quoted {
+			i++
+		}
+		for i < len(value) {
+			ch := value[i]
+			if quoted && ch == '"' {
+				i++
+				break
+			}
+			if !quoted && (ch == ' ' || ch == '\t') {
+				break
+			}
+			if ch == '\\' && i+1 < len(value) {
+				if i+3 < len(value) && isDigits(value[i+1:i+4]) {
+					n, _ := strconv.Atoi(value[i+1 : i+4])
+					b.WriteByte(byte(n))
+					i += 4
+					continue
+				}
+				b.WriteByte(value[i+1])
+				i += 2
+				continue
+			}
+			b.WriteByte(ch)
+			i++
+		}
+		strs = append(strs, b.String())
+	}
+	return strs
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// TXTValues returns each value in a TXT record set with its character-strings
+// joined back up, the way anything reading the record sees it.
+func TXTValues(txt *types.ResourceRecordSet) []string {
+	if txt == nil {
+		return nil
+	}
+	var values []string
+	for _, rr := range txt.ResourceRecords {
+		values = append(values, strings.Join(UnquoteTXT(aws.ToString(rr.Value)), ""))
+	}
+	return values
+}
+
+// WithMarkers is the values for a TXT record set that keeps everything in
+// the existing one except our old markers, plus the new markers.
+func WithMarkers(txt *types.ResourceRecordSet, markers ...string) []types.ResourceRecord {
+	var recs []types.ResourceRecord
+	if txt != nil {
+		for _, rr := range txt.ResourceRecords {
+			if !strings.HasPrefix(strings.Join(UnquoteTXT(aws.ToString(rr.Value)), ""), markerHeritage) {
+				recs = append(recs, rr)
+			}
+		}
+	}
+	for _, m := range markers {
+		recs = append(recs, types.ResourceRecord{Value: aws.String(QuoteTXT(m))})
+	}
+	return recs
+}
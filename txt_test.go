@@ -0,0 +1,88 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+func TestQuoteTXT(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"plain", "v=spf1 -all", `"v=spf1 -all"`},
+		{"empty", "", `""`},
+		{"quotes and backslashes", `say "hi" \o/`, `"say \"hi\" \\o/"`},
+		{"unprintable", "tab\there\x7f\xff", `"tab\009here\127\255"`},
+		{"exactly 255", strings.Repeat("a", 255), `"` + strings.Repeat("a", 255) + `"`},
+		{"split at 255", strings.Repeat("a", 255) + "bc", `"` + strings.Repeat("a", 255) + `" "bc"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := QuoteTXT(tt.value); got != tt.want {
+				t.Errorf("QuoteTXT(%q) = %s, want %s", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnquoteTXT(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  []string
+	}{
+		{"one string", `"v=spf1 -all"`, []string{"v=spf1 -all"}},
+		{"several strings", `"abc" "def"`, []string{"abc", "def"}},
+		{"escapes", `"say \"hi\" \\o/"`, []string{`say "hi" \o/`}},
+		{"decimal escapes", `"\065\066C"`, []string{"ABC"}},
+		{"decimal escape at the end", `\065`, []string{"A"}},
+		{"unquoted", `abc def`, []string{"abc", "def"}},
+		{"empty string", `""`, []string{""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := UnquoteTXT(tt.value); !slices.Equal(got, tt.want) {
+				t.Errorf("UnquoteTXT(%s) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+// Anything QuoteTXT writes comes back the same from TXTValues, long values
+// and odd bytes included.
+func TestTXTRoundTrip(t *testing.T) {
+	values := []string{
+		"heritage=route53Update,owner=laptop",
+		strings.Repeat("k=DKIM1; p=MIIB", 40),
+		"quote \" backslash \\ newline \n high \xe9",
+		"",
+	}
+	for _, value := range values {
+		txt := &types.ResourceRecordSet{ResourceRecords: []types.ResourceRecord{{Value: aws.String(QuoteTXT(value))}}}
+		if got := TXTValues(txt); len(got) != 1 || got[0] != value {
+			t.Errorf("Round trip of %q came back %q", value, got)
+		}
+	}
+}
+
+func TestWithMarkers(t *testing.T) {
+	txt := &types.ResourceRecordSet{ResourceRecords: []types.ResourceRecord{
+		{Value: aws.String(`"v=spf1 -all"`)},
+		{Value: aws.String(QuoteTXT(markerHeritage + "owner=old"))},
+		{Value: aws.String(QuoteTXT(strings.Repeat("x", 300)))},
+	}}
+	var got []string
+	for _, rr := range WithMarkers(txt, markerHeritage+"owner=new") {
+		got = append(got, aws.ToString(rr.Value))
+	}
+	want := []string{`"v=spf1 -all"`, QuoteTXT(strings.Repeat("x", 300)), QuoteTXT(markerHeritage + "owner=new")}
+	if !slices.Equal(got, want) {
+		t.Errorf("WithMarkers = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,685 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/mikerowehl/route53Update/route53update"
+)
+
+// How many times to go around when the record keeps changing between when we
+// read it and when we go to write it.
+const maxUpdateAttempts = 3
+
+// Updater has everything a check of a domain needs. The one shot command line
+// mode uses it once, watch mode keeps using it on a timer.
+type Updater struct {
+	Client   *route53.Client
+	Zones    *ZoneCache
+	Reporter *Reporter
+	Checker  *AddressChecker
+	Source   string
+
+	// Keep the AAAA rec up to date too
+	IPv6 bool
+
+	// Overrides IPv6 when set, see recordTypes
+	Records string
+
+	// 4 or 6 to publish one record of that family, or the other if the
+	// connection doesn't have it
+	PreferFamily int
+
+	// Report mismatches instead of fixing them
+	MonitorOnly bool
+
+	// Per domain settings, and approval links for notify only domains
+	Domains   map[string]DomainConfig
+	Approvals *Approvals
+
+	// When updates are allowed to happen, any time if there aren't any.
+	// IgnoreWindows is for emergencies.
+	Windows       []TimeWindow
+	IgnoreWindows bool
+
+	TTL TTLConfig
+
+	// Write the metadata TXT record along with updates
+	Metadata bool
+
+	// The last mismatch reported for each record in monitor only mode, so
+	// watch mode doesn't send the same alert every interval
+	mu         sync.Mutex
+	mismatches map[string]string
+
+	// Uplink streaks for failover, when there's no state file to keep them
+	streaks map[string]int
+
+	// Changes waiting to go out together, while UpdateAll is going
+	batch *changeBatch
+
+	// Optional, used to figure out who changed a record when it drifts
+	Attributor *CloudTrailAttributor
+
+	// DNS services other than Route53, by the name domains use to pick
+	// them in the config
+	Providers map[string]DNSProvider
+
+	// Commands to run around changes, the domains can have their own too
+	Hooks HooksConfig
+
+	// Limits on what addresses records can be changed to
+	Policies *Policies
+
+	// State file with the paused names, file or S3
+	State string
+
+	// Directory or S3 prefix to keep per domain locks in, so copies running
+	// in different places don't update the same record at once
+	Locks string
+
+	// How long an address remembered in the state file can stand in for
+	// reading the record, zero to always read it
+	CacheMaxAge time.Duration
+
+	// Set from the command line. The hosted zone to use instead of looking
+	// one up, a TTL that beats anything in the config, and going through
+	// everything but the change itself.
+	ZoneId   string
+	FixedTTL int64
+	DryRun   bool
+
+	// Look for private zones instead of public ones, associated with VPCId
+	// if it's set, for every domain
+	PrivateZone bool
+	VPCId       string
+
+	// Every domain is split horizon, see DomainConfig.SplitHorizon
+	SplitHorizon bool
+
+	// How long to wait for changes to go in sync before calling them done,
+	// zero to not wait. Failing to sync in time is an error, so a script
+	// can count on the record being live once we exit.
+	Wait time.Duration
+}
+
+// IPDetectionError is not being able to work out our own address, as
+// opposed to Route53 or anything after it going wrong.
+type IPDetectionError struct {
+	Err error
+}
+
+func (e *IPDetectionError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *IPDetectionError) Unwrap() error {
+	return e.Err
+}
+
+// How long to wait for a change to go in sync, zero if nothing needs it.
+// Post change hooks always need it, and get at least their own timeout.
+func (u *Updater) syncTimeout(hooks bool) time.Duration {
+	if hooks {
+		return max(u.Wait, hookSyncTimeout)
+	}
+	return u.Wait
+}
+
+// Wait for a batch of changes to go in sync if -wait asked for it.
+func (u *Updater) waitForSync(changeId string) error {
+	if u.Wait == 0 {
+		return nil
+	}
+	fmt.Printf("Waiting for the change to go in sync\n")
+	if _, err := route53update.WaitForChange(u.Client, changeId, u.Wait); err != nil {
+		return fmt.Errorf("Change never went in sync: %w", err)
+	}
+	return nil
+}
+
+// Update checks our public address against the A rec for the domain, and
+// changes the record in route53 if they don't match. Everything that happens
+// gets reported, the error is just so the caller knows it didn't work.
+//
+// With IPv6 on the AAAA rec gets the same treatment. The two don't depend on
+// each other, and on a slow link most of the time is spent waiting on the
+// address lookups and route53, so they run side by side.
+func (u *Updater) Update(name string) error {
+	return u.UpdateAll(context.Background(), []string{name})[0]
+}
+
+// Take the lock on a domain if there are locks. Gives back errLocked if
+// someone else has it.
+func (u *Updater) lock(name string) (func(), error) {
+	if u.Locks == "" || u.DryRun {
+		return func() {}, nil
+	}
+	return AcquireLock(strings.TrimSuffix(u.Locks, "/")+"/"+name+".lock", lockHolder(), lockTTL)
+}
+
+func (u *Updater) update(name string) error {
+	if uplinks := u.Domains[name].Uplinks; len(uplinks) > 0 {
+		return u.updateUplinks(name, uplinks)
+	}
+	if target := u.Domains[name].Follow; target != "" {
+		return u.updateFollow(name, route53update.NormalizeHostname(target))
+	}
+	var jobs []func() error
+	for _, rtype := range u.recordTypes(name) {
+		jobs = append(jobs, func() error { return u.updateRecord(name, rtype, false) })
+	}
+	if u.splitHorizon(name) {
+		for _, rtype := range u.lanTypes(name) {
+			jobs = append(jobs, func() error { return u.updateRecord(name, rtype, true) })
+		}
+	}
+	if len(jobs) == 1 {
+		return jobs[0]()
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(jobs))
+	for i, job := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = job()
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Whether the domain gets its LAN address in the private zone as well as
+// its public one in the public zone.
+func (u *Updater) splitHorizon(name string) bool {
+	d := u.Domains[name]
+	if d.Provider != "" && d.Provider != "route53" {
+		return false
+	}
+	return u.SplitHorizon || d.SplitHorizon
+}
+
+// Which records the private zone gets. Most LANs don't have private IPv6
+// addresses, so unless the domain asks for AAAA recs by name they're only
+// done when there is one.
+func (u *Updater) lanTypes(name string) []types.RRType {
+	var rtypes []types.RRType
+	for _, rtype := range u.recordTypes(name) {
+		if rtype == types.RRTypeAaaa && u.Domains[name].Type != "AAAA" {
+			if _, err := route53update.LANIP(u.Domains[name].LANInterface, true); err != nil {
+				fmt.Printf("No LAN IPv6 address, leaving the private zone's AAAA rec alone: %v\n", err)
+				continue
+			}
+		}
+		rtypes = append(rtypes, rtype)
+	}
+	return rtypes
+}
+
+func validRecords(records string) bool {
+	switch records {
+	case "", "A", "AAAA", "both", "auto":
+		return true
+	}
+	return false
+}
+
+// Which records to manage for a domain, its own type if it has one. Auto
+// looks at what the connection has every time, so a dual stack connection
+// gets both records kept up to date, and one that only has IPv4, or only
+// IPv6, doesn't get a failure every check for the one that's missing.
+func (u *Updater) recordTypes(name string) []types.RRType {
+	both := []types.RRType{types.RRTypeA, types.RRTypeAaaa}
+	records := u.Records
+	if t := u.Domains[name].Type; t != "" {
+		records = t
+	}
+	if records == "" && u.PreferFamily != 0 {
+		return u.preferredType()
+	}
+	switch records {
+	case "A":
+		return []types.RRType{types.RRTypeA}
+	case "AAAA":
+		return []types.RRType{types.RRTypeAaaa}
+	case "both":
+		return both
+	case "auto":
+		if !route53update.CanReachIPv4() {
+			fmt.Printf("No IPv4 route or NAT64, only doing the AAAA rec\n")
+			return []types.RRType{types.RRTypeAaaa}
+		}
+		if _, err := route53update.PublicIPv6(); err != nil {
+			fmt.Printf("No public IPv6 address, only doing the A rec: %v\n", err)
+			return []types.RRType{types.RRTypeA}
+		}
+		return both
+	}
+	if u.IPv6 {
+		return both
+	}
+	return []types.RRType{types.RRTypeA}
+}
+
+// Check and update one record for the domain, either the A or AAAA rec. The
+// lan one is the split horizon record, the LAN address in the private zone.
+func (u *Updater) updateRecord(name string, rtype types.RRType, lan bool) error {
+	// All the calls want full domain format, but that's not what I
+	// normally give as a domain name, so tack on the period at the end
+	domain := name + "."
+
+	// The A rec is reported without a record type, the way it always has
+	// been, so older history still lines up
+	record := ""
+	if rtype != types.RRTypeA {
+		record = string(rtype)
+	}
+	if lan {
+		record = "LAN " + string(rtype)
+	}
+	fail := func(err error) {
+		u.Reporter.Report(Event{Type: EventFailure, Domain: name, Record: record, Source: u.Source, Error: err.Error()})
+	}
+
+	// Get our public IP by using the ipify server to tell us what it
+	// tooks like our IP address is
+	var ip string
+	var err error
+	switch {
+	case lan:
+		ip, err = route53update.LANIP(u.Domains[name].LANInterface, rtype == types.RRTypeAaaa)
+	case rtype == types.RRTypeAaaa:
+		ip, err = route53update.PublicIPv6()
+	default:
+		ip, err = route53update.PublicIPv4()
+	}
+	if err != nil {
+		fail(err)
+		return &IPDetectionError{Err: err}
+	}
+	if lan {
+		fmt.Printf("Current LAN %s ip address: %s\n", rtype, ip)
+	} else {
+		fmt.Printf("Current %s ip address: %s\n", rtype, ip)
+	}
+
+	if p := u.Providers[u.Domains[name].Provider]; p != nil {
+		return u.updateWithProvider(p, name, record, rtype, ip)
+	}
+	if u.cacheHit(name, record, ip) {
+		return nil
+	}
+
+	// We need the zone id and not just the domain
+	var zone *types.HostedZone
+	if lan {
+		zone, err = u.lanZone(name)
+	} else {
+		zone, err = u.zone(name)
+	}
+	if err != nil {
+		fail(err)
+		return fmt.Errorf("Failed to find zone: %w", err)
+	}
+	fmt.Printf("Found zone: %s\n", *zone.Id)
+
+	// The library does the Route53 side, a change goes out with the short
+	// TTL if there is one
+	records := route53update.New(u.Client, route53update.Options{TTL: u.ttl(name).For(true)})
+	for attempt := 1; ; attempt++ {
+		// Look up the IP address current in route53. A missing AAAA rec
+		// just means it's the first time we've set it.
+		res, err := records.Check(*zone.Id, domain, rtype, ip)
+		if err == nil && res.Existing == nil && rtype != types.RRTypeAaaa {
+			err = route53update.ErrRecordNotFound
+		}
+		if err != nil {
+			fail(err)
+			return fmt.Errorf("Error trying to check configured %s ip: %w", rtype, err)
+		}
+		rec, configuredIp := res.Existing, res.OldIp
+		fmt.Printf("%s address in route53 is %s\n", rtype, configuredIp)
+		if configuredIp != "" {
+			u.checkDrift(name, record, configuredIp)
+		}
+
+		// If our public IP and what's in route53 match we're done
+		if ip == configuredIp {
+			// Forget any old mismatch, if it comes back it's news again
+			u.newMismatch(name+" "+record, "")
+			u.Reporter.Report(Event{Type: EventNoChange, Domain: name, Record: record, Source: u.Source, OldIp: configuredIp, NewIp: ip})
+			fmt.Printf("%s address already up to date, done\n", rtype)
+			u.rememberAddress(name, record, ip)
+			u.fixTTL(name, record, *zone.Id, rec)
+			return nil
+		}
+
+		// Sanity check the new address before it goes out. A LAN address
+		// is private on purpose and nobody else can see it, so there's
+		// nothing to check.
+		var report AddressReport
+		if !lan {
+			report = u.Checker.Check(ip)
+			if report.Ptr != "" {
+				fmt.Printf("Reverse DNS for %s is %s\n", ip, report.Ptr)
+			}
+			if err := u.Policies.Check(name, ip); err != nil {
+				u.reportPolicy(name, record, configuredIp, ip, err, report)
+				return nil
+			}
+		}
+
+		// Some setups want a mismatch reported but not fixed, at least
+		// not right now
+		if u.DryRun {
+			if held := u.heldBecause(name); held != "" {
+				fmt.Printf("A real run wouldn't change this yet: %s\n", held)
+			}
+			u.planChange(*zone.Id, name, rec, rtype, ip)
+			return nil
+		}
+		if held := u.heldBecause(name); held != "" {
+			fmt.Printf("%s address should be %s, not updating: %s\n", rtype, ip, held)
+			u.reportHeld(name, record, configuredIp, ip, held, report)
+			return nil
+		}
+
+		// Look again right before writing. If the record moved since we
+		// read it someone else is updating it, so back off and start over
+		// from the fresh value instead of stomping on their change.
+		if err := records.Recheck(res); err != nil {
+			if !errors.Is(err, route53update.ErrRecordChanged) {
+				fail(err)
+				return fmt.Errorf("Error trying to re-check configured %s ip: %w", rtype, err)
+			}
+			if attempt == maxUpdateAttempts {
+				fail(err)
+				return fmt.Errorf("Giving up after %d tries: %v", attempt, err)
+			}
+			fmt.Printf("%v, trying again\n", err)
+			time.Sleep(time.Duration(attempt)*time.Second + time.Duration(rand.Int63n(int64(time.Second))))
+			continue
+		}
+
+		// Try the address out on the canary first if there is one
+		if conf := u.Domains[name]; conf.Canary != "" && !lan {
+			fmt.Printf("Trying %s on canary %s first\n", ip, conf.Canary)
+			if err := Canary(u.Client, *zone.Id, conf.Canary, rtype, ip, conf.CanaryPort); err != nil {
+				fail(err)
+				return fmt.Errorf("Canary failed, not updating %s record: %v", rtype, err)
+			}
+		}
+
+		// Anything that depends on the address gets to get ready for it,
+		// or stop the change
+		hookChange := HookChange{Domain: name, Record: string(rtype), OldIp: configuredIp, NewIp: ip}
+		if err := runHooks("pre_change", u.hooksFor(name, "pre_change"), hookChange); err != nil {
+			fail(err)
+			u.changeFailed(name, hookChange, err)
+			return fmt.Errorf("Not updating %s record: %v", rtype, err)
+		}
+
+		// If the addresses don't match, update route53
+		changes := records.Changes(res)
+		if u.Metadata {
+			changes = append(changes, MetadataChange(domain, time.Now(), u.ttl(name).For(false)))
+		}
+		// A batch goes out once every domain has been checked, so the
+		// record gets one more look right before then
+		recheck := func() error {
+			return records.Recheck(res)
+		}
+		return u.submitChange(name, *zone.Id, changes, recheck, func(changeId string, err error) error {
+			if err != nil {
+				fail(err)
+				u.changeFailed(name, hookChange, err)
+				return fmt.Errorf("Error trying to update %s record: %w", rtype, err)
+			}
+			event := Event{Type: EventChange, Domain: name, Record: record, Source: u.Source, OldIp: configuredIp, NewIp: ip, TTL: u.ttl(name).For(true), AddressReport: report}
+			fmt.Printf("Updated %s %s. Change: %s\n", name, rtype, changeId)
+			u.rememberAddress(name, record, ip)
+
+			// Post change hooks wait until the change is out, which tells
+			// us how long that took while we're at it
+			post := u.hooksFor(name, "post_change")
+			if timeout := u.syncTimeout(len(post) > 0); timeout > 0 {
+				fmt.Printf("Waiting for the change to go in sync\n")
+				took, err := route53update.WaitForChange(u.Client, changeId, timeout)
+				if err != nil {
+					u.Reporter.Report(event)
+					if len(post) > 0 {
+						err = fmt.Errorf("Change never went in sync, post change hooks not run: %w", err)
+					} else {
+						err = fmt.Errorf("Change never went in sync: %w", err)
+					}
+					fail(err)
+					return err
+				}
+				event.Propagation = took.Seconds()
+			}
+			u.Reporter.Report(event)
+
+			hookChange.ChangeId = changeId
+			if err := runHooks("post_change", post, hookChange); err != nil {
+				fail(err)
+				return fmt.Errorf("Updated %s record but %v", rtype, err)
+			}
+			return nil
+		})
+	}
+}
+
+// One record for a single record setup, of the preferred family when the
+// connection has it.
+func (u *Updater) preferredType() []types.RRType {
+	if u.PreferFamily == 6 {
+		if _, err := route53update.PublicIPv6(); err != nil {
+			fmt.Printf("No public IPv6 address, doing the A rec instead: %v\n", err)
+			return []types.RRType{types.RRTypeA}
+		}
+		return []types.RRType{types.RRTypeAaaa}
+	}
+	if !route53update.CanReachIPv4() {
+		fmt.Printf("No IPv4 route or NAT64, doing the AAAA rec instead\n")
+		return []types.RRType{types.RRTypeAaaa}
+	}
+	return []types.RRType{types.RRTypeA}
+}
+
+// The TTL settings for a domain, with its own normal TTL if it has one.
+func (u *Updater) ttl(name string) TTLConfig {
+	if u.FixedTTL > 0 {
+		return TTLConfig{Normal: u.FixedTTL}
+	}
+	t := u.TTL
+	if d := u.Domains[name].TTL; d > 0 {
+		t.Normal = d
+	}
+	return t
+}
+
+// The name to find the hosted zone for a domain's records by, in the full
+// domain format. It's the zone from the config if there is one, and the
+// domain itself otherwise, which finds the closest zone it's part of.
+func (u *Updater) zoneFor(name string) string {
+	if zone := u.Domains[name].Zone; zone != "" {
+		return route53update.NormalizeHostname(zone) + "."
+	}
+	return name + "."
+}
+
+// The hosted zone for a domain's records, the one from the command line or
+// the domain's zone_id if there is one. Only the id of those is filled in,
+// but that's all the updates need.
+func (u *Updater) zone(name string) (*types.HostedZone, error) {
+	if u.ZoneId != "" {
+		return &types.HostedZone{Id: aws.String(u.ZoneId)}, nil
+	}
+	if id := u.Domains[name].ZoneId; id != "" {
+		return &types.HostedZone{Id: aws.String(id)}, nil
+	}
+	if private, vpcId := u.private(name); private && !u.splitHorizon(name) {
+		return u.Zones.Private(name, u.Domains[name].Zone, vpcId)
+	}
+	return u.Zones.For(name, u.Domains[name].Zone)
+}
+
+// The private zone for a split horizon domain's LAN records.
+func (u *Updater) lanZone(name string) (*types.HostedZone, error) {
+	_, vpcId := u.private(name)
+	return u.Zones.Private(name, u.Domains[name].Zone, vpcId)
+}
+
+// Whether the domain's records go in a private zone, and the VPC it has to
+// be associated with if that matters. The domain's vpc_id beats -vpc-id.
+func (u *Updater) private(name string) (bool, string) {
+	d := u.Domains[name]
+	vpcId := d.VPCId
+	if vpcId == "" {
+		vpcId = u.VPCId
+	}
+	return u.PrivateZone || d.PrivateZone || vpcId != "", vpcId
+}
+
+// The zones for a list of domains, for loading them all up front.
+func (u *Updater) zoneNames(domains []string) []string {
+	if u.ZoneId != "" {
+		return nil
+	}
+	var zones []string
+	for _, name := range domains {
+		// Loading zones up front only finds public ones
+		if private, _ := u.private(name); u.Domains[name].ZoneId == "" && (!private || u.splitHorizon(name)) {
+			zones = append(zones, u.zoneFor(name))
+		}
+	}
+	return zones
+}
+
+// Why a mismatch for the domain should be left alone for now, or "" if it
+// can be fixed right away.
+func (u *Updater) heldBecause(name string) string {
+	switch {
+	case u.MonitorOnly:
+		return "monitor only mode"
+	case u.Domains[name].Update == "notify":
+		return "needs approval"
+	case len(u.Windows) > 0 && !u.IgnoreWindows && !InWindows(u.Windows, time.Now()):
+		return "queued for the maintenance window at " + NextInWindows(u.Windows, time.Now()).Format("Mon 15:04")
+	}
+	if paused := u.pausedBecause(name); paused != "" {
+		return paused
+	}
+	if err := checkHealth(u.Domains[name].HealthCheck, ""); err != nil {
+		return "waiting on the health check: " + err.Error()
+	}
+	return ""
+}
+
+// Report a mismatch that isn't getting fixed, once per address, with an
+// approval link if it's waiting on one.
+func (u *Updater) reportHeld(name string, record string, configuredIp string, ip string, held string, report AddressReport) {
+	if !u.newMismatch(name+" "+record, ip) {
+		return
+	}
+	e := Event{Type: EventMismatch, Domain: name, Record: record, Source: u.Source, OldIp: configuredIp, NewIp: ip, Summary: held, AddressReport: report}
+	if u.Domains[name].Update == "notify" && !u.MonitorOnly && u.Approvals != nil {
+		// Someone already said no to this address, don't keep asking
+		if u.Reporter.Rejected(name, record, ip, time.Now().Add(-u.Approvals.Lifetime())) {
+			fmt.Printf("Change to %s was rejected, not asking again\n", ip)
+			return
+		}
+		e.ApproveURL = u.Approvals.Link(name, record, ip, time.Now())
+		e.RejectURL = u.Approvals.RejectLink(name, record, ip, time.Now())
+	}
+	u.Reporter.Report(e)
+}
+
+// Bring the TTL of a record that already has the right address in line,
+// so changing the TTL in the config or with -ttl takes effect without
+// waiting for the address to change. The short TTL right after a change is
+// left alone until the address has been stable long enough. Failing just
+// means trying again next time.
+func (u *Updater) fixTTL(name string, record string, zoneId string, rec *types.ResourceRecordSet) {
+	ttl := u.ttl(name)
+	want := ttl.For(false)
+	if rec.TTL == nil || *rec.TTL == want {
+		return
+	}
+	if ttl.AfterChange > 0 {
+		if last, ok := u.Reporter.LastChange(name, record); ok && time.Since(last.Time) < ttl.Stable {
+			return
+		}
+	}
+	if u.DryRun {
+		changed := *rec
+		changed.TTL = aws.Int64(want)
+		printPlannedChange(os.Stdout, rec, types.Change{Action: types.ChangeActionUpsert, ResourceRecordSet: &changed})
+		return
+	}
+	if held := u.heldBecause(name); held != "" {
+		fmt.Printf("%s TTL should be %d, not updating: %s\n", rec.Type, want, held)
+		return
+	}
+	_, err := route53update.UpdateRecIpTTL(u.Client, zoneId, *rec.Name, rec.Type, *rec.ResourceRecords[0].Value, want)
+	if err != nil {
+		log.Printf("Failed to change TTL on %s: %v", name, err)
+		return
+	}
+	fmt.Printf("Changed %s TTL from %d to %d\n", rec.Type, *rec.TTL, want)
+}
+
+// Remember the address a record should have, returning false if that's what
+// we already said last time.
+func (u *Updater) newMismatch(key string, ip string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.mismatches == nil {
+		u.mismatches = map[string]string{}
+	}
+	if u.mismatches[key] == ip {
+		return false
+	}
+	u.mismatches[key] = ip
+	return true
+}
+
+// If the record doesn't hold what we last saw in it, something other than
+// us changed it. That's worth an alert, with whoever did it if CloudTrail can
+// tell us.
+func (u *Updater) checkDrift(name string, record string, configuredIp string) {
+	last, ok := u.Reporter.LastKnown(name, record)
+	if !ok || last.NewIp == "" || last.NewIp == configuredIp {
+		return
+	}
+
+	e := Event{
+		Type:   EventDrift,
+		Domain: name,
+		Record: record,
+		Source: u.Source,
+		OldIp:  last.NewIp,
+		NewIp:  configuredIp,
+	}
+	if u.Attributor != nil {
+		attr, err := u.Attributor.Attribute(name, last.Time)
+		if err != nil {
+			log.Printf("Unable to find who changed %s: %v", name, err)
+		}
+		e.ChangedBy = attr
+	}
+	fmt.Printf("Record was changed outside of route53Update, last saw %s\n", last.NewIp)
+	u.Reporter.Report(e)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/mikerowehl/route53Update/route53update"
+)
+
+// A site with more than one uplink can publish all of them. Each uplink gets
+// its own weighted A record, with the weight from the config while its
+// health check passes and zero when it doesn't, so resolvers spread clients
+// over the uplinks that work. If every uplink is down Route53 treats all the
+// zero weights as equal, which is as good a guess as any.
+//
+// Weighted records can't share a name with a plain A record, so a domain
+// with uplinks should only have the weighted ones. For publishing just one
+// uplink at a time see failover.go.
+
+type uplinkState struct {
+	name    string
+	ip      string
+	weight  int64
+	healthy bool
+}
+
+// Check each uplink and bring the weighted records in line with the results.
+func (u *Updater) updateUplinks(name string, uplinks []UplinkConfig) error {
+	domain := name + "."
+	fail := func(err error) {
+		u.Reporter.Report(Event{Type: EventFailure, Domain: name, Source: u.Source, Error: err.Error()})
+	}
+
+	failover := u.Domains[name].UplinkMode == "failover"
+	var states []uplinkState
+	for _, up := range uplinks {
+		st := uplinkState{name: up.Name, weight: up.Weight, ip: up.IP}
+		if st.weight == 0 {
+			st.weight = 1
+		}
+		if st.ip == "" {
+			ip, err := uplinkIp(up.Interface)
+			if err != nil {
+				fmt.Printf("Uplink %s: can't get address: %v\n", up.Name, err)
+				// For failover that's just an uplink that's down
+				if failover {
+					states = append(states, st)
+				}
+				continue
+			}
+			st.ip = ip
+		}
+		if err := u.Policies.Check(name, st.ip); err != nil {
+			u.reportPolicy(name, "", "", st.ip, err, AddressReport{})
+			continue
+		}
+		st.healthy = checkHealth(up.Check, st.ip) == nil
+		fmt.Printf("Uplink %s: %s healthy=%v\n", up.Name, st.ip, st.healthy)
+		states = append(states, st)
+	}
+	if failover {
+		return u.updateFailover(name, states)
+	}
+	if len(states) == 0 {
+		err := fmt.Errorf("No uplink addresses found")
+		fail(err)
+		return err
+	}
+
+	zone, err := u.zone(name)
+	if err != nil {
+		fail(err)
+		return fmt.Errorf("Failed to find zone: %v", err)
+	}
+	current, err := getWeightedRecords(u.Client, *zone.Id, domain)
+	if err != nil {
+		fail(err)
+		return fmt.Errorf("Error reading uplink records: %v", err)
+	}
+
+	var changes []types.Change
+	var summary []string
+	for _, st := range states {
+		weight := st.weight
+		if !st.healthy {
+			weight = 0
+		}
+		summary = append(summary, fmt.Sprintf("%s=%s/%d", st.name, st.ip, weight))
+		rec, ok := current[st.name]
+		if ok && len(rec.ResourceRecords) > 0 && *rec.ResourceRecords[0].Value == st.ip && aws.ToInt64(rec.Weight) == weight {
+			continue
+		}
+		changes = append(changes, types.Change{
+			Action: types.ChangeActionUpsert,
+			ResourceRecordSet: &types.ResourceRecordSet{
+				Name:            aws.String(domain),
+				Type:            types.RRTypeA,
+				SetIdentifier:   aws.String(st.name),
+				Weight:          aws.Int64(weight),
+				ResourceRecords: []types.ResourceRecord{{Value: aws.String(st.ip)}},
+				TTL:             aws.Int64(u.ttl(name).For(true)),
+			},
+		})
+	}
+	if len(changes) == 0 {
+		u.Reporter.Report(Event{Type: EventNoChange, Domain: name, Source: u.Source, Summary: strings.Join(summary, " ")})
+		fmt.Printf("Uplink records already up to date, done\n")
+		return nil
+	}
+	if u.DryRun {
+		for _, c := range changes {
+			var cur *types.ResourceRecordSet
+			if rec, ok := current[*c.ResourceRecordSet.SetIdentifier]; ok {
+				cur = &rec
+			}
+			printPlannedChange(os.Stdout, cur, c)
+		}
+		return nil
+	}
+	if u.MonitorOnly || u.pausedBecause(name) != "" {
+		u.Reporter.Report(Event{Type: EventMismatch, Domain: name, Source: u.Source, Summary: "uplinks should be " + strings.Join(summary, " ")})
+		return nil
+	}
+
+	change, err := route53update.ChangeRecordSets(u.Client, &route53.ChangeResourceRecordSetsInput{
+		ChangeBatch:  &types.ChangeBatch{Changes: changes, Comment: u.changeComment(name)},
+		HostedZoneId: zone.Id,
+	})
+	if err != nil {
+		fail(err)
+		return fmt.Errorf("Error updating uplink records: %v", err)
+	}
+	u.Reporter.Report(Event{Type: EventChange, Domain: name, Source: u.Source, Summary: "uplinks now " + strings.Join(summary, " ")})
+	fmt.Printf("Updated %d uplink records\n", len(changes))
+	if err := u.waitForSync(*change.ChangeInfo.Id); err != nil {
+		fail(err)
+		return err
+	}
+	return nil
+}
+
+// The weighted A records for a name, by set identifier.
+func getWeightedRecords(client *route53.Client, zone string, domain string) (map[string]types.ResourceRecordSet, error) {
+	recs := map[string]types.ResourceRecordSet{}
+	paginator := route53.NewListResourceRecordSetsPaginator(client, &route53.ListResourceRecordSetsInput{
+		HostedZoneId:    aws.String(zone),
+		StartRecordName: aws.String(domain),
+		StartRecordType: types.RRTypeA,
+		MaxItems:        aws.Int32(20),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		for _, rec := range page.ResourceRecordSets {
+			if *rec.Name != domain || rec.Type != types.RRTypeA {
+				return recs, nil
+			}
+			if rec.SetIdentifier != nil {
+				recs[*rec.SetIdentifier] = rec
+			}
+		}
+	}
+	return recs, nil
+}
+
+// Find the public address of an uplink by asking ipify over a connection
+// from that interface's address. Only works if the routing sends traffic
+// from that address out the matching uplink, which is how multi-WAN setups
+// usually work anyway.
+func uplinkIp(iface string) (string, error) {
+	if iface == "" {
+		return "", fmt.Errorf("uplink needs an ip or an interface")
+	}
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return "", err
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return "", err
+	}
+	var local net.IP
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			local = ipnet.IP
+			break
+		}
+	}
+	if local == nil {
+		return "", fmt.Errorf("interface %s doesn't have an IPv4 address", iface)
+	}
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second, LocalAddr: &net.TCPAddr{IP: local}}
+	client := &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+	}
+	res, err := client.Get("https://api.ipify.org")
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, 64))
+	if err != nil {
+		return "", err
+	}
+	ip := strings.TrimSpace(string(body))
+	if net.ParseIP(ip) == nil {
+		return "", fmt.Errorf("ipify returned %q", ip)
+	}
+	return ip, nil
+}
+
+// Run a health check, for an uplink or a domain's health_check. Checks look
+// like tcp://host:port or an http(s) URL that should return a 2xx. {ip} in
+// the check gets replaced with the uplink's address, and no check at all
+// counts as healthy.
+func checkHealth(check string, ip string) error {
+	if check == "" {
+		return nil
+	}
+	check = strings.ReplaceAll(check, "{ip}", ip)
+	target, err := url.Parse(check)
+	if err != nil {
+		return err
+	}
+	switch target.Scheme {
+	case "tcp":
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(route53update.ReachableHost(target.Hostname()), target.Port()), 10*time.Second)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case "http", "https":
+		res, err := notifyClient.Get(check)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		if res.StatusCode < 200 || res.StatusCode >= 300 {
+			return fmt.Errorf("%s returned %s", check, res.Status)
+		}
+		return nil
+	default:
+		return fmt.Errorf("Unknown check type %s", target.Scheme)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// TimeWindow is a stretch of local time on some days of the week, like
+// 02:00 to 05:00 on weekdays. A window where To is before From runs past
+// midnight, and one without From and To is the whole day.
+type TimeWindow struct {
+	days     map[time.Weekday]bool
+	from, to time.Duration
+}
+
+// ParseTimeWindow checks over a window from the config. Days are three
+// letter names, and leaving them out means every day.
+func ParseTimeWindow(conf WindowConfig) (TimeWindow, error) {
+	w := TimeWindow{days: map[time.Weekday]bool{}}
+	for _, day := range conf.Days {
+		wd, ok := weekdays[strings.ToLower(day)[:min(3, len(day))]]
+		if !ok {
+			return w, fmt.Errorf("Unknown day %q in window", day)
+		}
+		w.days[wd] = true
+	}
+	if len(w.days) == 0 {
+		for _, wd := range weekdays {
+			w.days[wd] = true
+		}
+	}
+
+	if (conf.From == "") != (conf.To == "") {
+		return w, fmt.Errorf("Window needs both from and to, or neither")
+	}
+	if conf.From == "" {
+		w.to = 24 * time.Hour
+		return w, nil
+	}
+	var err error
+	if w.from, err = parseClock(conf.From); err != nil {
+		return w, err
+	}
+	if w.to, err = parseClock(conf.To); err != nil {
+		return w, err
+	}
+	return w, nil
+}
+
+// ParseTimeWindows parses a list of windows.
+func ParseTimeWindows(confs []WindowConfig) ([]TimeWindow, error) {
+	var windows []TimeWindow
+	for _, conf := range confs {
+		w, err := ParseTimeWindow(conf)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("Time %q should look like 08:00", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains says if t falls inside the window. For windows that run past
+// midnight, the part after midnight belongs to the day the window started.
+func (w TimeWindow) Contains(t time.Time) bool {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	clock := t.Sub(day)
+	if w.from <= w.to {
+		return w.days[t.Weekday()] && clock >= w.from && clock < w.to
+	}
+	if clock >= w.from {
+		return w.days[t.Weekday()]
+	}
+	return clock < w.to && w.days[day.AddDate(0, 0, -1).Weekday()]
+}
+
+// InWindows says if t is in any of the windows.
+func InWindows(windows []TimeWindow, t time.Time) bool {
+	for _, w := range windows {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextInWindows finds the next time at or after t that's in one of the
+// windows, to the minute. Returns the zero time if there isn't one in the
+// next week, which can only happen with no windows at all.
+func NextInWindows(windows []TimeWindow, t time.Time) time.Time {
+	return nextMatching(windows, t, true)
+}
+
+// NextOutsideWindows is the other way around, the next time that isn't in
+// any of the windows. Zero if the windows cover the whole week.
+func NextOutsideWindows(windows []TimeWindow, t time.Time) time.Time {
+	return nextMatching(windows, t, false)
+}
+
+func nextMatching(windows []TimeWindow, t time.Time, in bool) time.Time {
+	t = t.Truncate(time.Minute)
+	for i := 0; i <= 7*24*60; i++ {
+		if InWindows(windows, t) == in {
+			return t
+		}
+		t = t.Add(time.Minute)
+	}
+	return time.Time{}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/mikerowehl/route53Update/route53update"
+)
+
+// ParseZoneFile reads a BIND style zone file into Route53 record sets. It
+// handles what zone files people actually have lying around, $ORIGIN and
+// $TTL, relative names and @, blank owners carrying over from the line
+// before, records split over lines with parentheses, and \DDD escapes in
+// quoted strings. Every owner has to be in the zone. The SOA and the
+// NS records at the apex get left out, Route53 has its own and they need to
+// stay the way they are. Anything else that gets skipped comes back as a
+// warning.
+func ParseZoneFile(r io.Reader, origin string) ([]types.ResourceRecordSet, []string, error) {
+	origin = strings.ToLower(route53update.NormalizeHostname(origin)) + "."
+	apex := origin
+	var warnings []string
+	var ttl int64 = route53update.DefaultTTL
+	owner := origin
+
+	sets := map[string]*types.ResourceRecordSet{}
+	var order []string
+
+	entries, err := zoneFileEntries(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, entry := range entries {
+		fields := entry.fields
+		switch strings.ToUpper(fields[0].text) {
+		case "$ORIGIN":
+			if len(fields) < 2 {
+				return nil, nil, fmt.Errorf("Line %d: $ORIGIN needs a name", entry.line)
+			}
+			origin = absoluteName(fields[1].text, origin)
+			continue
+		case "$TTL":
+			if len(fields) < 2 {
+				return nil, nil, fmt.Errorf("Line %d: $TTL needs a value", entry.line)
+			}
+			if ttl, err = parseZoneTTL(fields[1].text); err != nil {
+				return nil, nil, fmt.Errorf("Line %d: %v", entry.line, err)
+			}
+			continue
+		case "$INCLUDE", "$GENERATE":
+			warnings = append(warnings, fmt.Sprintf("Line %d: %s isn't supported, skipped", entry.line, fields[0].text))
+			continue
+		}
+
+		if !entry.continued {
+			owner = absoluteName(fields[0].text, origin)
+			fields = fields[1:]
+			// A name outside the zone can't go in it, and it usually means
+			// the file, or an $ORIGIN in it, is for some other zone
+			if owner != apex && !strings.HasSuffix(owner, "."+apex) {
+				return nil, nil, fmt.Errorf("Line %d: %s isn't in %s", entry.line, owner, apex)
+			}
+		}
+		recTTL := ttl
+		var rtype string
+		for len(fields) > 0 && rtype == "" {
+			f := fields[0].text
+			fields = fields[1:]
+			switch upper := strings.ToUpper(f); {
+			case upper == "IN":
+			case upper == "CH" || upper == "HS":
+				return nil, nil, fmt.Errorf("Line %d: only IN class records can go in Route53", entry.line)
+			case f[0] >= '0' && f[0] <= '9':
+				if recTTL, err = parseZoneTTL(f); err != nil {
+					return nil, nil, fmt.Errorf("Line %d: %v", entry.line, err)
+				}
+			default:
+				rtype = upper
+			}
+		}
+		if rtype == "" {
+			return nil, nil, fmt.Errorf("Line %d: no record type", entry.line)
+		}
+
+		if rtype == "SOA" || (rtype == "NS" && owner == apex) {
+			continue
+		}
+		value, err := zoneRecordValue(rtype, fields, origin)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("Line %d: %v, skipped", entry.line, err))
+			continue
+		}
+
+		key := owner + " " + rtype
+		set, ok := sets[key]
+		if !ok {
+			set = &types.ResourceRecordSet{Name: aws.String(owner), Type: types.RRType(rtype), TTL: aws.Int64(recTTL)}
+			sets[key] = set
+			order = append(order, key)
+		} else if recTTL < *set.TTL {
+			// A record set only has one TTL, go with the shortest
+			set.TTL = aws.Int64(recTTL)
+		}
+		set.ResourceRecords = append(set.ResourceRecords, types.ResourceRecord{Value: aws.String(value)})
+	}
+
+	var recs []types.ResourceRecordSet
+	for _, key := range order {
+		recs = append(recs, *sets[key])
+	}
+	return recs, warnings, nil
+}
+
+var zoneRecordTypes = map[string]bool{
+	"A": true, "AAAA": true, "CNAME": true, "MX": true, "TXT": true, "SPF": true,
+	"NS": true, "PTR": true, "SRV": true, "CAA": true, "NAPTR": true, "DS": true,
+	"SSHFP": true, "TLSA": true, "SVCB": true, "HTTPS": true,
+}
+
+// Turn the fields of a record into the value Route53 wants, with any names
+// in it made absolute.
+func zoneRecordValue(rtype string, fields []zoneField, origin string) (string, error) {
+	if !zoneRecordTypes[rtype] {
+		return "", fmt.Errorf("Route53 doesn't support %s records", rtype)
+	}
+	if len(fields) == 0 {
+		return "", fmt.Errorf("%s record without a value", rtype)
+	}
+	var parts []string
+	for i, f := range fields {
+		text := f.text
+		if f.quoted {
+			text = quoteTXTString(text)
+		}
+		nameField := false
+		switch rtype {
+		case "CNAME", "NS", "PTR":
+			nameField = i == 0
+		case "MX":
+			nameField = i == 1
+		case "SRV":
+			nameField = i == 3
+		}
+		if nameField {
+			text = absoluteName(text, origin)
+		}
+		parts = append(parts, text)
+	}
+	return strings.Join(parts, " "), nil
+}
+
+func absoluteName(name string, origin string) string {
+	name = strings.ToLower(name)
+	switch {
+	case name == "@":
+		return origin
+	case strings.HasSuffix(name, "."):
+		return name
+	}
+	return name + "." + origin
+}
+
+// TTLs are seconds, or BIND's units like 1h30m or 2d.
+func parseZoneTTL(s string) (int64, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, nil
+	}
+	units := map[byte]int64{'s': 1, 'm': 60, 'h': 3600, 'd': 86400, 'w': 604800}
+	var total, n int64
+	digits := false
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case ch >= '0' && ch <= '9':
+			n = n*10 + int64(ch-'0')
+			digits = true
+		case units[ch|0x20] > 0 && digits:
+			total += n * units[ch|0x20]
+			n, digits = 0, false
+		default:
+			return 0, fmt.Errorf("bad TTL %q", s)
+		}
+	}
+	if digits {
+		return 0, fmt.Errorf("bad TTL %q", s)
+	}
+	return total, nil
+}
+
+type zoneField struct {
+	text   string
+	quoted bool
+}
+
+// One record or directive, after joining up parentheses.
+type zoneEntry struct {
+	line      int
+	continued bool // started with whitespace, so it has the last owner
+	fields    []zoneField
+}
+
+func zoneFileEntries(r io.Reader) ([]zoneEntry, error) {
+	var entries []zoneEntry
+	var cur *zoneEntry
+	depth := 0
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if depth == 0 {
+			cur = &zoneEntry{line: lineNo, continued: line != "" && (line[0] == ' ' || line[0] == '\t')}
+		}
+		for i := 0; i < len(line); {
+			ch := line[i]
+			switch {
+			case ch == ';':
+				i = len(line)
+			case ch == ' ' || ch == '\t':
+				i++
+			case ch == '(':
+				depth++
+				i++
+			case ch == ')':
+				if depth == 0 {
+					return nil, fmt.Errorf("Line %d: unbalanced )", lineNo)
+				}
+				depth--
+				i++
+			case ch == '"':
+				var b strings.Builder
+				i++
+				for i < len(line) && line[i] != '"' {
+					// \DDD is a byte in decimal, anything else after a
+					// backslash is just that character
+					if line[i] == '\\' && i+3 < len(line) && isDigits(line[i+1:i+4]) {
+						n, _ := strconv.Atoi(line[i+1 : i+4])
+						if n > 255 {
+							return nil, fmt.Errorf("Line %d: bad escape \\%s", lineNo, line[i+1:i+4])
+						}
+						b.WriteByte(byte(n))
+						i += 4
+						continue
+					}
+					if line[i] == '\\' && i+1 < len(line) {
+						b.WriteByte(line[i+1])
+						i += 2
+						continue
+					}
+					b.WriteByte(line[i])
+					i++
+				}
+				if i >= len(line) {
+					return nil, fmt.Errorf("Line %d: unterminated string", lineNo)
+				}
+				i++
+				cur.fields = append(cur.fields, zoneField{text: b.String(), quoted: true})
+			default:
+				start := i
+				for i < len(line) && !strings.ContainsRune(" \t;()\"", rune(line[i])) {
+					i++
+				}
+				cur.fields = append(cur.fields, zoneField{text: line[start:i]})
+			}
+		}
+		if depth == 0 && len(cur.fields) > 0 {
+			entries = append(entries, *cur)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to read zone file: %v", err)
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("Zone file ends inside parentheses")
+	}
+	return entries, nil
+}
+
+// sortedValues is a record set's values in a stable order for comparing.
+func sortedValues(rec types.ResourceRecordSet) []string {
+	var values []string
+	for _, rr := range rec.ResourceRecords {
+		values = append(values, aws.ToString(rr.Value))
+	}
+	sort.Strings(values)
+	return values
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestParseZoneFile(t *testing.T) {
+	tests := []struct {
+		name string
+		zone string
+		want []string // name type ttl values, one per record set
+	}{
+		{
+			name: "relative names and @",
+			zone: "$TTL 1h\n@ IN A 203.0.113.1\nwww 60 IN CNAME @\nmail IN MX 10 mx\n",
+			want: []string{
+				"example.com. A 3600 203.0.113.1",
+				"www.example.com. CNAME 60 example.com.",
+				"mail.example.com. MX 3600 10 mx.example.com.",
+			},
+		},
+		{
+			name: "blank owner carries over",
+			zone: "host IN A 203.0.113.1\n     IN A 203.0.113.2\n",
+			want: []string{"host.example.com. A 300 203.0.113.1|203.0.113.2"},
+		},
+		{
+			name: "parentheses",
+			zone: "@ IN SOA ns hostmaster ( 1 7200\n 3600 1209600 300 )\n_sip._tcp IN SRV ( 10 5\n 5060 sip )\n",
+			want: []string{"_sip._tcp.example.com. SRV 300 10 5 5060 sip.example.com."},
+		},
+		{
+			name: "origin inside the zone",
+			zone: "$ORIGIN lab.example.com.\nhost IN A 203.0.113.1\n",
+			want: []string{"host.lab.example.com. A 300 203.0.113.1"},
+		},
+		{
+			name: "apex NS left out",
+			zone: "@ IN NS ns1.example.net.\nsub IN NS ns1.example.net.\n",
+			want: []string{"sub.example.com. NS 300 ns1.example.net."},
+		},
+		{
+			name: "escapes in TXT",
+			zone: `@ IN TXT "\065\066C" "say \"hi\"" "back\\slash" "tab\009"` + "\n",
+			want: []string{`example.com. TXT 300 "ABC" "say \"hi\"" "back\\slash" "tab\009"`},
+		},
+		{
+			name: "comments",
+			zone: "; the whole zone\n@ IN A 203.0.113.1 ; the apex\n",
+			want: []string{"example.com. A 300 203.0.113.1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recs, _, err := ParseZoneFile(strings.NewReader(tt.zone), "example.com")
+			if err != nil {
+				t.Fatalf("ParseZoneFile: %v", err)
+			}
+			var got []string
+			for _, rec := range recs {
+				var values []string
+				for _, rr := range rec.ResourceRecords {
+					values = append(values, aws.ToString(rr.Value))
+				}
+				got = append(got, fmt.Sprintf("%s %s %d %s", aws.ToString(rec.Name), rec.Type, aws.ToInt64(rec.TTL), strings.Join(values, "|")))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestParseZoneFileErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		zone string
+		want string
+	}{
+		{"owner outside the zone", "host.example.net. IN A 203.0.113.1\n", "isn't in example.com."},
+		{"origin outside the zone", "$ORIGIN example.net.\nhost IN A 203.0.113.1\n", "isn't in example.com."},
+		{"zone that only ends the same", "badexample.com. IN A 203.0.113.1\n", "isn't in example.com."},
+		{"escape past a byte", `@ IN TXT "\256"` + "\n", "bad escape"},
+		{"unterminated string", `@ IN TXT "open` + "\n", "unterminated"},
+		{"unbalanced parentheses", "@ IN A 203.0.113.1 )\n", "unbalanced"},
+		{"open parentheses", "@ IN SOA ns hostmaster ( 1\n", "inside parentheses"},
+		{"other class", "@ CH A 203.0.113.1\n", "only IN class"},
+		{"bad TTL", "$TTL 1x\n", "bad TTL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := ParseZoneFile(strings.NewReader(tt.zone), "example.com")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Got %v, want an error with %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/mikerowehl/route53Update/route53update"
+)
+
+// Most changes to put in one batch, splitChanges keeps batches under the
+// Route53 limits as well
+const importBatchSize = 500
+
+// ZoneImportPlan is what importing a zone file would do. Records that are in
+// Route53 but not the file are left alone, unless pruning is on and they're
+// names this tool made, the ones with our heritage marker next to them.
+// Anything made by hand or by something else never gets pruned.
+type ZoneImportPlan struct {
+	Create    []types.ResourceRecordSet
+	Update    []types.ResourceRecordSet
+	Delete    []types.ResourceRecordSet
+	Unchanged int
+	Skipped   []string
+}
+
+// PlanZoneImport compares the records from a zone file against what's in
+// the hosted zone now.
+func PlanZoneImport(client *route53.Client, zone string, recs []types.ResourceRecordSet, prune bool) (*ZoneImportPlan, error) {
+	existing := map[string]types.ResourceRecordSet{}
+	byName := map[string][]types.ResourceRecordSet{}
+	var names []string
+	paginator := route53.NewListResourceRecordSetsPaginator(client, &route53.ListResourceRecordSetsInput{
+		HostedZoneId: aws.String(zone),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list records: %v", err)
+		}
+		for _, rec := range page.ResourceRecordSets {
+			existing[zoneImportKey(rec)] = rec
+			name := zoneImportName(rec)
+			if byName[name] == nil {
+				names = append(names, name)
+			}
+			byName[name] = append(byName[name], rec)
+		}
+	}
+
+	plan := &ZoneImportPlan{}
+	declared := map[string]bool{}
+	for _, rec := range recs {
+		declared[zoneImportName(rec)] = true
+	}
+	if prune {
+		for _, name := range names {
+			if declared[name] || !hasMarker(byName[name]) {
+				continue
+			}
+			for _, rec := range byName[name] {
+				switch rec.Type {
+				case types.RRTypeA, types.RRTypeAaaa, types.RRTypeTxt:
+					plan.Delete = append(plan.Delete, rec)
+				}
+			}
+		}
+	}
+	for _, rec := range recs {
+		current, ok := existing[zoneImportKey(rec)]
+		switch {
+		case !ok:
+			plan.Create = append(plan.Create, rec)
+		case current.SetIdentifier != nil || current.AliasTarget != nil:
+			plan.Skipped = append(plan.Skipped, fmt.Sprintf("%s %s is an alias or routing policy record in Route53", *rec.Name, rec.Type))
+		case aws.ToInt64(current.TTL) == aws.ToInt64(rec.TTL) && slices.Equal(sortedValues(current), sortedValues(rec)):
+			plan.Unchanged++
+		default:
+			plan.Update = append(plan.Update, rec)
+		}
+	}
+	return plan, nil
+}
+
+// Route53 hands back * as \052
+func zoneImportName(rec types.ResourceRecordSet) string {
+	return strings.ReplaceAll(strings.ToLower(aws.ToString(rec.Name)), `\052`, "*")
+}
+
+func zoneImportKey(rec types.ResourceRecordSet) string {
+	return zoneImportName(rec) + " " + string(rec.Type)
+}
+
+// If one of the records at a name is a TXT with our marker, we made it.
+func hasMarker(recs []types.ResourceRecordSet) bool {
+	for _, rec := range recs {
+		if rec.Type != types.RRTypeTxt {
+			continue
+		}
+		for _, v := range TXTValues(&rec) {
+			if strings.HasPrefix(v, markerHeritage) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Print shows the plan the way a diff would.
+func (p *ZoneImportPlan) Print() {
+	for _, rec := range p.Create {
+		fmt.Printf("+ %s %d %s %s\n", *rec.Name, *rec.TTL, rec.Type, strings.Join(sortedValues(rec), ", "))
+	}
+	for _, rec := range p.Update {
+		fmt.Printf("~ %s %d %s %s\n", *rec.Name, *rec.TTL, rec.Type, strings.Join(sortedValues(rec), ", "))
+	}
+	for _, rec := range p.Delete {
+		values := sortedValues(rec)
+		if rec.Type == types.RRTypeTxt {
+			// Only our markers go, anything else in there stays
+			values = nil
+			for _, v := range TXTValues(&rec) {
+				if strings.HasPrefix(v, markerHeritage) {
+					values = append(values, v)
+				}
+			}
+		}
+		fmt.Printf("- %s %d %s %s\n", *rec.Name, aws.ToInt64(rec.TTL), rec.Type, strings.Join(values, ", "))
+	}
+	for _, s := range p.Skipped {
+		fmt.Printf("! %s, skipped\n", s)
+	}
+	fmt.Printf("%d to create, %d to update, %d to prune, %d already match\n", len(p.Create), len(p.Update), len(p.Delete), p.Unchanged)
+}
+
+// Apply makes the changes, in batches for big zones. Pruned records go in
+// the recycle bin before anything changes.
+func (p *ZoneImportPlan) Apply(client *route53.Client, zone string) error {
+	var pruned []*types.ResourceRecordSet
+	for i := range p.Delete {
+		pruned = append(pruned, &p.Delete[i])
+	}
+	if err := RecycleRecords(zone, "pruned by zone import", pruned...); err != nil {
+		return err
+	}
+	batches := splitChanges(p.Changes(), importBatchSize)
+	var pacer ChangePacer
+	for _, batch := range batches {
+		pacer.Wait(batch)
+		_, err := route53update.ChangeRecordSets(client, &route53.ChangeResourceRecordSetsInput{
+			ChangeBatch: &types.ChangeBatch{
+				Changes: batch,
+				Comment: aws.String("route53Update zone import"),
+			},
+			HostedZoneId: aws.String(zone),
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to apply changes after %d of %d batches: %v", pacer.Batches-1, len(batches), err)
+		}
+	}
+	return nil
+}
+
+// Changes is the list of changes the plan makes.
+func (p *ZoneImportPlan) Changes() []types.Change {
+	var changes []types.Change
+	for _, rec := range p.Create {
+		changes = append(changes, types.Change{Action: types.ChangeActionCreate, ResourceRecordSet: &rec})
+	}
+	for _, rec := range p.Update {
+		changes = append(changes, types.Change{Action: types.ChangeActionUpsert, ResourceRecordSet: &rec})
+	}
+	for _, rec := range p.Delete {
+		changes = append(changes, deleteChanges(&rec)...)
+	}
+	return changes
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/mikerowehl/route53Update/route53update"
+)
+
+// ZoneCache remembers hosted zones by name. A config with lots of hostnames
+// usually only spreads them over a couple of zones, so rather than looking
+// the zone up again for every hostname on every update, all the zones get
+// found up front in as few calls as possible and reused after that.
+type ZoneCache struct {
+	client *route53.Client
+
+	mu    sync.Mutex
+	zones map[string]types.HostedZone
+
+	// The zone names hostnames turned out to be in, and whether zones has
+	// every zone in the account so nothing needs looking up
+	within   map[string]string
+	complete bool
+
+	// Names that are more than one zone with no way to choose, see
+	// route53update.PickZone
+	ambiguous map[string]error
+
+	// Private zones, by VPC id and then the domain they were found for
+	private map[string]types.HostedZone
+
+	// Zones from the state file that are still fresh, by domain
+	saved map[string]CachedZone
+}
+
+// The zone a domain is in basically never changes, but without anything
+// remembered every run starts with a ListHostedZonesByName. With
+// zone_cache_ttl set the zones that get found go in the state file too, and
+// the next run uses those until they're older than the TTL. -refresh-zones
+// ignores what's there and looks them all up again, for when a zone has
+// been recreated and the old id is gone.
+var (
+	zoneState    string
+	zoneCacheTTL time.Duration
+	refreshZones bool
+)
+
+// CachedZone is the hosted zone a domain was found in, and when.
+type CachedZone struct {
+	Zone types.HostedZone `json:"zone"`
+	Time time.Time        `json:"time"`
+}
+
+func NewZoneCache(client *route53.Client) *ZoneCache {
+	return &ZoneCache{
+		client:    client,
+		zones:     map[string]types.HostedZone{},
+		within:    map[string]string{},
+		ambiguous: map[string]error{},
+		private:   map[string]types.HostedZone{},
+		saved:     loadSavedZones(),
+	}
+}
+
+// The zones in the state file that haven't expired yet.
+func loadSavedZones() map[string]CachedZone {
+	saved := map[string]CachedZone{}
+	if zoneState == "" || refreshZones {
+		return saved
+	}
+	st, _, err := LoadRuntimeState(zoneState)
+	if err != nil {
+		log.Printf("Can't read the saved zones, looking them up: %v", err)
+		return saved
+	}
+	for domain, z := range st.Zones {
+		if time.Since(z.Time) < zoneCacheTTL && z.Zone.Id != nil && z.Zone.Name != nil {
+			saved[domain] = z
+		}
+	}
+	return saved
+}
+
+// Use a zone from the state file for the domain if there's one. Call with
+// the lock held.
+func (c *ZoneCache) useSaved(domain string) (types.HostedZone, bool) {
+	z, ok := c.saved[domain]
+	if !ok {
+		return types.HostedZone{}, false
+	}
+	name := route53update.NormalizeHostname(*z.Zone.Name) + "."
+	c.zones[name] = z.Zone
+	c.within[domain] = name
+	return z.Zone, true
+}
+
+// Save the zones domains were found in to the state file, dropping any
+// that have expired while we're at it.
+func (c *ZoneCache) save(found map[string]types.HostedZone) {
+	if zoneState == "" || len(found) == 0 {
+		return
+	}
+	now := time.Now().UTC()
+	err := UpdateRuntimeState(zoneState, func(st *RuntimeState) error {
+		if st.Zones == nil {
+			st.Zones = map[string]CachedZone{}
+		}
+		for domain, z := range st.Zones {
+			if time.Since(z.Time) >= zoneCacheTTL {
+				delete(st.Zones, domain)
+			}
+		}
+		for domain, zone := range found {
+			st.Zones[domain] = CachedZone{Zone: zone, Time: now}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to save zones: %v", err)
+		return
+	}
+	c.mu.Lock()
+	for domain, zone := range found {
+		c.saved[domain] = CachedZone{Zone: zone, Time: now}
+	}
+	c.mu.Unlock()
+}
+
+// Resolve finds all the named zones at once. Names can repeat, hostnames in
+// the same zone just collapse down to one lookup, and hostnames that aren't
+// zones themselves are fine as long as they're in one. One zone is a single
+// ListHostedZonesByName, more than that walks ListHostedZones a page (100
+// zones) at a time until they've all turned up, which is still only one call
+// for most accounts.
+func (c *ZoneCache) Resolve(names []string) error {
+	wanted := map[string]bool{}
+	c.mu.Lock()
+	for _, name := range names {
+		domain := route53update.NormalizeHostname(name) + "."
+		if _, ok := c.zones[domain]; ok {
+			continue
+		}
+		if _, ok := c.within[domain]; ok {
+			continue
+		}
+		if _, ok := c.useSaved(domain); !ok {
+			wanted[domain] = true
+		}
+	}
+	c.mu.Unlock()
+
+	switch len(wanted) {
+	case 0:
+		return nil
+	case 1:
+		for domain := range wanted {
+			_, err := c.For(domain, "")
+			return err
+		}
+	}
+
+	asked := make([]string, 0, len(wanted))
+	for domain := range wanted {
+		asked = append(asked, domain)
+	}
+
+	// Every zone gets remembered, not just the ones asked for, so if the
+	// listing goes all the way through hostnames that aren't zones of
+	// their own can be matched to the zone they're in without asking again
+	// A name can be more than one zone, so they're all gathered up before
+	// picking between them
+	listed := map[string][]types.HostedZone{}
+	paginator := route53.NewListHostedZonesPaginator(c.client, &route53.ListHostedZonesInput{})
+	for paginator.HasMorePages() && len(wanted) > 0 {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return fmt.Errorf("Failed to list hosted zones: %v", err)
+		}
+		for _, zone := range page.HostedZones {
+			name := route53update.NormalizeHostname(*zone.Name) + "."
+			listed[name] = append(listed[name], zone)
+			delete(wanted, name)
+		}
+	}
+	found := map[string]types.HostedZone{}
+	defer func() { c.save(found) }()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, zones := range listed {
+		if _, ok := c.zones[name]; ok {
+			continue
+		}
+		zone, err := route53update.PickZone(name, zones)
+		if err != nil {
+			c.ambiguous[name] = err
+			continue
+		}
+		c.zones[name] = *zone
+	}
+	if paginator.HasMorePages() {
+		for _, domain := range asked {
+			if zone, ok := c.zones[domain]; ok {
+				found[domain] = zone
+			}
+		}
+		return nil
+	}
+	c.complete = true
+	for _, domain := range asked {
+		zone, err := c.closest(domain)
+		if err != nil {
+			return err
+		}
+		found[domain] = zone
+	}
+	return nil
+}
+
+// The longest cached zone the domain is in. Call with the lock held.
+func (c *ZoneCache) closest(domain string) (types.HostedZone, error) {
+	for _, name := range route53update.ParentDomains(domain) {
+		if err, ok := c.ambiguous[name]; ok {
+			return types.HostedZone{}, err
+		}
+		if zone, ok := c.zones[name]; ok {
+			return zone, nil
+		}
+	}
+	return types.HostedZone{}, &route53update.ZoneNotFoundError{Domain: domain}
+}
+
+// For finds the hosted zone for a domain's records. That's the named zone if
+// there is one, and otherwise the zone with the longest name the domain is
+// part of, so home.example.com can go in example.com without saying so.
+func (c *ZoneCache) For(domain string, zone string) (*types.HostedZone, error) {
+	if zone != "" {
+		return c.Zone(route53update.NormalizeHostname(zone) + ".")
+	}
+	domain = route53update.NormalizeHostname(domain) + "."
+
+	c.mu.Lock()
+	if name, ok := c.within[domain]; ok {
+		found := c.zones[name]
+		c.mu.Unlock()
+		return &found, nil
+	}
+	if c.complete {
+		found, err := c.closest(domain)
+		c.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		return &found, nil
+	}
+	if saved, ok := c.useSaved(domain); ok {
+		c.mu.Unlock()
+		return &saved, nil
+	}
+	c.mu.Unlock()
+
+	found, err := route53update.FindHostedZone(c.client, domain)
+	if err != nil {
+		return nil, err
+	}
+	name := route53update.NormalizeHostname(*found.Name) + "."
+	c.mu.Lock()
+	c.zones[name] = *found
+	c.within[domain] = name
+	c.mu.Unlock()
+	c.save(map[string]types.HostedZone{domain: *found})
+	return found, nil
+}
+
+// Zone returns the hosted zone with exactly the given name, in the full
+// domain format with the period on the end. Zones that weren't resolved up
+// front get looked up and remembered.
+func (c *ZoneCache) Zone(domain string) (*types.HostedZone, error) {
+	domain = route53update.NormalizeHostname(domain) + "."
+	c.mu.Lock()
+	zone, ok := c.zones[domain]
+	ambiguous := c.ambiguous[domain]
+	if !ok && ambiguous == nil {
+		if saved, found := c.saved[domain]; found && route53update.NormalizeHostname(*saved.Zone.Name)+"." == domain {
+			zone, ok = c.useSaved(domain)
+		}
+	}
+	c.mu.Unlock()
+	if ok {
+		return &zone, nil
+	}
+	if ambiguous != nil {
+		return nil, ambiguous
+	}
+
+	found, err := route53update.GetHostedZone(c.client, domain)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.zones[domain] = *found
+	c.mu.Unlock()
+	c.save(map[string]types.HostedZone{domain: *found})
+	return found, nil
+}
+
+// Preload resolves zones ahead of time, logging instead of failing since
+// anything missing just gets looked up again when it's used.
+func (c *ZoneCache) Preload(names []string) {
+	if err := c.Resolve(names); err != nil {
+		log.Printf("Unable to look up all zones up front: %v", err)
+	}
+}
+
+// Private is For but for the private zone with the name, the one associated
+// with the VPC if vpcId is set. These don't come out of Resolve, since a
+// listing doesn't say which VPCs a zone is for, so each domain is looked up
+// the first time and remembered after that.
+func (c *ZoneCache) Private(domain string, zone string, vpcId string) (*types.HostedZone, error) {
+	domain = route53update.NormalizeHostname(domain) + "."
+	key := vpcId + " " + domain
+	c.mu.Lock()
+	found, ok := c.private[key]
+	c.mu.Unlock()
+	if ok {
+		return &found, nil
+	}
+
+	var z *types.HostedZone
+	var err error
+	if zone != "" {
+		z, err = route53update.GetPrivateHostedZone(c.client, zone, vpcId)
+	} else {
+		z, err = route53update.FindPrivateHostedZone(c.client, domain, vpcId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.private[key] = *z
+	c.mu.Unlock()
+	return z, nil
+}